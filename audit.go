@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditMaxSize = 100 // megabytes an audit file grows to before it is rotated
+	defaultAuditKeep    = 5   // rotated audit files kept
+	defaultEventsLimit  = 1000
+	maxEventsLimit      = 10000
+)
+
+// auditedMessages are the broadcast messages the audit log records
+var auditedMessages = map[string]bool{
+	protocol.TypeStatusChanged: true,
+	protocol.TypeTripEvent:     true,
+	protocol.TypeOfferEvent:    true,
+}
+
+// AuditLog appends significant events to a JSONL file for analysing a run
+// afterwards. Once the file would grow past maxSize it is rotated: path
+// becomes path.1, path.1 becomes path.2 and so on, keeping keep of them.
+type AuditLog struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File // nil once closed
+	w    *bufio.Writer
+	size int64
+	seq  int64
+}
+
+// NewAuditLog opens (or creates) the audit log in append-only mode
+func NewAuditLog(path string, maxSize int64, keep int) (*AuditLog, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid audit file size %d", maxSize)
+	}
+	if keep < 0 {
+		return nil, fmt.Errorf("invalid number of audit files to keep %d", keep)
+	}
+	a := &AuditLog{path: path, maxSize: maxSize, keep: keep}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the current file for appending. a.mu must be held.
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	a.file, a.w, a.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+// files returns the names of the audit files, oldest first
+func (a *AuditLog) files() []string {
+	names := make([]string, 0, a.keep+1)
+	for i := a.keep; i >= 1; i-- {
+		names = append(names, a.path+"."+strconv.Itoa(i))
+	}
+	return append(names, a.path)
+}
+
+// Record appends an event, numbering and timing it. Both are done under
+// the lock, so the log is in the order of both.
+func (a *AuditLog) Record(event protocol.AuditEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return os.ErrClosed
+	}
+
+	a.seq++
+	event.Seq = a.seq
+	event.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := a.w.Write(line); err != nil {
+		return err
+	}
+	a.size += int64(len(line))
+	return a.w.Flush()
+}
+
+// rotate moves the current file aside and starts a new one. a.mu must be
+// held.
+func (a *AuditLog) rotate() error {
+	if err := a.w.Flush(); err != nil {
+		return err
+	}
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	a.file = nil
+
+	files := a.files()
+	if a.keep == 0 {
+		if err := os.Remove(a.path); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	// Each file takes the place of the next older one; the oldest is
+	// replaced
+	for i := 1; i < len(files); i++ {
+		err := os.Rename(files[i], files[i-1])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	return a.open()
+}
+
+// Events reads the events after since (Unix milliseconds) from the audit
+// files, oldest first, keeping those of the given types (all when types is
+// empty). The events of a millisecond are returned whole: when there are
+// more than limit, those of the millisecond that doesn't fit are left out,
+// so asking again with the time of the last event returned picks up where
+// it ended. A millisecond with more than limit events is returned anyway.
+func (a *AuditLog) Events(since int64, types map[string]bool, limit int) (protocol.AuditEvents, error) {
+	a.mu.Lock()
+	if a.file != nil {
+		a.w.Flush()
+	}
+	a.mu.Unlock()
+
+	resp := protocol.AuditEvents{Events: []protocol.AuditEvent{}}
+	for _, name := range a.files() {
+		done, err := readEvents(name, since, types, limit, &resp)
+		if err != nil {
+			return resp, err
+		}
+		if done {
+			break
+		}
+	}
+
+	events := resp.Events
+	if n := len(events); n > limit {
+		cut := limit
+		for cut > 0 && events[cut-1].Time == events[cut].Time {
+			cut--
+		}
+		if cut == 0 {
+			for cut < n && events[cut].Time == events[0].Time {
+				cut++
+			}
+		}
+		resp.Events, resp.More = events[:cut], cut < n
+	}
+	return resp, nil
+}
+
+// readEvents adds the matching events of one audit file to resp, and
+// reports whether it has enough: more than limit, and one of a later
+// millisecond than the first. Missing files and lines that can't be read
+// (such as one being written) are skipped.
+func readEvents(name string, since int64, types map[string]bool, limit int, resp *protocol.AuditEvents) (bool, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event protocol.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if event.Time <= since || (len(types) > 0 && !types[event.Type]) {
+			continue
+		}
+		resp.Events = append(resp.Events, event)
+		if len(resp.Events) > limit && event.Time != resp.Events[0].Time {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Close flushes and closes the audit log; later events are lost
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.w.Flush()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	a.file = nil
+	return err
+}
+
+// audit records an event if the simulation keeps an audit log
+func (s *Simulation) audit(kind string, data interface{}) {
+	if s.auditLog == nil {
+		return
+	}
+	event := protocol.AuditEvent{
+		VirtualTime: s.clock.Now().UnixNano() / int64(time.Millisecond),
+		Type:        kind,
+		Data:        data,
+	}
+	if err := s.auditLog.Record(event); err != nil {
+		slog.Error("Auditing event failed", "type", kind, "err", err)
+	}
+}
+
+// auditClient records a client connecting, or disconnecting with what it
+// was sent
+func (s *Simulation) auditClient(client *WebSocketClient, connected bool) {
+	event := protocol.ClientEvent{
+		ID:        client.clientID,
+		Identity:  client.identity,
+		Transport: client.transport,
+		Remote:    client.remoteAddr,
+	}
+	if connected {
+		s.audit(protocol.AuditClientConnected, event)
+		return
+	}
+	event.ConnectedMs = time.Since(client.connectedAt).Milliseconds()
+	event.MessagesSent = client.metrics.messages.Load()
+	event.BytesSent = client.metrics.bytes.Load()
+	s.audit(protocol.AuditClientDisconnected, event)
+}
+
+// EventsHandler handles GET /api/events: the events of the audit log after
+// ?since= (Unix milliseconds), of the ?type= given, oldest first. It is
+// only there when the server keeps an audit log (-audit).
+func (s *Simulation) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auditLog == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	var since int64
+	if str := query.Get("since"); str != "" {
+		ms, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = ms
+	}
+	limit := defaultEventsLimit
+	if str := query.Get("limit"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 || n > maxEventsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxEventsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var types map[string]bool
+	if str := query.Get("type"); str != "" {
+		types = make(map[string]bool)
+		for _, kind := range strings.Split(str, ",") {
+			types[strings.TrimSpace(kind)] = true
+		}
+	}
+
+	resp, err := s.auditLog.Events(since, types, limit)
+	if err != nil {
+		slog.Error("Reading audit log failed", "err", err)
+		http.Error(w, "reading audit log failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
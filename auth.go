@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// APIKey is a static key a client authenticates with
+type APIKey struct {
+	Name           string `json:"name"` // identity shown in logs
+	Key            string `json:"key"`
+	MaxConnections int    `json:"max_connections"` // concurrent WebSocket connections; 0 is unlimited
+}
+
+// Auth checks the API keys of HTTP and WebSocket requests. A nil Auth lets
+// every request through anonymously.
+type Auth struct {
+	keys []APIKey
+
+	mu    sync.Mutex
+	conns map[string]int // open WebSocket connections per identity
+}
+
+// LoadAPIKeys reads a JSON array of API keys
+func LoadAPIKeys(path string) (*Auth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read API keys: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse API keys: %w", err)
+	}
+	return NewAuth(keys)
+}
+
+// NewAuth creates an Auth accepting the given keys
+func NewAuth(keys []APIKey) (*Auth, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys")
+	}
+	names := make(map[string]bool, len(keys))
+	for i, k := range keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("API key %d: name and key are required", i)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("API key %d: duplicate name %q", i, k.Name)
+		}
+		if k.MaxConnections < 0 {
+			return nil, fmt.Errorf("API key %s: max_connections must not be negative", k.Name)
+		}
+		names[k.Name] = true
+	}
+	return &Auth{keys: keys, conns: make(map[string]int)}, nil
+}
+
+// requestToken returns the token a request carries, either as
+// "Authorization: Bearer <token>" or a ?token= parameter (browsers can't set
+// headers on WebSocket connections)
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// identify returns the API key a request authenticates with. Without auth
+// every request is anonymous and allowed.
+func (a *Auth) identify(r *http.Request) (*APIKey, bool) {
+	if a == nil {
+		return nil, true
+	}
+
+	return a.identifyToken(requestToken(r))
+}
+
+// identifyToken returns the API key a token is, like identify does for a
+// request
+func (a *Auth) identifyToken(token string) (*APIKey, bool) {
+	if a == nil {
+		return nil, true
+	}
+
+	given := []byte(token)
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(given, []byte(a.keys[i].Key)) == 1 {
+			return &a.keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// Require wraps a handler so it only serves authenticated requests
+func (a *Auth) Require(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.identify(r); !ok {
+			unauthorized(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// errAdminDisabled refuses admin changes on a server without -admin-token
+var errAdminDisabled = errors.New("admin changes are disabled; start the server with -admin-token")
+
+// isAdmin reports whether a token presented with a request is the admin
+// token. No token is ever the admin token of a server that has none.
+func isAdmin(adminToken, presented string) bool {
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(adminToken)) == 1
+}
+
+// checkAdmin returns why a WebSocket command with the token presented may
+// not change the simulation, or nil when it may
+func checkAdmin(adminToken, presented string) error {
+	switch {
+	case adminToken == "":
+		return errAdminDisabled
+	case !isAdmin(adminToken, presented):
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+// RequireAdmin wraps a handler of the admin API. Reads are served to API
+// keys like the rest of the API, but changes need the admin token, which no
+// API key stands in for, and are refused when the server has none.
+func (a *Auth) RequireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	read, change := a.Require(next), requireAdmin(adminToken, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			read(w, r)
+		} else {
+			change(w, r)
+		}
+	}
+}
+
+// requireAdmin wraps a handler every request of which needs the admin token
+func requireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case adminToken == "":
+			http.Error(w, errAdminDisabled.Error(), http.StatusForbidden)
+		case !isAdmin(adminToken, requestToken(r)):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// acquire counts a new WebSocket connection of an identity against its
+// limit. It reports false when the limit is reached.
+func (a *Auth) acquire(key *APIKey) bool {
+	if a == nil || key == nil {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if key.MaxConnections > 0 && a.conns[key.Name] >= key.MaxConnections {
+		return false
+	}
+	a.conns[key.Name]++
+	return true
+}
+
+// release ends a connection counted by acquire
+func (a *Auth) release(key *APIKey) {
+	if a == nil || key == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns[key.Name]--
+}
+
+// identityName is the name of an API key, or empty for anonymous clients
+func identityName(key *APIKey) string {
+	if key == nil {
+		return ""
+	}
+	return key.Name
+}
+
+// unauthorized answers a request without a valid API key
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="taxi"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// demandZone is the time zone the hourly demand curves are in (Iraq, UTC+3)
+var demandZone = time.FixedZone("AST", 3*60*60)
+
+// weekdayCurve is the relative ride demand by local hour on a typical
+// weekday: quiet nights, a morning commute peak and a longer evening peak
+var weekdayCurve = []float64{
+	0.25, 0.15, 0.10, 0.08, 0.08, 0.15, // 00-05
+	0.35, 0.70, 1.00, 0.80, 0.60, 0.60, // 06-11
+	0.70, 0.65, 0.60, 0.65, 0.80, 1.00, // 12-17
+	0.95, 0.80, 0.70, 0.60, 0.50, 0.35, // 18-23
+}
+
+// CityDemand is the everyday ride demand of a city. Requests arrive as a
+// non-homogeneous Poisson process whose rate follows the hourly curve.
+type CityDemand struct {
+	City       string    `json:"city"`
+	PeakPerMin float64   `json:"peak_per_min"`     // requests per minute at the busiest hour
+	Hourly     []float64 `json:"hourly,omitempty"` // 24 relative rates by local hour (default: weekday curve)
+	Spread     float64   `json:"spread,omitempty"` // std dev of origins around the center in degrees (default: half the city radius)
+}
+
+// defaultBaseline is the everyday demand used unless a scenario overrides it
+var defaultBaseline = []CityDemand{
+	{City: "Erbil", PeakPerMin: 6},
+	{City: "Duhok", PeakPerMin: 3},
+}
+
+// cityBaseline is a CityDemand resolved against a city
+type cityBaseline struct {
+	CityDemand
+	city City
+}
+
+// rate returns the request rate (requests per second) at the given time,
+// interpolating linearly between hours so the rate changes smoothly
+func (b *cityBaseline) rate(now time.Time) float64 {
+	local := now.In(demandZone)
+	hour := float64(local.Hour()) + float64(local.Minute())/60 + float64(local.Second())/3600
+	i := int(hour) % 24
+	frac := hour - math.Floor(hour)
+	level := b.Hourly[i]*(1-frac) + b.Hourly[(i+1)%24]*frac
+	return level * b.PeakPerMin / 60
+}
+
+// resolveBaseline validates demand settings against the simulation's cities
+// and fills in defaults
+func resolveBaseline(demand []CityDemand, cities []City) ([]*cityBaseline, error) {
+	resolved := make([]*cityBaseline, 0, len(demand))
+	for _, cd := range demand {
+		var city *City
+		for i := range cities {
+			if cities[i].Name == cd.City {
+				city = &cities[i]
+			}
+		}
+		if city == nil {
+			return nil, fmt.Errorf("baseline demand for unknown city %q", cd.City)
+		}
+		if !(cd.PeakPerMin >= 0 && cd.PeakPerMin <= maxShockMagnitude*60) {
+			return nil, fmt.Errorf("baseline demand for %s: peak_per_min must be between 0 and %d", cd.City, maxShockMagnitude*60)
+		}
+		if cd.Hourly == nil {
+			cd.Hourly = weekdayCurve
+		}
+		if len(cd.Hourly) != 24 {
+			return nil, fmt.Errorf("baseline demand for %s: hourly needs 24 values, got %d", cd.City, len(cd.Hourly))
+		}
+		peak := 0.0
+		for _, v := range cd.Hourly {
+			if v < 0 {
+				return nil, fmt.Errorf("baseline demand for %s: hourly rates must not be negative", cd.City)
+			}
+			peak = math.Max(peak, v)
+		}
+		if peak > 0 {
+			// Normalize so PeakPerMin is the rate at the busiest hour
+			curve := make([]float64, 24)
+			for i, v := range cd.Hourly {
+				curve[i] = v / peak
+			}
+			cd.Hourly = curve
+		}
+		if cd.Spread > maxShockRadius || math.IsNaN(cd.Spread) {
+			return nil, fmt.Errorf("baseline demand for %s: spread must be at most %g", cd.City, maxShockRadius)
+		}
+		if cd.Spread <= 0 {
+			cd.Spread = city.Radius / 2
+		}
+		resolved = append(resolved, &cityBaseline{CityDemand: cd, city: *city})
+	}
+	return resolved, nil
+}
+
+// SetBaselineDemand replaces the everyday demand of the cities; an empty
+// list turns it off. It must run before the simulation starts or on the
+// main loop.
+func (s *Simulation) SetBaselineDemand(demand []CityDemand) error {
+	resolved, err := resolveBaseline(demand, s.cities)
+	if err != nil {
+		return err
+	}
+	s.demand.mu.Lock()
+	s.demand.baseline = resolved
+	s.demand.mu.Unlock()
+	return nil
+}
+
+// BaselineRate is the current everyday demand of one city
+type BaselineRate struct {
+	City   string  `json:"city"`
+	PerMin float64 `json:"per_min"`
+}
+
+// BaselineRates returns the current baseline request rate of every city
+func (g *DemandGenerator) BaselineRates(now time.Time) []BaselineRate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rates := make([]BaselineRate, 0, len(g.baseline))
+	for _, b := range g.baseline {
+		rates = append(rates, BaselineRate{City: b.City, PerMin: b.rate(now) * 60})
+	}
+	return rates
+}
+
+// poisson draws the number of arrivals in an interval with the given
+// expected count
+func poisson(lambda float64, r *rand.Rand) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		// Normal approximation; exact sampling gets slow for large rates
+		n := math.Round(lambda + math.Sqrt(lambda)*r.NormFloat64())
+		return int(math.Max(0, n))
+	}
+
+	// Knuth: count uniform draws until their product drops below e^-lambda
+	limit := math.Exp(-lambda)
+	count := 0
+	for p := r.Float64(); p > limit; p *= r.Float64() {
+		count++
+	}
+	return count
+}
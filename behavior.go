@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BehaviorProfile parameterizes how a driver drives and works. Chances are
+// per update interval, like the movement constants.
+type BehaviorProfile struct {
+	Name         string
+	TurnChance   float64 // chance of a heading change
+	TurnMaxAngle float64 // largest heading change in radians
+	AccelChance  float64 // chance of a speed change
+	AccelMax     float64 // largest relative speed change
+	IdleChance   float64 // chance an available driver pulls over to wait
+	IdleMin      float64 // shortest wait in seconds
+	IdleMax      float64 // longest wait in seconds
+	AcceptRate   float64 // fraction of ride offers accepted
+	NoShowRate   float64 // chance per second of abandoning a ride on the way to the pickup
+}
+
+// behaviorProfiles are the driver archetypes; "regular" matches the original
+// uniform movement parameters
+var behaviorProfiles = []*BehaviorProfile{
+	{
+		Name:         "regular",
+		TurnChance:   turnProbability,
+		TurnMaxAngle: turnMaxAngle,
+		AccelChance:  accelerationProb,
+		AccelMax:     accelerationMax,
+		IdleChance:   0.0005,
+		IdleMin:      20,
+		IdleMax:      90,
+		AcceptRate:   0.85,
+		NoShowRate:   0.00007,
+	},
+	{
+		// Weaves through traffic and takes every fare
+		Name:         "aggressive",
+		TurnChance:   0.12,
+		TurnMaxAngle: 0.3,
+		AccelChance:  0.15,
+		AccelMax:     0.3,
+		IdleChance:   0.0002,
+		IdleMin:      5,
+		IdleMax:      20,
+		AcceptRate:   0.98,
+		NoShowRate:   0.00004,
+	},
+	{
+		// Smooth, steady driving and picky about fares
+		Name:         "cautious",
+		TurnChance:   0.02,
+		TurnMaxAngle: 0.08,
+		AccelChance:  0.02,
+		AccelMax:     0.05,
+		IdleChance:   0.0008,
+		IdleMin:      30,
+		IdleMax:      120,
+		AcceptRate:   0.7,
+		NoShowRate:   0.00005,
+	},
+	{
+		// Parks often and for long, turns down many offers and sometimes
+		// doesn't show up
+		Name:         "lazy",
+		TurnChance:   0.03,
+		TurnMaxAngle: 0.1,
+		AccelChance:  0.03,
+		AccelMax:     0.1,
+		IdleChance:   0.001,
+		IdleMin:      60,
+		IdleMax:      300,
+		AcceptRate:   0.4,
+		NoShowRate:   0.0003,
+	},
+}
+
+// eagerAfterMin is how many minutes without a trip make a driver as keen on
+// offers as it gets
+const eagerAfterMin = 20
+
+// defaultProfileMix is used unless -profiles says otherwise
+const defaultProfileMix = "regular=0.4,aggressive=0.2,cautious=0.25,lazy=0.15"
+
+var defaultMix = mustParseProfileMix(defaultProfileMix)
+
+func mustParseProfileMix(spec string) *ProfileMix {
+	mix, err := ParseProfileMix(spec)
+	if err != nil {
+		panic(err)
+	}
+	return mix
+}
+
+// vehicleTypes are the kinds of vehicle in the fleet and their shares
+var vehicleTypes = []struct {
+	name  string
+	share float64
+}{
+	{"car", 0.75},
+	{"van", 0.15},
+	{"suv", 0.10},
+}
+
+// randomVehicle picks a vehicle type for a uniform roll in [0, 1)
+func randomVehicle(roll float64) string {
+	for _, v := range vehicleTypes {
+		if roll < v.share {
+			return v.name
+		}
+		roll -= v.share
+	}
+	return vehicleTypes[0].name
+}
+
+// findProfile returns the profile with the given name
+func findProfile(name string) (*BehaviorProfile, bool) {
+	for _, p := range behaviorProfiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ProfileMix assigns behavior profiles by configured proportions
+type ProfileMix struct {
+	profiles   []*BehaviorProfile
+	cumulative []float64 // normalized cumulative weights
+}
+
+// ParseProfileMix parses proportions like "aggressive=0.2,cautious=0.5,lazy=0.3".
+// Weights are relative and normalized, so "aggressive=1,lazy=1" is an even split.
+func ParseProfileMix(spec string) (*ProfileMix, error) {
+	parsed, err := parseWeights(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make(map[*BehaviorProfile]float64, len(parsed))
+	total := 0.0
+	for name, weight := range parsed {
+		profile, ok := findProfile(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown behavior profile %q", name)
+		}
+		weights[profile] += weight
+		total += weight
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("profile mix %q has no positive weights", spec)
+	}
+
+	// Keep the declaration order so assignment is deterministic
+	mix := &ProfileMix{}
+	sum := 0.0
+	for _, p := range behaviorProfiles {
+		if weights[p] == 0 {
+			continue
+		}
+		sum += weights[p] / total
+		mix.profiles = append(mix.profiles, p)
+		mix.cumulative = append(mix.cumulative, sum)
+	}
+	return mix, nil
+}
+
+// Pick returns the profile for a uniform roll in [0, 1)
+func (m *ProfileMix) Pick(roll float64) *BehaviorProfile {
+	i := sort.Search(len(m.cumulative), func(i int) bool { return m.cumulative[i] > roll })
+	if i >= len(m.profiles) {
+		i = len(m.profiles) - 1
+	}
+	return m.profiles[i]
+}
+
+// behavior returns the driver's profile; drivers loaded from a recording
+// have none and behave like regular drivers
+func (d *Driver) behavior() *BehaviorProfile {
+	if d.profile == nil {
+		return behaviorProfiles[0]
+	}
+	return d.profile
+}
+
+// acceptChance is the probability that the driver takes a ride offer: its
+// profile's accept rate, lowered for far pickups (down to half at the edge
+// of the search radius, maxKm) and raised the longer the driver has gone
+// without a trip. d.mu must be held.
+func (d *Driver) acceptChance(distKm, maxKm float64, sinceTrip time.Duration) float64 {
+	chance := d.behavior().AcceptRate
+	chance *= 1 - 0.5*math.Min(1, distKm/maxKm)
+
+	// Drivers without a fare for a while take up to half the offers they
+	// would otherwise turn down
+	eager := math.Min(1, sinceTrip.Minutes()/eagerAfterMin)
+	return chance + (1-chance)*0.5*eager
+}
+
+// SetProfileMix changes the profile proportions and reassigns every driver.
+// It must run before the simulation starts or on the main loop.
+func (s *Simulation) SetProfileMix(mix *ProfileMix) {
+	s.profileMix = mix
+
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		driver.profile = mix.Pick(s.rand.Float64())
+		driver.mu.Unlock()
+	}
+}
+
+// ProfileCounts returns how many drivers follow each profile
+func (s *Simulation) ProfileCounts() map[string]int {
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+
+	counts := make(map[string]int, len(behaviorProfiles))
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		counts[driver.behavior().Name]++
+		driver.mu.Unlock()
+	}
+	return counts
+}
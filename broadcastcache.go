@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"quadtree/protocol"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// broadcastCache shares the work of one broadcast tick between the clients
+// that watch the same drivers, which is most of them when they all look at
+// a city center: the drivers found in an area and the frames encoded from
+// them. It lives for one tick, so nothing in it is stale. Clients are sent
+// their updates concurrently; the first to need an entry builds it while
+// the others wait for it. A nil cache caches nothing.
+type broadcastCache struct {
+	mu      sync.Mutex // guards the maps, not the entries
+	updates map[areaKey]*cachedUpdate
+	frames  map[frameKey]*cachedFrame
+}
+
+type cachedUpdate struct {
+	once    sync.Once
+	message protocol.DriversUpdate
+}
+
+type cachedFrame struct {
+	once sync.Once
+	msg  outbound
+	err  error
+
+	uses     int // clients sent the frame, guarded by broadcastCache.mu
+	prepare  sync.Once
+	prepared *websocket.PreparedMessage
+}
+
+// areaKey identifies the drivers a client is sent: those in its area with
+// the statuses it asked for that pass its filter
+type areaKey struct {
+	lon, lat, radius float64
+	statuses         string // sorted and comma-separated; empty for all
+	filter           string
+}
+
+// frameKey identifies a driver update frame: the drivers, and the protocol
+// version and encoding they are sent in
+type frameKey struct {
+	area     areaKey
+	version  int
+	encoding string
+}
+
+func newBroadcastCache() *broadcastCache {
+	return &broadcastCache{
+		updates: make(map[areaKey]*cachedUpdate),
+		frames:  make(map[frameKey]*cachedFrame),
+	}
+}
+
+// newAreaKey returns the key of the drivers a client with the given
+// settings is sent in an area
+func newAreaKey(cfg clientSettings, lon, lat, radius float64) areaKey {
+	key := areaKey{lon: lon, lat: lat, radius: radius}
+	if cfg.statuses != nil {
+		statuses := make([]string, 0, len(cfg.statuses))
+		for status, ok := range cfg.statuses {
+			if ok {
+				statuses = append(statuses, status)
+			}
+		}
+		sort.Strings(statuses)
+		key.statuses = strings.Join(statuses, ",")
+	}
+	if cfg.filter != nil {
+		key.filter = cfg.filter.String()
+	}
+	return key
+}
+
+// update returns the driver update found for an area this tick, building
+// and keeping it the first time
+func (c *broadcastCache) update(key areaKey, build func() protocol.DriversUpdate) protocol.DriversUpdate {
+	if c == nil {
+		return build()
+	}
+	c.mu.Lock()
+	entry, ok := c.updates[key]
+	if !ok {
+		entry = &cachedUpdate{}
+		c.updates[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() { entry.message = build() })
+	return entry.message
+}
+
+// frame returns the frame encoded for a driver update this tick, encoding
+// and keeping it the first time. Once a second client is sent the frame it
+// is prepared as well, so every WebSocket after the first gets the same
+// framed (and, if negotiated, compressed) bytes.
+func (c *broadcastCache) frame(key frameKey, build func() (int, []byte, error)) (outbound, error) {
+	if c == nil {
+		messageType, data, err := build()
+		return outbound{messageType: messageType, data: data}, err
+	}
+	c.mu.Lock()
+	entry, ok := c.frames[key]
+	if !ok {
+		entry = &cachedFrame{}
+		c.frames[key] = entry
+	}
+	entry.uses++
+	shared := entry.uses > 1
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.msg.messageType, entry.msg.data, entry.err = build()
+	})
+	if entry.err != nil {
+		return outbound{}, entry.err
+	}
+	msg := entry.msg
+	if shared {
+		entry.prepare.Do(func() {
+			pm, err := websocket.NewPreparedMessage(msg.messageType, msg.data)
+			if err != nil {
+				slog.Error("Preparing driver update frame failed", "err", err)
+				return // sent unprepared
+			}
+			entry.prepared = pm
+		})
+		msg.prepared = entry.prepared
+	}
+	return msg, nil
+}
+
+// encodeBuffers recycles the buffers driver updates are encoded in. Frames
+// are copied out at their final size, since they outlive the call in the
+// clients' send queues.
+var encodeBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 64*1024)
+		return &buf
+	},
+}
+
+// encodeUpdate encodes a driver update in the encoding and protocol
+// version of a client's settings. JSON driver updates and snapshots, the
+// bulk of what is broadcast, skip reflection.
+func encodeUpdate(cfg clientSettings, v interface{}) (int, []byte, error) {
+	if cfg.encoding == protocol.EncodingProtobuf {
+		if data, ok := protocol.MarshalFrame(v, ""); ok {
+			return websocket.BinaryMessage, data, nil
+		}
+	}
+	if cfg.encoding != protocol.EncodingMsgpack {
+		var update *protocol.DriversUpdate
+		switch msg := v.(type) {
+		case protocol.DriversUpdate:
+			update = &msg
+		case protocol.DriversSnapshot:
+			update = (*protocol.DriversUpdate)(&msg)
+		}
+		if update != nil {
+			data, err := appendUpdate(cfg, update)
+			return websocket.TextMessage, data, err
+		}
+	}
+
+	if cfg.version >= protocol.Version {
+		v = protocol.Wrap(v)
+	}
+	if cfg.encoding == protocol.EncodingMsgpack {
+		data, err := protocol.MarshalMsgpack(v)
+		return websocket.BinaryMessage, data, err
+	}
+	data, err := json.Marshal(v)
+	return websocket.TextMessage, data, err
+}
+
+// appendUpdate encodes a driver update as JSON in a pooled buffer and
+// returns a copy of exactly its size
+func appendUpdate(cfg clientSettings, update *protocol.DriversUpdate) ([]byte, error) {
+	bufPtr := encodeBuffers.Get().(*[]byte)
+	defer encodeBuffers.Put(bufPtr)
+
+	var err error
+	buf := (*bufPtr)[:0]
+	if cfg.version >= protocol.Version {
+		buf, err = update.AppendEnvelopedJSON(buf)
+	} else {
+		buf, err = update.AppendJSON(buf)
+	}
+	*bufPtr = buf
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf...), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"quadtree/protocol"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// Cell level of /api/cells when none is asked for; level 12 cells are
+	// about 2km across
+	defaultCellLevel = 12
+
+	cellsBroadcastInterval = time.Second
+)
+
+// countCells counts the drivers of a published world per S2 cell of a
+// level, fullest cells first
+func countCells(world *WorldSnapshot, level int, now time.Time) protocol.DriverCells {
+	counts := make(map[s2.CellID]*protocol.DriverCell)
+	for _, state := range world.drivers {
+		id := s2.CellIDFromLatLng(s2.LatLngFromDegrees(state.lat, state.lon)).Parent(level)
+		cell, ok := counts[id]
+		if !ok {
+			center := id.LatLng()
+			cell = &protocol.DriverCell{Token: id.ToToken(), Lat: center.Lat.Degrees(), Lon: center.Lng.Degrees()}
+			counts[id] = cell
+		}
+		switch state.status {
+		case Available:
+			cell.Available++
+		case Busy:
+			cell.Busy++
+		case Offline:
+			cell.Offline++
+		}
+		cell.Count++
+	}
+
+	cells := protocol.DriverCells{
+		Type:  protocol.TypeDriverCells,
+		Level: level,
+		Cells: make([]protocol.DriverCell, 0, len(counts)),
+		Total: len(world.drivers),
+		Time:  now.UnixNano() / int64(time.Millisecond),
+	}
+	for _, cell := range counts {
+		cells.Cells = append(cells.Cells, *cell)
+	}
+	sort.Slice(cells.Cells, func(i, j int) bool {
+		a, b := cells.Cells[i], cells.Cells[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Token < b.Token
+	})
+	return cells
+}
+
+// inViewport keeps the cells whose center is in v; a nil v keeps them all
+func inViewport(cells protocol.DriverCells, v *protocol.Viewport) protocol.DriverCells {
+	if v == nil {
+		return cells
+	}
+	kept := make([]protocol.DriverCell, 0, len(cells.Cells))
+	cells.Total = 0
+	for _, cell := range cells.Cells {
+		if v.Contains(cell.Lat, cell.Lon) {
+			kept = append(kept, cell)
+			cells.Total += cell.Count
+		}
+	}
+	cells.Cells = kept
+	return cells
+}
+
+// handleWatchCells processes a watch_cells message. The first counts go out
+// right away, or an error if the message is invalid.
+func (s *Simulation) handleWatchCells(client *WebSocketClient, requestID string, message []byte) {
+	var msg protocol.WatchCells
+	err := json.Unmarshal(message, &msg)
+	if err != nil {
+		err = fmt.Errorf("invalid watch_cells message: %v", err)
+	} else {
+		err = msg.Validate()
+	}
+	if err != nil {
+		s.sendReply(client, requestID, protocol.ErrorMessage{Type: protocol.TypeError, Error: err.Error()})
+		return
+	}
+
+	client.subsMu.Lock()
+	client.cells = &msg
+	if msg.Level == 0 {
+		client.cells = nil
+	}
+	client.subsMu.Unlock()
+
+	if msg.Level != 0 {
+		s.sendReply(client, requestID, inViewport(countCells(s.world(), msg.Level, s.clock.Now()), msg.Viewport))
+	}
+}
+
+// BroadcastCells sends the clients that watch cells their counts, counting
+// each level once
+func (s *Simulation) BroadcastCells() {
+	watchers := make(map[*WebSocketClient]protocol.WatchCells)
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		client.subsMu.Lock()
+		if client.cells != nil {
+			watchers[client] = *client.cells
+		}
+		client.subsMu.Unlock()
+	}
+	s.clientsMu.RUnlock()
+	if len(watchers) == 0 {
+		return
+	}
+
+	world, now := s.world(), s.clock.Now()
+	levels := make(map[int]protocol.DriverCells)
+	for client, watch := range watchers {
+		cells, ok := levels[watch.Level]
+		if !ok {
+			cells = countCells(world, watch.Level, now)
+			levels[watch.Level] = cells
+		}
+		s.sendJSON(client, inViewport(cells, watch.Viewport))
+	}
+}
+
+// CellsHandler handles GET /api/cells: the drivers counted per S2 cell of a
+// level, optionally within a viewport
+func (s *Simulation) CellsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	watch := protocol.WatchCells{Level: defaultCellLevel}
+	if str := query.Get("level"); str != "" {
+		level, err := strconv.Atoi(str)
+		if err != nil || level == 0 {
+			http.Error(w, fmt.Sprintf("level must be between %d and %d", protocol.MinCellLevel, protocol.MaxCellLevel), http.StatusBadRequest)
+			return
+		}
+		watch.Level = level
+	}
+	if query.Has("south") || query.Has("west") || query.Has("north") || query.Has("east") {
+		var v protocol.Viewport
+		for name, dst := range map[string]*float64{"south": &v.South, "west": &v.West, "north": &v.North, "east": &v.East} {
+			f, err := strconv.ParseFloat(query.Get(name), 64)
+			if err != nil {
+				http.Error(w, "south, west, north and east are all needed for a viewport", http.StatusBadRequest)
+				return
+			}
+			*dst = f
+		}
+		watch.Viewport = &v
+	}
+	if err := watch.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inViewport(countCells(s.world(), watch.Level, s.clock.Now()), watch.Viewport))
+}
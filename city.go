@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"quadtree/geo"
+	"strconv"
+	"strings"
+)
+
+// defaultCityWeights spreads the initial drivers when -city-weights is not set
+const defaultCityWeights = "Erbil=0.7,Duhok=0.3"
+
+// parseWeights parses "name=weight,..." lists used by the -city-weights and
+// -profiles flags. Names are returned as given; weights must not be negative.
+func parseWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weightStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid proportion %q: want name=weight", part)
+		}
+		name = strings.TrimSpace(name)
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightStr), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", weightStr, name)
+		}
+		weights[name] += weight
+	}
+	return weights, nil
+}
+
+// applyCityWeights sets the spawn weight of each city from a parsed
+// -city-weights list; cities that aren't listed get no drivers
+func applyCityWeights(cities []City, weights map[string]float64) error {
+	for i := range cities {
+		cities[i].Weight = 0
+	}
+
+	total := 0.0
+	for name, weight := range weights {
+		found := false
+		for i := range cities {
+			if strings.EqualFold(cities[i].Name, name) {
+				cities[i].Weight += weight
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown city %q", name)
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("city weights have no positive weight")
+	}
+	return nil
+}
+
+// pickCity chooses a city in proportion to the spawn weights for a uniform
+// roll in [0, 1)
+func pickCity(cities []City, roll float64) City {
+	total := 0.0
+	for _, city := range cities {
+		total += city.Weight
+	}
+
+	target := roll * total
+	for _, city := range cities {
+		if target < city.Weight {
+			return city
+		}
+		target -= city.Weight
+	}
+
+	// Rounding left the roll past the end; use the last weighted city
+	for i := len(cities) - 1; i >= 0; i-- {
+		if cities[i].Weight > 0 {
+			return cities[i]
+		}
+	}
+	return cities[0]
+}
+
+// cityBoundary returns the boundary zone polygon around a city center, if
+// the geofences define one
+func (f *GeofenceSet) cityBoundary(city City) (geo.Polygon, bool) {
+	if f == nil {
+		return nil, false
+	}
+	for _, zone := range f.Zones {
+		if zone.Kind == ZoneBoundary && zone.Polygon.Contains(city.Lon, city.Lat) {
+			return zone.Polygon, true
+		}
+	}
+	return nil, false
+}
+
+// placeDriver picks an initial driver position in a city: anywhere inside
+// the city's boundary polygon when one exists, otherwise concentrated
+// around the center (10-60% of the city radius) so drivers are visible
+func placeDriver(city City, fences *GeofenceSet, r *rand.Rand) (float64, float64) {
+	polygon, ok := fences.cityBoundary(city)
+	if !ok {
+		return placeInCity(city, 0.1, 0.6, fences, r)
+	}
+
+	minLon, minLat, maxLon, maxLat := polygon.Bounds()
+	for attempt := 0; attempt < 100; attempt++ {
+		lon := minLon + r.Float64()*(maxLon-minLon)
+		lat := minLat + r.Float64()*(maxLat-minLat)
+		if polygon.Contains(lon, lat) && fences.Allowed(lon, lat) {
+			return lon, lat
+		}
+	}
+	return placeInCity(city, 0.1, 0.6, fences, r)
+}
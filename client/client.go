@@ -0,0 +1,388 @@
+// Package client is a Go SDK for the taxi simulation server. It wraps the
+// HTTP API and the WebSocket feed using the shared protocol types.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+)
+
+// Client talks to a simulation server over HTTP and WebSocket
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      string
+	adminToken string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for API requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithToken sets the API key sent with every request and WebSocket
+// connection, for servers started with -api-keys
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithAdminToken sets the admin token sent with changes to the simulation,
+// such as Reconfigure, SpawnDrivers and Conn.Control, for servers started
+// with -admin-token
+func WithAdminToken(token string) Option {
+	return func(c *Client) {
+		c.adminToken = token
+	}
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// APIError is returned when the server answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Query selects the area for a nearby-drivers request. City takes precedence
+// over Lat/Lon when set; a zero Radius uses the server default. Filter is an
+// optional server-side filter expression such as `status == "Available"`.
+// Sort is "distance" (the default), "id" or "status"; a non-zero Limit
+// returns a page of drivers starting at Offset, such as the NextOffset of
+// the previous page.
+type Query struct {
+	Lat    float64
+	Lon    float64
+	Radius float64
+	City   string
+	Filter string
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// values encodes the query as URL parameters
+func (q Query) values() url.Values {
+	v := url.Values{}
+	if q.City != "" {
+		v.Set("city", q.City)
+	} else {
+		v.Set("lat", strconv.FormatFloat(q.Lat, 'f', -1, 64))
+		v.Set("lon", strconv.FormatFloat(q.Lon, 'f', -1, 64))
+	}
+	if q.Radius > 0 {
+		v.Set("radius", strconv.FormatFloat(q.Radius, 'f', -1, 64))
+	}
+	if q.Filter != "" {
+		v.Set("filter", q.Filter)
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return v
+}
+
+// NearbyDrivers returns the drivers within the query area
+func (c *Client) NearbyDrivers(ctx context.Context, q Query) (*protocol.DriversResponse, error) {
+	var resp protocol.DriversResponse
+	if err := c.do(ctx, http.MethodGet, "/api/drivers", q.values(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// NearestQuery selects the drivers of a nearest-drivers request. A zero N
+// uses the server default of 5; Statuses and Filter optionally narrow the
+// drivers considered.
+type NearestQuery struct {
+	Lat      float64
+	Lon      float64
+	N        int
+	Statuses []string // e.g. "Available"
+	Filter   string
+}
+
+// NearestDrivers returns the drivers closest to a location, closest first
+func (c *Client) NearestDrivers(ctx context.Context, q NearestQuery) (*protocol.NearestDriversResponse, error) {
+	v := url.Values{}
+	v.Set("lat", strconv.FormatFloat(q.Lat, 'f', -1, 64))
+	v.Set("lon", strconv.FormatFloat(q.Lon, 'f', -1, 64))
+	if q.N > 0 {
+		v.Set("n", strconv.Itoa(q.N))
+	}
+	if len(q.Statuses) > 0 {
+		v.Set("status", strings.Join(q.Statuses, ","))
+	}
+	if q.Filter != "" {
+		v.Set("filter", q.Filter)
+	}
+
+	var resp protocol.NearestDriversResponse
+	if err := c.do(ctx, http.MethodGet, "/api/drivers/nearest", v, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// EstimateFare quotes a trip between two points at the current surge
+func (c *Client) EstimateFare(ctx context.Context, from, to protocol.Location) (*protocol.FareEstimate, error) {
+	latLon := func(l protocol.Location) string {
+		return strconv.FormatFloat(l.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(l.Lon, 'f', -1, 64)
+	}
+	query := url.Values{"from": {latLon(from)}, "to": {latLon(to)}}
+
+	var estimate protocol.FareEstimate
+	if err := c.do(ctx, http.MethodGet, "/api/fare", query, nil, &estimate); err != nil {
+		return nil, err
+	}
+	return &estimate, nil
+}
+
+// Driver returns the full state of one driver, including its current trip
+func (c *Client) Driver(ctx context.Context, id int) (*protocol.DriverDetail, error) {
+	var detail protocol.DriverDetail
+	if err := c.do(ctx, http.MethodGet, "/api/drivers/"+strconv.Itoa(id), nil, nil, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// RequestRide asks the dispatcher for a ride from pickup; a nil dropoff
+// lets the server pick one. Track the ride with Ride.
+func (c *Client) RequestRide(ctx context.Context, pickup protocol.Location, dropoff *protocol.Location) (*protocol.RideAssigned, error) {
+	var assigned protocol.RideAssigned
+	req := protocol.RequestRide{Pickup: pickup, Dropoff: dropoff}
+	if err := c.do(ctx, http.MethodPost, "/api/rides", nil, req, &assigned); err != nil {
+		return nil, err
+	}
+	return &assigned, nil
+}
+
+// ReportPosition feeds the position of a driver from a device or an
+// external simulator and returns the driver's new state. The driver stops
+// being simulated until its positions stop coming.
+func (c *Client) ReportPosition(ctx context.Context, id int, pos protocol.DriverPosition) (*protocol.DriverResponse, error) {
+	var driver protocol.DriverResponse
+	pos.DriverID = id
+	if err := c.doAdmin(ctx, http.MethodPost, "/api/drivers/"+strconv.Itoa(id)+"/position", nil, pos, &driver); err != nil {
+		return nil, err
+	}
+	return &driver, nil
+}
+
+// Cells counts the drivers per S2 cell of a level, within viewport unless
+// it is nil
+func (c *Client) Cells(ctx context.Context, level int, viewport *protocol.Viewport) (*protocol.DriverCells, error) {
+	query := url.Values{"level": {strconv.Itoa(level)}}
+	if viewport != nil {
+		query.Set("south", strconv.FormatFloat(viewport.South, 'f', -1, 64))
+		query.Set("west", strconv.FormatFloat(viewport.West, 'f', -1, 64))
+		query.Set("north", strconv.FormatFloat(viewport.North, 'f', -1, 64))
+		query.Set("east", strconv.FormatFloat(viewport.East, 'f', -1, 64))
+	}
+	var cells protocol.DriverCells
+	if err := c.do(ctx, http.MethodGet, "/api/cells", query, nil, &cells); err != nil {
+		return nil, err
+	}
+	return &cells, nil
+}
+
+// Ride returns where the driver of a ride is, or how the ride ended
+func (c *Client) Ride(ctx context.Context, id int) (*protocol.RideProgress, error) {
+	var progress protocol.RideProgress
+	if err := c.do(ctx, http.MethodGet, "/api/rides/"+strconv.Itoa(id), nil, nil, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// Config returns the simulation parameters that can be changed at runtime
+func (c *Client) Config(ctx context.Context) (*protocol.RuntimeConfig, error) {
+	var cfg protocol.RuntimeConfig
+	if err := c.do(ctx, http.MethodGet, "/api/admin/config", nil, nil, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Reconfigure changes the parameters set in patch and returns the new
+// configuration
+func (c *Client) Reconfigure(ctx context.Context, patch protocol.ConfigPatch) (*protocol.RuntimeConfig, error) {
+	var cfg protocol.RuntimeConfig
+	if err := c.doAdmin(ctx, http.MethodPatch, "/api/admin/config", nil, patch, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Stats returns the simulation statistics
+func (c *Client) Stats(ctx context.Context) (*protocol.Stats, error) {
+	var stats protocol.Stats
+	if err := c.do(ctx, http.MethodGet, "/api/stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// StatsHistory returns the statistics samples of the last minutes (all
+// the server keeps when minutes is 0), oldest first
+func (c *Client) StatsHistory(ctx context.Context, minutes float64) (*protocol.StatsHistory, error) {
+	query := url.Values{}
+	if minutes > 0 {
+		query.Set("minutes", strconv.FormatFloat(minutes, 'f', -1, 64))
+	}
+	var history protocol.StatsHistory
+	if err := c.do(ctx, http.MethodGet, "/api/stats/history", query, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// SpawnDrivers adds drivers at a location and returns their IDs
+func (c *Client) SpawnDrivers(ctx context.Context, req protocol.SpawnRequest) ([]int, error) {
+	var resp protocol.SpawnResponse
+	if err := c.doAdmin(ctx, http.MethodPost, "/api/drivers/spawn", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Spawned, nil
+}
+
+// DespawnDrivers removes drivers by ID and returns the IDs that existed
+func (c *Client) DespawnDrivers(ctx context.Context, ids []int) ([]int, error) {
+	var resp protocol.DespawnResponse
+	req := protocol.DespawnRequest{IDs: ids}
+	if err := c.doAdmin(ctx, http.MethodPost, "/api/drivers/despawn", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Removed, nil
+}
+
+// Pause freezes the simulation main loop
+func (c *Client) Pause(ctx context.Context) (*protocol.SimState, error) {
+	return c.control(ctx, "/api/sim/pause", nil)
+}
+
+// Resume continues a paused simulation
+func (c *Client) Resume(ctx context.Context) (*protocol.SimState, error) {
+	return c.control(ctx, "/api/sim/resume", nil)
+}
+
+// Step advances a paused simulation by the given number of updates
+func (c *Client) Step(ctx context.Context, ticks int) (*protocol.SimState, error) {
+	return c.control(ctx, "/api/sim/step", url.Values{"ticks": {strconv.Itoa(ticks)}})
+}
+
+func (c *Client) control(ctx context.Context, path string, query url.Values) (*protocol.SimState, error) {
+	var state protocol.SimState
+	if err := c.doAdmin(ctx, http.MethodPost, path, query, nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetSpeed changes the simulation speed multiplier
+func (c *Client) SetSpeed(ctx context.Context, speed float64) error {
+	query := url.Values{"x": {strconv.FormatFloat(speed, 'f', -1, 64)}}
+	return c.doAdmin(ctx, http.MethodPost, "/api/sim/speed", query, nil, nil)
+}
+
+// do performs an API request with the API key, encoding body as JSON and
+// decoding the response into out when both are non-nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	return c.request(ctx, c.token, method, path, query, body, out)
+}
+
+// doAdmin performs a change that needs the admin token rather than the API
+// key, like do
+func (c *Client) doAdmin(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	return c.request(ctx, c.adminToken, method, path, query, body, out)
+}
+
+func (c *Client) request(ctx context.Context, token, method, path string, query url.Values, body, out interface{}) error {
+	u := c.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// authorize adds the API key, if any, to request headers
+func (c *Client) authorize(h http.Header) {
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+}
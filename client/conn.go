@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net"
+	"net/http"
+	"quadtree/protocol"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a WebSocket connection to the simulation's live driver feed
+type Conn struct {
+	ws         *websocket.Conn
+	writeMu    sync.Mutex
+	adminToken string // sent with sim_control and driver_position
+	// Binary frames are protobuf Frames rather than MessagePack
+	protobuf atomic.Bool
+}
+
+// Connect opens a WebSocket connection to the server's /ws endpoint
+func (c *Client) Connect(ctx context.Context) (*Conn, error) {
+	u := *c.baseURL
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = "/ws"
+
+	// Ask for the current protocol, or version 2 from older servers;
+	// without either the server falls back to the legacy format
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{protocol.Subprotocol, protocol.SubprotocolFlat}
+	header := http.Header{}
+	c.authorize(header)
+	ws, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{ws: ws, adminToken: c.adminToken}, nil
+}
+
+// Subscribe sets the area the connection receives driver updates for
+func (conn *Conn) Subscribe(params protocol.ClientParams) error {
+	params.Type = protocol.TypeClientParams
+	if params.Encoding != "" {
+		conn.protobuf.Store(params.Encoding == protocol.EncodingProtobuf)
+	}
+	return conn.send(params)
+}
+
+// AddSubscription adds a subscription, or replaces the one with the same ID.
+// The server answers with a *protocol.Subscriptions through Next, and from
+// then on sends the drivers of all subscriptions instead of the Subscribe
+// area.
+func (conn *Conn) AddSubscription(sub protocol.Subscribe) error {
+	sub.Type = protocol.TypeSubscribe
+	if err := sub.Validate(); err != nil {
+		return err
+	}
+	return conn.send(sub)
+}
+
+// RemoveSubscription removes a subscription; an empty id removes all of them
+func (conn *Conn) RemoveSubscription(id string) error {
+	return conn.send(protocol.Unsubscribe{Type: protocol.TypeUnsubscribe, ID: id})
+}
+
+// Resync asks for a new snapshot, for instance after a gap in the sequence
+// numbers of the deltas
+func (conn *Conn) Resync() error {
+	return conn.send(protocol.Resync{Type: protocol.TypeResync})
+}
+
+// Follow asks for a driver's *protocol.DriverTrack on every simulation
+// update, wherever it goes. The server answers with a *protocol.Following
+// through Next.
+func (conn *Conn) Follow(driverID int) error {
+	return conn.send(protocol.Follow{Type: protocol.TypeFollow, DriverID: driverID})
+}
+
+// Unfollow stops following a driver; 0 stops following all of them
+func (conn *Conn) Unfollow(driverID int) error {
+	return conn.send(protocol.Unfollow{Type: protocol.TypeUnfollow, DriverID: driverID})
+}
+
+// RequestRide asks for a ride from pickup; a nil dropoff lets the server
+// pick one. The server answers with a *protocol.RideAssigned (or a
+// *protocol.ErrorMessage) through Next, then sends a *protocol.RideProgress
+// on every update until the trip ends.
+func (conn *Conn) RequestRide(pickup protocol.Location, dropoff *protocol.Location) error {
+	req := protocol.RequestRide{Type: protocol.TypeRequestRide, Pickup: pickup, Dropoff: dropoff}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	return conn.send(req)
+}
+
+// ReportPosition feeds the position of a driver from a device or an
+// external simulator. The server only answers with a *protocol.ErrorMessage
+// through Next, when it refuses the position.
+func (conn *Conn) ReportPosition(pos protocol.DriverPosition) error {
+	pos.Type, pos.Token = protocol.TypeDriverPosition, conn.adminToken
+	if err := pos.Validate(); err != nil {
+		return err
+	}
+	return conn.send(pos)
+}
+
+// WatchCells asks for the drivers counted per S2 cell of a level, within
+// viewport unless it is nil. A *protocol.DriverCells arrives through Next
+// right away and every second after; level 0 stops them.
+func (conn *Conn) WatchCells(level int, viewport *protocol.Viewport) error {
+	msg := protocol.WatchCells{Type: protocol.TypeWatchCells, Level: level, Viewport: viewport}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	return conn.send(msg)
+}
+
+// Control sends a sim_control admin message ("pause", "resume", "step" or
+// "state"); the resulting *protocol.SimState arrives through Next. All but
+// "state" need the admin token of WithAdminToken.
+func (conn *Conn) Control(action string, ticks int) error {
+	return conn.send(protocol.SimControlMessage{
+		Type:   protocol.TypeSimControl,
+		Action: action,
+		Ticks:  ticks,
+		Token:  conn.adminToken,
+	})
+}
+
+func (conn *Conn) send(v interface{}) error {
+	if conn.ws.Subprotocol() == protocol.Subprotocol {
+		v = protocol.Wrap(v)
+	}
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	return conn.ws.WriteJSON(v)
+}
+
+// Next blocks until the next server message arrives and returns it as a
+// typed pointer such as *protocol.DriversUpdate or *protocol.SimState.
+// Messages of unknown types are skipped.
+func (conn *Conn) Next() (interface{}, error) {
+	for {
+		messageType, data, err := conn.ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		// Driver updates arrive as binary frames after subscribing with
+		// Encoding: protocol.EncodingMsgpack, and so do subscriptions and
+		// events with protocol.EncodingProtobuf
+		var msg interface{}
+		if messageType == websocket.BinaryMessage && conn.protobuf.Load() {
+			msg, _, err = protocol.DecodeFrame(data)
+		} else if messageType == websocket.BinaryMessage {
+			msg, err = protocol.DecodeMsgpack(data)
+		} else {
+			msg, err = protocol.Decode(data)
+		}
+		if err != nil {
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// Updates iterates over driver updates, including snapshots, until the
+// connection fails or the loop is exited. Other message types are skipped.
+// A connection closed normally by either side ends the iteration without an
+// error.
+func (conn *Conn) Updates() iter.Seq2[*protocol.DriversUpdate, error] {
+	return func(yield func(*protocol.DriversUpdate, error) bool) {
+		for {
+			msg, err := conn.Next()
+			if err != nil {
+				if !isNormalClose(err) {
+					yield(nil, err)
+				}
+				return
+			}
+
+			update, ok := msg.(*protocol.DriversUpdate)
+			if snapshot, isSnapshot := msg.(*protocol.DriversSnapshot); isSnapshot {
+				update, ok = (*protocol.DriversUpdate)(snapshot), true
+			}
+			if ok && !yield(update, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection, telling the server it's a normal closure
+func (conn *Conn) Close() error {
+	conn.writeMu.Lock()
+	conn.ws.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.writeMu.Unlock()
+	return conn.ws.Close()
+}
+
+// isNormalClose reports whether err is an orderly connection shutdown
+func isNormalClose(err error) bool {
+	return websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) ||
+		errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"quadtree/protocol"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client transports
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportSocketIO  = "socketio"
+)
+
+// clientMetrics counts what a client was sent. Its writer and the send
+// queue update the counters while /api/admin/clients reads them.
+type clientMetrics struct {
+	messages  atomic.Int64 // data frames written
+	bytes     atomic.Int64 // their payload, before compression
+	dropped   atomic.Int64 // frames dropped from the full queue
+	stale     atomic.Int64 // driver updates replaced before they were written
+	lastWrite atomic.Int64 // how long the last write took
+	maxWrite  atomic.Int64 // how long the slowest write took
+}
+
+// wrote counts a frame the writer sent, and how long writing it took
+func (m *clientMetrics) wrote(msg outbound, took time.Duration) {
+	if msg.messageType == websocket.TextMessage || msg.messageType == websocket.BinaryMessage {
+		m.messages.Add(1)
+		m.bytes.Add(int64(len(msg.data)))
+	}
+	m.lastWrite.Store(int64(took))
+	for {
+		longest := m.maxWrite.Load()
+		if int64(took) <= longest || m.maxWrite.CompareAndSwap(longest, int64(took)) {
+			return
+		}
+	}
+}
+
+// clientInfo describes the client for /api/admin/clients
+func (s *Simulation) clientInfo(client *WebSocketClient) protocol.ClientInfo {
+	cfg := client.settings()
+	every := client.intervalTicks.Load()
+	if every == 0 {
+		every = int64(s.tunables.Load().ClientInterval / broadcastTick)
+	}
+
+	info := protocol.ClientInfo{
+		ID:           client.clientID,
+		Identity:     client.identity,
+		Transport:    client.transport,
+		Remote:       client.remoteAddr,
+		ConnectedAt:  client.connectedAt.UnixNano() / int64(time.Millisecond),
+		Version:      cfg.version,
+		Encoding:     cfg.encoding,
+		IntervalMs:   (time.Duration(every) * broadcastTick).Milliseconds(),
+		Deltas:       cfg.deltas,
+		Lat:          cfg.lat,
+		Lon:          cfg.lon,
+		Radius:       cfg.radius,
+		City:         cfg.city,
+		Filter:       cfg.filter != nil,
+		Topics:       len(s.hub.Topics(client.hubSub)),
+		MessagesSent: client.metrics.messages.Load(),
+		BytesSent:    client.metrics.bytes.Load(),
+		QueueDepth:   len(client.send),
+		QueueSize:    cap(client.send),
+		Dropped:      client.metrics.dropped.Load(),
+		StaleUpdates: client.metrics.stale.Load(),
+		LastWriteMs:  float64(client.metrics.lastWrite.Load()) / float64(time.Millisecond),
+		MaxWriteMs:   float64(client.metrics.maxWrite.Load()) / float64(time.Millisecond),
+	}
+
+	client.subsMu.Lock()
+	for id := range client.subscriptions {
+		info.Subscriptions = append(info.Subscriptions, id)
+	}
+	for id := range client.follows {
+		info.Follows = append(info.Follows, id)
+	}
+	client.subsMu.Unlock()
+	slices.Sort(info.Subscriptions)
+	slices.Sort(info.Follows)
+
+	client.queueMu.Lock()
+	if !client.saturatedSince.IsZero() {
+		info.SaturatedMs = time.Since(client.saturatedSince).Milliseconds()
+	}
+	client.queueMu.Unlock()
+	return info
+}
+
+// ClientsHandler lists the connected clients with their settings and
+// traffic. The most backed up come first: the fullest queues, then the
+// slowest writes.
+func (s *Simulation) ClientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.clientsMu.RLock()
+	clients := make([]*WebSocketClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+
+	resp := protocol.ClientsResponse{Clients: make([]protocol.ClientInfo, 0, len(clients))}
+	for _, client := range clients {
+		resp.Clients = append(resp.Clients, s.clientInfo(client))
+	}
+	sort.Slice(resp.Clients, func(i, j int) bool {
+		a, b := resp.Clients[i], resp.Clients[j]
+		if a.QueueDepth != b.QueueDepth {
+			return a.QueueDepth > b.QueueDepth
+		}
+		if a.MaxWriteMs != b.MaxWriteMs {
+			return a.MaxWriteMs > b.MaxWriteMs
+		}
+		return a.ID < b.ID
+	})
+	resp.Count = len(resp.Clients)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTimeScale caps the speed multiplier so a single update can't move
+// drivers across the whole world
+const maxTimeScale = 1000
+
+// SimClock is the virtual clock of the simulation. It runs at timeScale
+// times real time, and changing the scale keeps virtual time continuous.
+// A manual clock instead only moves when the main loop advances it, which
+// makes virtual time a pure function of the updates run (deterministic mode).
+type SimClock struct {
+	mu        sync.RWMutex
+	scale     float64
+	paused    bool
+	manual    bool
+	virtBase  time.Time // virtual time at the last scale change
+	realBase  time.Time // real time at the last scale change
+	startedAt time.Time // virtual time when the clock was created
+}
+
+// NewSimClock creates a clock starting at the current time with the given scale
+func NewSimClock(scale float64) *SimClock {
+	now := time.Now()
+	return &SimClock{
+		scale:     scale,
+		virtBase:  now,
+		realBase:  now,
+		startedAt: now,
+	}
+}
+
+// NewManualClock creates a clock that starts at the given time and only
+// moves forward through Advance
+func NewManualClock(start time.Time, scale float64) *SimClock {
+	return &SimClock{
+		scale:     scale,
+		manual:    true,
+		virtBase:  start,
+		realBase:  start,
+		startedAt: start,
+	}
+}
+
+// useClock replaces the simulation clock, along with the demand generator's
+// reference to it. It must be called before the simulation starts.
+func (s *Simulation) useClock(clock *SimClock) {
+	s.clock = clock
+	s.demand.clock = clock
+}
+
+// Now returns the current virtual time
+func (c *SimClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now()
+}
+
+// now returns the current virtual time; c.mu must be held
+func (c *SimClock) now() time.Time {
+	if c.paused || c.manual {
+		return c.virtBase
+	}
+	return c.virtBase.Add(time.Duration(float64(time.Since(c.realBase)) * c.scale))
+}
+
+// Elapsed returns the virtual time elapsed since the clock was created
+func (c *SimClock) Elapsed() time.Duration {
+	return c.Now().Sub(c.startedAt)
+}
+
+// Scale returns the current speed multiplier
+func (c *SimClock) Scale() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.scale
+}
+
+// SetScale changes the speed multiplier without jumping the virtual time
+func (c *SimClock) SetScale(scale float64) error {
+	if err := validateTimeScale(scale); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.virtBase = c.now()
+	c.realBase = time.Now()
+	c.scale = scale
+	return nil
+}
+
+// Pause freezes the virtual clock
+func (c *SimClock) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.virtBase = c.now()
+	c.paused = true
+}
+
+// Resume restarts a paused clock from where it was frozen
+func (c *SimClock) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.realBase = time.Now()
+	c.paused = false
+}
+
+// Paused reports whether the clock is frozen
+func (c *SimClock) Paused() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.paused
+}
+
+// Manual reports whether the clock only moves through Advance
+func (c *SimClock) Manual() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.manual
+}
+
+// Advance moves a paused or manual clock forward by d; it has no effect on a
+// running real-time clock
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused || c.manual {
+		c.virtBase = c.virtBase.Add(d)
+	}
+}
+
+// validateTimeScale checks that a speed multiplier is usable
+func validateTimeScale(scale float64) error {
+	if math.IsNaN(scale) || scale <= 0 || scale > maxTimeScale {
+		return fmt.Errorf("speed must be between 0 and %d", maxTimeScale)
+	}
+	return nil
+}
+
+// scaledChance converts a per-update probability into the probability for a
+// step of the given number of updates, so random events happen at the same
+// rate regardless of how the simulated time is sliced
+func scaledChance(p, updates float64) float64 {
+	if updates == 1 {
+		return p
+	}
+	return 1 - math.Pow(1-p, updates)
+}
+
+// SpeedHandler reports (GET) or changes (POST ?x=10 or {"speed":10}) the
+// simulation speed multiplier
+func (s *Simulation) SpeedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		var speed float64
+		if x := r.URL.Query().Get("x"); x != "" {
+			val, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				http.Error(w, "invalid speed", http.StatusBadRequest)
+				return
+			}
+			speed = val
+		} else {
+			var body struct {
+				Speed float64 `json:"speed"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid speed: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			speed = body.Speed
+		}
+
+		if err := s.clock.SetScale(speed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.audit(protocol.AuditAdmin, protocol.AdminEvent{Action: "speed", Params: map[string]float64{"speed": speed}})
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(protocol.SpeedState{
+		Speed:       s.clock.Scale(),
+		VirtualTime: s.clock.Now().UnixNano() / int64(time.Millisecond),
+		ElapsedS:    s.clock.Elapsed().Seconds(),
+	})
+}
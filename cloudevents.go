@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/url"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCloudEventsSource = "/taxi-sim"
+
+// cloudEventSource wraps the events sent to webhooks, Kafka and NATS in
+// CloudEvents envelopes, so routers like Knative or EventBridge can match
+// them on their type without knowing the simulation's messages
+type cloudEventSource struct {
+	source string
+	// Starts every ID: trip and webhook event IDs start over with each
+	// server run, and CloudEvents IDs must not repeat within a source
+	run string
+}
+
+// EnableCloudEvents sends the webhook, Kafka and NATS events as CloudEvents
+// from source, a URI reference. It must be called before the outputs are
+// started.
+func (s *Simulation) EnableCloudEvents(source string) error {
+	if source == "" {
+		return errors.New("empty CloudEvents source")
+	}
+	if _, err := url.Parse(source); err != nil {
+		return fmt.Errorf("invalid CloudEvents source %q: %w", source, err)
+	}
+	s.cloudEvents = &cloudEventSource{source: source, run: strings.ToLower(rand.Text()[:8])}
+	return nil
+}
+
+// tripEvent wraps a trip event. Its ID is the same on every output, so
+// consumers of several can drop the copies.
+func (c *cloudEventSource) tripEvent(event protocol.TripEvent) protocol.CloudEvent {
+	return c.envelope(fmt.Sprintf("trip-%d-%s", event.TripID, event.Event), protocol.CloudEventTripPrefix+event.Event,
+		"trips/"+strconv.Itoa(event.TripID), event.Time, event)
+}
+
+// webhookEvent wraps the data of a webhook event
+func (c *cloudEventSource) webhookEvent(event protocol.WebhookEvent) protocol.CloudEvent {
+	id := strconv.FormatInt(event.ID, 10)
+	switch data := event.Data.(type) {
+	case protocol.TripEvent:
+		return c.tripEvent(data)
+	case protocol.ZoneEvent:
+		typ := protocol.CloudEventZoneEntered
+		if event.Type == protocol.WebhookZoneExited {
+			typ = protocol.CloudEventZoneExited
+		}
+		return c.envelope(id, typ, "drivers/"+strconv.Itoa(data.DriverID), event.Time, data)
+	case protocol.DriverStatusChanged:
+		return c.envelope(id, protocol.CloudEventDriverOffline, "drivers/"+strconv.Itoa(data.DriverID), event.Time, data)
+	}
+	return c.envelope(id, "taxi."+event.Type, "", event.Time, event.Data)
+}
+
+// envelope wraps data that happened at virtual time ms
+func (c *cloudEventSource) envelope(id, typ, subject string, ms int64, data interface{}) protocol.CloudEvent {
+	return protocol.CloudEvent{
+		SpecVersion:     protocol.CloudEventsSpecVersion,
+		ID:              c.run + "-" + id,
+		Source:          c.source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            time.UnixMilli(ms).UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
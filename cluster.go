@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"quadtree/redisgeo"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultClusterPrefix = "taxi"
+
+	// The leader holds its lease for clusterLease and renews it every
+	// clusterRenewInterval; a follower takes over once a lease runs out
+	clusterLease         = 5 * time.Second
+	clusterRenewInterval = 1 * time.Second
+	// A subscription that hears nothing for this long is opened again, in
+	// case the connection died quietly
+	clusterSilence = 3 * clusterLease
+
+	// Messages waiting to be published (see offer), and leader events
+	// waiting for a follower's main loop; the ones dropped count towards
+	// cluster_dropped in /api/diag
+	clusterQueueSize = 1000
+)
+
+// errClusterFollower is returned for changes made on a server that follows
+// the cluster leader, whose fleet it only mirrors
+var errClusterFollower = errors.New("this server follows the cluster leader; make changes there")
+
+// ClusterConfig configures sharing the fleet with other servers through
+// Redis
+type ClusterConfig struct {
+	URL    string // redis://[:password@]host[:port][/db]
+	Prefix string // of the keys and channels: {prefix}:cluster:...
+	Node   string // this server's name in the cluster
+}
+
+// clusterNode is a server's part in a cluster. One server, the leader,
+// simulates the fleet and publishes every update and event; the others
+// follow, mirroring the fleet and relaying the events to their own
+// clients, so clients see the same fleet whichever server they reach.
+type clusterNode struct {
+	cfg    ClusterConfig
+	client *redisgeo.Client
+
+	// Switched on the main loop only, but read by handlers too
+	leading atomic.Bool
+	leader  atomic.Value // name of the current leader, once known
+
+	out    chan clusterMessage // to publish, filled from the main loop
+	frames chan *clusterFrame  // the leader's latest update
+	events chan *clusterEvent  // the leader's events
+}
+
+// clusterMessage is a message to publish
+type clusterMessage struct {
+	channel string
+	payload []byte
+}
+
+// clusterFrame is an update of the leader: every driver it simulates
+type clusterFrame struct {
+	Node    string          `json:"node"`
+	Dt      float64         `json:"dt"` // simulated seconds covered by the update
+	Drivers []clusterDriver `json:"drivers"`
+}
+
+// clusterDriver is a driver in a frame, with what a follower needs to
+// create it
+type clusterDriver struct {
+	RecordedDriver
+	Vehicle string `json:"vehicle,omitempty"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// clusterEvent is a message the leader broadcast, in the encodings its
+// clients get
+type clusterEvent struct {
+	Node      string          `json:"node"`
+	Kind      string          `json:"kind"`
+	Flat      json.RawMessage `json:"flat"`
+	Enveloped json.RawMessage `json:"enveloped"`
+	Proto     []byte          `json:"proto,omitempty"`
+}
+
+func (c *clusterNode) key(name string) string {
+	return c.cfg.Prefix + ":cluster:" + name
+}
+
+// JoinCluster connects to Redis and starts taking part in the cluster
+// until ctx is canceled. The server follows until it wins the leader's
+// lease, which happens right away when no other server holds it.
+func (s *Simulation) JoinCluster(ctx context.Context, cfg ClusterConfig) error {
+	if cfg.Node == "" {
+		return errors.New("empty cluster node name")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultClusterPrefix
+	}
+	client, err := redisgeo.Dial(cfg.URL)
+	if err != nil {
+		return err
+	}
+	c := &clusterNode{
+		cfg:    cfg,
+		client: client,
+		out:    make(chan clusterMessage, clusterQueueSize),
+		frames: make(chan *clusterFrame, 1),
+		events: make(chan *clusterEvent, clusterQueueSize),
+	}
+	c.leader.Store("")
+	s.cluster = c
+	slog.Info("Joining cluster", "node", cfg.Node, "prefix", cfg.Prefix)
+
+	s.goTracked(func() { s.runClusterLease(ctx, c) })
+	s.goTracked(func() { s.publishCluster(ctx, c) })
+	s.goTracked(func() { s.subscribeCluster(ctx, c) })
+	return nil
+}
+
+// renewLease extends the lease if this node still holds it
+const renewLease = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+// runClusterLease takes the leader's lease when it is free and renews it
+// while leading, switching the simulation between leading and following.
+// A leader that can't renew in time steps down, since another server may
+// have taken over.
+func (s *Simulation) runClusterLease(ctx context.Context, c *clusterNode) {
+	key, ttl := c.key("leader"), fmt.Sprint(clusterLease.Milliseconds())
+	ticker := time.NewTicker(clusterRenewInterval)
+	defer ticker.Stop()
+	var renewed time.Time
+	failing := false
+	for {
+		var err error
+		if c.leading.Load() {
+			var reply any
+			if reply, err = c.client.Do("EVAL", renewLease, "1", key, c.cfg.Node, ttl); err == nil {
+				if reply == int64(1) {
+					renewed = time.Now()
+				} else {
+					s.setClusterLeading(ctx, c, false, "lease lost")
+				}
+			} else if time.Since(renewed) >= clusterLease {
+				s.setClusterLeading(ctx, c, false, "lease not renewed")
+			}
+		} else {
+			var reply any
+			if reply, err = c.client.Do("SET", key, c.cfg.Node, "NX", "PX", ttl); err == nil && reply == "OK" {
+				renewed = time.Now()
+				s.setClusterLeading(ctx, c, true, "lease free")
+			} else if err == nil {
+				if leader, _ := c.client.Do("GET", key); leader != nil {
+					c.leader.Store(fmt.Sprint(leader))
+				}
+			}
+		}
+		if err != nil && !failing {
+			slog.Warn("Cluster lease failed", "err", err)
+		} else if err == nil && failing {
+			slog.Info("Cluster lease recovered")
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			if c.leading.Load() {
+				// Hand over right away rather than after the lease runs out
+				c.client.Do("EVAL", `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`, "1", key, c.cfg.Node)
+			}
+			c.client.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setClusterLeading switches the simulation to leading or following on
+// the main loop
+func (s *Simulation) setClusterLeading(ctx context.Context, c *clusterNode, leading bool, reason string) {
+	s.exec(ctx, func() error {
+		if c.leading.Load() == leading {
+			return nil
+		}
+		c.leading.Store(leading)
+		if leading {
+			c.leader.Store(c.cfg.Node)
+			// Carry on from the mirrored fleet; statuses announced by the
+			// old leader aren't changes
+			for _, driver := range s.drivers {
+				driver.mu.Lock()
+				driver.reportedStatus, driver.statusReported = driver.Status, true
+				driver.mu.Unlock()
+			}
+			slog.Info("Leading the cluster", "node", c.cfg.Node, "reason", reason, "drivers", len(s.drivers))
+		} else {
+			// The new leader's fleet replaces this one, trips and all
+			ids := make([]int, 0, len(s.drivers))
+			for _, driver := range s.drivers {
+				ids = append(ids, driver.ID)
+			}
+			s.dropTrips(ids)
+			slog.Warn("Following the cluster", "node", c.cfg.Node, "reason", reason)
+		}
+		return nil
+	})
+}
+
+// mirroring reports an error when the server shows a fleet simulated
+// elsewhere and can't change it: it follows a cluster leader, which the
+// error names, or gathers shards
+func (s *Simulation) mirroring() error {
+	if s.gateway != nil {
+		return errShardGateway
+	}
+	if s.cluster == nil || s.cluster.leading.Load() {
+		return nil
+	}
+	if leader := s.cluster.leader.Load().(string); leader != "" {
+		return fmt.Errorf("%w (%s)", errClusterFollower, leader)
+	}
+	return errClusterFollower
+}
+
+// publishCluster publishes the queued messages, a batch at a time
+func (s *Simulation) publishCluster(ctx context.Context, c *clusterNode) {
+	failing := false
+	for {
+		var batch []clusterMessage
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-c.out:
+			batch = append(batch, msg)
+		}
+	more:
+		for {
+			select {
+			case msg := <-c.out:
+				batch = append(batch, msg)
+			default:
+				break more
+			}
+		}
+
+		cmds := make([][]string, len(batch))
+		for i, msg := range batch {
+			cmds[i] = []string{"PUBLISH", msg.channel, string(msg.payload)}
+		}
+		_, err := c.client.Pipeline(cmds)
+		switch {
+		case err == nil:
+			s.clusterPublished.Add(int64(len(batch)))
+			if failing {
+				slog.Info("Cluster publishing recovered")
+				failing = false
+			}
+		default:
+			s.clusterDropped.Add(int64(len(batch)))
+			if !failing {
+				slog.Warn("Cluster publishing failed", "messages", len(batch), "err", err)
+				failing = true
+			}
+		}
+	}
+}
+
+// subscribeCluster passes the leader's frames and events on to the main
+// loop, subscribing again with backoff when the connection drops. A frame
+// replaces one the main loop hasn't taken yet.
+func (s *Simulation) subscribeCluster(ctx context.Context, c *clusterNode) {
+	framesKey, eventsKey := c.key("frames"), c.key("events")
+	retry := peerRetryMin
+	for {
+		sub, err := redisgeo.Subscribe(c.cfg.URL, framesKey, eventsKey)
+		if err == nil {
+			retry = peerRetryMin
+			stop := context.AfterFunc(ctx, func() { sub.Close() })
+			for {
+				var channel, payload string
+				if channel, payload, err = sub.Receive(clusterSilence); err != nil {
+					break
+				}
+				if c.leading.Load() {
+					continue
+				}
+				s.receiveCluster(c, channel == framesKey, []byte(payload))
+			}
+			stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Debug("Cluster subscription ended", "err", err, "retry_in", retry)
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		retry = min(retry*2, peerRetryMax)
+	}
+}
+
+// receiveCluster queues a frame or event from the leader for the main loop
+func (s *Simulation) receiveCluster(c *clusterNode, isFrame bool, payload []byte) {
+	if isFrame {
+		var frame clusterFrame
+		if err := json.Unmarshal(payload, &frame); err != nil || frame.Node == c.cfg.Node {
+			return
+		}
+		c.leader.Store(frame.Node)
+		select {
+		case <-c.frames:
+			s.clusterDropped.Add(1)
+		default:
+		}
+		c.frames <- &frame
+		s.clusterReceived.Add(1)
+		return
+	}
+
+	var event clusterEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.Node == c.cfg.Node {
+		return
+	}
+	if offer(c.events, &event, &s.clusterDropped) {
+		s.clusterReceived.Add(1)
+	}
+}
+
+// publishClusterFrame queues the state of the fleet for the followers:
+// the simulated drivers and the ones fed from devices, but not those of
+// federation peers, which every server can merge for itself. It must run
+// on the main loop.
+func (s *Simulation) publishClusterFrame(dt float64) {
+	frame := clusterFrame{Node: s.cluster.cfg.Node, Dt: dt, Drivers: make([]clusterDriver, 0, len(s.drivers))}
+	for _, driver := range s.drivers {
+		if driver.Origin != "" && driver.Origin != deviceOrigin {
+			continue
+		}
+		driver.mu.Lock()
+		frame.Drivers = append(frame.Drivers, clusterDriver{
+			RecordedDriver: RecordedDriver{
+				ID:      driver.ID,
+				Lon:     driver.lon(),
+				Lat:     driver.lat(),
+				Status:  driver.Status,
+				Speed:   driver.speed(),
+				Heading: driver.heading(),
+			},
+			Vehicle: driver.Vehicle,
+			Profile: driver.behavior().Name,
+		})
+		driver.mu.Unlock()
+	}
+	s.publishClusterMessage("frames", frame)
+}
+
+// publishClusterEvent queues a broadcast message for the followers' clients
+func (s *Simulation) publishClusterEvent(frames *eventFrames) {
+	s.publishClusterMessage("events", clusterEvent{
+		Node:      s.cluster.cfg.Node,
+		Kind:      frames.kind,
+		Flat:      frames.flat,
+		Enveloped: frames.enveloped,
+		Proto:     frames.proto,
+	})
+}
+
+func (s *Simulation) publishClusterMessage(name string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Encoding cluster message failed", "err", err)
+		return
+	}
+	offer(s.cluster.out, clusterMessage{channel: s.cluster.key(name), payload: payload}, &s.clusterDropped)
+}
+
+// followCluster is the update of a follower: the fleet takes the state of
+// the leader's latest frame, and the leader's events go out to the local
+// clients. It must run on the main loop.
+func (s *Simulation) followCluster() {
+	select {
+	case frame := <-s.cluster.frames:
+		s.mirrorFrame(frame)
+		s.UpdateIndex()
+	default:
+	}
+	s.publishDrivers()
+	for {
+		select {
+		case event := <-s.cluster.events:
+			s.hub.Publish(topicEvents, &eventFrames{kind: event.Kind, flat: event.Flat, enveloped: event.Enveloped, proto: event.Proto})
+			continue
+		default:
+		}
+		break
+	}
+	s.publishDriverTracks()
+}
+
+// mirrorFrame makes the drivers those of a leader's frame: drivers new to
+// the frame are created, ones missing from it removed, and the rest moved
+// like a replay moves them. Federated drivers are left alone. It must run
+// on the main loop.
+func (s *Simulation) mirrorFrame(frame *clusterFrame) {
+	inFrame := make(map[int]bool, len(frame.Drivers))
+	var added []*Driver
+	for _, cd := range frame.Drivers {
+		inFrame[cd.ID] = true
+		profile := behaviorProfiles[0]
+		for _, p := range behaviorProfiles {
+			if p.Name == cd.Profile {
+				profile = p
+			}
+		}
+		if driver, ok := s.driverByID(cd.ID); ok {
+			driver.mu.Lock()
+			driver.Vehicle, driver.profile = cd.Vehicle, profile
+			// It goes where the leader takes it
+			driver.dest, driver.trace = nil, nil
+			driver.mu.Unlock()
+			continue
+		}
+		added = append(added, &Driver{
+			ID:      cd.ID,
+			Status:  cd.Status,
+			Vehicle: cd.Vehicle,
+			profile: profile,
+			motion:  newMotion(cd.Lon, cd.Lat, cd.Speed, cd.Heading),
+		})
+		s.nextDriverID = max(s.nextDriverID, cd.ID)
+	}
+	var gone []int
+	for _, driver := range s.drivers {
+		if (driver.Origin == "" || driver.Origin == deviceOrigin) && !inFrame[driver.ID] {
+			gone = append(gone, driver.ID)
+		}
+	}
+	if len(gone) > 0 {
+		s.removeDrivers(gone)
+	}
+	if len(added) > 0 {
+		s.addDrivers(added)
+	}
+
+	recorded := recordLine{Type: "frame", Dt: frame.Dt, Drivers: make([]RecordedDriver, len(frame.Drivers))}
+	for i, cd := range frame.Drivers {
+		recorded.Drivers[i] = cd.RecordedDriver
+	}
+	s.ApplyFrame(&recorded)
+}
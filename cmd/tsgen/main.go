@@ -0,0 +1,479 @@
+// Command tsgen generates TypeScript definitions and a JSON schema for the
+// wire messages in the protocol package, and an OpenAPI document for its
+// HTTP endpoints. It is run via go generate:
+//
+//	go generate ./protocol
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"net/http"
+	"os"
+	"quadtree/protocol"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// field is a JSON-visible struct field
+type field struct {
+	name     string // JSON name
+	goType   reflect.Type
+	optional bool
+	doc      string
+}
+
+// generator collects the named types reachable from the registry
+type generator struct {
+	docs   map[string]string // "Type" and "Type.Field" -> doc comment
+	order  []reflect.Type
+	seen   map[reflect.Type]bool
+	consts map[reflect.Type]string // message type -> "type" field value
+}
+
+func main() {
+	tsPath := flag.String("ts", "", "write TypeScript definitions to this file")
+	schemaPath := flag.String("schema", "", "write the JSON schema to this file")
+	openapiPath := flag.String("openapi", "", "write the OpenAPI document of the HTTP API to this file")
+	srcDir := flag.String("src", ".", "directory of the protocol package sources (for doc comments)")
+	flag.Parse()
+
+	g := &generator{
+		docs:   make(map[string]string),
+		seen:   make(map[reflect.Type]bool),
+		consts: make(map[reflect.Type]string),
+	}
+	if err := g.loadDocs(*srcDir); err != nil {
+		log.Fatalf("tsgen: %v", err)
+	}
+
+	for _, info := range protocol.Registry {
+		t := reflect.TypeOf(info.Value)
+		if info.Type != "" {
+			g.consts[t] = info.Type
+		}
+		g.collect(t)
+	}
+	for _, ep := range protocol.Endpoints {
+		for _, v := range []interface{}{ep.Request, ep.Response} {
+			if v != nil {
+				g.collect(reflect.TypeOf(v))
+			}
+		}
+	}
+
+	if *tsPath != "" {
+		if err := os.WriteFile(*tsPath, g.typescript(), 0644); err != nil {
+			log.Fatalf("tsgen: %v", err)
+		}
+	}
+	if *schemaPath != "" {
+		data, err := g.schema()
+		if err != nil {
+			log.Fatalf("tsgen: %v", err)
+		}
+		if err := os.WriteFile(*schemaPath, data, 0644); err != nil {
+			log.Fatalf("tsgen: %v", err)
+		}
+	}
+	if *openapiPath != "" {
+		data, err := g.openapi()
+		if err != nil {
+			log.Fatalf("tsgen: %v", err)
+		}
+		if err := os.WriteFile(*openapiPath, data, 0644); err != nil {
+			log.Fatalf("tsgen: %v", err)
+		}
+	}
+}
+
+// loadDocs reads type and field doc comments from the package sources
+func (g *generator) loadDocs(dir string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if gen.Doc != nil {
+						g.docs[ts.Name.Name] = strings.TrimSpace(gen.Doc.Text())
+					}
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, f := range st.Fields.List {
+						doc := f.Doc
+						if doc == nil {
+							doc = f.Comment
+						}
+						if doc == nil {
+							continue
+						}
+						for _, name := range f.Names {
+							g.docs[ts.Name.Name+"."+name.Name] = strings.TrimSpace(doc.Text())
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// collect records t and every named struct type it references
+func (g *generator) collect(t reflect.Type) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || g.seen[t] {
+		return
+	}
+
+	g.seen[t] = true
+	for _, f := range g.fields(t) {
+		g.collect(f.goType)
+	}
+	g.order = append(g.order, t)
+}
+
+// fields returns the JSON-visible fields of a struct type
+func (g *generator) fields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name := sf.Name
+		optional := false
+		if tag, ok := sf.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" || opt == "omitzero" {
+					optional = true
+				}
+			}
+		}
+
+		fields = append(fields, field{
+			name:     name,
+			goType:   sf.Type,
+			optional: optional,
+			doc:      g.docs[t.Name()+"."+sf.Name],
+		})
+	}
+	return fields
+}
+
+// tsType returns the TypeScript type for a Go type
+func tsType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return tsType(t.Elem()) + " | null"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		elem := tsType(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + tsType(t.Elem()) + ">"
+	case reflect.Struct:
+		if t == timeType {
+			return "string"
+		}
+		return t.Name()
+	default:
+		return "unknown"
+	}
+}
+
+// typescript renders the collected types as TypeScript definitions
+func (g *generator) typescript() []byte {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by cmd/tsgen from the protocol package. DO NOT EDIT.\n")
+
+	for _, t := range g.order {
+		b.WriteString("\n")
+		writeTSDoc(&b, "", g.docs[t.Name()])
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+		for _, f := range g.fields(t) {
+			writeTSDoc(&b, "  ", f.doc)
+			typ := tsType(f.goType)
+			if c, ok := g.consts[t]; ok && f.name == "type" {
+				typ = fmt.Sprintf("%q", c)
+			}
+			opt := ""
+			if f.optional {
+				opt = "?"
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.name, opt, typ)
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, union := range []struct{ name, direction, doc string }{
+		{"ClientMessage", "client", "Any message a client can send over the WebSocket"},
+		{"ServerMessage", "server", "Any message the server can send over the WebSocket"},
+	} {
+		var members []string
+		for _, info := range protocol.Registry {
+			if info.Direction == union.direction {
+				members = append(members, reflect.TypeOf(info.Value).Name())
+			}
+		}
+		b.WriteString("\n")
+		writeTSDoc(&b, "", union.doc)
+		fmt.Fprintf(&b, "export type %s = %s;\n", union.name, strings.Join(members, " | "))
+	}
+
+	return b.Bytes()
+}
+
+func writeTSDoc(b *bytes.Buffer, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	lines := strings.Split(doc, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, doc)
+		return
+	}
+	fmt.Fprintf(b, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(b, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s */\n", indent)
+}
+
+// jsonSchema returns the JSON schema for a Go type. Named structs are
+// referenced as refs followed by their name.
+func jsonSchema(t reflect.Type, refs string) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{"anyOf": []interface{}{
+			jsonSchema(t.Elem(), refs),
+			map[string]interface{}{"type": "null"},
+		}}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), refs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), refs)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return map[string]interface{}{"$ref": refs + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// definitions returns the JSON schemas of the collected types by name
+func (g *generator) definitions(refs string) map[string]interface{} {
+	defs := make(map[string]interface{}, len(g.order))
+	for _, t := range g.order {
+		props := make(map[string]interface{})
+		required := []string{}
+		for _, f := range g.fields(t) {
+			prop := jsonSchema(f.goType, refs)
+			if c, ok := g.consts[t]; ok && f.name == "type" {
+				prop = map[string]interface{}{"const": c}
+			}
+			if f.doc != "" {
+				prop["description"] = f.doc
+			}
+			props[f.name] = prop
+			if !f.optional {
+				required = append(required, f.name)
+			}
+		}
+		sort.Strings(required)
+
+		def := map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+			"required":   required,
+		}
+		if doc := g.docs[t.Name()]; doc != "" {
+			def["description"] = doc
+		}
+		defs[t.Name()] = def
+	}
+	return defs
+}
+
+// schema renders the collected types as a JSON schema document
+func (g *generator) schema() ([]byte, error) {
+	defs := g.definitions("#/$defs/")
+	refs := func(direction string) []interface{} {
+		var out []interface{}
+		for _, info := range protocol.Registry {
+			if info.Direction == direction {
+				out = append(out, map[string]interface{}{"$ref": "#/$defs/" + reflect.TypeOf(info.Value).Name()})
+			}
+		}
+		return out
+	}
+	defs["ClientMessage"] = map[string]interface{}{"oneOf": refs("client")}
+	defs["ServerMessage"] = map[string]interface{}{"oneOf": refs("server")}
+
+	doc := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Taxi simulation protocol",
+		"$defs":   defs,
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// openapi renders protocol.Endpoints as an OpenAPI 3.1 document, with the
+// collected types as its component schemas
+func (g *generator) openapi() ([]byte, error) {
+	const refs = "#/components/schemas/"
+	textError := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		},
+	}
+	security := []interface{}{
+		map[string]interface{}{"bearer": []string{}},
+		map[string]interface{}{"token": []string{}},
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, ep := range protocol.Endpoints {
+		op := map[string]interface{}{"summary": ep.Summary}
+
+		if len(ep.Params) > 0 {
+			params := make([]interface{}, 0, len(ep.Params))
+			for _, p := range ep.Params {
+				param := map[string]interface{}{
+					"name":   p.Name,
+					"in":     p.In,
+					"schema": map[string]interface{}{"type": p.Type},
+				}
+				if p.Required {
+					param["required"] = true
+				}
+				if p.Description != "" {
+					param["description"] = p.Description
+				}
+				params = append(params, param)
+			}
+			op["parameters"] = params
+		}
+
+		if ep.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(ep.Request), refs)},
+				},
+			}
+		}
+
+		status := ep.Status
+		if status == 0 {
+			status = 200
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if ep.Response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(ep.Response), refs)},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default":            textError,
+		}
+
+		if ep.Auth {
+			op["security"] = security
+		}
+		if ep.Admin {
+			op["security"] = []interface{}{map[string]interface{}{"admin": []string{}}}
+		}
+
+		if paths[ep.Path] == nil {
+			paths[ep.Path] = make(map[string]interface{})
+		}
+		paths[ep.Path][strings.ToLower(ep.Method)] = op
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "Taxi simulation API",
+			"version": strconv.Itoa(protocol.Version),
+			"description": "HTTP API of the taxi simulation. Endpoints marked with security need an API key " +
+				"when the server runs with -api-keys; without it everything is open. Endpoints that change the " +
+				"simulation need the -admin-token instead, and are refused when the server has none.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.definitions(refs),
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"token":  map[string]interface{}{"type": "apiKey", "in": "query", "name": "token"},
+				"admin":  map[string]interface{}{"type": "http", "scheme": "bearer", "description": "the -admin-token"},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
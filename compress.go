@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest response worth compressing; smaller ones
+// gain little and cost CPU
+const minCompressSize = 1024
+
+// brotliLevel trades Brotli's ratio for speed, since every response is
+// compressed as it is made; it still beats gzip's default
+const brotliLevel = 4
+
+// encoder is a compressor that can be reused for the next response
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// encoders recycle the compressors of each content coding, which are
+// expensive to allocate
+var encoders = map[string]*sync.Pool{
+	"br":   {New: func() any { return brotli.NewWriterLevel(io.Discard, brotliLevel) }},
+	"gzip": {New: func() any { return gzip.NewWriter(io.Discard) }},
+}
+
+// Compress wraps an API handler so its JSON responses are Brotli- or
+// gzip-compressed for clients that accept it. Responses under
+// minCompressSize and other content types are sent as they are.
+func Compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		coding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if coding == "" {
+			next(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, status: http.StatusOK, coding: coding}
+		defer cw.close()
+		next(cw, r)
+	}
+}
+
+// negotiateEncoding picks the content coding for an Accept-Encoding header:
+// br or gzip, whichever has the higher q-value, named or through *, with br
+// winning ties. It is empty when the header rules out both.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = parsed
+			}
+		}
+		q[coding] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		weight, ok := q[coding]
+		if !ok {
+			weight = q["*"]
+		}
+		if weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the response is worth compressing, then either compresses or passes
+// through everything
+type compressWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	coding  string  // br or gzip
+	enc     encoder // nil when passing through
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.decided {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf.Write(p)
+	if cw.buf.Len() >= minCompressSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the status and what was held back, compressing from here
+// on when the response is large enough and compressible
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if large && compressible(cw.status, h) {
+		h.Set("Content-Encoding", cw.coding)
+		h.Del("Content-Length")
+		cw.enc = encoders[cw.coding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	held := cw.buf.Bytes()
+	cw.buf = bytes.Buffer{}
+	if len(held) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(held)
+	} else {
+		_, err = cw.ResponseWriter.Write(held)
+	}
+	return err
+}
+
+// close sends a response that stayed small, or finishes the compressed one
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+		return
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		encoders[cw.coding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// compressible reports whether a response is JSON with a body that isn't
+// encoded already
+func compressible(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	return strings.Contains(h.Get("Content-Type"), "json")
+}
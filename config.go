@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"time"
+)
+
+// Bounds of the runtime configuration
+const (
+	maxFleetSize   = 100000 // locally simulated drivers
+	maxSpeedKmhCap = 200
+	maxSurgeCap    = 10
+)
+
+// Tunables are the simulation parameters that can be changed while it runs,
+// through /api/admin/config. A published Tunables is never modified; a
+// change publishes a new one.
+type Tunables struct {
+	ClientInterval     time.Duration // driver updates of clients that didn't pick an interval
+	MinSpeed           float64       // degrees of arc per second
+	MaxSpeed           float64       // degrees of arc per second
+	StatusChangeChance float64       // per update, for drivers not on a trip
+	AvailableShare     float64       // chance a new or changed status is Available
+	BusyShare          float64       // chance it is Busy; otherwise it is Offline
+	SurgeSlope         float64       // surge added per recent request per available driver above one
+	MaxSurge           float64
+}
+
+// defaultTunables are the parameters a simulation starts with, before its
+// settings set the speeds and the client interval (see Settings.tunables)
+var defaultTunables = Tunables{
+	ClientInterval:     defaultClientInterval,
+	MinSpeed:           minSpeed,
+	MaxSpeed:           maxSpeed,
+	StatusChangeChance: 0.01,
+	AvailableShare:     driverStatusProbs,
+	BusyShare:          0.2,
+	SurgeSlope:         surgeSlope,
+	MaxSurge:           maxSurge,
+}
+
+// randomStatus picks a driver status using the configured probabilities
+func (t *Tunables) randomStatus(roll float64) DriverStatus {
+	if roll < t.AvailableShare {
+		return Available
+	} else if roll < t.AvailableShare+t.BusyShare {
+		return Busy
+	}
+	return Offline
+}
+
+// randomSpeed picks a driver speed between the limits
+func (t *Tunables) randomSpeed(roll float64) float64 {
+	return t.MinSpeed + roll*(t.MaxSpeed-t.MinSpeed)
+}
+
+// averageSpeedKmh is the mean driver speed, used to estimate trip durations
+func (t *Tunables) averageSpeedKmh() float64 {
+	return geo.DegreesToKm((t.MinSpeed+t.MaxSpeed)/2) * 3600
+}
+
+// apply returns the parameters changed by a patch, after checking them
+func (t Tunables) apply(patch protocol.ConfigPatch) (Tunables, error) {
+	if patch.BroadcastIntervalMs != nil {
+		interval := time.Duration(*patch.BroadcastIntervalMs) * time.Millisecond
+		if interval < minClientInterval || interval > maxClientInterval {
+			return t, fmt.Errorf("broadcast interval must be between %d and %d ms",
+				minClientInterval.Milliseconds(), maxClientInterval.Milliseconds())
+		}
+		t.ClientInterval = interval
+	}
+	if patch.MinSpeedKmh != nil {
+		t.MinSpeed = kmhToSpeed(*patch.MinSpeedKmh)
+	}
+	if patch.MaxSpeedKmh != nil {
+		t.MaxSpeed = kmhToSpeed(*patch.MaxSpeedKmh)
+	}
+	if !(t.MinSpeed > 0) || t.MinSpeed > t.MaxSpeed || speedToKmh(t.MaxSpeed) > maxSpeedKmhCap {
+		return t, fmt.Errorf("speeds must satisfy 0 < min <= max <= %d km/h", maxSpeedKmhCap)
+	}
+	if patch.StatusChangeChance != nil {
+		t.StatusChangeChance = *patch.StatusChangeChance
+	}
+	if !(t.StatusChangeChance >= 0 && t.StatusChangeChance <= 1) {
+		return t, fmt.Errorf("status change chance must be between 0 and 1")
+	}
+	if patch.AvailableShare != nil {
+		t.AvailableShare = *patch.AvailableShare
+	}
+	if patch.BusyShare != nil {
+		t.BusyShare = *patch.BusyShare
+	}
+	if !(t.AvailableShare >= 0 && t.BusyShare >= 0 && t.AvailableShare+t.BusyShare <= 1) {
+		return t, fmt.Errorf("available and busy shares must not be negative and may add up to at most 1")
+	}
+	if patch.SurgeSlope != nil {
+		t.SurgeSlope = *patch.SurgeSlope
+	}
+	if !(t.SurgeSlope >= 0) || math.IsInf(t.SurgeSlope, 0) {
+		return t, fmt.Errorf("surge slope must not be negative")
+	}
+	if patch.MaxSurge != nil {
+		t.MaxSurge = *patch.MaxSurge
+	}
+	if !(t.MaxSurge >= 1 && t.MaxSurge <= maxSurgeCap) {
+		return t, fmt.Errorf("max surge must be between 1 and %d", maxSurgeCap)
+	}
+	return t, nil
+}
+
+// kmhToSpeed converts km/h into degrees of arc per second
+func kmhToSpeed(kmh float64) float64 {
+	return geo.KmToDegrees(kmh / 3600)
+}
+
+// speedToKmh converts degrees of arc per second into km/h
+func speedToKmh(speed float64) float64 {
+	return geo.DegreesToKm(speed) * 3600
+}
+
+// ConfigHandler handles GET and PATCH /api/admin/config
+func (s *Simulation) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	var patch protocol.ConfigPatch
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cfg protocol.RuntimeConfig
+	err := s.exec(r.Context(), func() error {
+		if r.Method == http.MethodPatch {
+			if err := s.Reconfigure(patch); err != nil {
+				return err
+			}
+		}
+		cfg = s.runtimeConfig()
+		return nil
+	})
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		slog.Info("Runtime config changed", "config", cfg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// Reconfigure changes the simulation parameters, adding or removing drivers
+// to reach the requested fleet size. Nothing changes when the patch is
+// invalid. It must run on the main loop.
+func (s *Simulation) Reconfigure(patch protocol.ConfigPatch) error {
+	t, err := s.tunables.Load().apply(patch)
+	if err != nil {
+		return err
+	}
+	if patch.Drivers != nil {
+		if s.replayer != nil {
+			return errReplayMode
+		}
+		if err := s.mirroring(); err != nil {
+			return err
+		}
+		if *patch.Drivers < 0 || *patch.Drivers > maxFleetSize {
+			return fmt.Errorf("drivers must be between 0 and %d", maxFleetSize)
+		}
+	}
+
+	s.tunables.Store(&t)
+	if patch.Drivers != nil {
+		s.resizeFleet(*patch.Drivers)
+	}
+	s.record(recordLine{Command: "config", Patch: &patch})
+	return nil
+}
+
+// runtimeConfig describes the current parameters. It must run on the main
+// loop.
+func (s *Simulation) runtimeConfig() protocol.RuntimeConfig {
+	t := s.tunables.Load()
+	return protocol.RuntimeConfig{
+		BroadcastIntervalMs: t.ClientInterval.Milliseconds(),
+		Drivers:             s.localDriverCount(),
+		MinSpeedKmh:         speedToKmh(t.MinSpeed),
+		MaxSpeedKmh:         speedToKmh(t.MaxSpeed),
+		StatusChangeChance:  t.StatusChangeChance,
+		AvailableShare:      t.AvailableShare,
+		BusyShare:           t.BusyShare,
+		SurgeSlope:          t.SurgeSlope,
+		MaxSurge:            t.MaxSurge,
+	}
+}
+
+// localDriverCount counts the drivers simulated here, leaving out federated
+// ones. It must run on the main loop.
+func (s *Simulation) localDriverCount() int {
+	n := 0
+	for _, driver := range s.drivers {
+		if driver.Origin == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// resizeFleet adds drivers to the cities, placed like the initial ones, or
+// removes the newest, sparing drivers on a trip as long as it can, until
+// there are n local drivers. It must run on the main loop.
+func (s *Simulation) resizeFleet(n int) {
+	count := s.localDriverCount()
+	t := s.tunables.Load()
+
+	if n > count {
+		newDrivers := make([]*Driver, 0, n-count)
+		for i := count; i < n; i++ {
+			city := pickCity(s.cities, s.rand.Float64())
+			lon, lat := placeDriver(city, s.geofences, s.rand)
+			newDrivers = append(newDrivers, &Driver{
+				ID:      s.newDriverID(),
+				Status:  t.randomStatus(s.rand.Float64()),
+				motion:  newMotion(lon, lat, t.randomSpeed(s.rand.Float64()), s.rand.Float64()*2*math.Pi),
+				Vehicle: randomVehicle(s.rand.Float64()),
+				profile: s.profileMix.Pick(s.rand.Float64()),
+			})
+		}
+		s.addDrivers(newDrivers)
+		slog.Info("Added drivers", "count", len(newDrivers))
+	} else if n < count {
+		var ids []int
+		for _, onTrip := range []bool{false, true} {
+			for i := len(s.drivers) - 1; i >= 0 && len(ids) < count-n; i-- {
+				d := s.drivers[i]
+				if d.Origin == "" && (d.trip != nil) == onTrip {
+					ids = append(ids, d.ID)
+				}
+			}
+		}
+		slog.Info("Removed drivers", "count", len(s.removeDrivers(ids)))
+	} else {
+		return
+	}
+
+	s.UpdateIndex()
+}
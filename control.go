@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+	"time"
+)
+
+// maxStepTicks limits how many updates a single step request can run
+const maxStepTicks = 10000
+
+// errNotPaused is returned when stepping a running simulation
+var errNotPaused = errors.New("simulation must be paused to step")
+
+// simCommand is a control request handed to the main loop, which executes
+// it between updates so stepping is deterministic
+type simCommand struct {
+	action string
+	ticks  int
+	fn     func() error // for "exec": arbitrary work on the main loop
+	reply  chan simResult
+}
+
+type simResult struct {
+	state protocol.SimState
+	err   error
+}
+
+// Control asks the main loop to pause, resume or step and waits for the
+// resulting state
+func (s *Simulation) Control(ctx context.Context, action string, ticks int) (protocol.SimState, error) {
+	switch action {
+	case "pause", "resume", "state":
+	case "step":
+		if ticks == 0 {
+			ticks = 1
+		}
+		if ticks < 0 || ticks > maxStepTicks {
+			return protocol.SimState{}, fmt.Errorf("ticks must be between 1 and %d", maxStepTicks)
+		}
+	default:
+		return protocol.SimState{}, fmt.Errorf("unknown action %q", action)
+	}
+
+	cmd := simCommand{action: action, ticks: ticks, reply: make(chan simResult, 1)}
+	select {
+	case s.control <- cmd:
+	case <-ctx.Done():
+		return protocol.SimState{}, ctx.Err()
+	}
+
+	select {
+	case res := <-cmd.reply:
+		return res.state, res.err
+	case <-ctx.Done():
+		return protocol.SimState{}, ctx.Err()
+	}
+}
+
+// handleControl executes a control command; it runs on the main loop
+func (s *Simulation) handleControl(cmd simCommand) simResult {
+	switch cmd.action {
+	case "exec":
+		return simResult{err: cmd.fn()}
+	case "pause":
+		s.clock.Pause()
+		s.audit(protocol.AuditAdmin, protocol.AdminEvent{Action: cmd.action})
+	case "resume":
+		s.clock.Resume()
+		s.audit(protocol.AuditAdmin, protocol.AdminEvent{Action: cmd.action})
+	case "step":
+		if !s.clock.Paused() {
+			return simResult{state: s.simState(), err: errNotPaused}
+		}
+		s.audit(protocol.AuditAdmin, protocol.AdminEvent{Action: cmd.action, Params: map[string]int{"ticks": cmd.ticks}})
+		for i := 0; i < cmd.ticks; i++ {
+			if !s.clock.Manual() {
+				// A manual clock is advanced by the update itself
+				s.clock.Advance(time.Duration(float64(s.settings.UpdateInterval) * s.clock.Scale()))
+			}
+			s.update()
+		}
+	}
+	return simResult{state: s.simState()}
+}
+
+// simState returns the current run state
+func (s *Simulation) simState() protocol.SimState {
+	return protocol.SimState{
+		Type:        protocol.TypeSimState,
+		Paused:      s.clock.Paused(),
+		Tick:        s.tick,
+		Speed:       s.clock.Scale(),
+		VirtualTime: s.clock.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// ControlHandler returns a handler for /api/sim/pause, /api/sim/resume and
+// /api/sim/step?ticks=N
+func (s *Simulation) ControlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ticks := 0
+		if ticksStr := r.URL.Query().Get("ticks"); ticksStr != "" {
+			val, err := strconv.Atoi(ticksStr)
+			if err != nil {
+				http.Error(w, "invalid ticks", http.StatusBadRequest)
+				return
+			}
+			ticks = val
+		}
+
+		state, err := s.Control(r.Context(), action, ticks)
+		switch {
+		case errors.Is(err, errNotPaused):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil && r.Context().Err() != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"quadtree/protocol"
+	"slices"
+	"time"
+)
+
+// DebugState is a dump of the whole simulation, taken on the main loop
+// between updates so its parts agree with each other. It is for chasing
+// odd behavior on a live server, not for dashboards: its shape follows the
+// internals and may change with them.
+type DebugState struct {
+	Time        int64   `json:"time"`         // Unix milliseconds
+	VirtualTime int64   `json:"virtual_time"` // Unix milliseconds
+	Ticks       int64   `json:"ticks"`        // main loop ticks so far
+	Paused      bool    `json:"paused"`
+	Manual      bool    `json:"manual"` // the clock only moves through updates
+	Speed       float64 `json:"speed"`
+	Replay      bool    `json:"replay"`
+	// How the simulation was started, and its parameters now
+	Run    RunConfig              `json:"run"`
+	Config protocol.RuntimeConfig `json:"config"`
+	// Statistics, including the index shape, and runtime diagnostics
+	Stats       protocol.Stats `json:"stats"`
+	Diagnostics Diagnostics    `json:"diagnostics"`
+	Federation  []PeerStatus   `json:"federation,omitempty"`
+	// Subscribers of every hub topic
+	Topics  map[string]int          `json:"topics"`
+	Clients []DebugClient           `json:"clients"`
+	Trips   []protocol.RideProgress `json:"trips"`
+	// Left out with ?drivers=0, as it is most of the dump
+	Drivers []protocol.DriverDetail `json:"drivers,omitempty"`
+}
+
+// DebugClient is a client as /debug/state reports it: what
+// /api/admin/clients shows, and the state behind its updates
+type DebugClient struct {
+	protocol.ClientInfo
+	Filter        string              `json:"filter,omitempty"`
+	Statuses      []string            `json:"statuses,omitempty"`
+	NoEvents      bool                `json:"no_events,omitempty"`
+	NoStats       bool                `json:"no_stats,omitempty"`
+	HubTopics     []string            `json:"hub_topics"`
+	Subscriptions []DebugSubscription `json:"subscription_details,omitempty"`
+	// Delta state: the sequence number of the last snapshot or delta, and
+	// how many drivers the client knows of (-1 until its next snapshot)
+	Seq           int64 `json:"seq"`
+	KnownDrivers  int   `json:"known_drivers"`
+	Resync        bool  `json:"resync"`
+	PendingUpdate bool  `json:"pending_update"`
+	Disconnecting bool  `json:"disconnecting"`
+}
+
+// DebugSubscription is one of a client's subscriptions
+type DebugSubscription struct {
+	ID       string             `json:"id"`
+	Region   *protocol.Region   `json:"region,omitempty"`
+	Viewport *protocol.Viewport `json:"viewport,omitempty"`
+	City     string             `json:"city,omitempty"`
+	Drivers  []int              `json:"drivers,omitempty"`
+	Statuses []string           `json:"statuses,omitempty"`
+}
+
+// debugState collects the dump. It must run on the main loop.
+func (s *Simulation) debugState(drivers bool) DebugState {
+	state := DebugState{
+		Time:        time.Now().UnixNano() / int64(time.Millisecond),
+		VirtualTime: s.clock.Now().UnixNano() / int64(time.Millisecond),
+		Ticks:       s.broadcastTicks,
+		Paused:      s.clock.Paused(),
+		Manual:      s.clock.Manual(),
+		Speed:       s.clock.Scale(),
+		Replay:      s.replayer != nil,
+		Run:         s.runConfig,
+		Config:      s.runtimeConfig(),
+		Stats:       s.CollectStats(),
+		Diagnostics: s.CollectDiagnostics(),
+		Topics:      s.hub.Counts(),
+		Clients:     []DebugClient{},
+		Trips:       make([]protocol.RideProgress, 0, len(s.trips)),
+	}
+	if s.federation != nil {
+		state.Federation = s.federation.Status()
+	}
+
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		state.Clients = append(state.Clients, s.debugClient(client))
+	}
+	s.clientsMu.RUnlock()
+	slices.SortFunc(state.Clients, func(a, b DebugClient) int {
+		return cmp.Compare(a.ConnectedAt, b.ConnectedAt)
+	})
+
+	for _, trip := range s.trips {
+		state.Trips = append(state.Trips, s.rideProgress(trip, trip.State, "", nil))
+	}
+
+	if drivers {
+		s.driversMu.RLock()
+		ids := make([]int, 0, len(s.drivers))
+		for _, d := range s.drivers {
+			ids = append(ids, d.ID)
+		}
+		s.driversMu.RUnlock()
+		state.Drivers = make([]protocol.DriverDetail, 0, len(ids))
+		for _, id := range ids {
+			if detail, err := s.driverDetail(id); err == nil {
+				state.Drivers = append(state.Drivers, detail)
+			}
+		}
+	}
+	return state
+}
+
+// debugClient describes a client for /debug/state
+func (s *Simulation) debugClient(client *WebSocketClient) DebugClient {
+	cfg := client.settings()
+	dc := DebugClient{
+		ClientInfo: s.clientInfo(client),
+		Statuses:   slices.Sorted(maps.Keys(cfg.statuses)),
+		NoEvents:   cfg.noEvents,
+		NoStats:    cfg.noStats,
+		HubTopics:  s.hub.Topics(client.hubSub),
+		Resync:     client.resync.Load(),
+	}
+	if cfg.filter != nil {
+		dc.Filter = cfg.filter.String()
+	}
+
+	client.subsMu.Lock()
+	for _, id := range slices.Sorted(maps.Keys(client.subscriptions)) {
+		sub := client.subscriptions[id]
+		dc.Subscriptions = append(dc.Subscriptions, DebugSubscription{
+			ID:       id,
+			Region:   sub.region,
+			Viewport: sub.viewport,
+			City:     sub.city,
+			Drivers:  slices.Sorted(maps.Keys(sub.drivers)),
+			Statuses: slices.Sorted(maps.Keys(sub.statuses)),
+		})
+	}
+	client.subsMu.Unlock()
+
+	client.deltaMu.Lock()
+	dc.Seq, dc.KnownDrivers = client.seq, len(client.lastSent)
+	if client.lastSent == nil {
+		dc.KnownDrivers = -1
+	}
+	client.deltaMu.Unlock()
+
+	client.queueMu.Lock()
+	dc.PendingUpdate, dc.Disconnecting = client.pendingUpdate != nil, client.disconnecting
+	client.queueMu.Unlock()
+	return dc
+}
+
+// DebugStateHandler handles GET /debug/state: a dump of the simulation for
+// troubleshooting a live server. Pass ?drivers=0 to leave out the drivers.
+func (s *Simulation) DebugStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	drivers := r.URL.Query().Get("drivers") != "0"
+	var state DebugState
+	err := s.exec(r.Context(), func() error {
+		state = s.debugState(drivers)
+		return nil
+	})
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"quadtree/protocol"
+	"sort"
+	"time"
+)
+
+// deltaMinMove is how far a driver must move (degrees, about 1.1m) before a
+// delta reports it
+const deltaMinMove = 0.00001
+
+// sendDriversDelta sends a client that asked for deltas either a snapshot
+// (the full update) or the changes since what it was sent last. Each one
+// carries the next sequence number, so clients can tell when they missed
+// one. A client whose last update is still waiting to be written gets a
+// snapshot, which replaces it; a delta can't, since it builds on it.
+func (s *Simulation) sendDriversDelta(client *WebSocketClient, update protocol.DriversUpdate) {
+	// Held while sending so snapshots and deltas go out in order
+	client.deltaMu.Lock()
+	defer client.deltaMu.Unlock()
+
+	// Frames were dropped, so the client may have missed changes
+	if client.resync.Swap(false) || client.lagging() {
+		client.lastSent = nil
+	}
+
+	if client.lastSent == nil {
+		client.lastSent = make(map[int]protocol.DriverResponse, len(update.Drivers))
+		for _, driver := range update.Drivers {
+			client.lastSent[driver.ID] = driver
+		}
+		client.seq++
+		update.Seq = client.seq
+		if client.settings().version >= protocol.Version {
+			snapshot := protocol.DriversSnapshot(update)
+			snapshot.Type = protocol.TypeSnapshot
+			s.sendUpdate(client, snapshot)
+		} else {
+			s.sendUpdate(client, update)
+		}
+		return
+	}
+
+	start := time.Now()
+	delta := diffDrivers(client.lastSent, update.Drivers)
+	s.broadcast.assemble.since(start)
+	if len(delta.Appeared) == 0 && len(delta.Moved) == 0 &&
+		len(delta.StatusChanged) == 0 && len(delta.Disappeared) == 0 {
+		return
+	}
+	client.seq++
+	delta.Count = len(client.lastSent)
+	delta.Time = update.Time
+	delta.DataAgeMs = update.DataAgeMs
+	delta.Seq = client.seq
+	s.sendUpdate(client, delta)
+}
+
+// diffDrivers computes the changes from the drivers a client was last sent
+// to the current ones, and updates sent to match what the client will know
+// after applying them
+func diffDrivers(sent map[int]protocol.DriverResponse, current []protocol.DriverResponse) protocol.DriversDelta {
+	delta := protocol.DriversDelta{Type: protocol.TypeDriversDelta}
+
+	seen := make(map[int]bool, len(current))
+	for _, driver := range current {
+		seen[driver.ID] = true
+
+		prev, ok := sent[driver.ID]
+		if !ok {
+			delta.Appeared = append(delta.Appeared, driver)
+			sent[driver.ID] = driver
+			continue
+		}
+
+		// A driver that stopped or started has to be reported even before
+		// it moved, or clients would keep moving it on at the old velocity
+		if math.Abs(driver.Lon-prev.Lon) >= deltaMinMove || math.Abs(driver.Lat-prev.Lat) >= deltaMinMove ||
+			(driver.VelLon == 0 && driver.VelLat == 0) != (prev.VelLon == 0 && prev.VelLat == 0) {
+			delta.Moved = append(delta.Moved, protocol.DriverMove{
+				ID:        driver.ID,
+				Lon:       driver.Lon,
+				Lat:       driver.Lat,
+				Heading:   driver.Heading,
+				Speed:     driver.Speed,
+				Distance:  driver.Distance,
+				RawLon:    driver.RawLon,
+				RawLat:    driver.RawLat,
+				VelLon:    driver.VelLon,
+				VelLat:    driver.VelLat,
+				UpdatedAt: driver.UpdatedAt,
+			})
+			prev.Lon, prev.Lat = driver.Lon, driver.Lat
+			prev.Heading, prev.Speed, prev.Distance = driver.Heading, driver.Speed, driver.Distance
+			prev.RawLon, prev.RawLat = driver.RawLon, driver.RawLat
+			prev.VelLon, prev.VelLat, prev.UpdatedAt = driver.VelLon, driver.VelLat, driver.UpdatedAt
+		}
+		if driver.Status != prev.Status {
+			delta.StatusChanged = append(delta.StatusChanged, protocol.DriverStatusChange{
+				ID:     driver.ID,
+				Status: driver.Status,
+			})
+			prev.Status = driver.Status
+		}
+		sent[driver.ID] = prev
+	}
+
+	for id := range sent {
+		if !seen[id] {
+			delta.Disappeared = append(delta.Disappeared, id)
+			delete(sent, id)
+		}
+	}
+	sort.Ints(delta.Disappeared)
+	return delta
+}
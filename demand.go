@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"quadtree/geo"
+	"quadtree/quadtree"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Decay curves supported by demand shocks
+const (
+	DecayStep        = "step"        // full magnitude for the whole duration
+	DecayLinear      = "linear"      // ramps down linearly to zero
+	DecayExponential = "exponential" // halves every quarter of the duration
+)
+
+// Limits of demand shocks, so one can't flood the main loop with rides
+const (
+	maxShockMagnitude = 100 // ride requests per second
+	maxShockRadius    = 0.5 // degrees, about 55km
+)
+
+// DemandShock is a burst of ride requests around a point, e.g. a stadium
+// emptying or a flight landing
+type DemandShock struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name,omitempty"`
+	Lon       float64 `json:"lon"`
+	Lat       float64 `json:"lat"`
+	Radius    float64 `json:"radius"`     // spread of request origins in degrees
+	Magnitude float64 `json:"magnitude"`  // peak extra ride requests per second
+	Duration  float64 `json:"duration_s"` // seconds until the shock has fully decayed
+	Decay     string  `json:"decay"`      // step, linear or exponential
+	At        float64 `json:"at_s"`       // scenario offset in seconds (scenario files only)
+
+	StartedAt time.Time `json:"started_at"`
+	Requests  int       `json:"requests"` // ride requests generated so far
+}
+
+// validate checks the shock parameters against the world bounds and fills
+// in defaults
+func (sh *DemandShock) validate(bounds quadtree.Bounds) error {
+	if !bounds.Contains(sh.Lon, sh.Lat) {
+		return fmt.Errorf("location (%.6f, %.6f) is outside the world bounds", sh.Lon, sh.Lat)
+	}
+	if !(sh.Magnitude > 0 && sh.Magnitude <= maxShockMagnitude) {
+		return fmt.Errorf("magnitude must be above 0 and at most %d", maxShockMagnitude)
+	}
+	if !(sh.Duration > 0) {
+		return fmt.Errorf("duration_s must be positive")
+	}
+	if sh.Radius > maxShockRadius || math.IsNaN(sh.Radius) {
+		return fmt.Errorf("radius must be at most %g", maxShockRadius)
+	}
+	if sh.Radius <= 0 {
+		sh.Radius = 0.005 // about 550m
+	}
+	switch sh.Decay {
+	case "":
+		sh.Decay = DecayLinear
+	case DecayStep, DecayLinear, DecayExponential:
+	default:
+		return fmt.Errorf("unknown decay curve %q", sh.Decay)
+	}
+	return nil
+}
+
+// Rate returns the ride request rate (requests per second) at the given time
+func (sh *DemandShock) Rate(now time.Time) float64 {
+	elapsed := now.Sub(sh.StartedAt).Seconds()
+	if elapsed < 0 || elapsed >= sh.Duration {
+		return 0
+	}
+
+	progress := elapsed / sh.Duration
+	switch sh.Decay {
+	case DecayStep:
+		return sh.Magnitude
+	case DecayExponential:
+		return sh.Magnitude * math.Pow(0.5, progress*4)
+	default:
+		return sh.Magnitude * (1 - progress)
+	}
+}
+
+// Expired reports whether the shock no longer generates requests
+func (sh *DemandShock) Expired(now time.Time) bool {
+	return now.Sub(sh.StartedAt).Seconds() >= sh.Duration
+}
+
+// Scenario is a script of demand shocks triggered at offsets from startup.
+// It can also replace the everyday demand of the cities; an empty baseline
+// list turns it off.
+type Scenario struct {
+	Shocks   []DemandShock `json:"shocks"`
+	Baseline []CityDemand  `json:"baseline,omitempty"`
+}
+
+// LoadScenario reads a scenario script from a JSON file, for a world with
+// the given bounds
+func LoadScenario(path string, bounds quadtree.Bounds) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+
+	for i := range sc.Shocks {
+		if err := sc.Shocks[i].validate(bounds); err != nil {
+			return nil, fmt.Errorf("scenario shock %d: %w", i, err)
+		}
+	}
+	return &sc, nil
+}
+
+// DemandGenerator turns the everyday demand of the cities and active demand
+// shocks into simulated ride requests
+type DemandGenerator struct {
+	mu       sync.Mutex
+	baseline []*cityBaseline
+	active   []*DemandShock
+	pending  []*DemandShock // scenario shocks waiting for their offset
+	clock    *SimClock
+	bounds   quadtree.Bounds // of the world, which shocks and requests stay inside
+	nextID   int
+
+	totalRequests int
+}
+
+// NewDemandGenerator creates an empty demand generator driven by the
+// simulation's virtual clock, for a world with the given bounds
+func NewDemandGenerator(clock *SimClock, bounds quadtree.Bounds) *DemandGenerator {
+	return &DemandGenerator{clock: clock, bounds: bounds}
+}
+
+// Schedule queues the shocks of a scenario relative to the generator start
+func (g *DemandGenerator) Schedule(sc *Scenario) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := range sc.Shocks {
+		shock := sc.Shocks[i]
+		g.nextID++
+		shock.ID = g.nextID
+		g.pending = append(g.pending, &shock)
+	}
+}
+
+// Trigger starts a shock immediately
+func (g *DemandGenerator) Trigger(shock DemandShock) (DemandShock, error) {
+	if err := shock.validate(g.bounds); err != nil {
+		return DemandShock{}, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.nextID++
+	shock.ID = g.nextID
+	shock.StartedAt = g.clock.Now()
+	g.active = append(g.active, &shock)
+	return shock, nil
+}
+
+// Cancel stops an active or pending shock by ID
+func (g *DemandGenerator) Cancel(id int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, shock := range g.active {
+		if shock.ID == id {
+			g.active = append(g.active[:i], g.active[i+1:]...)
+			return true
+		}
+	}
+	for i, shock := range g.pending {
+		if shock.ID == id {
+			g.pending = append(g.pending[:i], g.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Shocks returns copies of the active and pending shocks
+func (g *DemandGenerator) Shocks() (active, pending []DemandShock) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	active = make([]DemandShock, 0, len(g.active))
+	for _, shock := range g.active {
+		active = append(active, *shock)
+	}
+	pending = make([]DemandShock, 0, len(g.pending))
+	for _, shock := range g.pending {
+		pending = append(pending, *shock)
+	}
+	return active, pending
+}
+
+// Generate advances the generator by deltaTime seconds and returns the
+// origins of the ride requests that arrived during that time
+func (g *DemandGenerator) Generate(now time.Time, deltaTime float64, r *rand.Rand) [][2]float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Activate scenario shocks whose offset has been reached
+	elapsed := g.clock.Elapsed().Seconds()
+	remaining := g.pending[:0]
+	for _, shock := range g.pending {
+		if elapsed >= shock.At {
+			shock.StartedAt = now
+			g.active = append(g.active, shock)
+			slog.Info("Demand shock started", "shock_id", shock.ID, "name", shock.Name, "lon", shock.Lon, "lat", shock.Lat)
+		} else {
+			remaining = append(remaining, shock)
+		}
+	}
+	g.pending = remaining
+
+	var origins [][2]float64
+	active := g.active[:0]
+	for _, shock := range g.active {
+		if shock.Expired(now) {
+			slog.Info("Demand shock ended", "shock_id", shock.ID, "name", shock.Name, "requests", shock.Requests)
+			continue
+		}
+		active = append(active, shock)
+
+		// Arrivals this step follow a Poisson distribution
+		count := poisson(shock.Rate(now)*deltaTime, r)
+
+		for i := 0; i < count; i++ {
+			// Normally distributed around the shock point, clamped to the world
+			lon := math.Max(g.bounds.MinX, math.Min(g.bounds.MaxX, shock.Lon+r.NormFloat64()*shock.Radius*geo.LonScale(shock.Lat)))
+			lat := math.Max(g.bounds.MinY, math.Min(g.bounds.MaxY, shock.Lat+r.NormFloat64()*shock.Radius))
+			origins = append(origins, [2]float64{lon, lat})
+		}
+		shock.Requests += count
+		g.totalRequests += count
+	}
+	g.active = active
+
+	// Everyday demand of each city, following its time-of-day curve
+	for _, b := range g.baseline {
+		count := poisson(b.rate(now)*deltaTime, r)
+		for i := 0; i < count; i++ {
+			lon := math.Max(g.bounds.MinX, math.Min(g.bounds.MaxX, b.city.Lon+r.NormFloat64()*b.Spread*geo.LonScale(b.city.Lat)))
+			lat := math.Max(g.bounds.MinY, math.Min(g.bounds.MaxY, b.city.Lat+r.NormFloat64()*b.Spread))
+			origins = append(origins, [2]float64{lon, lat})
+		}
+		g.totalRequests += count
+	}
+
+	return origins
+}
+
+// TotalRequests returns the number of ride requests generated so far
+func (g *DemandGenerator) TotalRequests() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.totalRequests
+}
+
+// TriggerShock starts a shock and records it. It must run on the main loop
+// so the shock begins between the same two updates when verified.
+func (s *Simulation) TriggerShock(shock DemandShock) (DemandShock, error) {
+	shock, err := s.demand.Trigger(shock)
+	if err != nil {
+		return shock, err
+	}
+	s.record(recordLine{Command: "shock", Shock: &shock})
+	return shock, nil
+}
+
+// CancelShock stops a shock and records the cancellation. It must run on
+// the main loop.
+func (s *Simulation) CancelShock(id int) bool {
+	if !s.demand.Cancel(id) {
+		return false
+	}
+	s.record(recordLine{Command: "cancel_shock", IDs: []int{id}})
+	return true
+}
+
+// ShocksHandler handles the demand shock admin API:
+// GET lists shocks, POST triggers a shock, DELETE ?id= cancels one
+func (s *Simulation) ShocksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	switch r.Method {
+	case http.MethodGet:
+		active, pending := s.demand.Shocks()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"active":  active,
+			"pending": pending,
+		})
+
+	case http.MethodPost:
+		var shock DemandShock
+		if err := json.NewDecoder(r.Body).Decode(&shock); err != nil {
+			http.Error(w, "invalid shock: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := s.exec(r.Context(), func() error {
+			var err error
+			shock, err = s.TriggerShock(shock)
+			return err
+		})
+		if err != nil {
+			writeFleetError(w, err)
+			return
+		}
+		slog.Info("Demand shock triggered", "shock_id", shock.ID, "name", shock.Name, "lon", shock.Lon, "lat", shock.Lat)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(shock)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		var found bool
+		err = s.exec(r.Context(), func() error {
+			found = s.CancelShock(id)
+			return nil
+		})
+		if err != nil {
+			writeFleetError(w, err)
+			return
+		}
+		if !found {
+			http.Error(w, "shock not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
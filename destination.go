@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"quadtree/geo"
+	"quadtree/quadtree"
+)
+
+const (
+	arrivalRadius = 0.001 // drivers this close to their destination have arrived (degrees, about 110m)
+	maxSteerRate  = 0.6   // radians per second a driver turns towards its destination
+	placeShare    = 0.35  // share of trips that end at a popular place
+	placeSpread   = 0.002 // std dev of drop-off points around a place (degrees)
+	dwellMin      = 20    // shortest stop at a destination in seconds
+	dwellMax      = 120   // longest stop at a destination in seconds
+)
+
+// Movement modes
+const (
+	MovementDestination = "destination" // drive towards destinations, dwell, repeat
+	MovementRandom      = "random"      // the original random walk
+)
+
+// Place is a popular destination; trips that end at a place pick one in
+// proportion to its weight
+type Place struct {
+	Name     string
+	City     string
+	Lon, Lat float64
+	Weight   float64
+}
+
+// popularPlaces are the places trips are drawn towards
+var popularPlaces = []Place{
+	{Name: "Erbil International Airport", City: "Erbil", Lon: 43.9632, Lat: 36.2376, Weight: 3},
+	{Name: "Erbil Citadel", City: "Erbil", Lon: 44.0092, Lat: 36.1912, Weight: 4},
+	{Name: "Family Mall Erbil", City: "Erbil", Lon: 43.9780, Lat: 36.2190, Weight: 3},
+	{Name: "Sami Abdulrahman Park", City: "Erbil", Lon: 43.9930, Lat: 36.2010, Weight: 2},
+	{Name: "Ankawa", City: "Erbil", Lon: 43.9950, Lat: 36.2300, Weight: 2},
+	{Name: "Duhok Bazaar", City: "Duhok", Lon: 42.9990, Lat: 36.8660, Weight: 3},
+	{Name: "University of Duhok", City: "Duhok", Lon: 42.9650, Lat: 36.8600, Weight: 2},
+	{Name: "Duhok Dam", City: "Duhok", Lon: 42.9570, Lat: 36.8790, Weight: 1},
+}
+
+// waypoint is a position a driver is heading for
+type waypoint struct {
+	lon, lat float64
+}
+
+// Destinations picks where drivers head next. Trips stay in the city the
+// driver is in and end either at a popular place or anywhere in the city.
+type Destinations struct {
+	cities []City
+	places []Place
+	bounds quadtree.Bounds // of the world, which drop-offs stay inside
+}
+
+// NewDestinations creates a destination picker for the given cities
+func NewDestinations(cities []City, places []Place, bounds quadtree.Bounds) *Destinations {
+	return &Destinations{cities: cities, places: places, bounds: bounds}
+}
+
+// Pick chooses the next destination of a driver at the given position
+func (ds *Destinations) Pick(lon, lat float64, fences *GeofenceSet, r *rand.Rand) *waypoint {
+	city := closestCity(ds.cities, lon, lat)
+
+	if r.Float64() < placeShare {
+		if place, ok := ds.pickPlace(city, r.Float64()); ok {
+			// Drop-off points scatter around the place
+			dLon := place.Lon + r.NormFloat64()*placeSpread*geo.LonScale(place.Lat)
+			dLat := place.Lat + r.NormFloat64()*placeSpread
+			if ds.bounds.Contains(dLon, dLat) && fences.Allowed(dLon, dLat) {
+				return &waypoint{dLon, dLat}
+			}
+		}
+	}
+
+	dLon, dLat := placeInCity(city, 0, 1, fences, r)
+	return &waypoint{dLon, dLat}
+}
+
+// pickPlace chooses a popular place in the city by weight for a uniform
+// roll in [0, 1)
+func (ds *Destinations) pickPlace(city City, roll float64) (Place, bool) {
+	total := 0.0
+	for _, p := range ds.places {
+		if p.City == city.Name {
+			total += p.Weight
+		}
+	}
+	if total <= 0 {
+		return Place{}, false
+	}
+
+	target := roll * total
+	var last Place
+	for _, p := range ds.places {
+		if p.City != city.Name {
+			continue
+		}
+		if target < p.Weight {
+			return p, true
+		}
+		target -= p.Weight
+		last = p
+	}
+	return last, true
+}
+
+// steerTowards turns heading towards target by at most maxTurn radians
+func steerTowards(heading, target, maxTurn float64) float64 {
+	diff := math.Remainder(target-heading, 2*math.Pi)
+	diff = math.Max(-maxTurn, math.Min(maxTurn, diff))
+	return math.Mod(heading+diff+2*math.Pi, 2*math.Pi)
+}
+
+// headForDestination picks a destination if the driver has none and steers
+// towards it. It returns false when the driver has just arrived and starts
+// dwelling there, or waits at the pickup or drop-off of its trip. d.mu must
+// be held.
+func (d *Driver) headForDestination(deltaTime float64, r *rand.Rand, fences *GeofenceSet, dests *Destinations) bool {
+	if d.dest == nil {
+		d.dest = dests.Pick(d.lon(), d.lat(), fences, r)
+	}
+
+	if geo.HaversineKm(d.lon(), d.lat(), d.dest.lon, d.dest.lat) <= geo.DegreesToKm(arrivalRadius) {
+		if d.trip != nil {
+			return false // wait for the trip to move on
+		}
+
+		// Arrived: stop for a while, then head somewhere else
+		d.dest = nil
+		d.idle = dwellMin + r.Float64()*(dwellMax-dwellMin)
+		return false
+	}
+
+	target := geo.BearingTo(d.lon(), d.lat(), d.dest.lon, d.dest.lat)
+	d.setHeading(steerTowards(d.heading(), target, maxSteerRate*deltaTime))
+	return true
+}
+
+// SetMovement selects how drivers move. It must run before the simulation
+// starts or on the main loop.
+func (s *Simulation) SetMovement(mode string) error {
+	switch mode {
+	case MovementDestination:
+		s.destinations = NewDestinations(s.cities, popularPlaces, s.settings.bounds())
+	case MovementRandom:
+		s.destinations = nil
+	default:
+		return fmt.Errorf("unknown movement mode %q (want %s or %s)", mode, MovementDestination, MovementRandom)
+	}
+
+	// Forget destinations picked under the previous mode; trips keep theirs
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		if driver.trip == nil {
+			driver.dest = nil
+		}
+		driver.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"strconv"
+	"time"
+)
+
+const (
+	// deviceOrigin tags the drivers whose positions come from a real device
+	// or an external simulator instead of the simulation
+	deviceOrigin = "device"
+
+	// A fed driver that reports nothing for deviceTimeout is simulated
+	// again, from where it was last seen; checked every deviceCheckInterval
+	deviceTimeout       = 2 * time.Minute
+	deviceCheckInterval = time.Second
+
+	// deviceClockSkew is how far ahead of the server's clock a position's
+	// time may be. A later one would refuse every fix after it as older, and
+	// keep the driver from timing out.
+	deviceClockSkew = 5 * time.Second
+)
+
+var (
+	// errDeviceReplaying is returned for positions fed during a replay
+	errDeviceReplaying = errors.New("driver positions cannot be fed while replaying a recording")
+	// errDeviceRefused is returned for drivers that can't be fed: federated
+	// ones, ones on a trip, and positions older than the last one
+	errDeviceRefused = errors.New("driver position refused")
+)
+
+// handleDriverPosition applies a driver_position message. Only errors are
+// answered, so devices can stream positions without reading replies.
+func (s *Simulation) handleDriverPosition(ctx context.Context, client *WebSocketClient, requestID string, message []byte) {
+	var msg protocol.DriverPosition
+	err := json.Unmarshal(message, &msg)
+	if err != nil {
+		err = fmt.Errorf("invalid driver_position message: %v", err)
+	} else {
+		err = msg.Validate()
+	}
+	if err == nil {
+		err = checkAdmin(s.adminToken, msg.Token)
+	}
+	if err == nil {
+		err = s.exec(ctx, func() error {
+			_, err := s.feedPosition(msg)
+			return err
+		})
+	}
+	if err != nil {
+		s.sendReply(client, requestID, protocol.ErrorMessage{Type: protocol.TypeError, Error: err.Error()})
+	}
+}
+
+// DevicePositionHandler handles POST /api/drivers/{id}/position, feeding a
+// driver's position like a driver_position message does
+func (s *Simulation) DevicePositionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid driver id %q", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	var msg protocol.DriverPosition
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid driver position: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg.DriverID = id
+	if err := msg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp protocol.DriverResponse
+	err = s.exec(r.Context(), func() error {
+		var err error
+		resp, err = s.feedPosition(msg)
+		return err
+	})
+	switch {
+	case errors.Is(err, errUnknownDriver):
+		http.Error(w, fmt.Sprintf("unknown driver %d", id), http.StatusNotFound)
+		return
+	case errors.Is(err, errDeviceReplaying), errors.Is(err, errDeviceRefused):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// feedPosition moves a driver to a position reported from outside, taking
+// the driver out of the simulation if it still was in it, and returns the
+// driver's new state. The speed, velocity and, unless given, heading come
+// from the last reported position. It must run on the main loop.
+func (s *Simulation) feedPosition(msg protocol.DriverPosition) (protocol.DriverResponse, error) {
+	if s.replayer != nil {
+		return protocol.DriverResponse{}, errDeviceReplaying
+	}
+	if err := s.mirroring(); err != nil {
+		return protocol.DriverResponse{}, err
+	}
+	if !s.settings.bounds().Contains(msg.Lon, msg.Lat) {
+		return protocol.DriverResponse{}, errors.New("position is outside the world bounds")
+	}
+	var status DriverStatus
+	if msg.Status != "" {
+		parsed, err := parseDriverStatus(msg.Status)
+		if err != nil {
+			return protocol.DriverResponse{}, err
+		}
+		status = parsed
+	}
+	driver, ok := s.driverByID(msg.DriverID)
+	if !ok {
+		return protocol.DriverResponse{}, errUnknownDriver
+	}
+
+	now := time.Now()
+	at := now
+	if msg.Time != 0 {
+		at = time.UnixMilli(msg.Time)
+		if ahead := at.Sub(now); ahead > deviceClockSkew {
+			return protocol.DriverResponse{}, fmt.Errorf("position time is %s ahead of the server's clock", ahead.Round(time.Second))
+		}
+	}
+
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+	switch {
+	case driver.Origin != "" && driver.Origin != deviceOrigin:
+		return protocol.DriverResponse{}, fmt.Errorf("%w: driver %d comes from %s", errDeviceRefused, driver.ID, driver.Origin)
+	case driver.trip != nil:
+		return protocol.DriverResponse{}, fmt.Errorf("%w: driver %d is on a trip", errDeviceRefused, driver.ID)
+	case driver.Origin == deviceOrigin && at.Before(driver.reportedAt):
+		return protocol.DriverResponse{}, fmt.Errorf("%w: position is older than the last one", errDeviceRefused)
+	}
+
+	if driver.Origin == "" {
+		// Leave the simulation: no more standby duty, destination, trace or
+		// simulated GPS, and no motion to derive the next one from
+		driver.Origin = deviceOrigin
+		driver.standby, driver.dest, driver.gps, driver.trace, driver.idle = nil, nil, nil, nil, 0
+		driver.reportedAt = time.Time{}
+		slog.Info("Driver positions fed from outside", "driver_id", driver.ID)
+	}
+
+	prevLon, prevLat := driver.lon(), driver.lat()
+	vlon, vlat, speed := 0.0, 0.0, 0.0
+	if dt := at.Sub(driver.reportedAt).Seconds(); !driver.reportedAt.IsZero() && dt > 0 {
+		vlon, vlat = (msg.Lon-prevLon)/dt, (msg.Lat-prevLat)/dt
+		// Speed is in degrees of arc per second, like a trace's
+		speed = geo.KmToDegrees(geo.HaversineKm(prevLon, prevLat, msg.Lon, msg.Lat)) / dt
+	}
+	driver.moveTo(msg.Lon, msg.Lat)
+	driver.setVelocity(vlon, vlat)
+	driver.setSpeed(speed)
+	if msg.Heading != nil {
+		driver.setHeading(*msg.Heading * math.Pi / 180)
+	} else if msg.Lon != prevLon || msg.Lat != prevLat {
+		driver.setHeading(geo.BearingTo(prevLon, prevLat, msg.Lon, msg.Lat))
+	}
+	if msg.Status != "" {
+		driver.Status = status
+	}
+	driver.reportedAt = at
+	s.devices[driver.ID] = now
+	s.devicePositions.Add(1)
+
+	return protocol.DriverResponse{
+		ID:        driver.ID,
+		Lon:       driver.lon(),
+		Lat:       driver.lat(),
+		Status:    driver.Status.String(),
+		Heading:   math.Mod(driver.heading()*180/math.Pi+360, 360),
+		Speed:     driver.speed(),
+		Profile:   driver.behavior().Name,
+		Vehicle:   driver.Vehicle,
+		Origin:    driver.Origin,
+		VelLon:    vlon,
+		VelLat:    vlat,
+		UpdatedAt: at.UnixMilli(),
+	}, nil
+}
+
+// releaseDevices hands the fed drivers that stopped reporting back to the
+// simulation, and forgets the ones that were removed. It must run on the
+// main loop.
+func (s *Simulation) releaseDevices() {
+	for id, last := range s.devices {
+		driver, ok := s.driverByID(id)
+		if !ok {
+			delete(s.devices, id)
+			continue
+		}
+		if time.Since(last) < deviceTimeout {
+			continue
+		}
+		delete(s.devices, id)
+
+		driver.mu.Lock()
+		driver.Origin = ""
+		driver.reportedAt = time.Time{}
+		driver.setVelocity(0, 0)
+		driver.setSpeed(s.tunables.Load().randomSpeed(s.rand.Float64()))
+		driver.mu.Unlock()
+		slog.Info("Driver positions stopped, simulating it again", "driver_id", id, "silent", time.Since(last).Round(time.Second))
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	httppprof "net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"time"
+)
+
+// gcPauseHistory is how many recent GC pauses the diagnostics report includes
+const gcPauseHistory = 32
+
+// profileWriteTimeout is how long a response of the profiling server may
+// take; CPU profiles and traces run for the requested seconds (30 by
+// default) before they are written, and pprof refuses ones this timeout
+// would cut off
+const profileWriteTimeout = 2 * time.Minute
+
+// Diagnostics is a snapshot of the Go runtime and the simulation's
+// connection bookkeeping, used to spot goroutine and memory leaks
+type Diagnostics struct {
+	Uptime     string  `json:"uptime"`
+	Goroutines int     `json:"goroutines"`
+	Clients    int     `json:"clients"`
+	PerClient  float64 `json:"goroutines_per_client"`
+	// Frames dropped because clients didn't keep up, and clients
+	// disconnected for it
+	DroppedMessages int64 `json:"dropped_messages"`
+	SlowDisconnects int64 `json:"slow_disconnects"`
+	// Driver updates skipped because a newer one was queued behind them
+	StaleUpdates int64 `json:"stale_updates"`
+	// Connections refused by the client limit or upgrade throttling
+	RejectedClients int64 `json:"rejected_clients"`
+	// API requests refused by the per-IP rate limit
+	ThrottledRequests int64 `json:"throttled_requests"`
+	// Driver positions published with -mqtt
+	MQTTPublished int64 `json:"mqtt_published"`
+	// Positions and trip events written with -kafka, and the ones dropped
+	KafkaProduced int64 `json:"kafka_produced"`
+	KafkaDropped  int64 `json:"kafka_dropped"`
+	// Positions and trip events published with -nats and the trip events
+	// dropped, and the external positions consumed and rejected
+	NATSPublished int64 `json:"nats_published"`
+	NATSDropped   int64 `json:"nats_dropped"`
+	NATSConsumed  int64 `json:"nats_consumed"`
+	NATSRejected  int64 `json:"nats_rejected"`
+	// Position samples and trips written with -postgis, and the ones dropped
+	PostGISWritten int64 `json:"postgis_written"`
+	PostGISDropped int64 `json:"postgis_dropped"`
+	// Position samples and trips written with -export, and the ones dropped
+	ExportWritten int64 `json:"export_written"`
+	ExportDropped int64 `json:"export_dropped"`
+	// Trip events and status changes written with -store, and the ones dropped
+	StoreWritten int64 `json:"store_written"`
+	StoreDropped int64 `json:"store_dropped"`
+	// Webhook events delivered, given up on, and dropped from full queues
+	WebhooksDelivered int64 `json:"webhooks_delivered"`
+	WebhooksFailed    int64 `json:"webhooks_failed"`
+	WebhooksDropped   int64 `json:"webhooks_dropped"`
+	// Frames and events published to the cluster with -cluster, received
+	// from its leader and dropped, and the leader's name
+	ClusterPublished int64  `json:"cluster_published"`
+	ClusterReceived  int64  `json:"cluster_received"`
+	ClusterDropped   int64  `json:"cluster_dropped"`
+	ClusterLeader    string `json:"cluster_leader,omitempty"`
+	// Driver frames and events sent to the gateway by a -shard worker,
+	// received by a gateway from its shards, and dropped
+	ShardSent     int64 `json:"shard_sent"`
+	ShardReceived int64 `json:"shard_received"`
+	ShardDropped  int64 `json:"shard_dropped"`
+	// Driver positions fed through /api/drivers/{id}/position or
+	// driver_position messages
+	DevicePositions int64 `json:"device_positions"`
+	// Hub topics and the client subscriptions across them
+	Topics             int    `json:"topics"`
+	TopicSubscriptions int    `json:"topic_subscriptions"`
+	HeapAlloc          uint64 `json:"heap_alloc_bytes"`
+	HeapObjects        uint64 `json:"heap_objects"`
+	HeapSys            uint64 `json:"heap_sys_bytes"`
+	NumGC              uint32 `json:"num_gc"`
+	// Most recent GC pauses first, in milliseconds
+	GCPauses     []float64 `json:"gc_pauses_ms"`
+	GCPauseTotal float64   `json:"gc_pause_total_ms"`
+	LastGC       time.Time `json:"last_gc"`
+}
+
+// startTime is used for uptime reporting
+var startTime = time.Now()
+
+// CollectDiagnostics gathers the current runtime diagnostics
+func (s *Simulation) CollectDiagnostics() Diagnostics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	gcStats := debug.GCStats{Pause: make([]time.Duration, gcPauseHistory)}
+	debug.ReadGCStats(&gcStats)
+
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+	topics, subscriptions := s.hub.Stats()
+
+	diag := Diagnostics{
+		Uptime:             time.Since(startTime).Round(time.Second).String(),
+		Goroutines:         runtime.NumGoroutine(),
+		Clients:            clients,
+		DroppedMessages:    s.droppedMessages.Load(),
+		SlowDisconnects:    s.slowDisconnects.Load(),
+		StaleUpdates:       s.staleUpdates.Load(),
+		RejectedClients:    s.rejectedClients.Load(),
+		ThrottledRequests:  s.throttled.Load(),
+		MQTTPublished:      s.mqttPublished.Load(),
+		KafkaProduced:      s.kafkaProduced.Load(),
+		KafkaDropped:       s.kafkaDropped.Load(),
+		NATSPublished:      s.natsPublished.Load(),
+		NATSDropped:        s.natsDropped.Load(),
+		NATSConsumed:       s.natsConsumed.Load(),
+		NATSRejected:       s.natsRejected.Load(),
+		PostGISWritten:     s.postgisWritten.Load(),
+		PostGISDropped:     s.postgisDropped.Load(),
+		ExportWritten:      s.exportWritten.Load(),
+		ExportDropped:      s.exportDropped.Load(),
+		StoreWritten:       s.storeWritten.Load(),
+		StoreDropped:       s.storeDropped.Load(),
+		WebhooksDelivered:  s.webhooksDelivered.Load(),
+		WebhooksFailed:     s.webhooksFailed.Load(),
+		WebhooksDropped:    s.webhooksDropped.Load(),
+		ClusterPublished:   s.clusterPublished.Load(),
+		ClusterReceived:    s.clusterReceived.Load(),
+		ClusterDropped:     s.clusterDropped.Load(),
+		ShardSent:          s.shardSent.Load(),
+		ShardReceived:      s.shardReceived.Load(),
+		ShardDropped:       s.shardDropped.Load(),
+		DevicePositions:    s.devicePositions.Load(),
+		Topics:             topics,
+		TopicSubscriptions: subscriptions,
+		HeapAlloc:          mem.HeapAlloc,
+		HeapObjects:        mem.HeapObjects,
+		HeapSys:            mem.HeapSys,
+		NumGC:              mem.NumGC,
+		GCPauses:           make([]float64, 0, len(gcStats.Pause)),
+		GCPauseTotal:       float64(gcStats.PauseTotal) / float64(time.Millisecond),
+		LastGC:             gcStats.LastGC,
+	}
+	if s.cluster != nil {
+		diag.ClusterLeader = s.cluster.leader.Load().(string)
+	}
+	if clients > 0 {
+		diag.PerClient = float64(diag.Goroutines) / float64(clients)
+	}
+	for _, pause := range gcStats.Pause {
+		diag.GCPauses = append(diag.GCPauses, float64(pause)/float64(time.Millisecond))
+	}
+
+	return diag
+}
+
+// LogDiagnostics writes a runtime summary to the log
+func (s *Simulation) LogDiagnostics() {
+	diag := s.CollectDiagnostics()
+
+	lastPause := 0.0
+	if len(diag.GCPauses) > 0 {
+		lastPause = diag.GCPauses[0]
+	}
+
+	slog.Info("Diagnostics",
+		"goroutines", diag.Goroutines,
+		"clients", diag.Clients,
+		"heap_mb", float64(diag.HeapAlloc)/(1<<20),
+		"heap_objects", diag.HeapObjects,
+		"gcs", diag.NumGC,
+		"last_pause_ms", lastPause,
+		"dropped_messages", diag.DroppedMessages,
+		"stale_updates", diag.StaleUpdates,
+		"slow_disconnects", diag.SlowDisconnects,
+		"rejected_clients", diag.RejectedClients,
+		"throttled_requests", diag.ThrottledRequests,
+		"topics", diag.Topics,
+		"topic_subscriptions", diag.TopicSubscriptions)
+}
+
+// requireToken wraps a handler so it only serves requests carrying the given
+// token, either as "Authorization: Bearer <token>" or a ?token= parameter.
+// An empty token disables the handler entirely.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="diagnostics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// DiagnosticsHandler returns runtime diagnostics as JSON
+func (s *Simulation) DiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.CollectDiagnostics())
+}
+
+// HeapProfileHandler triggers a heap profile and streams it in pprof format.
+// Pass ?gc=1 to run a garbage collection first so the profile reflects live
+// objects only.
+func (s *Simulation) HeapProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="heap-%d.pprof"`, time.Now().Unix()))
+
+	if err := pprof.Lookup("heap").WriteTo(w, 0); err != nil {
+		slog.Error("Writing heap profile failed", "err", err)
+	}
+}
+
+// StartProfiling serves net/http/pprof under /debug/pprof/ and expvar,
+// including the diagnostics report, under /debug/vars on addr, apart from
+// the API so profiles of the broadcast and movement loops under load need
+// no token and no rebuild. Bind it to localhost (e.g. localhost:6060);
+// nothing on it is authenticated.
+func (s *Simulation) StartProfiling(addr string) *http.Server {
+	expvar.Publish("diagnostics", expvar.Func(func() any { return s.CollectDiagnostics() }))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      profileWriteTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	slog.Info("Serving profiles", "url", "http://"+addr+"/debug/pprof/")
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Profiling server failed", "err", err)
+		}
+	}()
+	return srv
+}
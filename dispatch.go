@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"sort"
+	"time"
+)
+
+const (
+	repositionTicks      = 9    // updates between standby pool top-ups (about 2s)
+	instantPickupRadius  = 0.02 // pickups this close to a landmark use its pool (degrees)
+	standbyRecruitRadius = 0.1  // how far away drivers are recruited into a pool (degrees)
+	maxOffers            = 5    // drivers a ride request is offered to before it goes unserved
+)
+
+// Landmark is a high-demand place (airport, mall, stadium) where a small
+// pool of drivers is kept on warm standby for instant pickups
+type Landmark struct {
+	Name       string
+	Lon, Lat   float64
+	Radius     float64 // degrees; standby drivers wait within this distance
+	PoolTarget int     // number of drivers to keep on standby
+}
+
+// PoolStats reports the occupancy of one standby pool
+type PoolStats struct {
+	Landmark string
+	Target   int
+	Reserved int // drivers assigned to the pool
+	Ready    int // reserved drivers already waiting at the landmark
+}
+
+// generateLandmarks creates the standby landmarks for Erbil and Duhok
+func generateLandmarks() []*Landmark {
+	return []*Landmark{
+		{Name: "Erbil International Airport", Lon: 43.9632, Lat: 36.2376, Radius: 0.005, PoolTarget: 5},
+		{Name: "Erbil Citadel", Lon: 44.0092, Lat: 36.1912, Radius: 0.004, PoolTarget: 3},
+		{Name: "Family Mall Erbil", Lon: 43.9780, Lat: 36.2190, Radius: 0.004, PoolTarget: 3},
+		{Name: "Duhok Bazaar", Lon: 42.9990, Lat: 36.8660, Radius: 0.004, PoolTarget: 2},
+	}
+}
+
+// moveToStandby steers a standby driver towards its landmark and holds it
+// there; d.mu must be held
+func (d *Driver) moveToStandby(deltaTime float64, fences *GeofenceSet) {
+	lm := d.standby
+	lon, lat := d.lon(), d.lat()
+	distKm := geo.HaversineKm(lon, lat, lm.Lon, lm.Lat)
+	if distKm <= geo.DegreesToKm(lm.Radius) {
+		return // waiting at the landmark
+	}
+
+	d.setHeading(geo.BearingTo(lon, lat, lm.Lon, lm.Lat))
+	stepKm := math.Min(geo.DegreesToKm(d.speed()*deltaTime), distKm)
+	newLon, newLat := geo.Offset(lon, lat, d.heading(), stepKm)
+	if fences.Allowed(newLon, newLat) {
+		d.moveTo(newLon, newLat)
+	}
+}
+
+// RepositionStandby tops up standby pools that are below target by
+// recruiting the nearest available drivers, and releases pool drivers that
+// are no longer available. Recruits are found in the published index. It
+// must run on the main loop.
+func (s *Simulation) RepositionStandby() {
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+
+	world := s.world()
+	maxKm := geo.DegreesToKm(standbyRecruitRadius)
+	for _, lm := range s.landmarks {
+		pool := s.standbyPool(lm)
+		missing := lm.PoolTarget - len(pool)
+		if missing <= 0 {
+			continue
+		}
+
+		type candidate struct {
+			driver *Driver
+			distKm float64
+		}
+		var candidates []candidate
+		for _, point := range world.nearby("", lm.Lon, lm.Lat, standbyRecruitRadius) {
+			driver, ok := s.driversByID[point.ID]
+			if !ok {
+				continue // removed since the snapshot
+			}
+			driver.mu.Lock()
+			if driver.Status == Available && driver.standby == nil && driver.Origin == "" && driver.trace == nil {
+				if dist := geo.HaversineKm(driver.lon(), driver.lat(), lm.Lon, lm.Lat); dist <= maxKm {
+					candidates = append(candidates, candidate{driver, dist})
+				}
+			}
+			driver.mu.Unlock()
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].distKm < candidates[j].distKm
+		})
+		for i := 0; i < missing && i < len(candidates); i++ {
+			driver := candidates[i].driver
+			driver.mu.Lock()
+			driver.standby = lm
+			driver.mu.Unlock()
+			pool = append(pool, driver)
+		}
+		s.pools[lm] = pool
+	}
+}
+
+// standbyPool returns the drivers in a landmark's standby pool. It forgets
+// the drivers that left the pool or were removed, and releases the ones
+// that are no longer available. s.driversMu must be held, and it must run
+// on the main loop.
+func (s *Simulation) standbyPool(lm *Landmark) []*Driver {
+	pool := s.pools[lm][:0]
+	for _, driver := range s.pools[lm] {
+		if s.driversByID[driver.ID] != driver {
+			continue
+		}
+		driver.mu.Lock()
+		if driver.standby == lm && driver.Status != Available {
+			driver.standby = nil
+		}
+		member := driver.standby == lm
+		driver.mu.Unlock()
+		if member {
+			pool = append(pool, driver)
+		}
+	}
+	s.pools[lm] = pool
+	return pool
+}
+
+// PoolStats returns the occupancy of every standby pool
+func (s *Simulation) PoolStats() []PoolStats {
+	stats := make([]PoolStats, len(s.landmarks))
+	index := make(map[*Landmark]int, len(s.landmarks))
+	for i, lm := range s.landmarks {
+		stats[i] = PoolStats{Landmark: lm.Name, Target: lm.PoolTarget}
+		index[lm] = i
+	}
+
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		if lm := driver.standby; lm != nil {
+			ps := &stats[index[lm]]
+			ps.Reserved++
+			if geo.HaversineKm(driver.lon(), driver.lat(), lm.Lon, lm.Lat) <= geo.DegreesToKm(lm.Radius) {
+				ps.Ready++
+			}
+		}
+		driver.mu.Unlock()
+	}
+	return stats
+}
+
+// MatchResult is the outcome of matching a ride request to a driver
+type MatchResult struct {
+	Driver   *Driver
+	Instant  bool // served from a standby pool at a nearby landmark
+	Declines int  // offers turned down before a driver accepted
+}
+
+// MatchDriver offers ride request tripID at the given location to drivers
+// in order of preference until one accepts, and marks that driver Busy.
+// Drivers waiting in a standby pool at a landmark next to the pickup are
+// asked first, then available drivers within the search radius from nearest
+// to farthest, up to maxOffers drivers. Whether a driver accepts depends on
+// its profile, the distance to the pickup and how long it has gone without
+// a trip. Every offer and answer is published as an offer event. It returns
+// false when nobody accepts. Candidates come from the nearby pools and the
+// published index rather than the whole fleet. It must run on the main loop.
+func (s *Simulation) MatchDriver(tripID int, lon, lat float64) (MatchResult, bool) {
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+
+	type candidate struct {
+		driver  *Driver
+		distKm  float64
+		instant bool
+	}
+	var candidates []candidate
+	seen := make(map[*Driver]bool)
+	maxKm := geo.DegreesToKm(s.settings.SearchRadius)
+
+	consider := func(driver *Driver) {
+		if seen[driver] || s.driversByID[driver.ID] != driver {
+			return
+		}
+		seen[driver] = true
+
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+		if driver.Status != Available || driver.Origin != "" || driver.trace != nil {
+			return
+		}
+		dist := geo.HaversineKm(lon, lat, driver.lon(), driver.lat())
+		lm := driver.standby
+
+		// A pool driver ready at a landmark close to the pickup goes first
+		ready := lm != nil &&
+			geo.HaversineKm(driver.lon(), driver.lat(), lm.Lon, lm.Lat) <= geo.DegreesToKm(lm.Radius) &&
+			geo.HaversineKm(lon, lat, lm.Lon, lm.Lat) <= geo.DegreesToKm(instantPickupRadius)
+
+		if ready || dist <= maxKm {
+			candidates = append(candidates, candidate{driver, dist, ready})
+		}
+	}
+
+	// The pools of the landmarks next to the pickup, then the drivers the
+	// published index has within the search radius
+	for _, lm := range s.landmarks {
+		if geo.HaversineKm(lon, lat, lm.Lon, lm.Lat) <= geo.DegreesToKm(instantPickupRadius) {
+			for _, driver := range s.pools[lm] {
+				consider(driver)
+			}
+		}
+	}
+	for _, point := range s.world().nearby("", lon, lat, s.settings.SearchRadius) {
+		if driver, ok := s.driversByID[point.ID]; ok {
+			consider(driver)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].instant != candidates[j].instant {
+			return candidates[i].instant
+		}
+		return candidates[i].distKm < candidates[j].distKm
+	})
+
+	now := s.clock.Now()
+	result := MatchResult{}
+	for i := 0; i < len(candidates) && i < maxOffers; i++ {
+		c := candidates[i]
+		s.publishOfferEvent(tripID, c.driver, protocol.OfferMade, i+1, c.distKm)
+
+		c.driver.mu.Lock()
+		sinceTrip := s.clock.Elapsed()
+		if !c.driver.lastTrip.IsZero() {
+			sinceTrip = now.Sub(c.driver.lastTrip)
+		}
+		if s.rand.Float64() >= c.driver.acceptChance(c.distKm, maxKm, sinceTrip) {
+			c.driver.mu.Unlock()
+			result.Declines++
+			s.publishOfferEvent(tripID, c.driver, protocol.OfferDeclined, i+1, c.distKm)
+			continue
+		}
+		c.driver.Status = Busy
+		c.driver.standby = nil
+		c.driver.idle = 0
+		c.driver.mu.Unlock()
+		s.publishOfferEvent(tripID, c.driver, protocol.OfferAccepted, i+1, c.distKm)
+
+		result.Driver = c.driver
+		result.Instant = c.instant
+		return result, true
+	}
+
+	return result, false
+}
+
+// publishOfferEvent tells clients about an offer to a driver or its answer
+func (s *Simulation) publishOfferEvent(tripID int, driver *Driver, event string, attempt int, distKm float64) {
+	s.broadcastMessage(protocol.OfferEvent{
+		Type:       protocol.TypeOfferEvent,
+		Event:      event,
+		TripID:     tripID,
+		DriverID:   driver.ID,
+		Attempt:    attempt,
+		DistanceKm: distKm,
+		Time:       s.clock.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
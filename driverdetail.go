@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"strconv"
+	"time"
+)
+
+// errUnknownDriver is returned for driver IDs not in the simulation
+var errUnknownDriver = errors.New("unknown driver")
+
+// DriverHandler handles GET /api/drivers/{id}
+func (s *Simulation) DriverHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid driver id %q", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	// Trips and destinations change on the main loop, so read them there
+	var detail protocol.DriverDetail
+	err = s.exec(r.Context(), func() error {
+		var err error
+		detail, err = s.driverDetail(id)
+		return err
+	})
+	if errors.Is(err, errUnknownDriver) {
+		http.Error(w, fmt.Sprintf("unknown driver %d", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// driverDetail describes a driver in full. It must run on the main loop.
+func (s *Simulation) driverDetail(id int) (protocol.DriverDetail, error) {
+	d, ok := s.driverByID(id)
+	if !ok {
+		return protocol.DriverDetail{}, errUnknownDriver
+	}
+
+	detail := protocol.DriverDetail{Time: s.clock.Now().UnixNano() / int64(time.Millisecond)}
+
+	d.mu.Lock()
+	detail.Driver = protocol.DriverResponse{
+		ID:       d.ID,
+		Lon:      d.lon(),
+		Lat:      d.lat(),
+		Status:   d.Status.String(),
+		Heading:  math.Mod(d.heading()*180/math.Pi+360, 360),
+		Speed:    d.speed(),
+		Profile:  d.behavior().Name,
+		Vehicle:  d.Vehicle,
+		Origin:   d.Origin,
+		RemoteID: d.RemoteID,
+	}
+	detail.SpeedKmh = geo.DegreesToKm(d.speed()) * 3600
+	detail.City = s.nearestCity(d.lon(), d.lat()).Name
+	if d.dest != nil {
+		detail.Destination = &protocol.Location{Lat: d.dest.lat, Lon: d.dest.lon}
+	}
+	if d.standby != nil {
+		detail.Standby = d.standby.Name
+	}
+	detail.OdometerKm = d.odometer
+	if trip := d.trip; trip != nil {
+		detail.Trip = &protocol.DriverTrip{
+			ID:            trip.ID,
+			State:         trip.State,
+			Pickup:        protocol.Location{Lat: trip.Pickup.lat, Lon: trip.Pickup.lon},
+			Dropoff:       protocol.Location{Lat: trip.Dropoff.lat, Lon: trip.Dropoff.lon},
+			EstimatedFare: trip.Estimate,
+			AssignedAt:    trip.assignedAt.UnixNano() / int64(time.Millisecond),
+		}
+	}
+	d.mu.Unlock()
+
+	d.applyGPS(&detail.Driver)
+	return detail, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"quadtree/filter"
+	"quadtree/geo"
+	"quadtree/protocol"
+)
+
+// driverFields are the fields filter expressions can use on drivers
+var driverFields = filter.Fields{
+	"id":          filter.Number,
+	"status":      filter.String, // "Available", "Busy" or "Offline"
+	"speed":       filter.Number, // degrees of arc per second
+	"speed_kmh":   filter.Number,
+	"heading":     filter.Number, // degrees clockwise from north
+	"distance_km": filter.Number, // from the query center
+	"profile":     filter.String,
+	"type":        filter.String, // vehicle type
+	"vehicle":     filter.String, // alias of type
+	"origin":      filter.String, // federation peer, "" for local drivers
+}
+
+// compileDriverFilter compiles a client's filter expression; an empty
+// expression means no filter
+func compileDriverFilter(expr string) (*filter.Filter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return filter.Compile(expr, driverFields)
+}
+
+// parseStatuses reads the statuses of a client_params message; null or an
+// empty list means every status
+func parseStatuses(raw json.RawMessage) (map[string]bool, error) {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("statuses must be a list of strings")
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	statuses := make(map[string]bool, len(list))
+	for _, status := range list {
+		if !protocol.ValidStatus(status) {
+			return nil, fmt.Errorf("unknown status %v", status)
+		}
+		statuses[status] = true
+	}
+	return statuses, nil
+}
+
+// matchDriver reports whether a driver response passes the filter (nil
+// passes everything)
+func matchDriver(f *filter.Filter, d *protocol.DriverResponse) bool {
+	if f == nil {
+		return true
+	}
+	return f.Match(func(field string) interface{} {
+		switch field {
+		case "id":
+			return float64(d.ID)
+		case "status":
+			return d.Status
+		case "speed":
+			return d.Speed
+		case "speed_kmh":
+			return geo.DegreesToKm(d.Speed) * 3600
+		case "heading":
+			return d.Heading
+		case "distance_km":
+			return d.Distance
+		case "profile":
+			return d.Profile
+		case "origin":
+			return d.Origin
+		default: // type, vehicle
+			return d.Vehicle
+		}
+	})
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Export formats
+const (
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+const (
+	// Ended trips waiting for the exporter (see offer); the ones dropped
+	// count towards export_dropped in /api/diag
+	exportQueueSize = 10000
+	// Rows per Parquet row group, so a long file isn't held in memory
+	exportRowGroupSize = 100_000
+	// Shortest -export-roll, so file names stay unique
+	minExportRoll = time.Minute
+)
+
+// ExportConfig configures exporting a run to files for offline analysis
+type ExportConfig struct {
+	Dir      string
+	Format   string        // ExportCSV or ExportParquet
+	Interval time.Duration // between position samples
+	// Start new files every Roll; 0 writes one pair of files for the run
+	Roll time.Duration
+}
+
+// positionRow is a driver's position in a sample of the world
+type positionRow struct {
+	Tick     int64     `parquet:"tick"` // the world's publish count
+	Time     time.Time `parquet:"time"`
+	DriverID int64     `parquet:"driver_id"`
+	Lon      float64   `parquet:"lon"`
+	Lat      float64   `parquet:"lat"`
+	Status   string    `parquet:"status,dict"`
+	Heading  float64   `parquet:"heading"`
+	SpeedKmh float64   `parquet:"speed_kmh"`
+	City     string    `parquet:"city,dict"`
+	Vehicle  string    `parquet:"vehicle,dict"`
+	Origin   string    `parquet:"origin,dict"`
+}
+
+func (r *positionRow) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(r.Tick, 10), csvTime(r.Time), strconv.FormatInt(r.DriverID, 10),
+		csvFloat(r.Lon), csvFloat(r.Lat), r.Status, csvFloat(r.Heading), csvFloat(r.SpeedKmh),
+		r.City, r.Vehicle, r.Origin,
+	}
+}
+
+// tripRow is a trip that ended, with the columns of the PostGIS trips table
+type tripRow struct {
+	TripID        int64      `parquet:"trip_id"`
+	DriverID      int64      `parquet:"driver_id"`
+	Outcome       string     `parquet:"outcome,dict"`
+	Reason        string     `parquet:"reason,dict"`
+	City          string     `parquet:"city,dict"`
+	AssignedAt    time.Time  `parquet:"assigned_at"`
+	PickedUpAt    *time.Time `parquet:"picked_up_at,optional"`
+	EndedAt       time.Time  `parquet:"ended_at"`
+	WaitS         *float64   `parquet:"wait_s,optional"`
+	PickupETAS    float64    `parquet:"pickup_eta_s"`
+	PickupLon     float64    `parquet:"pickup_lon"`
+	PickupLat     float64    `parquet:"pickup_lat"`
+	DropoffLon    float64    `parquet:"dropoff_lon"`
+	DropoffLat    float64    `parquet:"dropoff_lat"`
+	EstimatedFare float64    `parquet:"estimated_fare"`
+	FinalFare     *float64   `parquet:"final_fare,optional"`
+	DistanceKm    *float64   `parquet:"distance_km,optional"`
+	DurationMin   *float64   `parquet:"duration_min,optional"`
+	Surge         float64    `parquet:"surge"`
+	Currency      string     `parquet:"currency,dict"`
+}
+
+func (r *tripRow) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(r.TripID, 10), strconv.FormatInt(r.DriverID, 10), r.Outcome, r.Reason, r.City,
+		csvTime(r.AssignedAt), csvOptional(r.PickedUpAt, csvTime), csvTime(r.EndedAt),
+		csvOptional(r.WaitS, csvFloat), csvFloat(r.PickupETAS),
+		csvFloat(r.PickupLon), csvFloat(r.PickupLat), csvFloat(r.DropoffLon), csvFloat(r.DropoffLat),
+		csvFloat(r.EstimatedFare), csvOptional(r.FinalFare, csvFloat), csvOptional(r.DistanceKm, csvFloat),
+		csvOptional(r.DurationMin, csvFloat), csvFloat(r.Surge), r.Currency,
+	}
+}
+
+func newTripRow(t endedTrip) tripRow {
+	row := tripRow{
+		TripID:        int64(t.trip.ID),
+		DriverID:      int64(t.trip.Driver.ID),
+		Outcome:       t.outcome,
+		Reason:        t.reason,
+		City:          t.city,
+		AssignedAt:    t.trip.assignedAt,
+		EndedAt:       t.endedAt,
+		PickupETAS:    t.trip.pickupETA.Seconds(),
+		PickupLon:     t.trip.Pickup.lon,
+		PickupLat:     t.trip.Pickup.lat,
+		DropoffLon:    t.trip.Dropoff.lon,
+		DropoffLat:    t.trip.Dropoff.lat,
+		EstimatedFare: t.trip.Estimate.Total,
+		Surge:         t.trip.Estimate.Surge,
+		Currency:      t.trip.Estimate.Currency,
+	}
+	if !t.trip.pickedUpAt.IsZero() {
+		wait := t.trip.pickedUpAt.Sub(t.trip.assignedAt).Seconds()
+		row.PickedUpAt, row.WaitS = &t.trip.pickedUpAt, &wait
+	}
+	if t.finalFare != nil {
+		row.FinalFare, row.DistanceKm, row.DurationMin = &t.finalFare.Total, &t.finalFare.DistanceKm, &t.finalFare.DurationMin
+	}
+	return row
+}
+
+func csvFloat(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+// csvTime writes times as RFC 3339 in UTC with milliseconds, which pandas
+// and DuckDB parse as timestamps
+func csvTime(t time.Time) string { return t.UTC().Format("2006-01-02T15:04:05.000Z") }
+
+// csvOptional writes a missing value as an empty field
+func csvOptional[T any](v *T, format func(T) string) string {
+	if v == nil {
+		return ""
+	}
+	return format(*v)
+}
+
+// exportRow is a row type of an export file
+type exportRow interface {
+	positionRow | tripRow
+}
+
+// exportFile is a CSV or Parquet file rows of one type are written to
+type exportFile[T exportRow] struct {
+	file    *os.File
+	csv     *csv.Writer
+	parquet *parquet.GenericWriter[T]
+}
+
+// createExportFile creates a file named after the table and when it was
+// opened, and writes the CSV header
+func createExportFile[T exportRow](dir, table, format string, opened time.Time) (*exportFile[T], error) {
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.%s", table, opened.UTC().Format("20060102T150405Z"), format))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f := &exportFile[T]{file: file}
+	if format == ExportParquet {
+		f.parquet = parquet.NewGenericWriter[T](file, parquet.MaxRowsPerRowGroup(exportRowGroupSize), parquet.Compression(&parquet.Zstd))
+		return f, nil
+	}
+	f.csv = csv.NewWriter(file)
+	if err := f.csv.Write(exportColumns[T]()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// exportColumns are the column names of a row type, from its Parquet tags
+func exportColumns[T exportRow]() []string {
+	t := reflect.TypeFor[T]()
+	columns := make([]string, t.NumField())
+	for i := range columns {
+		columns[i], _, _ = strings.Cut(t.Field(i).Tag.Get("parquet"), ",")
+	}
+	return columns
+}
+
+// write appends rows. CSV rows are flushed right away, so the file can be
+// read while it grows; Parquet files can only be read once closed.
+func (f *exportFile[T]) write(rows []T) error {
+	if f.parquet != nil {
+		_, err := f.parquet.Write(rows)
+		return err
+	}
+	for i := range rows {
+		var record []string
+		switch r := any(&rows[i]).(type) {
+		case *positionRow:
+			record = r.csvRecord()
+		case *tripRow:
+			record = r.csvRecord()
+		}
+		if err := f.csv.Write(record); err != nil {
+			return err
+		}
+	}
+	f.csv.Flush()
+	return f.csv.Error()
+}
+
+// close finishes the file; a Parquet file gets its footer
+func (f *exportFile[T]) close() error {
+	var err error
+	if f.parquet != nil {
+		err = f.parquet.Close()
+	} else {
+		f.csv.Flush()
+		err = f.csv.Error()
+	}
+	return errors.Join(err, f.file.Close())
+}
+
+// exporter writes position samples and ended trips to files in a directory
+type exporter struct {
+	cfg       ExportConfig
+	trips     chan endedTrip // from the main loop
+	positions *exportFile[positionRow]
+	ended     *exportFile[tripRow]
+	openedAt  time.Time
+	lastTick  int64 // epoch of the last world sampled
+}
+
+// StartExport writes a sample of every driver's position each interval and
+// every trip that ends to files in cfg.Dir, until ctx is canceled. Failing
+// to create the files is an error; once running, failed writes are logged
+// and dropped.
+func (s *Simulation) StartExport(ctx context.Context, cfg ExportConfig) error {
+	switch {
+	case cfg.Format != ExportCSV && cfg.Format != ExportParquet:
+		return fmt.Errorf("unknown export format %q (want %s or %s)", cfg.Format, ExportCSV, ExportParquet)
+	case cfg.Interval <= 0:
+		return fmt.Errorf("invalid export interval %v", cfg.Interval)
+	case cfg.Roll != 0 && cfg.Roll < minExportRoll:
+		return fmt.Errorf("export files can roll at most every %v", minExportRoll)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return err
+	}
+	ex := &exporter{cfg: cfg, trips: make(chan endedTrip, exportQueueSize)}
+	if err := ex.open(time.Now()); err != nil {
+		return err
+	}
+
+	s.export = ex
+	slog.Info("Exporting run", "dir", cfg.Dir, "format", cfg.Format, "interval", cfg.Interval, "roll", cfg.Roll)
+	s.goTracked(func() { s.runExport(ctx, ex) })
+	return nil
+}
+
+// open starts a new pair of files
+func (ex *exporter) open(now time.Time) error {
+	positions, err := createExportFile[positionRow](ex.cfg.Dir, "positions", ex.cfg.Format, now)
+	if err != nil {
+		return err
+	}
+	ended, err := createExportFile[tripRow](ex.cfg.Dir, "trips", ex.cfg.Format, now)
+	if err != nil {
+		positions.close()
+		return err
+	}
+	ex.positions, ex.ended, ex.openedAt = positions, ended, now
+	return nil
+}
+
+// close finishes the current files
+func (ex *exporter) close() error {
+	return errors.Join(ex.positions.close(), ex.ended.close())
+}
+
+// runExport samples the world every interval and writes trips as they end,
+// starting new files every roll, then writes what is left and closes the
+// files once ctx is canceled
+func (s *Simulation) runExport(ctx context.Context, ex *exporter) {
+	ticker := time.NewTicker(ex.cfg.Interval)
+	defer ticker.Stop()
+	failing := false
+	logged := func(kind string, rows int, err error) {
+		switch {
+		case err == nil:
+			s.exportWritten.Add(int64(rows))
+			if failing {
+				slog.Info("Export writes recovered")
+				failing = false
+			}
+		case !failing:
+			slog.Warn("Writing the export failed", "kind", kind, "rows", rows, "err", err)
+			failing = true
+		}
+		if err != nil {
+			s.exportDropped.Add(int64(rows))
+		}
+	}
+	writeTrips := func(trips []endedTrip) {
+		rows := make([]tripRow, len(trips))
+		for i, t := range trips {
+			rows[i] = newTripRow(t)
+		}
+		logged("trips", len(rows), ex.ended.write(rows))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if trips := ex.pending(nil); len(trips) > 0 {
+				writeTrips(trips)
+			}
+			if err := ex.close(); err != nil {
+				slog.Warn("Closing the export failed", "err", err)
+			}
+			return
+		case now := <-ticker.C:
+			if ex.cfg.Roll > 0 && now.Sub(ex.openedAt) >= ex.cfg.Roll {
+				if err := ex.roll(now); err != nil {
+					slog.Warn("Starting new export files failed", "err", err)
+				}
+			}
+			// A paused simulation publishes nothing new to sample
+			world := s.world()
+			if world.epoch == ex.lastTick {
+				continue
+			}
+			ex.lastTick = world.epoch
+			rows := positionRows(world, s.clock.Now())
+			logged("positions", len(rows), ex.positions.write(rows))
+		case trip := <-ex.trips:
+			writeTrips(ex.pending([]endedTrip{trip}))
+		}
+	}
+}
+
+// roll closes the current files and opens new ones; the current ones stay
+// in use if that fails
+func (ex *exporter) roll(now time.Time) error {
+	positions, ended := ex.positions, ex.ended
+	if err := ex.open(now); err != nil {
+		return err
+	}
+	return errors.Join(positions.close(), ended.close())
+}
+
+// pending appends the queued trips to trips without waiting for more
+func (ex *exporter) pending(trips []endedTrip) []endedTrip {
+	for {
+		select {
+		case trip := <-ex.trips:
+			trips = append(trips, trip)
+		default:
+			return trips
+		}
+	}
+}
+
+// positionRows turns a published world into a sample of every driver's
+// position, in the order of their IDs
+func positionRows(world *WorldSnapshot, at time.Time) []positionRow {
+	rows := make([]positionRow, 0, len(world.drivers))
+	for id, state := range world.drivers {
+		rows = append(rows, positionRow{
+			Tick:     world.epoch,
+			Time:     at,
+			DriverID: int64(id),
+			Lon:      state.resp.Lon,
+			Lat:      state.resp.Lat,
+			Status:   state.resp.Status,
+			Heading:  state.resp.Heading,
+			SpeedKmh: speedToKmh(state.resp.Speed),
+			City:     state.city,
+			Vehicle:  state.resp.Vehicle,
+			Origin:   state.resp.Origin,
+		})
+	}
+	slices.SortFunc(rows, func(a, b positionRow) int { return int(a.DriverID - b.DriverID) })
+	return rows
+}
+
+// exportTrip queues a trip that just ended for the trips file. It must run
+// on the main loop, and doesn't block.
+func (s *Simulation) exportTrip(ended endedTrip) {
+	offer(s.export.trips, ended, &s.exportDropped)
+}
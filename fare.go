@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"strconv"
+	"strings"
+)
+
+const (
+	roadFactor     = 1.3  // road distance per straight-line km
+	surgeRadius    = 0.03 // demand and supply are compared this close to the pickup (degrees, about 3.3km)
+	surgeSlope     = 0.25 // surge added per recent request per available driver above one
+	surgeStep      = 0.1  // surge multipliers are rounded to this
+	maxSurge       = 3.0
+	fareRoundingTo = 250 // totals are rounded to the nearest 250 dinar
+)
+
+// FareModel prices trips: base + per-km + per-minute, times the surge
+type FareModel struct {
+	Base     float64
+	PerKm    float64
+	PerMin   float64
+	Minimum  float64
+	Currency string
+}
+
+// defaultFareModel is priced like a Kurdistan ride-hailing app, in Iraqi dinar
+var defaultFareModel = FareModel{Base: 1500, PerKm: 500, PerMin: 75, Minimum: 3000, Currency: "IQD"}
+
+// Price computes the fare of a trip of the given road distance and duration
+func (m FareModel) Price(distanceKm, durationMin, surge float64) protocol.Fare {
+	fare := protocol.Fare{
+		Base:        m.Base,
+		Distance:    m.PerKm * distanceKm,
+		Time:        m.PerMin * durationMin,
+		Surge:       surge,
+		Currency:    m.Currency,
+		DistanceKm:  distanceKm,
+		DurationMin: durationMin,
+	}
+	total := math.Max((fare.Base+fare.Distance+fare.Time)*surge, m.Minimum)
+	fare.Total = math.Round(total/fareRoundingTo) * fareRoundingTo
+	return fare
+}
+
+// EstimateFare quotes a trip between two points at the current surge. The
+// road distance and duration are estimated from the straight-line distance.
+func (s *Simulation) EstimateFare(fromLon, fromLat, toLon, toLat float64) protocol.Fare {
+	distKm := geo.HaversineKm(fromLon, fromLat, toLon, toLat) * roadFactor
+	durationMin := distKm / s.tunables.Load().averageSpeedKmh() * 60
+	return s.fares.Price(distKm, durationMin, s.Surge(fromLon, fromLat))
+}
+
+// Surge returns the price multiplier for a pickup location. It rises when
+// recent ride requests around the pickup outnumber the available drivers.
+func (s *Simulation) Surge(lon, lat float64) float64 {
+	demand := s.heatmap.CountNear(lon, lat, surgeRadius, s.clock.Now())
+	supply := s.availableNear(lon, lat, surgeRadius)
+
+	t := s.tunables.Load()
+	ratio := float64(demand) / float64(max(supply, 1))
+	surge := 1 + math.Max(0, ratio-1)*t.SurgeSlope
+	return math.Min(t.MaxSurge, math.Round(surge/surgeStep)*surgeStep)
+}
+
+// availableNear counts the local available drivers within radius degrees
+func (s *Simulation) availableNear(lon, lat, radius float64) int {
+	maxKm := geo.DegreesToKm(radius)
+
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+
+	count := 0
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		if driver.Status == Available && driver.Origin == "" && driver.trace == nil &&
+			geo.HaversineKm(lon, lat, driver.lon(), driver.lat()) <= maxKm {
+			count++
+		}
+		driver.mu.Unlock()
+	}
+	return count
+}
+
+// parseLatLon parses a "lat,lon" query parameter inside the world bounds
+func parseLatLon(value string, bounds quadtree.Bounds) (protocol.Location, error) {
+	latStr, lonStr, ok := strings.Cut(value, ",")
+	if !ok {
+		return protocol.Location{}, fmt.Errorf("%q is not lat,lon", value)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil {
+		return protocol.Location{}, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil {
+		return protocol.Location{}, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	if !bounds.Contains(lon, lat) {
+		return protocol.Location{}, fmt.Errorf("(%g, %g) is outside the world bounds", lat, lon)
+	}
+	return protocol.Location{Lat: lat, Lon: lon}, nil
+}
+
+// FareHandler handles GET /api/fare?from=lat,lon&to=lat,lon
+func (s *Simulation) FareHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	query := r.URL.Query()
+	from, err := parseLatLon(query.Get("from"), s.settings.bounds())
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseLatLon(query.Get("to"), s.settings.bounds())
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.FareEstimate{
+		From: from,
+		To:   to,
+		Fare: s.EstimateFare(from.Lon, from.Lat, to.Lon, to.Lat),
+	})
+}
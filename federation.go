@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"quadtree/client"
+	"quadtree/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// federatedIDBase offsets the local IDs of federated drivers so they
+	// never collide with (or shift) the IDs of simulated drivers
+	federatedIDBase = 1 << 30
+
+	// Reconnect backoff when a peer is unreachable
+	peerRetryMin = 1 * time.Second
+	peerRetryMax = 30 * time.Second
+)
+
+// Peer is another taxi-sim instance whose drivers are merged into this one
+type Peer struct {
+	Name string `json:"name"` // origin tag of its drivers
+	URL  string `json:"url"`
+	// API key presented to the peer, if it requires one
+	Token string `json:"-"`
+}
+
+// ParsePeers parses a -federate list like
+// "erbil=http://10.0.0.5:8080,duhok=http://10.0.0.6:8080"
+func ParsePeers(spec string) ([]Peer, error) {
+	var peers []Peer
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, rawURL, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid peer %q: want name=url", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate peer name %q", name)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q for peer %s: want http(s)://host:port", rawURL, name)
+		}
+		seen[name] = true
+		peers = append(peers, Peer{Name: name, URL: rawURL})
+	}
+	return peers, nil
+}
+
+// PeerStatus reports the state of the feed from one peer
+type PeerStatus struct {
+	Peer
+	Connected  bool      `json:"connected"`
+	Drivers    int       `json:"drivers"`
+	LastUpdate time.Time `json:"last_update,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// remoteKey identifies a driver by its origin and the ID its peer uses
+type remoteKey struct {
+	origin string
+	id     int
+}
+
+// Federation merges the driver feeds of peer instances into the local
+// world. Remote drivers are read-only: they are never moved, dispatched or
+// recorded locally.
+type Federation struct {
+	// Remote drivers by origin and remote ID; only used on the main loop
+	drivers map[remoteKey]*Driver
+	nextID  int
+
+	mu     sync.Mutex
+	status map[string]*PeerStatus
+}
+
+// NewFederation creates an empty federation
+func NewFederation() *Federation {
+	return &Federation{
+		drivers: make(map[remoteKey]*Driver),
+		status:  make(map[string]*PeerStatus),
+	}
+}
+
+// setStatus updates a peer's status under the lock
+func (f *Federation) setStatus(name string, update func(*PeerStatus)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	update(f.status[name])
+}
+
+// Status returns the state of every peer, sorted by name
+func (f *Federation) Status() []PeerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	peers := make([]PeerStatus, 0, len(f.status))
+	for _, st := range f.status {
+		peers = append(peers, *st)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	return peers
+}
+
+// Federate starts following the given peers until ctx is canceled
+func (s *Simulation) Federate(ctx context.Context, peers []Peer) {
+	for _, peer := range peers {
+		s.federation.mu.Lock()
+		s.federation.status[peer.Name] = &PeerStatus{Peer: peer}
+		s.federation.mu.Unlock()
+
+		s.goTracked(func() { s.followPeer(ctx, peer) })
+	}
+}
+
+// followPeer subscribes to a peer's WebSocket feed covering the whole world
+// and merges every update, reconnecting with backoff when the feed drops
+func (s *Simulation) followPeer(ctx context.Context, peer Peer) {
+	retry := peerRetryMin
+	for {
+		err := s.streamPeer(ctx, peer, func() { retry = peerRetryMin })
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("Federation peer disconnected", "peer", peer.Name, "url", peer.URL, "err", err, "retry_in", retry)
+		s.federation.setStatus(peer.Name, func(st *PeerStatus) {
+			st.Connected = false
+			st.LastError = err.Error()
+		})
+
+		// Drop the peer's drivers rather than showing them frozen in place
+		s.exec(ctx, func() error {
+			s.applyPeerUpdate(peer.Name, nil)
+			return nil
+		})
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		retry = min(retry*2, peerRetryMax)
+	}
+}
+
+// streamPeer runs one connection to a peer; connected is called once the
+// subscription is in place
+func (s *Simulation) streamPeer(ctx context.Context, peer Peer, connected func()) error {
+	c, err := client.New(peer.URL, client.WithToken(peer.Token))
+	if err != nil {
+		return err
+	}
+	conn, err := c.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Close the connection when ctx is canceled so the read loop ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// One subscription around the center of the world that covers all of it
+	err = conn.Subscribe(protocol.ClientParams{
+		Lon:    (s.settings.MinLon + s.settings.MaxLon) / 2,
+		Lat:    (s.settings.MinLat + s.settings.MaxLat) / 2,
+		Radius: math.Max(s.settings.MaxLon-s.settings.MinLon, s.settings.MaxLat-s.settings.MinLat),
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Federating drivers", "peer", peer.Name, "url", peer.URL)
+	s.federation.setStatus(peer.Name, func(st *PeerStatus) {
+		st.Connected = true
+		st.LastError = ""
+	})
+	connected()
+
+	for update, err := range conn.Updates() {
+		if err != nil {
+			return err
+		}
+		err := s.exec(ctx, func() error {
+			s.applyPeerUpdate(peer.Name, update)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("connection closed")
+}
+
+// applyPeerUpdate replaces the drivers from origin with the ones in update
+// (nil removes them all). Drivers the peer itself federated from elsewhere
+// are skipped so instances can follow each other without loops. It must run
+// on the main loop.
+func (s *Simulation) applyPeerUpdate(origin string, update *protocol.DriversUpdate) {
+	fed := s.federation
+	seen := make(map[int]bool)
+	var added []*Driver
+
+	if update != nil {
+		for _, rd := range update.Drivers {
+			if rd.Origin != "" {
+				continue
+			}
+			status, err := parseDriverStatus(rd.Status)
+			if err != nil {
+				continue
+			}
+			seen[rd.ID] = true
+
+			key := remoteKey{origin, rd.ID}
+			driver, ok := fed.drivers[key]
+			if !ok {
+				fed.nextID++
+				driver = &Driver{ID: federatedIDBase + fed.nextID, Origin: origin, RemoteID: rd.ID, motion: newMotion(0, 0, 0, 0)}
+				fed.drivers[key] = driver
+				added = append(added, driver)
+			}
+
+			driver.mu.Lock()
+			driver.moveTo(rd.Lon, rd.Lat)
+			driver.Status = status
+			driver.setSpeed(rd.Speed)
+			driver.setHeading(rd.Heading * math.Pi / 180)
+			driver.setVelocity(rd.VelLon, rd.VelLat)
+			driver.reportedAt = time.Now()
+			if rd.UpdatedAt != 0 {
+				driver.reportedAt = time.Unix(0, rd.UpdatedAt*int64(time.Millisecond))
+			}
+			driver.Vehicle = rd.Vehicle
+			if profile, ok := findProfile(rd.Profile); ok {
+				driver.profile = profile
+			}
+			driver.mu.Unlock()
+		}
+	}
+
+	removed := make(map[*Driver]bool)
+	for key, driver := range fed.drivers {
+		if key.origin == origin && !seen[key.id] {
+			removed[driver] = true
+			delete(fed.drivers, key)
+		}
+	}
+
+	s.swapDrivers(removed, added)
+	s.UpdateIndex()
+
+	s.federation.setStatus(origin, func(st *PeerStatus) {
+		st.Drivers = len(seen)
+		if update != nil {
+			st.LastUpdate = time.Now()
+		}
+	})
+}
+
+// FederationHandler lists the federation peers and the state of their feeds
+func (s *Simulation) FederationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peers": s.federation.Status(),
+	})
+}
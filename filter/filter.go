@@ -0,0 +1,496 @@
+// Package filter implements the small expression language WebSocket and HTTP
+// clients use to filter drivers on the server, e.g.
+//
+//	status == "Available" && speed_kmh > 20 && type in ["car", "van"]
+//
+// Expressions combine comparisons (==, !=, <, <=, >, >=), membership tests
+// (in [...]), !, && and || with parentheses. Operands are declared fields,
+// numbers, "strings" and true/false. Expressions are type checked when
+// compiled, so evaluation never fails.
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxLength limits the size of an expression
+const MaxLength = 1024
+
+// maxDepth limits nesting of parentheses and unary operators
+const maxDepth = 32
+
+// Kind is the type of a field or value
+type Kind int
+
+const (
+	Number Kind = iota
+	String
+	Bool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	default:
+		return "unknown"
+	}
+}
+
+// Fields declares the fields an expression may use and their kinds
+type Fields map[string]Kind
+
+// Record returns the value of a field while an expression is evaluated: a
+// float64 for Number fields, a string for String fields and a bool for Bool
+// fields
+type Record func(field string) interface{}
+
+// Filter is a compiled expression
+type Filter struct {
+	src  string
+	root node
+}
+
+// Compile parses and type checks an expression against the declared fields
+func Compile(src string, fields Fields) (*Filter, error) {
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("filter: expression longer than %d bytes", MaxLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, fields: fields}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("filter: unexpected %s at position %d", tok, tok.pos)
+	}
+	if root.kind() != Bool {
+		return nil, fmt.Errorf("filter: expression is a %s, not a condition", root.kind())
+	}
+
+	return &Filter{src: src, root: root}, nil
+}
+
+// Match reports whether the record satisfies the expression
+func (f *Filter) Match(rec Record) bool {
+	return f.root.eval(rec).(bool)
+}
+
+// String returns the source of the expression
+func (f *Filter) String() string {
+	return f.src
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp // == != < <= > >= && || ! ( ) [ ] ,
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits an expression into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			// Quoted string; backslash escapes the next character
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("filter: unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: b.String(), pos: i})
+			i = j + 1
+
+		case c >= '0' && c <= '9' || c == '.' || c == '-' && i+1 < len(src) && (src[i+1] >= '0' && src[i+1] <= '9' || src[i+1] == '.'):
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("filter: invalid number %q at position %d", src[i:j], i)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:j], num: num, pos: i})
+			i = j
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("filter: unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields Fields
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("filter: expected %q but found %s at position %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+// parseOr parses a || b || ...
+func (p *parser) parseOr(depth int) (node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		tok := p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(tok, left, right); err != nil {
+			return nil, err
+		}
+		left = &logicNode{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses a && b && ...
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		tok := p.next()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(tok, left, right); err != nil {
+			return nil, err
+		}
+		left = &logicNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses !a and comparisons
+func (p *parser) parseUnary(depth int) (node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("filter: expression nested too deeply")
+	}
+
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "!" {
+		p.next()
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBool(tok, operand); err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+
+	return p.parseComparison(depth)
+}
+
+// parseComparison parses a == b, a < b, a in [...] or a single operand
+func (p *parser) parseComparison(depth int) (node, error) {
+	left, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == tokIdent && tok.text == "in":
+		p.next()
+		values, err := p.parseList(left.kind())
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{operand: left, values: values}, nil
+
+	case tok.kind == tokOp && (tok.text == "==" || tok.text == "!=" || tok.text == "<" ||
+		tok.text == "<=" || tok.text == ">" || tok.text == ">="):
+		p.next()
+		right, err := p.parseOperand(depth)
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != right.kind() {
+			return nil, fmt.Errorf("filter: cannot compare %s with %s at position %d", left.kind(), right.kind(), tok.pos)
+		}
+		if tok.text != "==" && tok.text != "!=" && left.kind() != Number {
+			return nil, fmt.Errorf("filter: %s needs numbers, not %ss, at position %d", tok.text, left.kind(), tok.pos)
+		}
+		return &compareNode{op: tok.text, left: left, right: right}, nil
+	}
+
+	return left, nil
+}
+
+// parseOperand parses a field, literal or parenthesized expression
+func (p *parser) parseOperand(depth int) (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &literalNode{value: tok.num, k: Number}, nil
+	case tokString:
+		return &literalNode{value: tok.text, k: String}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &literalNode{value: tok.text == "true", k: Bool}, nil
+		case "in":
+			return nil, fmt.Errorf("filter: unexpected \"in\" at position %d", tok.pos)
+		}
+		k, ok := p.fields[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("filter: unknown field %q at position %d", tok.text, tok.pos)
+		}
+		return &fieldNode{name: tok.text, k: k}, nil
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("filter: unexpected %s at position %d", tok, tok.pos)
+}
+
+// parseList parses ["a", "b", ...] whose elements must all be of kind k
+func (p *parser) parseList(k Kind) ([]interface{}, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	if p.accept("]") {
+		return values, nil
+	}
+	for {
+		tok := p.next()
+		var value interface{}
+		var vk Kind
+		switch {
+		case tok.kind == tokNumber:
+			value, vk = tok.num, Number
+		case tok.kind == tokString:
+			value, vk = tok.text, String
+		case tok.kind == tokIdent && (tok.text == "true" || tok.text == "false"):
+			value, vk = tok.text == "true", Bool
+		default:
+			return nil, fmt.Errorf("filter: expected a value but found %s at position %d", tok, tok.pos)
+		}
+		if vk != k {
+			return nil, fmt.Errorf("filter: %s value in a list of %ss at position %d", vk, k, tok.pos)
+		}
+		values = append(values, value)
+
+		if p.accept("]") {
+			return values, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkBool makes sure the operands of a logical operator are conditions
+func checkBool(op token, operands ...node) error {
+	for _, operand := range operands {
+		if operand.kind() != Bool {
+			return fmt.Errorf("filter: %s needs conditions, not a %s, at position %d", op.text, operand.kind(), op.pos)
+		}
+	}
+	return nil
+}
+
+// Expression tree
+
+type node interface {
+	kind() Kind
+	eval(rec Record) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+	k     Kind
+}
+
+func (n *literalNode) kind() Kind              { return n.k }
+func (n *literalNode) eval(Record) interface{} { return n.value }
+
+type fieldNode struct {
+	name string
+	k    Kind
+}
+
+func (n *fieldNode) kind() Kind                  { return n.k }
+func (n *fieldNode) eval(rec Record) interface{} { return rec(n.name) }
+
+type logicNode struct {
+	or          bool
+	left, right node
+}
+
+func (n *logicNode) kind() Kind { return Bool }
+
+func (n *logicNode) eval(rec Record) interface{} {
+	if n.or {
+		return n.left.eval(rec).(bool) || n.right.eval(rec).(bool)
+	}
+	return n.left.eval(rec).(bool) && n.right.eval(rec).(bool)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) kind() Kind                  { return Bool }
+func (n *notNode) eval(rec Record) interface{} { return !n.operand.eval(rec).(bool) }
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) kind() Kind { return Bool }
+
+func (n *compareNode) eval(rec Record) interface{} {
+	a, b := n.left.eval(rec), n.right.eval(rec)
+	switch n.op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+
+	x, y := a.(float64), b.(float64)
+	switch n.op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
+
+type inNode struct {
+	operand node
+	values  []interface{}
+}
+
+func (n *inNode) kind() Kind { return Bool }
+
+func (n *inNode) eval(rec Record) interface{} {
+	v := n.operand.eval(rec)
+	for _, candidate := range n.values {
+		if v == candidate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+// testFields are the fields the tests' expressions may use
+var testFields = Fields{
+	"status":    String,
+	"speed_kmh": Number,
+	"type":      String,
+	"busy":      Bool,
+}
+
+// testDriver is the record the tests' expressions are matched against
+func testDriver(field string) interface{} {
+	switch field {
+	case "status":
+		return "Available"
+	case "speed_kmh":
+		return 35.0
+	case "type":
+		return "van"
+	case "busy":
+		return false
+	}
+	return nil
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`status == "Available"`, true},
+		{`status != "Available"`, false},
+		{`status == 'Available'`, true},
+		{`speed_kmh > 20`, true},
+		{`speed_kmh >= 35`, true},
+		{`speed_kmh < 35`, false},
+		{`speed_kmh <= 3.5e1`, true},
+		{`speed_kmh > -1`, true},
+		{`type in ["car", "van"]`, true},
+		{`type in ["car"]`, false},
+		{`type in []`, false},
+		{`speed_kmh in [10, 35]`, true},
+		{`busy`, false},
+		{`!busy`, true},
+		{`busy == false`, true},
+		{`true`, true},
+		{`status == "Available" && speed_kmh > 20 && type in ["car", "van"]`, true},
+		{`status == "Busy" || speed_kmh > 20`, true},
+		{`status == "Busy" || speed_kmh > 50`, false},
+		{`!(status == "Busy" || busy)`, true},
+		{`status == "Busy" && busy || !busy`, true}, // && binds tighter than ||
+		{`status == "A\"b"`, false},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.src, testFields)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", tt.src, err)
+			continue
+		}
+		if got := f.Match(testDriver); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+		if f.String() != tt.src {
+			t.Errorf("String() = %q, want %q", f.String(), tt.src)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // the error, after "filter: "
+	}{
+		{``, `unexpected end of expression at position 0`},
+		{`status ==`, `unexpected end of expression at position 9`},
+		{`colour == "red"`, `unknown field "colour" at position 0`},
+		{`status == "Available`, `unterminated string at position 10`},
+		{`speed_kmh > 1.2.3`, `invalid number "1.2.3" at position 12`},
+		{`speed_kmh > 20 # comment`, `unexpected character '#' at position 15`},
+		{`status == 20`, `cannot compare string with number at position 7`},
+		{`status < "B"`, `< needs numbers, not strings, at position 7`},
+		{`speed_kmh && busy`, `&& needs conditions, not a number, at position 10`},
+		{`busy || status`, `|| needs conditions, not a string, at position 5`},
+		{`!speed_kmh`, `! needs conditions, not a number, at position 0`},
+		{`speed_kmh`, `expression is a number, not a condition`},
+		{`(busy`, `expected ")" but found end of expression at position 5`},
+		{`busy)`, `unexpected ")" at position 4`},
+		{`type in "van"`, `expected "[" but found "van" at position 8`},
+		{`type in ["car", 3]`, `number value in a list of strings at position 16`},
+		{`type in ["car" "van"]`, `expected "," but found "van" at position 15`},
+		{`type in [status]`, `expected a value but found "status" at position 9`},
+		{`in == "x"`, `unexpected "in" at position 0`},
+		{strings.Repeat("(", 40) + "busy" + strings.Repeat(")", 40), `expression nested too deeply`},
+		{strings.Repeat(" ", MaxLength) + "busy", `expression longer than 1024 bytes`},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, testFields)
+		if err == nil {
+			t.Errorf("Compile(%s) succeeded, want %q", tt.src, tt.want)
+			continue
+		}
+		if got := strings.TrimPrefix(err.Error(), "filter: "); got != tt.want {
+			t.Errorf("Compile(%s) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
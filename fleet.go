@@ -0,0 +1,74 @@
+package main
+
+// fleetMotion holds the motion of drivers in columns rather than in the
+// drivers themselves: the driver in row i is at lon[i], lat[i], heading
+// heading[i] radians at speed[i], and covered vlon[i], vlat[i] degrees per
+// second in its last movement step. The simulation keeps one for all its
+// drivers, in the order of its drivers slice, so moving the fleet and
+// indexing its positions scan contiguous memory even with tens of thousands
+// of drivers. A Driver is a view of its row; one that isn't part of the
+// simulation (yet or anymore) has a table of its own.
+type fleetMotion struct {
+	lon, lat, heading, speed []float64
+	vlon, vlat               []float64
+}
+
+// newMotion returns a table holding the motion of one driver
+func newMotion(lon, lat, speed, heading float64) *fleetMotion {
+	return &fleetMotion{
+		lon:     []float64{lon},
+		lat:     []float64{lat},
+		heading: []float64{heading},
+		speed:   []float64{speed},
+		vlon:    []float64{0},
+		vlat:    []float64{0},
+	}
+}
+
+// arrange makes the table hold the motion of the given drivers, in order,
+// and points every driver at its row. Drivers in the table that aren't
+// given must be detached first. It must run on the main loop with
+// s.driversMu held.
+func (m *fleetMotion) arrange(drivers []*Driver) {
+	n := len(drivers)
+	lon, lat := make([]float64, n), make([]float64, n)
+	heading, speed := make([]float64, n), make([]float64, n)
+	vlon, vlat := make([]float64, n), make([]float64, n)
+	for i, d := range drivers {
+		lon[i], lat[i], heading[i], speed[i] = d.lon(), d.lat(), d.heading(), d.speed()
+		vlon[i], vlat[i] = d.velocity()
+	}
+
+	m.lon, m.lat, m.heading, m.speed = lon, lat, heading, speed
+	m.vlon, m.vlat = vlon, vlat
+	for i, d := range drivers {
+		d.motion, d.row = m, i
+	}
+}
+
+// detach moves the driver's motion to a table of its own, for drivers
+// leaving the simulation
+func (d *Driver) detach() {
+	m := newMotion(d.lon(), d.lat(), d.speed(), d.heading())
+	m.vlon[0], m.vlat[0] = d.velocity()
+	d.motion, d.row = m, 0
+}
+
+// lon, lat, heading, speed and velocity read the driver's row
+func (d *Driver) lon() float64     { return d.motion.lon[d.row] }
+func (d *Driver) lat() float64     { return d.motion.lat[d.row] }
+func (d *Driver) heading() float64 { return d.motion.heading[d.row] }
+func (d *Driver) speed() float64   { return d.motion.speed[d.row] }
+func (d *Driver) velocity() (float64, float64) {
+	return d.motion.vlon[d.row], d.motion.vlat[d.row]
+}
+
+// moveTo, setHeading, setSpeed and setVelocity write the driver's row
+func (d *Driver) moveTo(lon, lat float64) {
+	d.motion.lon[d.row], d.motion.lat[d.row] = lon, lat
+}
+func (d *Driver) setHeading(heading float64) { d.motion.heading[d.row] = heading }
+func (d *Driver) setSpeed(speed float64)     { d.motion.speed[d.row] = speed }
+func (d *Driver) setVelocity(vlon, vlat float64) {
+	d.motion.vlon[d.row], d.motion.vlat[d.row] = vlon, vlat
+}
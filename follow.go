@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"quadtree/protocol"
+	"sort"
+	"time"
+)
+
+// maxFollows is how many drivers one connection may follow
+const maxFollows = 5
+
+// handleFollow processes a follow or unfollow message, answering with the
+// followed drivers or an error. A new driver's first track follows right
+// away.
+func (s *Simulation) handleFollow(client *WebSocketClient, requestID, msgType string, message []byte) {
+	driver, err := s.updateFollows(client, msgType, message)
+	if err != nil {
+		s.sendReply(client, requestID, protocol.ErrorMessage{Type: protocol.TypeError, Error: err.Error()})
+		return
+	}
+
+	client.subsMu.Lock()
+	following := make([]int, 0, len(client.follows))
+	for id := range client.follows {
+		following = append(following, id)
+	}
+	client.subsMu.Unlock()
+	sort.Ints(following)
+	s.sendReply(client, requestID, protocol.Following{Type: protocol.TypeFollowing, Drivers: following})
+
+	if driver != nil {
+		s.sendJSON(client, s.driverTrack(driver))
+	}
+}
+
+// updateFollows applies a follow or unfollow message and returns the driver
+// that was followed, if any
+func (s *Simulation) updateFollows(client *WebSocketClient, msgType string, message []byte) (*Driver, error) {
+	if msgType == protocol.TypeUnfollow {
+		var msg protocol.Unfollow
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return nil, fmt.Errorf("invalid unfollow message: %v", err)
+		}
+
+		client.subsMu.Lock()
+		defer client.subsMu.Unlock()
+		if msg.DriverID == 0 {
+			clear(client.follows)
+			return nil, nil
+		}
+		if !client.follows[msg.DriverID] {
+			return nil, fmt.Errorf("not following driver %d", msg.DriverID)
+		}
+		delete(client.follows, msg.DriverID)
+		return nil, nil
+	}
+
+	var msg protocol.Follow
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, fmt.Errorf("invalid follow message: %v", err)
+	}
+
+	driver, ok := s.driverByID(msg.DriverID)
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %d", msg.DriverID)
+	}
+
+	client.subsMu.Lock()
+	defer client.subsMu.Unlock()
+	if client.follows == nil {
+		client.follows = make(map[int]bool)
+	}
+	if !client.follows[msg.DriverID] && len(client.follows) >= maxFollows {
+		return nil, fmt.Errorf("at most %d followed drivers per connection", maxFollows)
+	}
+	client.follows[msg.DriverID] = true
+	return driver, nil
+}
+
+// publishDriverTracks sends every followed driver to its followers. Drivers
+// that left the simulation are no longer followed, which their followers
+// are told with an error. It must run on the main loop, after the drivers
+// moved.
+func (s *Simulation) publishDriverTracks() {
+	followers := make(map[*WebSocketClient][]int)
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		client.subsMu.Lock()
+		for id := range client.follows {
+			followers[client] = append(followers[client], id)
+		}
+		client.subsMu.Unlock()
+	}
+	s.clientsMu.RUnlock()
+	if len(followers) == 0 {
+		return
+	}
+
+	tracks := make(map[int]protocol.DriverTrack)
+
+	for client, ids := range followers {
+		sort.Ints(ids)
+		for _, id := range ids {
+			driver, ok := s.driverByID(id)
+			if !ok {
+				client.subsMu.Lock()
+				delete(client.follows, id)
+				client.subsMu.Unlock()
+				s.sendJSON(client, protocol.ErrorMessage{
+					Type:  protocol.TypeError,
+					Error: fmt.Sprintf("driver %d left the simulation", id),
+				})
+				continue
+			}
+
+			track, ok := tracks[id]
+			if !ok {
+				track = s.driverTrack(driver)
+				tracks[id] = track
+			}
+			s.sendJSON(client, track)
+		}
+	}
+}
+
+// driverTrack describes a followed driver
+func (s *Simulation) driverTrack(d *Driver) protocol.DriverTrack {
+	track := protocol.DriverTrack{
+		Type: protocol.TypeDriverTrack,
+		Time: s.clock.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	d.mu.Lock()
+	track.Driver = protocol.DriverResponse{
+		ID:       d.ID,
+		Lon:      d.lon(),
+		Lat:      d.lat(),
+		Status:   d.Status.String(),
+		Heading:  math.Mod(d.heading()*180/math.Pi+360, 360),
+		Speed:    d.speed(),
+		Profile:  d.behavior().Name,
+		Vehicle:  d.Vehicle,
+		Origin:   d.Origin,
+		RemoteID: d.RemoteID,
+	}
+	if d.trip != nil {
+		track.TripID = d.trip.ID
+		track.TripState = d.trip.State
+	}
+	d.mu.Unlock()
+
+	d.applyGPS(&track.Driver)
+	return track
+}
@@ -0,0 +1,107 @@
+// Package geo provides geodesic helpers for working with longitude/latitude
+// coordinates on the Earth's surface.
+package geo
+
+import "math"
+
+const (
+	// EarthRadiusKm is the mean Earth radius
+	EarthRadiusKm = 6371.0088
+
+	// KmPerDegree is the length of one degree of arc along a great circle
+	// (one degree of latitude, or of longitude at the equator)
+	KmPerDegree = EarthRadiusKm * math.Pi / 180
+)
+
+// toRadians converts degrees to radians
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// HaversineKm returns the great-circle distance in km between two points
+func HaversineKm(lon1, lat1, lon2, lat2 float64) float64 {
+	phi1, phi2 := toRadians(lat1), toRadians(lat2)
+	dPhi := phi2 - phi1
+	dLambda := toRadians(lon2 - lon1)
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// DegreesToKm converts an arc length in degrees to km
+func DegreesToKm(deg float64) float64 {
+	return deg * KmPerDegree
+}
+
+// KmToDegrees converts a distance in km to degrees of arc
+func KmToDegrees(km float64) float64 {
+	return km / KmPerDegree
+}
+
+// LonScale returns how many degrees of longitude span one degree of arc at
+// the given latitude. East-west distances shrink with cos(latitude), so
+// covering the same ground takes more degrees of longitude away from the
+// equator.
+func LonScale(lat float64) float64 {
+	cosLat := math.Cos(toRadians(lat))
+	// Avoid blowing up at the poles
+	if cosLat < 1e-6 {
+		cosLat = 1e-6
+	}
+	return 1 / cosLat
+}
+
+// Offset moves a point distKm along the heading (radians clockwise from
+// north) and returns the new position. It treats the Earth as locally flat,
+// which is accurate for the short steps used by the simulation.
+func Offset(lon, lat, heading, distKm float64) (float64, float64) {
+	distDeg := KmToDegrees(distKm)
+	newLat := lat + math.Cos(heading)*distDeg
+	newLon := lon + math.Sin(heading)*distDeg*LonScale(lat)
+	return newLon, newLat
+}
+
+// Polygon is a closed ring of [lon, lat] vertices (GeoJSON order). The last
+// vertex may repeat the first one but doesn't have to.
+type Polygon [][2]float64
+
+// Contains reports whether the point lies inside the polygon, using the
+// even-odd ray casting rule
+func (p Polygon) Contains(lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		xi, yi := p[i][0], p[i][1]
+		xj, yj := p[j][0], p[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Bounds returns the polygon's bounding box
+func (p Polygon) Bounds() (minLon, minLat, maxLon, maxLat float64) {
+	minLon, minLat = math.Inf(1), math.Inf(1)
+	maxLon, maxLat = math.Inf(-1), math.Inf(-1)
+	for _, v := range p {
+		minLon, maxLon = math.Min(minLon, v[0]), math.Max(maxLon, v[0])
+		minLat, maxLat = math.Min(minLat, v[1]), math.Max(maxLat, v[1])
+	}
+	return minLon, minLat, maxLon, maxLat
+}
+
+// BearingTo returns the initial bearing in radians (clockwise from north,
+// in [0, 2π)) from the first point towards the second
+func BearingTo(lon1, lat1, lon2, lat2 float64) float64 {
+	phi1, phi2 := toRadians(lat1), toRadians(lat2)
+	dLambda := toRadians(lon2 - lon1)
+
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	bearing := math.Atan2(y, x)
+	if bearing < 0 {
+		bearing += 2 * math.Pi
+	}
+	return bearing
+}
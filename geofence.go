@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"quadtree/geo"
+)
+
+// Geofence zone kinds
+const (
+	ZoneNoGo     = "no_go"    // drivers never enter (lakes, military zones, runways)
+	ZoneBoundary = "boundary" // drivers stay inside (outer city limits)
+	ZoneWatch    = "watch"    // drivers move freely; entering and leaving is reported to webhooks
+)
+
+// GeofenceZone is a named polygon that restricts driver movement
+type GeofenceZone struct {
+	Name    string      `json:"name"`
+	Kind    string      `json:"kind"`    // no_go, boundary or watch
+	Polygon geo.Polygon `json:"polygon"` // [lon, lat] vertices
+}
+
+// GeofenceSet holds the zones that constrain where drivers may be. A position
+// is allowed when it is outside every no-go zone and, if any boundary zones
+// exist, inside at least one of them.
+type GeofenceSet struct {
+	Zones []GeofenceZone `json:"zones"`
+}
+
+// LoadGeofences reads geofence zones from a JSON file
+func LoadGeofences(path string) (*GeofenceSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read geofences: %w", err)
+	}
+
+	var fences GeofenceSet
+	if err := json.Unmarshal(data, &fences); err != nil {
+		return nil, fmt.Errorf("parse geofences: %w", err)
+	}
+
+	for i, zone := range fences.Zones {
+		if zone.Kind != ZoneNoGo && zone.Kind != ZoneBoundary && zone.Kind != ZoneWatch {
+			return nil, fmt.Errorf("geofence %d (%s): unknown kind %q", i, zone.Name, zone.Kind)
+		}
+		if len(zone.Polygon) < 3 {
+			return nil, fmt.Errorf("geofence %d (%s): polygon needs at least 3 vertices", i, zone.Name)
+		}
+	}
+	return &fences, nil
+}
+
+// Allowed reports whether a driver may be at the given position. A nil set
+// allows everything.
+func (fs *GeofenceSet) Allowed(lon, lat float64) bool {
+	if fs == nil {
+		return true
+	}
+
+	hasBoundary, insideBoundary := false, false
+	for _, zone := range fs.Zones {
+		switch zone.Kind {
+		case ZoneNoGo:
+			if zone.Polygon.Contains(lon, lat) {
+				return false
+			}
+		case ZoneBoundary:
+			hasBoundary = true
+			if !insideBoundary && zone.Polygon.Contains(lon, lat) {
+				insideBoundary = true
+			}
+		}
+	}
+	return !hasBoundary || insideBoundary
+}
+
+// watched returns the watch zones. A nil set has none.
+func (fs *GeofenceSet) watched() []GeofenceZone {
+	if fs == nil {
+		return nil
+	}
+	var zones []GeofenceZone
+	for _, zone := range fs.Zones {
+		if zone.Kind == ZoneWatch {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// deflect turns a driver that hit a geofence back the way it came, with some
+// randomness so it doesn't bounce along the same line forever
+func deflect(heading float64, r *rand.Rand) float64 {
+	heading += math.Pi + (r.Float64()*2-1)*math.Pi/4
+	return math.Mod(heading+2*math.Pi, 2*math.Pi)
+}
+
+// placeInCity picks a random allowed position within a city's radius,
+// falling back to the city center after too many attempts
+func placeInCity(city City, minFrac, maxFrac float64, fences *GeofenceSet, r *rand.Rand) (float64, float64) {
+	for attempt := 0; attempt < 100; attempt++ {
+		angle := r.Float64() * 2 * math.Pi
+		distance := (minFrac + r.Float64()*(maxFrac-minFrac)) * city.Radius
+		lon, lat := geo.Offset(city.Lon, city.Lat, angle, geo.DegreesToKm(distance))
+		if fences.Allowed(lon, lat) {
+			return lon, lat
+		}
+	}
+	return city.Lon, city.Lat
+}
+
+// SetGeofences installs geofences and relocates drivers that are currently
+// in forbidden positions. It must run before the simulation starts or on the
+// main loop.
+func (s *Simulation) SetGeofences(fences *GeofenceSet) int {
+	s.geofences = fences
+
+	relocated := 0
+	s.driversMu.RLock()
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		if !fences.Allowed(driver.lon(), driver.lat()) {
+			driver.moveTo(placeDriver(s.nearestCity(driver.lon(), driver.lat()), fences, s.rand))
+			relocated++
+		}
+		driver.mu.Unlock()
+	}
+	s.driversMu.RUnlock()
+
+	s.UpdateIndex()
+	return relocated
+}
+
+// nearestCity returns the city whose center is closest to the position
+func (s *Simulation) nearestCity(lon, lat float64) City {
+	return closestCity(s.cities, lon, lat)
+}
+
+// closestCity returns the city of the list closest to the position
+func closestCity(cities []City, lon, lat float64) City {
+	nearest := cities[0]
+	minDist := math.MaxFloat64
+	for _, city := range cities {
+		if dist := geo.HaversineKm(lon, lat, city.Lon, city.Lat); dist < minDist {
+			minDist = dist
+			nearest = city
+		}
+	}
+	return nearest
+}
+
+// GeofencesHandler returns the configured geofence zones so clients can draw them
+func (s *Simulation) GeofencesHandler(w http.ResponseWriter, r *http.Request) {
+	fences := s.geofences
+	if fences == nil {
+		fences = &GeofenceSet{Zones: []GeofenceZone{}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+	json.NewEncoder(w).Encode(fences)
+}
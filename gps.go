@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"quadtree/geo"
+	"quadtree/protocol"
+)
+
+const (
+	gpsBiasTau      = 30.0 // seconds the slowly drifting part of the GPS error stays correlated
+	gpsOutlierProb  = 0.01 // chance per fix of a multipath jump
+	gpsOutlierScale = 5.0  // multipath jumps are this many times the noise level
+)
+
+// gpsTrack simulates the GPS receiver of a driver: noisy fixes around the
+// true position, and a Kalman filter that smooths them again. Positions are
+// in degrees; the filter runs independently on each axis with a
+// constant-position model whose process noise follows the driver's speed.
+type gpsTrack struct {
+	biasE, biasN   float64 // drifting error in meters (first-order Gauss-Markov)
+	rawLon, rawLat float64 // latest noisy fix
+
+	lon, lat    float64 // filtered position
+	varLon      float64 // filter variance, degrees squared
+	varLat      float64
+	initialized bool
+}
+
+// update takes a new fix of a driver at the true position, moving at speed
+// degrees of arc per second, deltaTime seconds after the previous one.
+// sigma is the noise level in meters.
+func (g *gpsTrack) update(lon, lat, speed, deltaTime, sigma float64, r *rand.Rand) {
+	// Drifting error plus white noise, and the occasional multipath outlier
+	decay := math.Exp(-deltaTime / gpsBiasTau)
+	drift := sigma * math.Sqrt(1-decay*decay)
+	g.biasE = g.biasE*decay + r.NormFloat64()*drift
+	g.biasN = g.biasN*decay + r.NormFloat64()*drift
+	errE := g.biasE + r.NormFloat64()*sigma/2
+	errN := g.biasN + r.NormFloat64()*sigma/2
+	if r.Float64() < gpsOutlierProb {
+		errE += r.NormFloat64() * sigma * gpsOutlierScale
+		errN += r.NormFloat64() * sigma * gpsOutlierScale
+	}
+
+	latDeg := geo.KmToDegrees(errN / 1000)
+	lonDeg := geo.KmToDegrees(errE/1000) * geo.LonScale(lat)
+	g.rawLon, g.rawLat = lon+lonDeg, lat+latDeg
+
+	// Measurement variance in degrees squared, per axis
+	sigmaLat := geo.KmToDegrees(sigma / 1000)
+	sigmaLon := sigmaLat * geo.LonScale(lat)
+	if !g.initialized {
+		g.lon, g.lat = g.rawLon, g.rawLat
+		g.varLon, g.varLat = sigmaLon*sigmaLon, sigmaLat*sigmaLat
+		g.initialized = true
+		return
+	}
+
+	// Predict: the driver may have moved up to speed*deltaTime either way
+	step := speed * deltaTime
+	g.varLat += step * step
+	g.varLon += step * step * geo.LonScale(lat) * geo.LonScale(lat)
+
+	// Correct with the new fix
+	g.lon, g.varLon = kalmanCorrect(g.lon, g.varLon, g.rawLon, sigmaLon*sigmaLon)
+	g.lat, g.varLat = kalmanCorrect(g.lat, g.varLat, g.rawLat, sigmaLat*sigmaLat)
+}
+
+// kalmanCorrect blends an estimate with a measurement by their variances
+func kalmanCorrect(estimate, variance, measured, noise float64) (float64, float64) {
+	gain := variance / (variance + noise)
+	return estimate + gain*(measured-estimate), (1 - gain) * variance
+}
+
+// SetGPSNoise turns on simulated GPS noise of sigma meters (0 turns it off).
+// Reported positions are then the filtered fixes, with the raw fixes next
+// to them. It must run before the simulation starts or on the main loop.
+func (s *Simulation) SetGPSNoise(sigma float64) error {
+	if sigma < 0 || math.IsNaN(sigma) {
+		return fmt.Errorf("invalid GPS noise %g: must not be negative", sigma)
+	}
+	s.gpsNoise = sigma
+	if sigma > 0 && s.gpsRand == nil {
+		// Noise has its own sequence so it doesn't disturb the engine's
+		s.gpsRand = rand.New(rand.NewSource(s.rand.Int63()))
+	}
+
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		driver.gps = nil
+		driver.mu.Unlock()
+	}
+	return nil
+}
+
+// updateGPS takes a new GPS fix of every local driver. It must run on the
+// main loop.
+func (s *Simulation) updateGPS(deltaTime float64) {
+	if s.gpsNoise <= 0 {
+		return
+	}
+
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		if driver.Origin == "" {
+			if driver.gps == nil {
+				driver.gps = &gpsTrack{}
+			}
+			driver.gps.update(driver.lon(), driver.lat(), driver.speed(), deltaTime, s.gpsNoise, s.gpsRand)
+		}
+		driver.mu.Unlock()
+	}
+}
+
+// applyGPS reports a driver at its filtered GPS position, with the raw fix
+// alongside, when GPS noise is on
+func (d *Driver) applyGPS(resp *protocol.DriverResponse) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.gps == nil {
+		return
+	}
+	resp.Lon, resp.Lat = d.gps.lon, d.gps.lat
+	resp.RawLon, resp.RawLat = d.gps.rawLon, d.gps.rawLat
+}
@@ -0,0 +1,242 @@
+// Package grid implements a uniform grid spatial hash: the bounds are cut
+// into cells of one fixed size and every point is kept in the cell it falls
+// in. Finding a point's cell is arithmetic, so inserts and moves never
+// search or rebalance, and a query visits only the cells its bounds
+// overlap. It serves the same queries as the quadtree (quadtree.Index).
+package grid
+
+import (
+	"container/heap"
+	"math"
+	"quadtree/quadtree"
+	"slices"
+	"sort"
+)
+
+// Grid is a uniform grid of points over fixed bounds
+type Grid struct {
+	bounds     quadtree.Bounds
+	cellSize   float64
+	cols, rows int
+	cells      [][]quadtree.Point // row-major; nil until a point lands there
+}
+
+var _ quadtree.Index = (*Grid)(nil)
+
+// New creates a grid over bounds with square cells of cellSize. Memory
+// grows with the number of cells, so the cells should be no smaller than
+// the queries need.
+func New(bounds quadtree.Bounds, cellSize float64) *Grid {
+	cols := max(1, int(math.Ceil((bounds.MaxX-bounds.MinX)/cellSize)))
+	rows := max(1, int(math.Ceil((bounds.MaxY-bounds.MinY)/cellSize)))
+	return &Grid{
+		bounds:   bounds,
+		cellSize: cellSize,
+		cols:     cols,
+		rows:     rows,
+		cells:    make([][]quadtree.Point, cols*rows),
+	}
+}
+
+// inside reports whether (x, y) is within the grid's bounds
+func (g *Grid) inside(x, y float64) bool {
+	return x >= g.bounds.MinX && x <= g.bounds.MaxX &&
+		y >= g.bounds.MinY && y <= g.bounds.MaxY
+}
+
+// col and row return the column and row (x, y) falls in, clamped to the
+// grid so the far edges belong to the last cells
+func (g *Grid) col(x float64) int {
+	return min(g.cols-1, max(0, int((x-g.bounds.MinX)/g.cellSize)))
+}
+
+func (g *Grid) row(y float64) int {
+	return min(g.rows-1, max(0, int((y-g.bounds.MinY)/g.cellSize)))
+}
+
+// cell returns the index of the cell (x, y) falls in
+func (g *Grid) cell(x, y float64) int {
+	return g.row(y)*g.cols + g.col(x)
+}
+
+// indexOf returns where a cell holds the point with p's ID and position, or
+// -1 when it doesn't
+func (g *Grid) indexOf(cell int, p quadtree.Point) int {
+	for i, n := range g.cells[cell] {
+		if n.ID == p.ID && n.X == p.X && n.Y == p.Y {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert adds a point, reporting false when it is outside the grid
+func (g *Grid) Insert(p quadtree.Point) bool {
+	if !g.inside(p.X, p.Y) {
+		return false
+	}
+	cell := g.cell(p.X, p.Y)
+	g.cells[cell] = append(g.cells[cell], p)
+	return true
+}
+
+// Remove deletes the point with p's ID at p's position, reporting false
+// when there is none
+func (g *Grid) Remove(p quadtree.Point) bool {
+	if !g.inside(p.X, p.Y) {
+		return false
+	}
+	cell := g.cell(p.X, p.Y)
+	i := g.indexOf(cell, p)
+	if i < 0 {
+		return false
+	}
+	g.cells[cell] = slices.Delete(g.cells[cell], i, i+1)
+	return true
+}
+
+// Move changes the position of the point with p's ID at p's position to
+// (x, y), in place when it stays in its cell. It reports false when there
+// is no such point, or when (x, y) is outside the grid, which then no
+// longer holds it.
+func (g *Grid) Move(p quadtree.Point, x, y float64) bool {
+	if !g.inside(p.X, p.Y) {
+		return false
+	}
+	cell := g.cell(p.X, p.Y)
+	i := g.indexOf(cell, p)
+	if i < 0 {
+		return false
+	}
+	if g.inside(x, y) && g.cell(x, y) == cell {
+		g.cells[cell][i].X, g.cells[cell][i].Y = x, y
+		return true
+	}
+
+	g.cells[cell] = slices.Delete(g.cells[cell], i, i+1)
+	p.X, p.Y = x, y
+	return g.Insert(p)
+}
+
+// QueryResults returns all points within the given bounds
+func (g *Grid) QueryResults(bounds quadtree.Bounds) []quadtree.Point {
+	if bounds.MaxX < g.bounds.MinX || bounds.MinX > g.bounds.MaxX ||
+		bounds.MaxY < g.bounds.MinY || bounds.MinY > g.bounds.MaxY {
+		return nil
+	}
+
+	var results []quadtree.Point
+	minCol, maxCol := g.col(bounds.MinX), g.col(bounds.MaxX)
+	for row := g.row(bounds.MinY); row <= g.row(bounds.MaxY); row++ {
+		for _, cell := range g.cells[row*g.cols+minCol : row*g.cols+maxCol+1] {
+			for _, p := range cell {
+				if p.X >= bounds.MinX && p.X <= bounds.MaxX && p.Y >= bounds.MinY && p.Y <= bounds.MaxY {
+					results = append(results, p)
+				}
+			}
+		}
+	}
+	return results
+}
+
+// Nearest returns up to n points closest to (x, y), closest first, skipping
+// those keep rejects (a nil keep accepts every point). It searches rings of
+// cells outwards from the cell of (x, y) and stops once no cell further out
+// can hold a point closer than the n found.
+func (g *Grid) Nearest(x, y float64, n int, dist quadtree.DistanceFunc, keep func(quadtree.Point) bool) []quadtree.Point {
+	if n <= 0 {
+		return nil
+	}
+	if dist == nil {
+		dist = quadtree.Euclidean
+	}
+
+	best := &farthestFirst{}
+	consider := func(col, row int) {
+		if col < 0 || col >= g.cols || row < 0 || row >= g.rows {
+			return
+		}
+		for _, p := range g.cells[row*g.cols+col] {
+			if keep != nil && !keep(p) {
+				continue
+			}
+			d := dist(x, y, p.X, p.Y)
+			if best.Len() < n {
+				heap.Push(best, found{dist: d, point: p})
+			} else if d < (*best)[0].dist {
+				(*best)[0] = found{dist: d, point: p}
+				heap.Fix(best, 0)
+			}
+		}
+	}
+
+	cx, cy := g.col(x), g.row(y)
+	maxRing := max(cx, g.cols-1-cx, cy, g.rows-1-cy)
+	for ring := 0; ring <= maxRing; ring++ {
+		if ring == 0 {
+			consider(cx, cy)
+		} else {
+			for col := cx - ring; col <= cx+ring; col++ {
+				consider(col, cy-ring)
+				consider(col, cy+ring)
+			}
+			for row := cy - ring + 1; row < cy+ring; row++ {
+				consider(cx-ring, row)
+				consider(cx+ring, row)
+			}
+		}
+
+		if best.Len() == n && (*best)[0].dist <= g.beyond(x, y, cx, cy, ring, dist) {
+			break
+		}
+	}
+
+	sort.Sort(sort.Reverse(best))
+	results := make([]quadtree.Point, best.Len())
+	for i, f := range *best {
+		results[i] = f.point
+	}
+	return results
+}
+
+// beyond returns the distance from (x, y) to the closest position of the
+// grid outside the square of cells ring cells around (cx, cy); infinity
+// when the square covers the grid
+func (g *Grid) beyond(x, y float64, cx, cy, ring int, dist quadtree.DistanceFunc) float64 {
+	closest := math.Inf(1)
+	if col := cx - ring; col > 0 {
+		closest = math.Min(closest, dist(x, y, g.bounds.MinX+float64(col)*g.cellSize, y))
+	}
+	if col := cx + ring + 1; col < g.cols {
+		closest = math.Min(closest, dist(x, y, g.bounds.MinX+float64(col)*g.cellSize, y))
+	}
+	if row := cy - ring; row > 0 {
+		closest = math.Min(closest, dist(x, y, x, g.bounds.MinY+float64(row)*g.cellSize))
+	}
+	if row := cy + ring + 1; row < g.rows {
+		closest = math.Min(closest, dist(x, y, x, g.bounds.MinY+float64(row)*g.cellSize))
+	}
+	return closest
+}
+
+// found is a point found by Nearest and its distance
+type found struct {
+	dist  float64
+	point quadtree.Point
+}
+
+// farthestFirst is a max-heap of found points, so the farthest of the
+// closest points found so far is the one replaced
+type farthestFirst []found
+
+func (h farthestFirst) Len() int            { return len(h) }
+func (h farthestFirst) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h farthestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(x interface{}) { *h = append(*h, x.(found)) }
+
+func (h *farthestFirst) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
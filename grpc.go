@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/url"
+	"quadtree/protocol"
+	"quadtree/protocol/taxipb"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer serves the gRPC service of protocol/taxi.proto through the
+// stubs protoc generates into protocol/taxipb. Its streams end when ctx is
+// done.
+type grpcServer struct {
+	taxipb.UnimplementedTaxiServer
+	sim *Simulation
+	ctx context.Context
+}
+
+// StartGRPC serves the gRPC service on addr, alongside the HTTP server. Its
+// calls authenticate like the API, with "authorization: Bearer <key>"
+// metadata.
+func (s *Simulation) StartGRPC(ctx context.Context, addr string) *grpc.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("gRPC server failed", "err", err)
+	}
+
+	srv := grpc.NewServer()
+	taxipb.RegisterTaxiServer(srv, &grpcServer{sim: s, ctx: ctx})
+	slog.Info("Serving gRPC", "addr", lis.Addr().String())
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			fatal("gRPC server failed", "err", err)
+		}
+	}()
+	return srv
+}
+
+// stopGRPC lets calls in flight finish until ctx is done, then cancels the
+// rest
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+		slog.Warn("gRPC server shutdown incomplete", "err", ctx.Err())
+	}
+}
+
+// identify returns the API key a call authenticates with, like
+// Auth.identify does for HTTP requests
+func (g *grpcServer) identify(ctx context.Context) (*APIKey, error) {
+	var token string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	key, ok := g.sim.auth.identifyToken(token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return key, nil
+}
+
+// grpcError converts the error of a call into a gRPC status
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, errReplayMode), errors.Is(err, errRidesReplaying), errors.Is(err, errClusterFollower), errors.Is(err, errShardGateway):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errNoDriver):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// SubscribeDrivers streams the drivers in a region on every broadcast
+// interval until the client cancels or the server stops. gRPC flow control
+// holds a slow client back; it then misses intervals rather than queueing
+// them.
+func (g *grpcServer) SubscribeDrivers(req *taxipb.Region, stream taxipb.Taxi_SubscribeDriversServer) error {
+	ctx := stream.Context()
+	region := protocol.RegionFromProto(req)
+	key, err := g.identify(ctx)
+	if err != nil {
+		return err
+	}
+	if err := region.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !g.sim.auth.acquire(key) {
+		return status.Error(codes.ResourceExhausted, "connection limit reached for this API key")
+	}
+	defer g.sim.auth.release(key)
+
+	logger := slog.With("identity", identityName(key), "region", region)
+	if p, ok := peer.FromContext(ctx); ok {
+		logger = logger.With("remote", p.Addr.String())
+	}
+	logger.Info("gRPC subscriber connected")
+	start, sent := time.Now(), 0
+	defer func() {
+		logger.Info("gRPC subscriber disconnected", "connected_for", time.Since(start), "updates_sent", sent)
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-g.ctx.Done():
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-timer.C:
+		}
+
+		world := g.sim.world()
+		update := protocol.DriversUpdate{
+			Drivers:   g.sim.nearbyDrivers(world, "", region.Lon, region.Lat, region.Radius, nil),
+			Center:    protocol.Location{Lat: region.Lat, Lon: region.Lon},
+			Radius:    region.Radius,
+			Time:      time.Now().UnixNano() / int64(time.Millisecond),
+			DataAgeMs: time.Since(world.publishedAt).Milliseconds(),
+		}
+		if err := stream.Send(update.ToProto()); err != nil {
+			return err
+		}
+		sent++
+		timer.Reset(g.sim.tunables.Load().ClientInterval)
+	}
+}
+
+// GetNearbyDrivers answers like /api/drivers, except that an unknown city
+// is an error rather than the first one
+func (g *grpcServer) GetNearbyDrivers(ctx context.Context, req *taxipb.NearbyRequest) (*taxipb.DriversResponse, error) {
+	if _, err := g.identify(ctx); err != nil {
+		return nil, err
+	}
+
+	lat, lon, radius, scope := req.GetLat(), req.GetLon(), req.GetRadius(), ""
+	if req.GetCity() != "" {
+		city, ok := g.sim.findCity(req.GetCity())
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown city %q", req.GetCity())
+		}
+		lat, lon, scope = city.Lat, city.Lon, city.Name
+	}
+	if radius == 0 {
+		radius = g.sim.settings.SearchRadius
+	} else if !(radius > 0 && radius <= protocol.MaxRegionRadius) {
+		return nil, status.Errorf(codes.InvalidArgument, "radius %g must be above 0 and at most %g degrees", radius, protocol.MaxRegionRadius)
+	}
+	if req.GetCity() == "" && !(lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180) {
+		return nil, status.Errorf(codes.InvalidArgument, "(%g, %g) is not a valid position", lat, lon)
+	}
+
+	driverFilter, err := compileDriverFilter(req.GetFilter())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	query := url.Values{"sort": {req.GetSort()}}
+	if req.GetLimit() != 0 {
+		query.Set("limit", strconv.Itoa(int(req.GetLimit())))
+	}
+	if req.GetOffset() != 0 {
+		query.Set("offset", strconv.Itoa(int(req.GetOffset())))
+	}
+	page, err := parseDriverPage(query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	world := g.sim.world()
+	resp := &protocol.DriversResponse{
+		Drivers:   g.sim.nearbyDrivers(world, scope, lon, lat, radius, driverFilter),
+		Center:    protocol.Location{Lat: lat, Lon: lon},
+		Radius:    radius,
+		DataAgeMs: time.Since(world.publishedAt).Milliseconds(),
+	}
+	resp.Total = len(resp.Drivers)
+	resp.Drivers, resp.NextOffset = page.apply(resp.Drivers)
+	resp.Count = len(resp.Drivers)
+	return resp.ToProto(), nil
+}
+
+// RequestRide dispatches a ride like POST /api/rides. The rider tracks it
+// through /api/rides/{id}.
+func (g *grpcServer) RequestRide(ctx context.Context, pb *taxipb.RideRequest) (*taxipb.RideAssigned, error) {
+	if _, err := g.identify(ctx); err != nil {
+		return nil, err
+	}
+	req, err := protocol.RequestRideFromProto(pb)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var assigned protocol.RideAssigned
+	err = g.sim.exec(ctx, func() error {
+		var err error
+		assigned, err = g.sim.requestRide(nil, req)
+		return err
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return assigned.ToProto(), nil
+}
+
+// GetStats answers like /api/stats, without the index and message details
+// taxi.v1.Stats leaves out
+func (g *grpcServer) GetStats(ctx context.Context, _ *taxipb.StatsRequest) (*taxipb.Stats, error) {
+	if _, err := g.identify(ctx); err != nil {
+		return nil, err
+	}
+
+	var stats protocol.Stats
+	err := g.sim.exec(ctx, func() error {
+		stats = g.sim.CollectStats()
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return stats.ToProto(), nil
+}
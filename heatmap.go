@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	heatmapCellSize          = 0.01 // degrees per grid cell (about 1.1km)
+	heatmapBucketSpan        = 1 * time.Minute
+	heatmapBuckets           = 10 // the heatmap covers the last 10 bucket spans
+	heatmapBroadcastInterval = 5 * time.Second
+)
+
+// heatmapCell identifies a grid cell by its row and column
+type heatmapCell struct {
+	row, col int
+}
+
+// heatmapBucket counts ride request origins per cell during one bucket span
+type heatmapBucket struct {
+	start  time.Time
+	counts map[heatmapCell]int
+}
+
+// DemandHeatmap aggregates ride request origins into a grid over a sliding
+// window of virtual time
+type DemandHeatmap struct {
+	mu             sync.Mutex
+	buckets        []heatmapBucket // oldest first
+	minLon, minLat float64         // southwest corner of the world, where the grid starts
+}
+
+// NewDemandHeatmap creates an empty heatmap over the world bounds
+func NewDemandHeatmap(bounds quadtree.Bounds) *DemandHeatmap {
+	return &DemandHeatmap{minLon: bounds.MinX, minLat: bounds.MinY}
+}
+
+// cellFor returns the grid cell containing a position
+func (h *DemandHeatmap) cellFor(lon, lat float64) heatmapCell {
+	return heatmapCell{
+		row: int(math.Floor((lat - h.minLat) / heatmapCellSize)),
+		col: int(math.Floor((lon - h.minLon) / heatmapCellSize)),
+	}
+}
+
+// Add records a ride request origin at the given virtual time
+func (h *DemandHeatmap) Add(lon, lat float64, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := now.Truncate(heatmapBucketSpan)
+	if n := len(h.buckets); n == 0 || h.buckets[n-1].start.Before(start) {
+		h.buckets = append(h.buckets, heatmapBucket{start: start, counts: make(map[heatmapCell]int)})
+	}
+	h.expire(now)
+
+	h.buckets[len(h.buckets)-1].counts[h.cellFor(lon, lat)]++
+}
+
+// expire drops buckets that fell out of the window; h.mu must be held
+func (h *DemandHeatmap) expire(now time.Time) {
+	cutoff := now.Truncate(heatmapBucketSpan).Add(-(heatmapBuckets - 1) * heatmapBucketSpan)
+	drop := 0
+	for drop < len(h.buckets) && h.buckets[drop].start.Before(cutoff) {
+		drop++
+	}
+	h.buckets = h.buckets[drop:]
+}
+
+// Snapshot returns the non-empty cells of the current window
+func (h *DemandHeatmap) Snapshot(now time.Time) protocol.DemandHeatmap {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expire(now)
+
+	totals := make(map[heatmapCell]int)
+	for _, bucket := range h.buckets {
+		for cell, count := range bucket.counts {
+			totals[cell] += count
+		}
+	}
+
+	heatmap := protocol.DemandHeatmap{
+		Type:     protocol.TypeDemandHeatmap,
+		CellSize: heatmapCellSize,
+		WindowS:  (heatmapBuckets * heatmapBucketSpan).Seconds(),
+		Cells:    make([]protocol.HeatmapCell, 0, len(totals)),
+		Time:     now.UnixNano() / int64(time.Millisecond),
+	}
+	for cell, count := range totals {
+		heatmap.Cells = append(heatmap.Cells, protocol.HeatmapCell{
+			// Report the cell center
+			Lat:   h.minLat + (float64(cell.row)+0.5)*heatmapCellSize,
+			Lon:   h.minLon + (float64(cell.col)+0.5)*heatmapCellSize,
+			Count: count,
+		})
+		heatmap.Total += count
+	}
+
+	// Busiest cells first
+	sort.Slice(heatmap.Cells, func(i, j int) bool {
+		return heatmap.Cells[i].Count > heatmap.Cells[j].Count
+	})
+	return heatmap
+}
+
+// DemandHeatmapHandler handles GET /api/heatmap/demand
+func (s *Simulation) DemandHeatmapHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+	json.NewEncoder(w).Encode(s.heatmap.Snapshot(s.clock.Now()))
+}
+
+// BroadcastHeatmap sends the demand heatmap to all connected clients that
+// speak the current protocol. A shard gateway has no ride requests, and
+// so no heatmap, of its own.
+func (s *Simulation) BroadcastHeatmap() {
+	if s.gateway != nil {
+		return
+	}
+	s.broadcastMessage(s.heatmap.Snapshot(s.clock.Now()))
+}
+
+// CountNear returns the number of ride requests in the window whose cell
+// center lies within radius degrees of a position (a box, wider east-west
+// away from the equator)
+func (h *DemandHeatmap) CountNear(lon, lat, radius float64, now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expire(now)
+
+	lonRadius := radius * geo.LonScale(lat)
+	count := 0
+	for _, bucket := range h.buckets {
+		for cell, n := range bucket.counts {
+			cellLat := h.minLat + (float64(cell.row)+0.5)*heatmapCellSize
+			cellLon := h.minLon + (float64(cell.col)+0.5)*heatmapCellSize
+			if math.Abs(cellLat-lat) <= radius && math.Abs(cellLon-lon) <= lonRadius {
+				count += n
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,171 @@
+// Package hub is a topic-based publish/subscribe hub. Subscribers subscribe
+// to named topics; messages published to a topic are delivered to each of
+// its subscribers. Topics exist while they have subscribers.
+package hub
+
+import (
+	"sort"
+	"sync"
+)
+
+// Subscriber receives the messages published to the topics it subscribed
+// to. Deliver is called on the publisher's goroutine, so it must not block.
+type Subscriber interface {
+	Deliver(topic string, msg interface{})
+}
+
+// Hub routes messages from publishers to subscribers. It is safe for
+// concurrent use.
+type Hub struct {
+	mu     sync.RWMutex
+	topics map[string]*topic
+	subs   map[Subscriber]map[string]bool // topics of each subscriber
+}
+
+type topic struct {
+	subs map[Subscriber]bool
+}
+
+// New creates an empty hub
+func New() *Hub {
+	return &Hub{
+		topics: make(map[string]*topic),
+		subs:   make(map[Subscriber]map[string]bool),
+	}
+}
+
+// Subscribe adds topics to a subscriber's
+func (h *Hub) Subscribe(sub Subscriber, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range topics {
+		h.subscribe(sub, name)
+	}
+}
+
+// Unsubscribe removes topics from a subscriber's
+func (h *Hub) Unsubscribe(sub Subscriber, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range topics {
+		h.unsubscribe(sub, name)
+	}
+}
+
+// SetTopics replaces all of a subscriber's topics; no topics unsubscribes
+// it from everything
+func (h *Hub) SetTopics(sub Subscriber, topics []string) {
+	want := make(map[string]bool, len(topics))
+	for _, name := range topics {
+		want[name] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name := range h.subs[sub] {
+		if !want[name] {
+			h.unsubscribe(sub, name)
+		}
+	}
+	for name := range want {
+		h.subscribe(sub, name)
+	}
+}
+
+// Topics returns a subscriber's topics, sorted
+func (h *Hub) Topics(sub Subscriber) []string {
+	h.mu.RLock()
+	topics := make([]string, 0, len(h.subs[sub]))
+	for name := range h.subs[sub] {
+		topics = append(topics, name)
+	}
+	h.mu.RUnlock()
+
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribers returns how many subscribers a topic has
+func (h *Hub) Subscribers(name string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if t, ok := h.topics[name]; ok {
+		return len(t.subs)
+	}
+	return 0
+}
+
+// Publish delivers a message to the subscribers of a topic and returns how
+// many there were
+func (h *Hub) Publish(name string, msg interface{}) int {
+	h.mu.RLock()
+	t, ok := h.topics[name]
+	var subs []Subscriber
+	if ok {
+		subs = make([]Subscriber, 0, len(t.subs))
+		for sub := range t.subs {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	// Outside the lock, so subscribers may change their topics meanwhile
+	for _, sub := range subs {
+		sub.Deliver(name, msg)
+	}
+	return len(subs)
+}
+
+// Counts returns how many subscribers every topic has
+func (h *Hub) Counts() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	counts := make(map[string]int, len(h.topics))
+	for name, t := range h.topics {
+		counts[name] = len(t.subs)
+	}
+	return counts
+}
+
+// Stats returns the number of topics and of subscriptions across them
+func (h *Hub) Stats() (topics, subscriptions int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, t := range h.topics {
+		subscriptions += len(t.subs)
+	}
+	return len(h.topics), subscriptions
+}
+
+func (h *Hub) subscribe(sub Subscriber, name string) {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &topic{subs: make(map[Subscriber]bool)}
+		h.topics[name] = t
+	}
+	t.subs[sub] = true
+
+	if h.subs[sub] == nil {
+		h.subs[sub] = make(map[string]bool)
+	}
+	h.subs[sub][name] = true
+}
+
+func (h *Hub) unsubscribe(sub Subscriber, name string) {
+	if t, ok := h.topics[name]; ok {
+		delete(t.subs, sub)
+		h.drop(name, t)
+	}
+
+	delete(h.subs[sub], name)
+	if len(h.subs[sub]) == 0 {
+		delete(h.subs, sub)
+	}
+}
+
+// drop forgets a topic nobody needs anymore
+func (h *Hub) drop(name string, t *topic) {
+	if len(t.subs) == 0 {
+		delete(h.topics, name)
+	}
+}
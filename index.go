@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"quadtree/grid"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"quadtree/redisgeo"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runTag tells the snapshot epochs of different server runs apart in
+// ETags, since epochs start over at every start
+var runTag = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// etag is the ETag of a /api/drivers response built from the snapshot.
+// Every publish makes a new snapshot, with the drivers' statuses and
+// positions and the index as of that moment. There is no Last-Modified:
+// snapshots are published several times a second, which its one-second
+// granularity can't tell apart.
+func (w *WorldSnapshot) etag() string {
+	return fmt.Sprintf(`W/"%s-%d"`, runTag, w.epoch)
+}
+
+// notModified reports whether a conditional request already has the
+// response with the given ETag, which If-None-Match lists
+func notModified(r *http.Request, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fragmentationLimit is how much the share of empty leaves may grow over
+// what a fresh build of the index has before the index is rebuilt; moves
+// and removals leave leaves empty that queries still have to visit
+const fragmentationLimit = 0.2
+
+// Spatial index structures
+const (
+	IndexQuadtree = "quadtree" // cells split where drivers cluster
+	IndexGrid     = "grid"     // fixed cells of gridCellSize
+	IndexRedis    = "redis"    // GEO sorted sets in Redis (see UseRedisIndex)
+)
+
+// defaultIndexCapacity is how many points a quadtree node holds before it
+// splits, unless -index-capacity says otherwise
+const defaultIndexCapacity = 8
+
+// gridCellSize is the cell size of the grid index in degrees, which the
+// index benchmarks found to balance moves, which scan the cell they leave,
+// against queries, which visit every cell they overlap
+const gridCellSize = 0.025
+
+// newIndexTree returns a constructor of empty trees of the given structure
+// over the world bounds; capacity is the node capacity of a quadtree
+func newIndexTree(kind string, capacity int, bounds quadtree.Bounds) (func() quadtree.Index, error) {
+	switch kind {
+	case IndexQuadtree:
+		if capacity < 1 {
+			return nil, fmt.Errorf("invalid index capacity %d (want at least 1)", capacity)
+		}
+		return func() quadtree.Index { return quadtree.New(bounds, capacity) }, nil
+	case IndexGrid:
+		return func() quadtree.Index { return grid.New(bounds, gridCellSize) }, nil
+	}
+	return nil, fmt.Errorf("unknown index %q (want %s or %s)", kind, IndexQuadtree, IndexGrid)
+}
+
+// fragmenter is a tree that can tell how many of its leaves removals and
+// moves left empty
+type fragmenter interface {
+	Leaves() (total, empty int)
+}
+
+// shaper is a tree that can describe its shape
+type shaper interface {
+	Stats() quadtree.Stats
+}
+
+// remoteTree is a tree kept outside the process, which buffers changes
+// until they are flushed. Snapshots query it live rather than copying it.
+type remoteTree interface {
+	Flush() error
+}
+
+// spatialIndex is a tree of driver positions, a quadtree unless UseIndex
+// chose otherwise; every city has one, guarded by cityIndexes.mu. It is
+// kept in step with the drivers by moving, inserting and removing only the
+// points that changed after every simulation update, and rebuilt from
+// scratch only once a quadtree has fragmented.
+type spatialIndex struct {
+	kind      string
+	newTree   func() quadtree.Index
+	tree      quadtree.Index
+	points    map[int]quadtree.Point // what the tree holds, by driver ID
+	updatedAt time.Time
+	version   int64           // counts updates that changed the tree
+	rebuilds  int64           // counts full builds
+	baseline  float64         // share of empty leaves after the last build
+	extent    quadtree.Bounds // smallest bounds holding every point; meaningless without points
+
+	// How long the last update and the last full build took
+	updateTime, buildTime time.Duration
+	builtAt               time.Time
+
+	failing bool // the last flush of a remote tree failed
+}
+
+// UseIndex selects the structure of the spatial index, IndexQuadtree or
+// IndexGrid, and rebuilds every city's index in it; capacity is the node
+// capacity of a quadtree. It must run before the simulation starts or on
+// the main loop.
+func (s *Simulation) UseIndex(kind string, capacity int) error {
+	newTree, err := newIndexTree(kind, capacity, s.settings.bounds())
+	if err != nil {
+		return err
+	}
+
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	for _, part := range s.index.parts {
+		part.index.use(kind, newTree)
+	}
+	return nil
+}
+
+// UseRedisIndex keeps every city's index in Redis, in a GEO sorted set at
+// <prefix>:drivers:<city>, so other servers and tools can search the
+// drivers too. It must run before the simulation starts or on the main
+// loop.
+func (s *Simulation) UseRedisIndex(rawURL, prefix string) error {
+	client, err := redisgeo.Dial(rawURL)
+	if err != nil {
+		return err
+	}
+
+	bounds := s.settings.bounds()
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	for _, part := range s.index.parts {
+		key := prefix + ":drivers:" + part.city.Name
+		part.index.use(IndexRedis, func() quadtree.Index { return redisgeo.NewIndex(client, key, bounds) })
+	}
+	return nil
+}
+
+// use switches the index to trees made by newTree and rebuilds it
+func (idx *spatialIndex) use(kind string, newTree func() quadtree.Index) {
+	idx.kind, idx.newTree = kind, newTree
+	if idx.tree != nil {
+		idx.build(idx.positions())
+		idx.flush()
+	}
+}
+
+// flush writes out the buffered changes of a remote tree, logging when
+// that starts or stops failing; other trees have nothing to flush
+func (idx *spatialIndex) flush() {
+	remote, ok := idx.tree.(remoteTree)
+	if !ok {
+		return
+	}
+	err := remote.Flush()
+	switch {
+	case err != nil && !idx.failing:
+		slog.Warn("Updating the index failed", "index", idx.kind, "err", err)
+	case err == nil && idx.failing:
+		slog.Info("Updating the index recovered", "index", idx.kind)
+	}
+	idx.failing = err != nil
+}
+
+// positions returns the points the tree holds
+func (idx *spatialIndex) positions() []quadtree.Point {
+	positions := make([]quadtree.Point, 0, len(idx.points))
+	for _, p := range idx.points {
+		positions = append(positions, p)
+	}
+	return positions
+}
+
+// update brings the index in step with the given positions: drivers that
+// moved are moved in the tree, new ones inserted and missing ones removed.
+// It rebuilds the tree instead once it has fragmented.
+func (idx *spatialIndex) update(positions []quadtree.Point) {
+	start := time.Now()
+	defer func() { idx.updateTime = time.Since(start) }()
+	defer idx.flush()
+	if idx.tree == nil {
+		idx.build(positions)
+		return
+	}
+
+	changed := false
+	seen := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		seen[p.ID] = true
+		old, ok := idx.points[p.ID]
+		switch {
+		case ok && old.X == p.X && old.Y == p.Y:
+			continue
+		case ok && idx.tree.Move(old, p.X, p.Y):
+			idx.points[p.ID] = p
+		case !ok && idx.tree.Insert(p):
+			idx.points[p.ID] = p
+		default:
+			// Moved or spawned outside the world; queries can't find it
+			delete(idx.points, p.ID)
+		}
+		changed = true
+	}
+	for id, p := range idx.points {
+		if !seen[id] {
+			idx.tree.Remove(p)
+			delete(idx.points, id)
+			changed = true
+		}
+	}
+
+	idx.updatedAt = time.Now()
+	if !changed {
+		return
+	}
+	idx.version++
+	idx.measure()
+	if f, ok := idx.tree.(fragmenter); ok {
+		if leaves, empty := f.Leaves(); float64(empty)/float64(leaves) > idx.baseline+fragmentationLimit {
+			idx.build(positions)
+		}
+	}
+}
+
+// build replaces the tree with a fresh one holding the given positions
+func (idx *spatialIndex) build(positions []quadtree.Point) {
+	start := time.Now()
+	idx.tree = idx.newTree()
+	idx.points = make(map[int]quadtree.Point, len(positions))
+	for _, p := range positions {
+		if idx.tree.Insert(p) {
+			idx.points[p.ID] = p
+		}
+	}
+	if f, ok := idx.tree.(fragmenter); ok {
+		leaves, empty := f.Leaves()
+		idx.baseline = float64(empty) / float64(leaves)
+	}
+	idx.measure()
+	idx.updatedAt = time.Now()
+	idx.version++
+	idx.rebuilds++
+	idx.buildTime, idx.builtAt = time.Since(start), idx.updatedAt
+}
+
+// stats describes the index for /api/stats
+func (idx *spatialIndex) stats() protocol.IndexStats {
+	stats := protocol.IndexStats{
+		Kind:     idx.kind,
+		Points:   len(idx.points),
+		Rebuilds: idx.rebuilds,
+		UpdateMs: float64(idx.updateTime) / float64(time.Millisecond),
+		BuildMs:  float64(idx.buildTime) / float64(time.Millisecond),
+	}
+	if !idx.builtAt.IsZero() {
+		stats.BuiltAt = idx.builtAt.UnixNano() / int64(time.Millisecond)
+	}
+	if t, ok := idx.tree.(shaper); ok {
+		tree := t.Stats()
+		shape := &protocol.IndexShape{
+			Capacity:   tree.Capacity,
+			Depth:      tree.Depth,
+			Nodes:      tree.Nodes,
+			Leaves:     tree.Leaves,
+			LeafPoints: tree.LeafPoints,
+		}
+		shape.EmptyLeaves = shape.LeafPoints[0]
+		if filled := shape.Leaves - shape.EmptyLeaves; filled > 0 {
+			shape.AvgLeafPoints = float64(tree.Points) / float64(filled)
+		}
+		stats.Shape = shape
+	}
+	return stats
+}
+
+// measure recomputes the extent of the points
+func (idx *spatialIndex) measure() {
+	first := true
+	for _, p := range idx.points {
+		if first {
+			idx.extent = quadtree.Bounds{MinX: p.X, MinY: p.Y, MaxX: p.X, MaxY: p.Y}
+			first = false
+			continue
+		}
+		idx.extent.MinX = min(idx.extent.MinX, p.X)
+		idx.extent.MinY = min(idx.extent.MinY, p.Y)
+		idx.extent.MaxX = max(idx.extent.MaxX, p.X)
+		idx.extent.MaxY = max(idx.extent.MaxY, p.Y)
+	}
+}
+
+// overlaps reports whether two bounds share any position
+func overlaps(a, b quadtree.Bounds) bool {
+	return a.MinX <= b.MaxX && b.MinX <= a.MaxX && a.MinY <= b.MaxY && b.MinY <= a.MaxY
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"quadtree/geo"
+	"quadtree/grid"
+	"quadtree/quadtree"
+	"quadtree/redisgeo"
+	"testing"
+)
+
+// The index benchmarks run the simulation's workload: drivers packed around
+// Erbil's center, all of them moving every update, and queries of the
+// default radius. Every benchmark runs each index structure, and the grid
+// at several cell sizes, so the data can choose the structure and
+// gridCellSize. Compare runs with benchstat:
+//
+//	go test -run '^$' -bench BenchmarkIndex -count 10 > new.txt
+//	benchstat old.txt new.txt
+//
+// TAXI_BENCH_REDIS=redis://localhost:6379/15 adds the Redis index; its keys
+// start with taxibench.
+
+// benchFleets are the fleet sizes benchmarked
+var benchFleets = []int{1000, 10000, 50000}
+
+// Drivers are spread around Erbil's center and move about as far per
+// update as a 220ms update at city speeds takes them
+const (
+	benchCenterLon, benchCenterLat = 44.0092, 36.1911
+	benchSpread                    = 0.05
+	benchStep                      = 0.0001
+)
+
+// benchBounds are the world bounds of a run without flags
+var benchBounds = defaultSettings().bounds()
+
+// benchIndex is an index structure under benchmark
+type benchIndex struct {
+	name string
+	new  func() quadtree.Index
+}
+
+// benchIndexes returns the index structures to benchmark
+func benchIndexes(b *testing.B) []benchIndex {
+	indexes := []benchIndex{{
+		name: "quadtree",
+		new:  func() quadtree.Index { return quadtree.New(benchBounds, defaultIndexCapacity) },
+	}}
+	for _, size := range []float64{0.01, gridCellSize, 0.05, 0.1} {
+		indexes = append(indexes, benchIndex{
+			name: fmt.Sprintf("grid-%g", size),
+			new:  func() quadtree.Index { return grid.New(benchBounds, size) },
+		})
+	}
+
+	if url := os.Getenv("TAXI_BENCH_REDIS"); url != "" {
+		client, err := redisgeo.Dial(url)
+		if err != nil {
+			b.Fatalf("Connecting to Redis: %v", err)
+		}
+		b.Cleanup(func() { client.Close() })
+		indexes = append(indexes, benchIndex{
+			name: "redis",
+			new:  func() quadtree.Index { return redisgeo.NewIndex(client, "taxibench:drivers", benchBounds) },
+		})
+	}
+	return indexes
+}
+
+// runIndexBenchmarks runs bench for every index structure and fleet size
+func runIndexBenchmarks(b *testing.B, bench func(b *testing.B, ix benchIndex, drivers []quadtree.Point)) {
+	for _, ix := range benchIndexes(b) {
+		for _, n := range benchFleets {
+			drivers := benchDrivers(n, benchSpread, rand.New(rand.NewSource(1)))
+			b.Run(fmt.Sprintf("%s/drivers=%d", ix.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+				bench(b, ix, drivers)
+			})
+		}
+	}
+}
+
+// benchDrivers places n points normally distributed around the center
+func benchDrivers(n int, spread float64, r *rand.Rand) []quadtree.Point {
+	points := make([]quadtree.Point, n)
+	for i := range points {
+		points[i] = quadtree.Point{
+			X:  max(benchBounds.MinX, min(benchBounds.MaxX, benchCenterLon+r.NormFloat64()*spread)),
+			Y:  max(benchBounds.MinY, min(benchBounds.MaxY, benchCenterLat+r.NormFloat64()*spread)),
+			ID: i + 1,
+		}
+	}
+	return points
+}
+
+// benchBuild returns an index of the drivers, with a remote one written out
+func benchBuild(ix benchIndex, drivers []quadtree.Point) quadtree.Index {
+	tree := ix.new()
+	for _, p := range drivers {
+		tree.Insert(p)
+	}
+	benchFlush(tree)
+	return tree
+}
+
+// benchFlush writes out the changes a remote index buffered
+func benchFlush(tree quadtree.Index) {
+	if remote, ok := tree.(remoteTree); ok {
+		remote.Flush()
+	}
+}
+
+// benchCenters returns where the benchmarked queries are made: around the
+// center, where the riders are
+func benchCenters(seed int64) []quadtree.Point {
+	return benchDrivers(1024, 0.03, rand.New(rand.NewSource(seed)))
+}
+
+// BenchmarkIndexInsert inserts the whole fleet into an empty index per
+// operation, as a full rebuild does
+func BenchmarkIndexInsert(b *testing.B) {
+	runIndexBenchmarks(b, func(b *testing.B, ix benchIndex, drivers []quadtree.Point) {
+		for b.Loop() {
+			benchBuild(ix, drivers)
+		}
+	})
+}
+
+// BenchmarkIndexUpdate moves every driver once per operation, as a
+// simulation update does
+func BenchmarkIndexUpdate(b *testing.B) {
+	runIndexBenchmarks(b, func(b *testing.B, ix benchIndex, drivers []quadtree.Point) {
+		tree := benchBuild(ix, drivers)
+		current := append([]quadtree.Point(nil), drivers...)
+		r := rand.New(rand.NewSource(2))
+		for b.Loop() {
+			for i, p := range current {
+				x := max(benchBounds.MinX, min(benchBounds.MaxX, p.X+(r.Float64()*2-1)*benchStep))
+				y := max(benchBounds.MinY, min(benchBounds.MaxY, p.Y+(r.Float64()*2-1)*benchStep))
+				tree.Move(p, x, y)
+				current[i].X, current[i].Y = x, y
+			}
+			benchFlush(tree)
+		}
+	})
+}
+
+// BenchmarkIndexRadiusQuery makes one query of the default search radius
+// per operation, as /api/drivers does
+func BenchmarkIndexRadiusQuery(b *testing.B) {
+	runIndexBenchmarks(b, func(b *testing.B, ix benchIndex, drivers []quadtree.Point) {
+		tree := benchBuild(ix, drivers)
+		centers := benchCenters(3)
+		i := 0
+		for b.Loop() {
+			c := centers[i%len(centers)]
+			i++
+			lonRadius := defaultSearchRadius * geo.LonScale(c.Y)
+			tree.QueryResults(quadtree.Bounds{
+				MinX: c.X - lonRadius, MinY: c.Y - defaultSearchRadius,
+				MaxX: c.X + lonRadius, MaxY: c.Y + defaultSearchRadius,
+			})
+		}
+	})
+}
+
+// BenchmarkIndexNearest finds the five closest drivers per operation, as
+// /api/drivers/nearest does by default
+func BenchmarkIndexNearest(b *testing.B) {
+	runIndexBenchmarks(b, func(b *testing.B, ix benchIndex, drivers []quadtree.Point) {
+		tree := benchBuild(ix, drivers)
+		centers := benchCenters(4)
+		i := 0
+		for b.Loop() {
+			c := centers[i%len(centers)]
+			i++
+			tree.Nearest(c.X, c.Y, 5, geo.HaversineKm, nil)
+		}
+	})
+}
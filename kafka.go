@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultKafkaInterval       = 1 * time.Second
+	defaultKafkaPositionsTopic = "taxi.positions"
+	defaultKafkaTripsTopic     = "taxi.trips"
+
+	// Trip events waiting for the producer (see offer); the ones dropped
+	// count towards kafka_dropped in /api/diag
+	kafkaQueueSize = 10000
+	// How long the events still queued at shutdown get to be written
+	kafkaFlushTimeout = 5 * time.Second
+)
+
+// KafkaConfig configures producing driver positions and trip events to
+// Kafka
+type KafkaConfig struct {
+	Brokers        []string
+	PositionsTopic string
+	TripsTopic     string
+	Interval       time.Duration // between rounds of positions
+}
+
+// kafkaProducer writes positions and trip events, keyed by driver ID so
+// the events of a driver stay in order on one partition
+type kafkaProducer struct {
+	cfg    KafkaConfig
+	writer *kafka.Writer
+	events chan kafka.Message // trip events, filled from the main loop
+}
+
+// kafkaPosition is the value of a position message: a driver as in
+// drivers_update, with the city it is in
+type kafkaPosition struct {
+	protocol.DriverResponse
+	City string `json:"city"`
+}
+
+// ParseKafkaBrokers parses a -kafka list like "localhost:9092,10.0.0.7:9092"
+func ParseKafkaBrokers(spec string) ([]string, error) {
+	var brokers []string
+	for _, addr := range strings.Split(spec, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, ":") {
+			return nil, fmt.Errorf("invalid Kafka broker %q: want host:port", addr)
+		}
+		brokers = append(brokers, addr)
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers in %q", spec)
+	}
+	return brokers, nil
+}
+
+// ProduceKafka writes the position of every driver to cfg.PositionsTopic
+// each interval, and trip events to cfg.TripsTopic as they happen, until
+// ctx is canceled. Both are JSON keyed by driver ID, partitioned like the
+// Java client does. Writes that fail are logged and dropped: the next round
+// of positions replaces them anyway.
+func (s *Simulation) ProduceKafka(ctx context.Context, cfg KafkaConfig) error {
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid Kafka interval %v", cfg.Interval)
+	}
+	if cfg.PositionsTopic == "" || cfg.TripsTopic == "" {
+		return errors.New("empty Kafka topic")
+	}
+
+	p := &kafkaProducer{
+		cfg: cfg,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Balancer:               &kafka.Murmur2Balancer{},
+			BatchSize:              1000,
+			BatchTimeout:           10 * time.Millisecond,
+			MaxAttempts:            3,
+			RequiredAcks:           kafka.RequireOne,
+			AllowAutoTopicCreation: true,
+		},
+		events: make(chan kafka.Message, kafkaQueueSize),
+	}
+	s.kafka = p
+	slog.Info("Producing to Kafka", "brokers", cfg.Brokers, "positions", cfg.PositionsTopic, "trips", cfg.TripsTopic, "interval", cfg.Interval)
+	s.goTracked(func() { s.runKafka(ctx, p) })
+	return nil
+}
+
+// runKafka writes positions on every tick and trip events as they are
+// queued, then flushes what is left once ctx is canceled
+func (s *Simulation) runKafka(ctx context.Context, p *kafkaProducer) {
+	defer func() {
+		if err := p.writer.Close(); err != nil {
+			slog.Warn("Closing Kafka producer failed", "err", err)
+		}
+	}()
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	failing := false
+	write := func(ctx context.Context, kind string, msgs []kafka.Message) {
+		if len(msgs) == 0 {
+			return
+		}
+		err := p.writer.WriteMessages(ctx, msgs...)
+		switch {
+		case err == nil:
+			s.kafkaProduced.Add(int64(len(msgs)))
+			if failing {
+				slog.Info("Kafka writes recovered")
+				failing = false
+			}
+		case ctx.Err() == nil:
+			s.kafkaDropped.Add(int64(len(msgs)))
+			if !failing {
+				slog.Warn("Writing to Kafka failed", "kind", kind, "messages", len(msgs), "err", err)
+				failing = true
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), kafkaFlushTimeout)
+			write(flushCtx, "trips", p.pending(nil))
+			cancel()
+			return
+		case <-ticker.C:
+			write(ctx, "positions", p.positions(s.world()))
+		case msg := <-p.events:
+			write(ctx, "trips", p.pending([]kafka.Message{msg}))
+		}
+	}
+}
+
+// pending appends the queued trip events to msgs without waiting for more
+func (p *kafkaProducer) pending(msgs []kafka.Message) []kafka.Message {
+	for {
+		select {
+		case msg := <-p.events:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// positions returns a message for every driver of a published world
+func (p *kafkaProducer) positions(world *WorldSnapshot) []kafka.Message {
+	msgs := make([]kafka.Message, 0, len(world.drivers))
+	for id, state := range world.drivers {
+		value, err := json.Marshal(kafkaPosition{DriverResponse: state.resp, City: state.city})
+		if err != nil {
+			slog.Error("Encoding Kafka position failed", "driver", id, "err", err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Topic: p.cfg.PositionsTopic, Key: []byte(strconv.Itoa(id)), Value: value})
+	}
+	return msgs
+}
+
+// produceTripEvent queues a trip event, encoded as it was broadcast or as a
+// CloudEvent, for the trips topic. It must not block: it runs on the main
+// loop.
+func (s *Simulation) produceTripEvent(event protocol.TripEvent, encoded []byte) {
+	msg := kafka.Message{
+		Topic: s.kafka.cfg.TripsTopic,
+		Key:   []byte(strconv.Itoa(event.DriverID)),
+		Value: encoded,
+	}
+	if s.cloudEvents != nil {
+		// Structured mode of the Kafka binding
+		msg.Headers = []kafka.Header{{Key: "content-type", Value: []byte(protocol.CloudEventsContentType)}}
+	}
+	offer(s.kafka.events, msg, &s.kafkaDropped)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"quadtree/protocol"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of a latencyHistogram;
+// longer durations go in one more bucket
+var latencyBuckets = [...]time.Duration{
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyHistogram counts durations in latencyBuckets. The broadcast
+// goroutines of every client observe into it, so it has no lock.
+type latencyHistogram struct {
+	buckets [len(latencyBuckets) + 1]atomic.Int64 // the last counts the longer durations
+	count   atomic.Int64
+	sum     atomic.Int64
+	max     atomic.Int64
+}
+
+// observe counts a duration
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		longest := h.max.Load()
+		if int64(d) <= longest || h.max.CompareAndSwap(longest, int64(d)) {
+			return
+		}
+	}
+}
+
+// since counts the time since start
+func (h *latencyHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// percentile estimates the duration q of the observed ones (0 to 1) took
+// at most, as the upper bound of the bucket it falls in
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	longest := time.Duration(h.max.Load())
+	var seen int64
+	for i := range latencyBuckets {
+		seen += h.buckets[i].Load()
+		if float64(seen) >= q*float64(h.count.Load()) && seen > 0 {
+			return min(latencyBuckets[i], longest)
+		}
+	}
+	return longest
+}
+
+// snapshot returns the histogram as /api/stats reports it
+func (h *latencyHistogram) snapshot() protocol.Histogram {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	hist := protocol.Histogram{
+		Count:   h.count.Load(),
+		P50Ms:   ms(h.percentile(0.5)),
+		P90Ms:   ms(h.percentile(0.9)),
+		P99Ms:   ms(h.percentile(0.99)),
+		MaxMs:   ms(time.Duration(h.max.Load())),
+		Buckets: make([]protocol.HistogramBucket, len(h.buckets)),
+	}
+	if hist.Count > 0 {
+		hist.AvgMs = ms(time.Duration(h.sum.Load() / hist.Count))
+	}
+	for i := range h.buckets {
+		hist.Buckets[i].Count = h.buckets[i].Load()
+		if i < len(latencyBuckets) {
+			hist.Buckets[i].LeMs = ms(latencyBuckets[i])
+		}
+	}
+	return hist
+}
+
+// broadcastTimes are histograms of the phases of sending clients their
+// driver updates, so the one that dominates at a given number of clients
+// can be told from the others
+type broadcastTimes struct {
+	tick     latencyHistogram // BroadcastDrivers, for every client due an update
+	query    latencyHistogram // collecting a client's drivers from its topics
+	assemble latencyHistogram // filtering them into an update, or diffing a delta
+	encode   latencyHistogram // encoding an update frame
+	write    latencyHistogram // writing a frame to a client's connection
+}
+
+// snapshot returns the histograms as /api/stats reports them
+func (t *broadcastTimes) snapshot() protocol.BroadcastTimes {
+	return protocol.BroadcastTimes{
+		Tick:     t.tick.snapshot(),
+		Query:    t.query.snapshot(),
+		Assemble: t.assemble.snapshot(),
+		Encode:   t.encode.snapshot(),
+		Write:    t.write.snapshot(),
+	}
+}
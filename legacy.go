@@ -0,0 +1,92 @@
+package main
+
+import (
+	"quadtree/protocol"
+	"slices"
+)
+
+// Compatibility shim for frontends written before the protocol package.
+// They send client_params without negotiating a version and only understand
+// the original drivers_update message, so they get that format and none of
+// the newer message types.
+
+// legacyDriver is a driver in the original drivers_update format
+type legacyDriver struct {
+	ID       int     `json:"id"`
+	Lon      float64 `json:"lon"`
+	Lat      float64 `json:"lat"`
+	Status   string  `json:"status"`
+	Distance float64 `json:"distance,omitempty"` // distance in km from query point
+	Heading  float64 `json:"heading"`            // direction in degrees (0-360)
+	Speed    float64 `json:"speed"`              // speed in degrees per second
+}
+
+// legacyDriversUpdate is the original drivers_update message
+type legacyDriversUpdate struct {
+	Type    string            `json:"type"` // "drivers_update"
+	Drivers []legacyDriver    `json:"drivers"`
+	Count   int               `json:"count"`
+	Center  protocol.Location `json:"center"`
+	Radius  float64           `json:"radius"`
+	Time    int64             `json:"time"` // Timestamp in milliseconds
+}
+
+// legacyUpdate converts a driver update to the legacy format
+func legacyUpdate(update protocol.DriversUpdate) legacyDriversUpdate {
+	drivers := make([]legacyDriver, 0, len(update.Drivers))
+	for _, d := range update.Drivers {
+		drivers = append(drivers, legacyDriver{
+			ID:       d.ID,
+			Lon:      d.Lon,
+			Lat:      d.Lat,
+			Status:   d.Status,
+			Distance: d.Distance,
+			Heading:  d.Heading,
+			Speed:    d.Speed,
+		})
+	}
+	return legacyDriversUpdate{
+		Type:    protocol.TypeDriversUpdate,
+		Drivers: drivers,
+		Count:   len(drivers),
+		Center:  update.Center,
+		Radius:  update.Radius,
+		Time:    update.Time,
+	}
+}
+
+// negotiateVersion picks the protocol version for a client's hello: the
+// highest version both sides speak
+func negotiateVersion(requested int) int {
+	if requested < protocol.VersionLegacy {
+		return protocol.VersionLegacy
+	}
+	return min(requested, protocol.Version)
+}
+
+// serverCapabilities are the capabilities this server offers
+var serverCapabilities = []string{
+	protocol.CapDeltas,
+	protocol.CapMsgpack,
+	protocol.CapProtobuf,
+	protocol.CapSubscriptions,
+	protocol.CapUpdateInterval,
+	protocol.CapEvents,
+	protocol.CapStats,
+}
+
+// negotiateCapabilities picks the capabilities for a client's hello: those
+// it asked for that the server offers, or all of them if it asked for none
+func negotiateCapabilities(requested []string) []string {
+	if len(requested) == 0 {
+		return serverCapabilities
+	}
+
+	enabled := make([]string, 0, len(requested))
+	for _, c := range serverCapabilities {
+		if slices.Contains(requested, c) {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits protects the WebSocket endpoint from clients opening too many
+// connections: it caps the connections open at once and throttles upgrade
+// attempts per IP. A nil Limits lets every connection through.
+type Limits struct {
+	maxClients int        // concurrent WebSocket connections; 0 is unlimited
+	upgrades   *ipLimiter // upgrade attempts per IP; nil is unlimited
+
+	mu      sync.Mutex
+	clients int
+}
+
+// NewLimits creates limits of maxClients concurrent connections and
+// perMinute upgrade attempts per IP; 0 leaves either unlimited. An idle IP
+// may use up a whole minute's attempts at once.
+func NewLimits(maxClients int, perMinute float64) (*Limits, error) {
+	if maxClients < 0 {
+		return nil, fmt.Errorf("max clients must not be negative")
+	}
+	if perMinute < 0 {
+		return nil, fmt.Errorf("upgrade rate must not be negative")
+	}
+	l := &Limits{maxClients: maxClients}
+	if perMinute > 0 {
+		l.upgrades = newIPLimiter(perMinute/60, math.Max(1, perMinute))
+	}
+	return l, nil
+}
+
+// allowUpgrade takes an upgrade attempt from an IP's bucket. When it is
+// empty it reports false and how long until the next attempt is allowed.
+func (l *Limits) allowUpgrade(ip string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.upgrades.allow(ip)
+}
+
+// acquire counts a new WebSocket connection against the server's limit. It
+// reports false when the limit is reached.
+func (l *Limits) acquire() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxClients > 0 && l.clients >= l.maxClients {
+		return false
+	}
+	l.clients++
+	return true
+}
+
+// release ends a connection counted by acquire
+func (l *Limits) release() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clients--
+}
+
+// ipLimiter throttles requests per client IP with token buckets: each IP
+// may make burst requests at once and rate more per second after that. A
+// nil ipLimiter allows everything.
+type ipLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// tokenBucket is the bucket of one IP
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPLimiter(rate, burst float64) *ipLimiter {
+	return &ipLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		pruned:  time.Now(),
+	}
+}
+
+// allow takes a token from an IP's bucket. When it is empty it reports
+// false and how long until the next token.
+func (l *ipLimiter) allow(ip string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget IPs whose buckets have filled up again, so the map doesn't
+	// grow with every address that ever connected
+	if now.Sub(l.pruned) > time.Minute {
+		for addr, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, addr)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Limit wraps a handler so each IP is throttled, answering requests over
+// the limit with 429 Too Many Requests. throttled counts them.
+func (l *ipLimiter) Limit(throttled *atomic.Int64, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := l.allow(remoteIP(r)); !ok {
+			throttled.Add(1)
+			w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS, so browsers see the 429
+			tooManyRequests(w, retry, "too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// remoteIP is the IP address a request comes from. Forwarding headers are
+// ignored, since anyone can set them.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tooManyRequests answers a throttled request, saying when to try again
+func tooManyRequests(w http.ResponseWriter, retry time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
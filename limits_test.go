@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// advance makes the limiter's buckets as old as if d had passed
+func (l *ipLimiter) advance(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.buckets {
+		b.last = b.last.Add(-d)
+	}
+	l.pruned = l.pruned.Add(-d)
+}
+
+func TestIPLimiter(t *testing.T) {
+	type step struct {
+		after     time.Duration // since the previous step
+		ip        string
+		want      bool
+		wantRetry time.Duration // roughly, when refused
+	}
+	tests := []struct {
+		name        string
+		rate, burst float64
+		steps       []step
+	}{
+		{
+			name: "burst, then the rate",
+			rate: 1, burst: 3,
+			steps: []step{
+				{ip: "a", want: true},
+				{ip: "a", want: true},
+				{ip: "a", want: true},
+				{ip: "a", want: false, wantRetry: time.Second},
+				{after: 500 * time.Millisecond, ip: "a", want: false, wantRetry: 500 * time.Millisecond},
+				{after: 500 * time.Millisecond, ip: "a", want: true},
+				{ip: "a", want: false, wantRetry: time.Second},
+			},
+		},
+		{
+			name: "a bucket holds at most burst",
+			rate: 10, burst: 2,
+			steps: []step{
+				{ip: "a", want: true},
+				{after: time.Hour, ip: "a", want: true},
+				{ip: "a", want: true},
+				{ip: "a", want: false, wantRetry: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "IPs have their own buckets",
+			rate: 1, burst: 1,
+			steps: []step{
+				{ip: "a", want: true},
+				{ip: "a", want: false, wantRetry: time.Second},
+				{ip: "b", want: true},
+				{ip: "2001:db8::1", want: true},
+				{ip: "b", want: false, wantRetry: time.Second},
+			},
+		},
+		{
+			name: "slow rates",
+			rate: 1.0 / 60, burst: 1,
+			steps: []step{
+				{ip: "a", want: true},
+				{after: 30 * time.Second, ip: "a", want: false, wantRetry: 30 * time.Second},
+				{after: 30 * time.Second, ip: "a", want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		l := newIPLimiter(tt.rate, tt.burst)
+		for i, s := range tt.steps {
+			l.advance(s.after)
+			ok, retry := l.allow(s.ip)
+			if ok != s.want {
+				t.Errorf("%s, step %d: allowed %v, want %v", tt.name, i, ok, s.want)
+				continue
+			}
+			if diff := retry - s.wantRetry; diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+				t.Errorf("%s, step %d: retry after %s, want %s", tt.name, i, retry, s.wantRetry)
+			}
+		}
+	}
+}
+
+func TestIPLimiterForgetsFullBuckets(t *testing.T) {
+	l := newIPLimiter(1, 2)
+	l.allow("idle")
+	l.allow("busy")
+	l.allow("busy")
+	l.advance(time.Minute + time.Second)
+	l.allow("busy")
+	l.allow("busy")
+	l.allow("busy") // empty again
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("the full bucket of an idle IP was kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("the bucket of a busy IP was forgotten")
+	}
+}
+
+func TestNilLimitsAllowEverything(t *testing.T) {
+	var l *ipLimiter
+	for range 100 {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatal("nil ipLimiter refused a request")
+		}
+	}
+	var limits *Limits
+	if ok, _ := limits.allowUpgrade("a"); !ok || !limits.acquire() {
+		t.Fatal("nil Limits refused a connection")
+	}
+	limits.release()
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		maxClients int
+		perMinute  float64
+		wantErr    bool
+		upgrades   int // allowed at once
+		clients    int // allowed at once; -1 for all of them
+	}{
+		{maxClients: 0, perMinute: 0, upgrades: -1, clients: -1},
+		{maxClients: 2, perMinute: 0, upgrades: -1, clients: 2},
+		{maxClients: 0, perMinute: 5, upgrades: 5, clients: -1},
+		{maxClients: 0, perMinute: 0.5, upgrades: 1, clients: -1}, // always at least one
+		{maxClients: -1, wantErr: true},
+		{perMinute: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		l, err := NewLimits(tt.maxClients, tt.perMinute)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewLimits(%d, %g) error = %v, want error %v", tt.maxClients, tt.perMinute, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if got := count(func() bool { ok, _ := l.allowUpgrade("a"); return ok }); got != tt.upgrades {
+			t.Errorf("NewLimits(%d, %g): %d upgrades at once, want %d", tt.maxClients, tt.perMinute, got, tt.upgrades)
+		}
+		if got := count(l.acquire); got != tt.clients {
+			t.Errorf("NewLimits(%d, %g): %d clients, want %d", tt.maxClients, tt.perMinute, got, tt.clients)
+		}
+		if tt.clients > 0 {
+			l.release()
+			if !l.acquire() {
+				t.Errorf("NewLimits(%d, %g): no room after a release", tt.maxClients, tt.perMinute)
+			}
+		}
+	}
+}
+
+// count calls allow until it reports false, up to 100 times, and returns
+// how often it reported true; -1 when it never reported false
+func count(allow func() bool) int {
+	for n := range 100 {
+		if !allow() {
+			return n
+		}
+	}
+	return -1
+}
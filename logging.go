@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Log formats
+const (
+	LogText = "text" // key=value pairs, for reading in a terminal
+	LogJSON = "json" // one JSON object per line, for log aggregators
+)
+
+// setupLogging makes the default logger write records of the given level
+// and above to w in the given format. The standard log package writes
+// through it too, at LevelInfo.
+func setupLogging(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case LogText:
+		handler = slog.NewTextHandler(w, opts)
+	case LogJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (want %s or %s)", format, LogText, LogJSON)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs an error and exits, for failures the server can't start or
+// keep running with
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"quadtree/protocol"
+	"sync"
+	"sync/atomic"
+)
+
+// Message types counted for messages the protocol has no type for
+const (
+	messageInvalid = "invalid" // received messages that aren't JSON objects
+	messageUnknown = "unknown" // JSON objects without a type
+	messageBinary  = "binary"  // binary frames received
+	messageClose   = "close"   // close frames sent
+)
+
+// typeCounts counts messages by type. Writers of every client add to it,
+// so the counters are atomic rather than behind a lock.
+type typeCounts struct {
+	counts sync.Map // message type → *atomic.Int64
+}
+
+// add counts a message of the given type
+func (c *typeCounts) add(kind string) {
+	if kind == "" {
+		kind = messageUnknown
+	}
+	n, ok := c.counts.Load(kind)
+	if !ok {
+		n, _ = c.counts.LoadOrStore(kind, new(atomic.Int64))
+	}
+	n.(*atomic.Int64).Add(1)
+}
+
+// snapshot returns the counts so far
+func (c *typeCounts) snapshot() map[string]int64 {
+	counts := map[string]int64{}
+	c.counts.Range(func(kind, n any) bool {
+		counts[kind.(string)] = n.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
+
+// clientTypes are the message types clients may send; others are counted
+// as unknown, so clients can't grow the counts without bound
+var clientTypes = func() map[string]bool {
+	types := map[string]bool{}
+	for _, info := range protocol.Registry {
+		if info.Direction == "client" {
+			types[info.Type] = true
+		}
+	}
+	return types
+}()
+
+// received counts a message from a client, given its type or the error
+// parsing it
+func (m *messageCounts) received(msgType string, err error) {
+	switch {
+	case err != nil:
+		m.in.add(messageInvalid)
+	case clientTypes[msgType]:
+		m.in.add(msgType)
+	default:
+		m.in.add(messageUnknown)
+	}
+}
+
+// messageCounts counts the messages clients sent and were sent, for
+// /api/stats
+type messageCounts struct {
+	in, out, dropped, stale typeCounts
+}
+
+// snapshot returns the counts in the form /api/stats reports them
+func (m *messageCounts) snapshot() protocol.MessageCounts {
+	return protocol.MessageCounts{
+		In:      m.in.snapshot(),
+		Out:     m.out.snapshot(),
+		Dropped: m.dropped.snapshot(),
+		Stale:   m.stale.snapshot(),
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "math/rand"
+
+// moveShardSize is how many drivers a worker moves at a time. Shards are
+// cut by position in the drivers slice rather than by the number of cores,
+// so a seed moves the drivers the same way on any machine. A shard's
+// drivers are consecutive rows of the motion columns, so every worker writes
+// a stretch of memory of its own.
+const moveShardSize = 1024
+
+// moveDrivers moves every driver by deltaTime on all cores and records the
+// velocity of the step in the motion columns. Each shard of drivers draws
+// from its own RNG, seeded from the simulation's the first time the fleet
+// grows to that shard, so runs with the same seed and commands stay
+// reproducible. It must run on the main loop.
+func (s *Simulation) moveDrivers(deltaTime float64, t *Tunables) {
+	shards := (len(s.drivers) + moveShardSize - 1) / moveShardSize
+	for len(s.moveRands) < shards {
+		s.moveRands = append(s.moveRands, rand.New(rand.NewSource(s.rand.Int63())))
+	}
+
+	m := s.motion
+	moveShard := func(i int) {
+		start, end := i*moveShardSize, min((i+1)*moveShardSize, len(s.drivers))
+		r := s.moveRands[i]
+		for row := start; row < end; row++ {
+			driver := s.drivers[row]
+			lon, lat := m.lon[row], m.lat[row]
+			driver.Move(deltaTime, r, &s.settings, s.geofences, s.destinations, t)
+			// Federated drivers keep the velocity their peer reported
+			if driver.Origin == "" {
+				m.vlon[row], m.vlat[row] = (m.lon[row]-lon)/deltaTime, (m.lat[row]-lat)/deltaTime
+			}
+		}
+	}
+
+	fanOut(shards, moveShard)
+}
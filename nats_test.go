@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNATSURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    natsServer
+		wantErr bool
+	}{
+		{raw: "nats://nats", want: natsServer{addr: "nats:4222", host: "nats"}},
+		{raw: "nats://nats:4333", want: natsServer{addr: "nats:4333", host: "nats"}},
+		{raw: "tls://nats", want: natsServer{addr: "nats:4222", host: "nats", tls: true}},
+		{raw: "nats://fleet:pw@nats", want: natsServer{addr: "nats:4222", host: "nats", user: "fleet", pass: "pw"}},
+		{raw: "nats://s3cret@nats", want: natsServer{addr: "nats:4222", host: "nats", token: "s3cret"}},
+		{raw: "tcp://nats", wantErr: true},
+		{raw: "nats://", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNATSURL(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseNATSURL(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseNATSURL(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNATSToken(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Erbil", "Erbil"},
+		{"Sulaymaniyah City", "Sulaymaniyah_City"},
+		{"a.b*c>d\te", "a_b_c_d_e"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := natsToken(tt.name); got != tt.want {
+			t.Errorf("natsToken(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	conn := newBufConn("")
+	nc := &natsConn{conn: conn, w: bufio.NewWriter(conn)}
+	nc.publish("taxi.drivers.Erbil.7", []byte(`{"id":7}`))
+	nc.publish("taxi.trips.started", nil)
+	if err := nc.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "PUB taxi.drivers.Erbil.7 8\r\n{\"id\":7}\r\nPUB taxi.trips.started 0\r\n\r\n"
+	if got := conn.w.String(); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestNATSConnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      natsServer
+		from        string // what the server sends
+		wantConnect natsConnect
+		wantErr     string
+	}{
+		{
+			name:        "anonymous",
+			from:        "INFO {\"server_id\":\"x\"}\r\nPONG\r\n",
+			wantConnect: natsConnect{Name: "taxi-sim", Lang: "go", Version: "1", Protocol: 1},
+		},
+		{
+			name:        "user and password",
+			server:      natsServer{user: "fleet", pass: "pw"},
+			from:        "INFO {}\r\n+OK\r\nPONG\r\n",
+			wantConnect: natsConnect{Name: "taxi-sim", Lang: "go", Version: "1", Protocol: 1, User: "fleet", Pass: "pw"},
+		},
+		{
+			name:        "token",
+			server:      natsServer{token: "s3cret"},
+			from:        "INFO {}\r\nPONG\r\n",
+			wantConnect: natsConnect{Name: "taxi-sim", Lang: "go", Version: "1", Protocol: 1, AuthToken: "s3cret"},
+		},
+		{
+			name:        "refused",
+			from:        "INFO {}\r\n-ERR 'Authorization Violation'\r\n",
+			wantConnect: natsConnect{Name: "taxi-sim", Lang: "go", Version: "1", Protocol: 1},
+			wantErr:     "server refused the connection: 'Authorization Violation'",
+		},
+		{name: "no INFO", from: "PING\r\n", wantErr: `unexpected "PING" instead of INFO`},
+		{name: "invalid INFO", from: "INFO {\r\n", wantErr: "invalid INFO"},
+		{
+			name:        "no PONG",
+			from:        "INFO {}\r\n",
+			wantConnect: natsConnect{Name: "taxi-sim", Lang: "go", Version: "1", Protocol: 1},
+			wantErr:     "waiting for PONG",
+		},
+	}
+	for _, tt := range tests {
+		conn := newBufConn(tt.from)
+		nc := &natsConn{conn: conn, r: bufio.NewReader(conn)}
+		err := nc.connect(tt.server)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+
+		sent := conn.w.String()
+		if tt.wantConnect == (natsConnect{}) {
+			if sent != "" {
+				t.Errorf("%s: sent %q before the server's INFO", tt.name, sent)
+			}
+			continue
+		}
+		options, ok := strings.CutPrefix(sent, "CONNECT ")
+		options, ok2 := strings.CutSuffix(options, "\r\nPING\r\n")
+		if !ok || !ok2 {
+			t.Errorf("%s: sent %q, want CONNECT and PING", tt.name, sent)
+			continue
+		}
+		var got natsConnect
+		if err := json.Unmarshal([]byte(options), &got); err != nil {
+			t.Errorf("%s: CONNECT options %q: %v", tt.name, options, err)
+		} else if got != tt.wantConnect {
+			t.Errorf("%s: CONNECT %+v, want %+v", tt.name, got, tt.wantConnect)
+		}
+	}
+}
+
+func TestNATSReadLoop(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		capacity int // of the channel the messages go to
+		want     []natsMessage
+		wantSent string
+		wantDrop int
+		wantErr  string
+	}{
+		{
+			name:     "messages",
+			from:     "MSG ext.drivers 1 5\r\nhello\r\nMSG ext.drivers 1 inbox.7 2\r\n{}\r\nMSG ext.x 1 0\r\n\r\n",
+			capacity: 3,
+			want: []natsMessage{
+				{subject: "ext.drivers", payload: []byte("hello")},
+				{subject: "ext.drivers", payload: []byte("{}")},
+				{subject: "ext.x", payload: []byte{}},
+			},
+			wantErr: "EOF",
+		},
+		{
+			name:     "full channel",
+			from:     "MSG a 1 1\r\nx\r\nMSG b 1 1\r\ny\r\n",
+			capacity: 1,
+			want:     []natsMessage{{subject: "a", payload: []byte("x")}},
+			wantDrop: 1,
+			wantErr:  "EOF",
+		},
+		{name: "ping", from: "PING\r\nPONG\r\n+OK\r\nINFO {}\r\n", wantSent: "PONG\r\n", wantErr: "EOF"},
+		{name: "server error", from: "-ERR 'Stale Connection'\r\n", wantErr: "server error: 'Stale Connection'"},
+		{name: "unknown verb", from: "HMSG a 1 0 0\r\n", wantErr: `unexpected "HMSG a 1 0 0"`},
+		{name: "missing size", from: "MSG a 1\r\n", wantErr: "malformed"},
+		{name: "negative size", from: "MSG a 1 -1\r\n", wantErr: "malformed"},
+		{name: "oversized", from: "MSG a 1 2000000\r\n", wantErr: "malformed"},
+		{name: "short payload", from: "MSG a 1 5\r\nhi\r\n", capacity: 1, wantErr: io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		conn := newBufConn(tt.from)
+		nc := &natsConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+		msgs := make(chan natsMessage, tt.capacity)
+		dropped := 0
+		err := nc.readLoop(msgs, func() { dropped++ })
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		close(msgs)
+
+		var got []natsMessage
+		for msg := range msgs {
+			got = append(got, msg)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if dropped != tt.wantDrop {
+			t.Errorf("%s: dropped %d, want %d", tt.name, dropped, tt.wantDrop)
+		}
+		if sent := conn.w.String(); sent != tt.wantSent {
+			t.Errorf("%s: sent %q, want %q", tt.name, sent, tt.wantSent)
+		}
+	}
+}
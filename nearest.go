@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"quadtree/filter"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nearest-driver queries return this many drivers by default, and at most
+// maxNearest
+const (
+	defaultNearest = 5
+	maxNearest     = 100
+)
+
+// NearestDriversHandler handles GET /api/drivers/nearest?lat=&lon=&n=5,
+// optionally narrowed by status (e.g. status=available or
+// status=available,busy) and a filter expression
+func (s *Simulation) NearestDriversHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+	if err != nil || !(lat >= -90 && lat <= 90) {
+		http.Error(w, fmt.Sprintf("invalid lat %q: want a number from -90 to 90", query.Get("lat")), http.StatusBadRequest)
+		return
+	}
+	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+	if err != nil || !(lon >= -180 && lon <= 180) {
+		http.Error(w, fmt.Sprintf("invalid lon %q: want a number from -180 to 180", query.Get("lon")), http.StatusBadRequest)
+		return
+	}
+
+	n := defaultNearest
+	if nStr := query.Get("n"); nStr != "" {
+		n, err = strconv.Atoi(nStr)
+		if err != nil || n < 1 || n > maxNearest {
+			http.Error(w, fmt.Sprintf("n must be between 1 and %d", maxNearest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var statuses map[DriverStatus]bool
+	if statusStr := query.Get("status"); statusStr != "" {
+		statuses = make(map[DriverStatus]bool)
+		for _, name := range strings.Split(statusStr, ",") {
+			status, err := parseDriverStatus(strings.TrimSpace(name))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			statuses[status] = true
+		}
+	}
+
+	driverFilter, err := compileDriverFilter(query.Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	drivers, publishedAt := s.NearestDrivers(lon, lat, n, statuses, driverFilter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.NearestDriversResponse{
+		Drivers:   drivers,
+		Count:     len(drivers),
+		Center:    protocol.Location{Lat: lat, Lon: lon},
+		DataAgeMs: time.Since(publishedAt).Milliseconds(),
+	})
+}
+
+// NearestDrivers finds the n drivers closest to a location by great-circle
+// distance, closest first, among those with one of the given statuses (nil
+// allows any) that pass the filter. It searches the last published world
+// and also returns when that was published.
+func (s *Simulation) NearestDrivers(lon, lat float64, n int, statuses map[DriverStatus]bool, f *filter.Filter) ([]protocol.DriverResponse, time.Time) {
+	world := s.world()
+
+	// Describe every candidate once while searching, so the filter sees
+	// the same driver the response does
+	responses := make(map[int]protocol.DriverResponse)
+	keep := func(p quadtree.Point) bool {
+		state, ok := world.driver(p.ID)
+		if !ok {
+			return false // indexed, but not published yet
+		}
+		if statuses != nil && !statuses[state.status] {
+			return false
+		}
+
+		resp := state.resp
+		resp.Distance = geo.HaversineKm(lon, lat, p.X, p.Y)
+		if !matchDriver(f, &resp) {
+			return false
+		}
+		responses[p.ID] = resp
+		return true
+	}
+
+	start := time.Now()
+	points := world.nearest(lon, lat, n, keep)
+	s.recordQuery(len(points), time.Since(start))
+
+	drivers := make([]protocol.DriverResponse, len(points))
+	for i, p := range points {
+		drivers[i] = responses[p.ID]
+	}
+	return drivers, world.publishedAt
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"quadtree/protocol"
+	"slices"
+	"strconv"
+)
+
+// maxPageSize is the largest limit a /api/drivers request may ask for
+const maxPageSize = 1000
+
+// Orders /api/drivers results can be sorted in
+const (
+	sortDistance = "distance" // closest first (the default)
+	sortID       = "id"
+	sortStatus   = "status" // Available, then Busy, then Offline; closest first within each
+)
+
+// driverPage is the order and page of drivers a /api/drivers request asks
+// for. A zero limit returns every driver from offset on.
+type driverPage struct {
+	sort   string
+	offset int
+	limit  int
+}
+
+// parseDriverPage reads the sort, limit and offset parameters
+func parseDriverPage(query url.Values) (driverPage, error) {
+	page := driverPage{sort: sortDistance}
+
+	switch by := query.Get("sort"); by {
+	case "":
+	case sortDistance, sortID, sortStatus:
+		page.sort = by
+	default:
+		return page, fmt.Errorf("unknown sort %q (want %s, %s or %s)", by, sortDistance, sortID, sortStatus)
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.limit = limit
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("offset must not be negative")
+		}
+		page.offset = offset
+	}
+	return page, nil
+}
+
+// apply sorts drivers and cuts out the page. It returns the offset of the
+// next page, 0 on the last one. The offset counts drivers in the order of
+// the next request's results, not this one's, so drivers that move between
+// pages can be skipped or repeated.
+func (p driverPage) apply(drivers []protocol.DriverResponse) ([]protocol.DriverResponse, int) {
+	slices.SortFunc(drivers, func(a, b protocol.DriverResponse) int {
+		switch p.sort {
+		case sortID:
+			return cmp.Compare(a.ID, b.ID)
+		case sortStatus:
+			if c := cmp.Compare(statusRank(a.Status), statusRank(b.Status)); c != 0 {
+				return c
+			}
+		}
+		// Ties go to the lower ID, so pages stay stable
+		if c := cmp.Compare(a.Distance, b.Distance); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	if p.offset >= len(drivers) {
+		return []protocol.DriverResponse{}, 0
+	}
+	drivers = drivers[p.offset:]
+	if p.limit == 0 || p.limit >= len(drivers) {
+		return drivers, 0
+	}
+	return drivers[:p.limit], p.offset + p.limit
+}
+
+// statusRank orders statuses by how useful the drivers are to a rider
+func statusRank(name string) int {
+	status, err := parseDriverStatus(name)
+	if err != nil {
+		return int(Offline) + 1
+	}
+	return int(status)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/url"
+	"quadtree/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestParseDriverPage(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    driverPage
+		wantErr string
+	}{
+		{query: "", want: driverPage{sort: sortDistance}},
+		{query: "sort=id&limit=50", want: driverPage{sort: sortID, limit: 50}},
+		{query: "sort=status&limit=1000&offset=2000", want: driverPage{sort: sortStatus, limit: 1000, offset: 2000}},
+		{query: "offset=0", want: driverPage{sort: sortDistance}},
+		{query: "sort=speed", wantErr: `unknown sort "speed" (want distance, id or status)`},
+		{query: "limit=0", wantErr: "limit must be between 1 and 1000"},
+		{query: "limit=1001", wantErr: "limit must be between 1 and 1000"},
+		{query: "limit=ten", wantErr: "limit must be between 1 and 1000"},
+		{query: "offset=-1", wantErr: "offset must not be negative"},
+		{query: "offset=x", wantErr: "offset must not be negative"},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		got, err := parseDriverPage(query)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseDriverPage(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDriverPage(%q): %v", tt.query, err)
+		} else if got != tt.want {
+			t.Errorf("parseDriverPage(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDriverPageApply(t *testing.T) {
+	// IDs 1 to 6, in neither distance nor ID order
+	drivers := []protocol.DriverResponse{
+		{ID: 4, Distance: 0.5, Status: "Busy"},
+		{ID: 2, Distance: 1.5, Status: "Available"},
+		{ID: 6, Distance: 0.5, Status: "Offline"},
+		{ID: 1, Distance: 2.0, Status: "Available"},
+		{ID: 5, Distance: 0.1, Status: "Busy"},
+		{ID: 3, Distance: 0.3, Status: "Unknown"},
+	}
+	tests := []struct {
+		name     string
+		page     driverPage
+		wantIDs  []int
+		wantNext int
+	}{
+		{"closest first, ties by ID", driverPage{sort: sortDistance}, []int{5, 3, 4, 6, 2, 1}, 0},
+		{"by ID", driverPage{sort: sortID}, []int{1, 2, 3, 4, 5, 6}, 0},
+		{"by status, then closest", driverPage{sort: sortStatus}, []int{2, 1, 5, 4, 6, 3}, 0},
+		{"first page", driverPage{sort: sortID, limit: 4}, []int{1, 2, 3, 4}, 4},
+		{"middle page", driverPage{sort: sortID, limit: 2, offset: 2}, []int{3, 4}, 4},
+		{"last page", driverPage{sort: sortID, limit: 4, offset: 4}, []int{5, 6}, 0},
+		{"page that ends with the drivers", driverPage{sort: sortID, limit: 3, offset: 3}, []int{4, 5, 6}, 0},
+		{"offset without limit", driverPage{sort: sortID, offset: 5}, []int{6}, 0},
+		{"past the end", driverPage{sort: sortID, limit: 2, offset: 6}, []int{}, 0},
+	}
+	for _, tt := range tests {
+		got, next := tt.page.apply(append([]protocol.DriverResponse(nil), drivers...))
+		ids := make([]int, len(got))
+		for i, d := range got {
+			ids[i] = d.ID
+		}
+		if !reflect.DeepEqual(ids, tt.wantIDs) || next != tt.wantNext {
+			t.Errorf("%s: got %v, next offset %d; want %v, %d", tt.name, ids, next, tt.wantIDs, tt.wantNext)
+		}
+	}
+}
+
+// TestDriverPageWalk follows next offsets through every page
+func TestDriverPageWalk(t *testing.T) {
+	drivers := make([]protocol.DriverResponse, 25)
+	for i := range drivers {
+		drivers[i] = protocol.DriverResponse{ID: 100 - i, Distance: float64(i % 7)}
+	}
+
+	for _, limit := range []int{1, 4, 5, 24, 25, 26} {
+		seen := make(map[int]bool)
+		page := driverPage{sort: sortDistance, limit: limit}
+		for pages := 0; ; pages++ {
+			if pages > len(drivers) {
+				t.Fatalf("limit %d: pages never end", limit)
+			}
+			got, next := page.apply(append([]protocol.DriverResponse(nil), drivers...))
+			for _, d := range got {
+				if seen[d.ID] {
+					t.Errorf("limit %d: driver %d on two pages", limit, d.ID)
+				}
+				seen[d.ID] = true
+			}
+			if next == 0 {
+				break
+			}
+			page.offset = next
+		}
+		if len(seen) != len(drivers) {
+			t.Errorf("limit %d: saw %d drivers, want %d", limit, len(seen), len(drivers))
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cityIndexes partitions the spatial index by city: every driver is kept in
+// the index of the city closest to it. A query only visits the cities whose
+// drivers it can reach, so one scoped to Erbil never touches Duhok's
+// drivers, and every city's driver counts fall out of the update. The
+// partitions are made with the simulation and never change.
+type cityIndexes struct {
+	// Guards the partitions. Held for reading across a query and for
+	// writing across an update, so a driver changing cities is never found
+	// twice or missed.
+	mu         sync.RWMutex
+	parts      []*cityPartition
+	updateTime time.Duration // how long the last update of every city took
+}
+
+// cityPartition is the index of one city's drivers
+type cityPartition struct {
+	city   City
+	index  spatialIndex
+	counts protocol.CityStats // as of the last update
+}
+
+// newCityIndexes makes an empty partition for every city, in quadtrees over
+// the world bounds until UseIndex picks another structure
+func newCityIndexes(cities []City, bounds quadtree.Bounds) cityIndexes {
+	newTree, _ := newIndexTree(IndexQuadtree, defaultIndexCapacity, bounds)
+	parts := make([]*cityPartition, len(cities))
+	for i, city := range cities {
+		parts[i] = &cityPartition{
+			city:   city,
+			index:  spatialIndex{kind: IndexQuadtree, newTree: newTree},
+			counts: protocol.CityStats{City: city.Name},
+		}
+	}
+	return cityIndexes{parts: parts}
+}
+
+// indexedDriver is a driver's position and status as an index update sees it
+type indexedDriver struct {
+	point  quadtree.Point
+	status DriverStatus
+}
+
+// update brings every city's index in step with the drivers, moving the
+// ones that crossed into another city to its index
+func (ci *cityIndexes) update(drivers []indexedDriver) {
+	if len(ci.parts) == 0 {
+		return
+	}
+	start := time.Now()
+	cities := make([]City, len(ci.parts))
+	for i, part := range ci.parts {
+		cities[i] = part.city
+	}
+
+	positions := make([][]quadtree.Point, len(ci.parts))
+	counts := make([]protocol.CityStats, len(ci.parts))
+	for _, d := range drivers {
+		i := ci.partOf(closestCity(cities, d.point.X, d.point.Y).Name)
+		positions[i] = append(positions[i], d.point)
+		c := &counts[i]
+		c.Drivers++
+		switch d.status {
+		case Available:
+			c.Available++
+		case Busy:
+			c.Busy++
+		case Offline:
+			c.Offline++
+		}
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	for i, part := range ci.parts {
+		part.index.update(positions[i])
+		counts[i].City = part.city.Name
+		part.counts = counts[i]
+	}
+	ci.updateTime = time.Since(start)
+}
+
+// partOf returns where the partition of the named city is
+func (ci *cityIndexes) partOf(name string) int {
+	for i, part := range ci.parts {
+		if part.city.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// state sums the update and rebuild counts of the cities' indexes and
+// returns when the least recently updated one was brought up to date
+func (ci *cityIndexes) state() (kind string, version, rebuilds int64, updatedAt time.Time) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	return ci.stateLocked()
+}
+
+// stateLocked is state for callers already holding ci.mu
+func (ci *cityIndexes) stateLocked() (kind string, version, rebuilds int64, updatedAt time.Time) {
+	for i, part := range ci.parts {
+		idx := &part.index
+		version += idx.version
+		rebuilds += idx.rebuilds
+		if i == 0 || idx.updatedAt.Before(updatedAt) {
+			kind, updatedAt = idx.kind, idx.updatedAt
+		}
+	}
+	return kind, version, rebuilds, updatedAt
+}
+
+// stats describes the index of every city together, and returns the
+// driver counts of every city as of the last update with its index
+func (ci *cityIndexes) stats() (protocol.IndexStats, []protocol.CityStats) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	total := protocol.IndexStats{UpdateMs: float64(ci.updateTime) / float64(time.Millisecond)}
+	cities := make([]protocol.CityStats, len(ci.parts))
+	for i, part := range ci.parts {
+		cities[i] = part.counts
+		idx := part.index.stats()
+		cities[i].Index = idx
+
+		if i == 0 {
+			total.Kind = idx.Kind
+		}
+		total.Points += idx.Points
+		total.Rebuilds += idx.Rebuilds
+		if idx.BuiltAt > total.BuiltAt {
+			total.BuiltAt, total.BuildMs = idx.BuiltAt, idx.BuildMs
+		}
+		if idx.Shape != nil {
+			total.Shape = mergeShapes(total.Shape, idx.Shape)
+		}
+	}
+	return total, cities
+}
+
+// mergeShapes describes the trees of two shapes together, as if they were
+// the subtrees of one; total may be nil
+func mergeShapes(total, shape *protocol.IndexShape) *protocol.IndexShape {
+	if total == nil {
+		total = &protocol.IndexShape{Capacity: shape.Capacity}
+	}
+	points := total.AvgLeafPoints*float64(total.Leaves-total.EmptyLeaves) +
+		shape.AvgLeafPoints*float64(shape.Leaves-shape.EmptyLeaves)
+	total.Depth = max(total.Depth, shape.Depth)
+	total.Nodes += shape.Nodes
+	total.Leaves += shape.Leaves
+	total.EmptyLeaves += shape.EmptyLeaves
+	if filled := total.Leaves - total.EmptyLeaves; filled > 0 {
+		total.AvgLeafPoints = points / float64(filled)
+	}
+	for len(total.LeafPoints) < len(shape.LeafPoints) {
+		total.LeafPoints = append(total.LeafPoints, 0)
+	}
+	for n, leaves := range shape.LeafPoints {
+		total.LeafPoints[n] += leaves
+	}
+	return total
+}
+
+// query returns the points inside bounds (see cityTrees.query) and when the
+// least recently updated index was brought up to date
+func (ci *cityIndexes) query(city string, bounds quadtree.Bounds) ([]quadtree.Point, time.Time) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	_, _, _, updatedAt := ci.stateLocked()
+	return ci.trees().query(city, bounds), updatedAt
+}
+
+// trees returns the live trees of the cities; ci.mu must be held for as
+// long as they are used
+func (ci *cityIndexes) trees() cityTrees {
+	trees := make(cityTrees, len(ci.parts))
+	for i, part := range ci.parts {
+		idx := &part.index
+		trees[i] = cityTree{city: part.city.Name, tree: idx.tree, extent: idx.extent, size: len(idx.points)}
+	}
+	return trees
+}
+
+// copyTrees returns fresh copies of the cities' trees, which nothing ever
+// changes, and the summed version of the indexes they were copied from.
+// Remote trees aren't copied: snapshots search them as they are now.
+func (ci *cityIndexes) copyTrees() (cityTrees, int64) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	_, version, _, _ := ci.stateLocked()
+	trees := ci.trees()
+	for i, part := range ci.parts {
+		if part.index.tree == nil {
+			continue // never built, so it holds nothing
+		}
+		if _, ok := part.index.tree.(remoteTree); ok {
+			continue
+		}
+		tree := part.index.newTree()
+		for _, p := range part.index.points {
+			tree.Insert(p)
+		}
+		trees[i].tree = tree
+	}
+	return trees, version
+}
+
+// cityTree is the tree of one city's drivers
+type cityTree struct {
+	city   string
+	tree   quadtree.Index
+	extent quadtree.Bounds // of its points; meaningless without points
+	size   int
+}
+
+// cityTrees are the trees of every city, live or copied for a
+// WorldSnapshot. Searches skip the cities whose points they can't reach.
+type cityTrees []cityTree
+
+// query returns the points inside bounds from the cities whose drivers
+// bounds reaches, or from the named city alone when city isn't empty
+func (trees cityTrees) query(city string, bounds quadtree.Bounds) []quadtree.Point {
+	var results []quadtree.Point
+	for _, t := range trees {
+		if city != "" && !strings.EqualFold(t.city, city) {
+			continue
+		}
+		if t.size > 0 && overlaps(t.extent, bounds) {
+			results = append(results, t.tree.QueryResults(bounds)...)
+		}
+	}
+	return results
+}
+
+// nearest runs a nearest-neighbour search (see quadtree.Index) over the
+// cities, closest city first. A city whose drivers are all further away
+// than the n closest found so far is skipped.
+func (trees cityTrees) nearest(lon, lat float64, n int, dist quadtree.DistanceFunc, keep func(quadtree.Point) bool) []quadtree.Point {
+	if n <= 0 {
+		return nil
+	}
+
+	type reach struct {
+		tree quadtree.Index
+		dist float64 // to the closest position of the city's drivers
+	}
+	var order []reach
+	for _, t := range trees {
+		if t.size > 0 {
+			order = append(order, reach{t.tree, distanceTo(t.extent, lon, lat, dist)})
+		}
+	}
+	slices.SortStableFunc(order, func(a, b reach) int { return cmp.Compare(a.dist, b.dist) })
+
+	type found struct {
+		point quadtree.Point
+		dist  float64
+	}
+	var results []found
+	for _, r := range order {
+		if len(results) == n && results[n-1].dist <= r.dist {
+			break
+		}
+		for _, p := range r.tree.Nearest(lon, lat, n, dist, keep) {
+			results = append(results, found{p, dist(lon, lat, p.X, p.Y)})
+		}
+		slices.SortStableFunc(results, func(a, b found) int { return cmp.Compare(a.dist, b.dist) })
+		results = results[:min(len(results), n)]
+	}
+
+	points := make([]quadtree.Point, len(results))
+	for i, r := range results {
+		points[i] = r.point
+	}
+	return points
+}
+
+// distanceTo returns the distance from (x, y) to the closest position within
+// bounds; zero when it is inside
+func distanceTo(b quadtree.Bounds, x, y float64, dist quadtree.DistanceFunc) float64 {
+	cx := math.Max(b.MinX, math.Min(x, b.MaxX))
+	cy := math.Max(b.MinY, math.Min(y, b.MaxY))
+	if cx == x && cy == y {
+		return 0
+	}
+	return dist(x, y, cx, cy)
+}
@@ -0,0 +1,548 @@
+package protocol
+
+// SpeedState is the response of /api/sim/speed
+type SpeedState struct {
+	Speed       float64 `json:"speed"`        // simulation speed multiplier
+	VirtualTime int64   `json:"virtual_time"` // simulation clock, Unix milliseconds
+	ElapsedS    float64 `json:"elapsed_s"`    // simulated seconds since the start
+}
+
+// Endpoint describes an HTTP endpoint. cmd/tsgen walks Endpoints to produce
+// the OpenAPI document served at /api/openapi.json, so new endpoints must
+// be added there.
+type Endpoint struct {
+	Method   string
+	Path     string // path parameters in braces, e.g. /api/drivers/{id}
+	Summary  string
+	Params   []Param
+	Request  interface{} // zero value of the JSON body; nil for none
+	Response interface{} // zero value of the JSON response; nil for one described only by Summary
+	Status   int         // status of a successful response; 0 means 200
+	Auth     bool        // requires an API key when the server has them
+	Admin    bool        // requires the admin token instead; refused without one
+}
+
+// Param is a query or path parameter of an Endpoint
+type Param struct {
+	Name        string
+	In          string // "query" or "path"
+	Type        string // JSON schema type: "string", "number", "integer" or "boolean"
+	Required    bool
+	Description string
+}
+
+// paramFilter is the filter parameter of the driver queries
+var paramFilter = Param{Name: "filter", In: "query", Type: "string", Description: `server-side filter expression, e.g. status == "Available" && speed_kmh > 20`}
+
+// Endpoints lists the server's HTTP API
+var Endpoints = []Endpoint{
+	{
+		Method: "GET", Path: "/api/drivers", Auth: true,
+		Summary: "Drivers within a radius of a location or city",
+		Params: []Param{
+			{Name: "lat", In: "query", Type: "number", Description: "latitude of the search center"},
+			{Name: "lon", In: "query", Type: "number", Description: "longitude of the search center"},
+			{Name: "radius", In: "query", Type: "number", Description: "search radius in degrees of arc (default 0.15)"},
+			{Name: "city", In: "query", Type: "string", Description: "search around this city's center instead of lat/lon"},
+			paramFilter,
+			{Name: "sort", In: "query", Type: "string", Description: "distance (default), id or status"},
+			{Name: "limit", In: "query", Type: "integer", Description: "page size, up to 1000; all drivers when left out"},
+			{Name: "offset", In: "query", Type: "integer", Description: "drivers to skip"},
+		},
+		Response: DriversResponse{},
+	},
+	{
+		Method: "GET", Path: "/api/drivers/nearest", Auth: true,
+		Summary: "The drivers closest to a location, closest first",
+		Params: []Param{
+			{Name: "lat", In: "query", Type: "number", Required: true, Description: "latitude of the location"},
+			{Name: "lon", In: "query", Type: "number", Required: true, Description: "longitude of the location"},
+			{Name: "n", In: "query", Type: "integer", Description: "number of drivers, 1 to 100 (default 5)"},
+			{Name: "status", In: "query", Type: "string", Description: "comma-separated statuses to consider, e.g. available"},
+			paramFilter,
+		},
+		Response: NearestDriversResponse{},
+	},
+	{
+		Method: "GET", Path: "/api/drivers/{id}", Auth: true,
+		Summary:  "The full state of one driver, including its current trip",
+		Params:   []Param{{Name: "id", In: "path", Type: "integer", Required: true, Description: "driver ID"}},
+		Response: DriverDetail{},
+	},
+	{
+		Method: "POST", Path: "/api/drivers/{id}/position", Admin: true,
+		Summary: "Feed a driver's position from a device or an external simulator; the driver stops being simulated until its positions stop for 2 minutes",
+		Params:  []Param{{Name: "id", In: "path", Type: "integer", Required: true, Description: "driver ID"}},
+		Request: DriverPosition{}, Response: DriverResponse{},
+	},
+	{
+		Method: "PUT", Path: "/api/drivers/{id}/trace", Admin: true,
+		Summary: "Put a driver on the recorded track in the body, a GPX file or NMEA sentences; it drives the track instead of being simulated and isn't dispatched",
+		Params: []Param{
+			{Name: "id", In: "path", Type: "integer", Required: true, Description: "driver ID"},
+			{Name: "scale", In: "query", Type: "number", Description: "trace seconds per simulated second (default 1)"},
+			{Name: "loop", In: "query", Type: "boolean", Description: "start over at the end (default true); otherwise the driver is simulated again"},
+			{Name: "name", In: "query", Type: "string", Description: "name of the trace"},
+		},
+		Response: TraceAssignment{},
+	},
+	{
+		Method: "DELETE", Path: "/api/drivers/{id}/trace", Admin: true,
+		Summary: "Take a driver off its trace; it is simulated again from where it is",
+		Params:  []Param{{Name: "id", In: "path", Type: "integer", Required: true, Description: "driver ID"}},
+		Status:  204,
+	},
+	{
+		Method: "POST", Path: "/api/drivers/spawn", Admin: true,
+		Summary: "Add drivers around a location, up to 100000 local drivers in all",
+		Request: SpawnRequest{}, Response: SpawnResponse{}, Status: 201,
+	},
+	{
+		Method: "POST", Path: "/api/drivers/despawn", Admin: true,
+		Summary: "Remove drivers by ID",
+		Request: DespawnRequest{}, Response: DespawnResponse{},
+	},
+	{
+		Method: "DELETE", Path: "/api/drivers/despawn", Admin: true,
+		Summary:  "Remove drivers by ID",
+		Params:   []Param{{Name: "ids", In: "query", Type: "string", Required: true, Description: "comma-separated driver IDs"}},
+		Response: DespawnResponse{},
+	},
+	{
+		Method: "GET", Path: "/api/fare", Auth: true,
+		Summary: "Quote a trip at the current surge",
+		Params: []Param{
+			{Name: "from", In: "query", Type: "string", Required: true, Description: "pickup as lat,lon"},
+			{Name: "to", In: "query", Type: "string", Required: true, Description: "drop-off as lat,lon"},
+		},
+		Response: FareEstimate{},
+	},
+	{
+		Method: "POST", Path: "/api/rides", Auth: true,
+		Summary: "Request a ride: the dispatcher assigns a driver, who heads to the pickup. Without a dropoff the server picks one.",
+		Request: RequestRide{}, Response: RideAssigned{}, Status: 201,
+	},
+	{
+		Method: "GET", Path: "/api/rides/{id}", Auth: true,
+		Summary:  "Where the driver of a ride is, or how the ride ended; ended rides are kept for a while",
+		Params:   []Param{{Name: "id", In: "path", Type: "integer", Required: true, Description: "trip_id of the ride"}},
+		Response: RideProgress{},
+	},
+	{
+		Method: "GET", Path: "/api/stats", Auth: true,
+		Summary:  "Simulation statistics",
+		Response: Stats{},
+	},
+	{
+		Method: "GET", Path: "/api/stats/history", Auth: true,
+		Summary: "Statistics sampled every 5 seconds over the last hour (see -stats-history), oldest first",
+		Params: []Param{
+			{Name: "minutes", In: "query", Type: "number", Description: "only the samples of the last this many minutes"},
+			{Name: "since", In: "query", Type: "integer", Description: "only the samples taken after this Unix millisecond time"},
+		},
+		Response: StatsHistory{},
+	},
+	{
+		Method: "GET", Path: "/api/events", Auth: true,
+		Summary: "Events of the audit log (see -audit), oldest first",
+		Params: []Param{
+			{Name: "since", In: "query", Type: "integer", Description: "only the events after this Unix millisecond time"},
+			{Name: "type", In: "query", Type: "string", Description: "only the events of these comma-separated types"},
+			{Name: "limit", In: "query", Type: "integer", Description: "at most this many events (default 1000, at most 10000)"},
+		},
+		Response: AuditEvents{},
+	},
+	{
+		Method: "GET", Path: "/api/trips", Auth: true,
+		Summary: "Trips of the trip store (see -store) assigned between from and to, in the order they were assigned",
+		Params: []Param{
+			{Name: "from", In: "query", Type: "integer", Description: "only the trips assigned at or after this virtual Unix millisecond time"},
+			{Name: "to", In: "query", Type: "integer", Description: "only the trips assigned before this virtual Unix millisecond time"},
+			{Name: "driver", In: "query", Type: "integer", Description: "only the trips of this driver"},
+			{Name: "limit", In: "query", Type: "integer", Description: "at most this many trips (default 1000, at most 10000)"},
+		},
+		Response: TripRecords{},
+	},
+	{
+		Method: "GET", Path: "/api/heatmap/demand", Auth: true,
+		Summary:  "Ride requests of the last 10 minutes on a 0.01° grid, busiest cells first",
+		Response: DemandHeatmap{},
+	},
+	{
+		Method: "GET", Path: "/api/cells", Auth: true,
+		Summary: "Drivers counted by status per S2 cell, fullest cells first",
+		Params: []Param{
+			{Name: "level", In: "query", Type: "integer", Description: "S2 cell level from 1 to 20 (default 12, about 2km)"},
+			{Name: "south", In: "query", Type: "number", Description: "south edge of a viewport to count in, given with the other three edges; every cell when left out"},
+			{Name: "west", In: "query", Type: "number", Description: "west edge of the viewport"},
+			{Name: "north", In: "query", Type: "number", Description: "north edge of the viewport"},
+			{Name: "east", In: "query", Type: "number", Description: "east edge of the viewport"},
+		},
+		Response: DriverCells{},
+	},
+	{
+		Method: "GET", Path: "/api/geofences", Auth: true,
+		Summary: "The configured geofence zones: polygons with a name and a kind (no_go, boundary or watch)",
+	},
+	{
+		Method: "GET", Path: "/api/webhooks", Admin: true,
+		Summary:  "The registered webhooks and how their deliveries went",
+		Response: Webhooks{},
+	},
+	{
+		Method: "POST", Path: "/api/webhooks", Admin: true,
+		Summary: "Register a webhook to be POSTed zone_entered, zone_exited, trip_completed and driver_offline events, retried with backoff",
+		Request: WebhookRegistration{}, Response: Webhook{}, Status: 201,
+	},
+	{
+		Method: "DELETE", Path: "/api/webhooks/{id}", Admin: true,
+		Summary: "Remove a webhook; events still waiting for it are dropped",
+		Params:  []Param{{Name: "id", In: "path", Type: "integer", Required: true, Description: "webhook ID"}},
+		Status:  204,
+	},
+	{
+		Method: "GET", Path: "/api/admin/shocks", Auth: true,
+		Summary: "Active and pending demand shocks",
+	},
+	{
+		Method: "POST", Path: "/api/admin/shocks", Admin: true,
+		Summary: "Trigger a demand shock; the body is a shock as in scenario files",
+		Status:  201,
+	},
+	{
+		Method: "DELETE", Path: "/api/admin/shocks", Admin: true,
+		Summary: "Cancel a demand shock",
+		Params:  []Param{{Name: "id", In: "query", Type: "integer", Required: true, Description: "shock ID"}},
+	},
+	{
+		Method: "GET", Path: "/api/admin/config", Auth: true,
+		Summary:  "The simulation parameters that can be changed while it runs",
+		Response: RuntimeConfig{},
+	},
+	{
+		Method: "PATCH", Path: "/api/admin/config", Admin: true,
+		Summary: "Change simulation parameters; fields left out keep their value",
+		Request: ConfigPatch{}, Response: RuntimeConfig{},
+	},
+	{
+		Method: "GET", Path: "/api/admin/clients", Auth: true,
+		Summary:  "Connected WebSocket and SSE clients with their settings and traffic, the most backed up first",
+		Response: ClientsResponse{},
+	},
+	{
+		Method: "GET", Path: "/api/federation", Auth: true,
+		Summary: "Federation peers and the state of their feeds",
+	},
+	{
+		Method: "GET", Path: "/api/shards", Auth: true,
+		Summary: "The shards and the state of their feeds to the gateway: all of them on a gateway, its own on a shard worker",
+	},
+	{
+		Method: "GET", Path: "/api/shards/feed", Auth: true,
+		Summary: "WebSocket a shard worker feeds the gateway its drivers on, as binary taxi.v1.DriversUpdate frames, and its events, as JSON text frames",
+		Params:  []Param{{Name: "shard", In: "query", Type: "string", Required: true, Description: "name of the shard, one of the gateway's -shards"}},
+	},
+	{
+		Method: "GET", Path: "/api/sim/speed", Auth: true,
+		Summary:  "The simulation speed and virtual time",
+		Response: SpeedState{},
+	},
+	{
+		Method: "POST", Path: "/api/sim/speed", Admin: true,
+		Summary:  "Change the simulation speed multiplier",
+		Params:   []Param{{Name: "x", In: "query", Type: "number", Description: "new speed; or send {\"speed\": x} as the body"}},
+		Response: SpeedState{},
+	},
+	{
+		Method: "POST", Path: "/api/sim/pause", Admin: true,
+		Summary:  "Freeze the main loop and the virtual clock",
+		Response: SimState{},
+	},
+	{
+		Method: "POST", Path: "/api/sim/resume", Admin: true,
+		Summary:  "Continue a paused simulation",
+		Response: SimState{},
+	},
+	{
+		Method: "POST", Path: "/api/sim/step", Admin: true,
+		Summary:  "Advance a paused simulation",
+		Params:   []Param{{Name: "ticks", In: "query", Type: "integer", Description: "updates to advance by (default 1)"}},
+		Response: SimState{},
+	},
+	{
+		Method: "GET", Path: "/api/schema",
+		Summary: "JSON schema of every WebSocket and HTTP message",
+	},
+	{
+		Method: "GET", Path: "/api/openapi.json",
+		Summary: "This document",
+	},
+	{
+		Method: "GET", Path: "/api/stream", Auth: true,
+		Summary: "The WebSocket feed's driver updates and events as Server-Sent Events (text/event-stream), in the v2 protocol. " +
+			"Each event's data is one JSON message as described by /api/schema.",
+		Params: []Param{
+			{Name: "lat", In: "query", Type: "number", Description: "latitude of the area center"},
+			{Name: "lon", In: "query", Type: "number", Description: "longitude of the area center"},
+			{Name: "radius", In: "query", Type: "number", Description: "area radius in degrees of arc (default 0.15)"},
+			{Name: "city", In: "query", Type: "string", Description: "watch this city's center instead of lat/lon"},
+			{Name: "interval_ms", In: "query", Type: "number", Description: "driver update interval, 100 to 10000 ms"},
+			{Name: "deltas", In: "query", Type: "boolean", Description: "send a snapshot, then only changes"},
+			{Name: "status", In: "query", Type: "string", Description: "comma-separated statuses to send, e.g. available"},
+			paramFilter,
+		},
+	},
+	{
+		Method: "GET", Path: "/ws", Auth: true,
+		Summary: "Upgrade to the WebSocket feed; its messages are described by /api/schema. " +
+			"Request the taxi.v3 (or taxi.v3.msgpack, taxi.v2, taxi.v2.msgpack) subprotocol to select the protocol version.",
+		Status: 101,
+	},
+	{
+		Method: "GET", Path: "/socket.io/", Auth: true,
+		Summary: "The WebSocket feed for socket.io-client (Engine.IO 4, over long polling or WebSockets), in the v2 protocol. " +
+			"Each message is an event named after its type; clients emit their messages the same way.",
+		Params: []Param{
+			{Name: "EIO", In: "query", Type: "string", Description: "Engine.IO protocol version, 4"},
+			{Name: "transport", In: "query", Type: "string", Description: "polling or websocket"},
+			{Name: "sid", In: "query", Type: "string", Description: "session ID, once the session is open"},
+		},
+	},
+}
+
+// RuntimeConfig is the response of /api/admin/config: the simulation
+// parameters that can be changed while it runs
+type RuntimeConfig struct {
+	BroadcastIntervalMs int64   `json:"broadcast_interval_ms"` // driver updates of clients that didn't pick an interval
+	Drivers             int     `json:"drivers"`               // locally simulated drivers
+	MinSpeedKmh         float64 `json:"min_speed_kmh"`
+	MaxSpeedKmh         float64 `json:"max_speed_kmh"`
+	StatusChangeChance  float64 `json:"status_change_chance"` // per update, for drivers not on a trip
+	AvailableShare      float64 `json:"available_share"`      // chance a new or changed status is Available
+	BusyShare           float64 `json:"busy_share"`           // chance it is Busy; otherwise it is Offline
+	SurgeSlope          float64 `json:"surge_slope"`          // surge added per recent request per available driver above one
+	MaxSurge            float64 `json:"max_surge"`
+}
+
+// ConfigPatch is the body of PATCH /api/admin/config. Fields left out keep
+// their value.
+type ConfigPatch struct {
+	BroadcastIntervalMs *int64   `json:"broadcast_interval_ms,omitempty"`
+	Drivers             *int     `json:"drivers,omitempty"` // drivers are added or removed to reach it
+	MinSpeedKmh         *float64 `json:"min_speed_kmh,omitempty"`
+	MaxSpeedKmh         *float64 `json:"max_speed_kmh,omitempty"`
+	StatusChangeChance  *float64 `json:"status_change_chance,omitempty"`
+	AvailableShare      *float64 `json:"available_share,omitempty"`
+	BusyShare           *float64 `json:"busy_share,omitempty"`
+	SurgeSlope          *float64 `json:"surge_slope,omitempty"`
+	MaxSurge            *float64 `json:"max_surge,omitempty"`
+}
+
+// ClientsResponse is the response of /api/admin/clients
+type ClientsResponse struct {
+	Clients []ClientInfo `json:"clients"`
+	Count   int          `json:"count"`
+}
+
+// ClientInfo describes a connected client: its connection, what it asked
+// for and what it was sent
+type ClientInfo struct {
+	ID          string  `json:"id"`
+	Identity    string  `json:"identity,omitempty"` // name of the API key it connected with
+	Transport   string  `json:"transport"`          // "websocket", "sse" or "socketio"
+	Remote      string  `json:"remote"`             // IP address it connected from
+	ConnectedAt int64   `json:"connected_at"`       // Unix milliseconds
+	Version     int     `json:"version"`            // negotiated protocol version
+	Encoding    string  `json:"encoding"`           // of driver updates: json, msgpack or protobuf
+	IntervalMs  int64   `json:"interval_ms"`        // between driver updates
+	Deltas      bool    `json:"deltas"`
+	Lat         float64 `json:"lat,omitempty"` // client_params area
+	Lon         float64 `json:"lon,omitempty"`
+	Radius      float64 `json:"radius,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Filter      bool    `json:"filter,omitempty"` // a server-side filter is set
+	// Subscriptions replace the client_params area once there is one
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	Follows       []int    `json:"follows,omitempty"` // IDs of the drivers it follows
+	Topics        int      `json:"topics"`            // hub topics it receives
+	// Traffic: data frames written and their bytes before compression,
+	// frames waiting in the send queue (of QueueSize), frames dropped from
+	// it and driver updates replaced before they were written
+	MessagesSent int64 `json:"messages_sent"`
+	BytesSent    int64 `json:"bytes_sent"`
+	QueueDepth   int   `json:"queue_depth"`
+	QueueSize    int   `json:"queue_size"`
+	Dropped      int64 `json:"dropped"`
+	StaleUpdates int64 `json:"stale_updates"`
+	// How long its last and its slowest write took, and how long its
+	// queue has been full (zero when it has room)
+	LastWriteMs float64 `json:"last_write_ms"`
+	MaxWriteMs  float64 `json:"max_write_ms"`
+	SaturatedMs int64   `json:"saturated_ms,omitempty"`
+}
+
+// Audit event types
+const (
+	AuditClientConnected    = "client_connected"
+	AuditClientDisconnected = "client_disconnected"
+	AuditAdmin              = "admin"
+)
+
+// AuditEvent is a line of the audit log. Driver status changes and the
+// events of rides are audited with the types and data of their
+// driver_status_changed, trip_event and offer_event messages.
+type AuditEvent struct {
+	Seq         int64       `json:"seq"`          // counts the events of a server run
+	Time        int64       `json:"time"`         // Unix milliseconds
+	VirtualTime int64       `json:"virtual_time"` // simulation clock, Unix milliseconds
+	Type        string      `json:"type"`
+	Data        interface{} `json:"data"` // ClientEvent, AdminEvent or the message audited
+}
+
+// ClientEvent is the data of client_connected and client_disconnected
+// audit events
+type ClientEvent struct {
+	ID        string `json:"id"`
+	Identity  string `json:"identity,omitempty"` // name of the API key it connected with
+	Transport string `json:"transport"`          // "websocket", "sse" or "socketio"
+	Remote    string `json:"remote"`             // IP address it connected from
+	// Once it disconnected: how long it was connected and what it was sent
+	ConnectedMs  int64 `json:"connected_ms,omitempty"`
+	MessagesSent int64 `json:"messages_sent,omitempty"`
+	BytesSent    int64 `json:"bytes_sent,omitempty"`
+}
+
+// AdminEvent is the data of an admin audit event: a change made through
+// the admin or simulation control API
+type AdminEvent struct {
+	Action string      `json:"action"` // config, spawn, despawn, shock, cancel_shock, speed, pause, resume or step
+	Params interface{} `json:"params,omitempty"`
+}
+
+// AuditEvents is the response of /api/events
+type AuditEvents struct {
+	Events []AuditEvent `json:"events"`
+	More   bool         `json:"more"` // the limit left later events out
+}
+
+// Webhook event types
+const (
+	WebhookZoneEntered   = "zone_entered"   // a driver entered a watch geofence zone
+	WebhookZoneExited    = "zone_exited"    // a driver left a watch geofence zone
+	WebhookTripCompleted = "trip_completed" // a trip ended at its drop-off
+	WebhookDriverOffline = "driver_offline" // a driver went offline
+)
+
+// WebhookRegistration registers a URL to be POSTed events
+type WebhookRegistration struct {
+	URL string `json:"url"` // http(s)
+	// Event types to deliver; every type when empty
+	Events []string `json:"events,omitempty"`
+	// Signs every delivery: X-Webhook-Signature is "sha256=" and the hex
+	// HMAC-SHA256 of the body with it as the key
+	Secret string `json:"secret,omitempty"`
+}
+
+// Webhook is a registered webhook and how its deliveries went. Its secret
+// is never returned.
+type Webhook struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"` // every type when empty
+	Signed    bool     `json:"signed"` // it has a secret
+	Delivered int64    `json:"delivered"`
+	// Events given up on after the last retry or a 4xx response, and ones
+	// dropped because too many were waiting
+	Failed    int64  `json:"failed"`
+	Dropped   int64  `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Webhooks is the response of GET /api/webhooks
+type Webhooks struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookEvent is the body POSTed to a webhook
+type WebhookEvent struct {
+	ID   int64       `json:"id"` // counts the events of a server run; retries repeat it
+	Type string      `json:"type"`
+	Time int64       `json:"time"` // virtual time in milliseconds
+	Data interface{} `json:"data"` // ZoneEvent, or the TripEvent or DriverStatusChanged message
+}
+
+// CloudEventsSpecVersion is the CloudEvents version of CloudEvent
+const CloudEventsSpecVersion = "1.0"
+
+// CloudEventsContentType is the media type of a CloudEvent in structured
+// mode, where the envelope and data travel together as the body
+const CloudEventsContentType = "application/cloudevents+json"
+
+// CloudEvent types, which replace the webhook event types and trip event
+// names when events are sent as CloudEvents (see -cloudevents). Trip
+// events are "taxi.trip." and their event, e.g. taxi.trip.picked_up.
+const (
+	CloudEventZoneEntered   = "taxi.zone.entered"
+	CloudEventZoneExited    = "taxi.zone.exited"
+	CloudEventTripPrefix    = "taxi.trip."
+	CloudEventDriverOffline = "taxi.driver.offline"
+)
+
+// CloudEvent is a webhook, Kafka or NATS event in the CloudEvents 1.0 JSON
+// envelope
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// Unique per source: the trip and its event for trip events, the
+	// webhook event ID otherwise, after the server run
+	ID      string `json:"id"`
+	Source  string `json:"source"` // URI reference of the server, see -cloudevents-source
+	Type    string `json:"type"`
+	Subject string `json:"subject,omitempty"` // "trips/{trip_id}" or "drivers/{driver_id}"
+	Time    string `json:"time"`              // virtual time, RFC 3339
+	// Always application/json
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"` // as in WebhookEvent
+}
+
+// TraceAssignment is the response of PUT /api/drivers/{id}/trace
+type TraceAssignment struct {
+	DriverID   int     `json:"driver_id"`
+	Name       string  `json:"name"`
+	Points     int     `json:"points"`
+	DurationS  float64 `json:"duration_s"` // at the trace's own pace
+	DistanceKm float64 `json:"distance_km"`
+	Scale      float64 `json:"scale"` // trace seconds per simulated second
+	Loop       bool    `json:"loop"`  // starts over at the end; otherwise the driver is simulated again
+}
+
+// ZoneEvent is the data of zone_entered and zone_exited webhook events
+type ZoneEvent struct {
+	DriverID int     `json:"driver_id"`
+	Zone     string  `json:"zone"` // name of the geofence zone
+	Lon      float64 `json:"lon"`
+	Lat      float64 `json:"lat"`
+}
+
+// TripRecord is a trip as the trip store (see -store) keeps it, brought up
+// to date by each of its trip events
+type TripRecord struct {
+	RunID         int64    `json:"run_id"` // the server run that dispatched it
+	TripID        int      `json:"trip_id"`
+	DriverID      int      `json:"driver_id"`
+	State         string   `json:"state"`            // its last event: "assigned", "picked_up", "completed" or "cancelled"
+	Reason        string   `json:"reason,omitempty"` // why it was cancelled
+	Pickup        Location `json:"pickup"`
+	Dropoff       Location `json:"dropoff"`
+	EstimatedFare Fare     `json:"estimated_fare"`
+	FinalFare     *Fare    `json:"final_fare,omitempty"` // completed trips only
+	// Virtual times in milliseconds; the later ones once they happened
+	AssignedAt int64 `json:"assigned_at"`
+	PickedUpAt int64 `json:"picked_up_at,omitempty"`
+	EndedAt    int64 `json:"ended_at,omitempty"`
+}
+
+// TripRecords is the response of /api/trips
+type TripRecords struct {
+	Trips []TripRecord `json:"trips"`
+	More  bool         `json:"more"` // the limit left later trips out
+}
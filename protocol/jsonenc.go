@@ -0,0 +1,215 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Driver updates are encoded for every client on every broadcast, which
+// made reflection in json.Marshal the top of CPU profiles. AppendJSON and
+// AppendEnvelopedJSON write the same bytes without it.
+
+// AppendJSON appends the JSON encoding of the update to dst, exactly as
+// json.Marshal encodes it
+func (u *DriversUpdate) AppendJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"type":`...)
+	dst = appendJSONString(dst, u.Type)
+	dst = append(dst, `,"drivers":`...)
+	if u.Drivers == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '[')
+		for i := range u.Drivers {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var err error
+			if dst, err = u.Drivers[i].AppendJSON(dst); err != nil {
+				return dst, err
+			}
+		}
+		dst = append(dst, ']')
+	}
+	dst = append(dst, `,"count":`...)
+	dst = strconv.AppendInt(dst, int64(u.Count), 10)
+	dst = append(dst, `,"center":{"lat":`...)
+	dst = appendJSONFloat(dst, u.Center.Lat)
+	dst = append(dst, `,"lon":`...)
+	dst = appendJSONFloat(dst, u.Center.Lon)
+	dst = append(dst, `},"radius":`...)
+	dst = appendJSONFloat(dst, u.Radius)
+	dst = append(dst, `,"time":`...)
+	dst = strconv.AppendInt(dst, u.Time, 10)
+	dst = append(dst, `,"data_age_ms":`...)
+	dst = strconv.AppendInt(dst, u.DataAgeMs, 10)
+	if u.Seq != 0 {
+		dst = append(dst, `,"seq":`...)
+		dst = strconv.AppendInt(dst, u.Seq, 10)
+	}
+	dst = append(dst, '}')
+	return dst, checkFloats(u.Center.Lat, u.Center.Lon, u.Radius)
+}
+
+// AppendEnvelopedJSON appends the JSON encoding of the update in an
+// envelope, exactly as json.Marshal encodes Wrap(u)
+func (u *DriversUpdate) AppendEnvelopedJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"version":`...)
+	dst = strconv.AppendInt(dst, Version, 10)
+	dst = append(dst, `,"type":`...)
+	dst = appendJSONString(dst, u.Type)
+	dst = append(dst, `,"payload":`...)
+	dst, err := u.AppendJSON(dst)
+	return append(dst, '}'), err
+}
+
+// AppendJSON appends the JSON encoding of the driver to dst, exactly as
+// json.Marshal encodes it
+func (d *DriverResponse) AppendJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"id":`...)
+	dst = strconv.AppendInt(dst, int64(d.ID), 10)
+	dst = append(dst, `,"lon":`...)
+	dst = appendJSONFloat(dst, d.Lon)
+	dst = append(dst, `,"lat":`...)
+	dst = appendJSONFloat(dst, d.Lat)
+	dst = append(dst, `,"status":`...)
+	dst = appendJSONString(dst, d.Status)
+	if d.Distance != 0 {
+		dst = append(dst, `,"distance":`...)
+		dst = appendJSONFloat(dst, d.Distance)
+	}
+	dst = append(dst, `,"heading":`...)
+	dst = appendJSONFloat(dst, d.Heading)
+	dst = append(dst, `,"speed":`...)
+	dst = appendJSONFloat(dst, d.Speed)
+	if d.Profile != "" {
+		dst = append(dst, `,"profile":`...)
+		dst = appendJSONString(dst, d.Profile)
+	}
+	if d.Vehicle != "" {
+		dst = append(dst, `,"vehicle":`...)
+		dst = appendJSONString(dst, d.Vehicle)
+	}
+	if d.Origin != "" {
+		dst = append(dst, `,"origin":`...)
+		dst = appendJSONString(dst, d.Origin)
+	}
+	if d.RemoteID != 0 {
+		dst = append(dst, `,"remote_id":`...)
+		dst = strconv.AppendInt(dst, int64(d.RemoteID), 10)
+	}
+	if d.RawLon != 0 {
+		dst = append(dst, `,"raw_lon":`...)
+		dst = appendJSONFloat(dst, d.RawLon)
+	}
+	if d.RawLat != 0 {
+		dst = append(dst, `,"raw_lat":`...)
+		dst = appendJSONFloat(dst, d.RawLat)
+	}
+	if d.VelLon != 0 {
+		dst = append(dst, `,"vel_lon":`...)
+		dst = appendJSONFloat(dst, d.VelLon)
+	}
+	if d.VelLat != 0 {
+		dst = append(dst, `,"vel_lat":`...)
+		dst = appendJSONFloat(dst, d.VelLat)
+	}
+	if d.UpdatedAt != 0 {
+		dst = append(dst, `,"updated_at":`...)
+		dst = strconv.AppendInt(dst, d.UpdatedAt, 10)
+	}
+	dst = append(dst, '}')
+	return dst, checkFloats(d.Lon, d.Lat, d.Distance, d.Heading, d.Speed, d.RawLon, d.RawLat, d.VelLon, d.VelLat)
+}
+
+// errUnsupportedFloat is what json.Marshal fails with on NaN and infinities
+var errUnsupportedFloat = errors.New("json: unsupported value: NaN or infinite float")
+
+// checkFloats fails, like json.Marshal, when any of the values has no JSON
+// encoding
+func checkFloats(values ...float64) error {
+	for _, f := range values {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return errUnsupportedFloat
+		}
+	}
+	return nil
+}
+
+// appendJSONFloat formats a float the way encoding/json does: the shortest
+// representation, in exponent form only for very large and small values
+func appendJSONFloat(dst []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes a string the way encoding/json does, including
+// its escaping of <, > and & for embedding in HTML, invalid UTF-8 and the
+// line and paragraph separators
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalMsgpack(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"false", false, []byte{0xc2}},
+		{"true", true, []byte{0xc3}},
+		{"positive fixint", 7, []byte{0x07}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"uint 8", 200, []byte{0xcc, 0xc8}},
+		{"uint 16", 1000, []byte{0xcd, 0x03, 0xe8}},
+		{"uint 32", 70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{"uint 64", uint64(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"int 8", -100, []byte{0xd0, 0x9c}},
+		{"int 16", -1000, []byte{0xd1, 0xfc, 0x18}},
+		{"int 32", -70000, []byte{0xd2, 0xff, 0xfe, 0xee, 0x90}},
+		{"int 64", int64(math.MinInt64), []byte{0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{"float 32", float32(1.5), []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float 64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", "hi", []byte{0xa2, 'h', 'i'}},
+		{"str 8", strings.Repeat("a", 32), append([]byte{0xd9, 32}, strings.Repeat("a", 32)...)},
+		{"str 16", strings.Repeat("a", 256), append([]byte{0xda, 0x01, 0x00}, strings.Repeat("a", 256)...)},
+		{"fixarray", []int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{"array 16", make([]bool, 16), append([]byte{0xdc, 0x00, 0x10}, bytes.Repeat([]byte{0xc2}, 16)...)},
+		{"nil slice", []int(nil), []byte{0xc0}},
+		{"map", map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 0x01}},
+		{"nil map", map[string]int(nil), []byte{0xc0}},
+		{"pointer", &[]int{3}, []byte{0x91, 0x03}},
+		{
+			"struct with JSON names and omitempty",
+			struct {
+				A int    `json:"a"`
+				B string `json:"b,omitempty"`
+				C bool   `json:"-"`
+				D int
+				e int
+			}{A: 1, C: true, D: 2, e: 3},
+			[]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'D', 0x02},
+		},
+	}
+	for _, tt := range tests {
+		got, err := MarshalMsgpack(tt.v)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := MarshalMsgpack(map[int]int{1: 1}); err == nil {
+		t.Error("map with int keys: no error")
+	}
+	if _, err := MarshalMsgpack(make(chan int)); err == nil {
+		t.Error("channel: no error")
+	}
+}
+
+func TestMsgpackDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    interface{}
+		wantErr bool
+	}{
+		{"nil", []byte{0xc0}, nil, false},
+		{"bool", []byte{0xc3}, true, false},
+		{"positive fixint", []byte{0x07}, int64(7), false},
+		{"negative fixint", []byte{0xff}, int64(-1), false},
+		{"uint 16", []byte{0xcd, 0x03, 0xe8}, uint64(1000), false},
+		{"int 8", []byte{0xd0, 0x9c}, int64(-100), false},
+		{"int 32", []byte{0xd2, 0xff, 0xfe, 0xee, 0x90}, int64(-70000), false},
+		{"float 32", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, 1.5, false},
+		{"float 64", []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5, false},
+		{"fixstr", []byte{0xa2, 'h', 'i'}, "hi", false},
+		{"str 8", []byte{0xd9, 0x02, 'h', 'i'}, "hi", false},
+		{"bin 8", []byte{0xc4, 0x02, 0x01, 0x02}, []byte{0x01, 0x02}, false},
+		{"fixarray", []byte{0x92, 0x01, 0xa1, 'x'}, []interface{}{int64(1), "x"}, false},
+		{"array 16", []byte{0xdc, 0x00, 0x01, 0xc0}, []interface{}{nil}, false},
+		{"fixmap", []byte{0x81, 0xa1, 'a', 0x91, 0x01}, map[string]interface{}{"a": []interface{}{int64(1)}}, false},
+		{"map 16", []byte{0xde, 0x00, 0x01, 0xa1, 'a', 0xc2}, map[string]interface{}{"a": false}, false},
+		{"empty", nil, nil, true},
+		{"short string", []byte{0xa3, 'h', 'i'}, nil, true},
+		{"short float", []byte{0xcb, 0x3f}, nil, true},
+		{"array longer than the data", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"map with an int key", []byte{0x81, 0x01, 0x01}, nil, true},
+		{"unsupported format", []byte{0xc1}, nil, true},
+		{"ext", []byte{0xd4, 0x01, 0x01}, nil, true},
+	}
+	for _, tt := range tests {
+		d := msgpackDecoder{data: tt.data}
+		got, err := d.decode()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestDecodeMsgpack checks that a message decodes from MessagePack to what
+// it decodes to from JSON
+func TestDecodeMsgpack(t *testing.T) {
+	tests := []interface{}{
+		&DriversUpdate{
+			Type: TypeDriversUpdate,
+			Drivers: []DriverResponse{
+				{ID: 7, Lon: 44.0092, Lat: 36.1911, Status: "Available", Heading: 90, Speed: 0.0001, Vehicle: "van"},
+				{ID: 300, Lon: -0.5, Lat: 51.5, Status: "Busy", RemoteID: 70000, UpdatedAt: 1792168919517},
+			},
+			Count:  2,
+			Center: Location{Lat: 36.19, Lon: 44.01},
+			Radius: 0.15,
+			Time:   1792168919517,
+		},
+		&DriverStatusChanged{Type: TypeStatusChanged, DriverID: 42, OldStatus: "Available", NewStatus: "Busy", Time: -1},
+		&ErrorMessage{Type: TypeError, Error: strings.Repeat("long error ", 40)},
+	}
+	for _, msg := range tests {
+		data, err := MarshalMsgpack(msg)
+		if err != nil {
+			t.Fatalf("%T: %v", msg, err)
+		}
+		got, err := DecodeMsgpack(data)
+		if err != nil {
+			t.Fatalf("%T: %v", msg, err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%T: got %+v, want %+v", msg, got, msg)
+		}
+
+		jsonData, _ := json.Marshal(msg)
+		fromJSON, err := Decode(jsonData)
+		if err != nil {
+			t.Fatalf("%T: decoding JSON: %v", msg, err)
+		}
+		if !reflect.DeepEqual(got, fromJSON) {
+			t.Errorf("%T: got %+v from MessagePack, %+v from JSON", msg, got, fromJSON)
+		}
+	}
+
+	if _, err := DecodeMsgpack([]byte{0xc0, 0xc0}); err == nil {
+		t.Error("trailing data: no error")
+	}
+}
@@ -0,0 +1,614 @@
+package protocol
+
+//go:generate protoc --go_out=taxipb --go_opt=paths=source_relative --go-grpc_out=taxipb --go-grpc_opt=paths=source_relative taxi.proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"quadtree/protocol/taxipb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// The gRPC service and the protobuf WebSocket frames carry the messages of
+// taxi.proto, whose Go types protoc generates into taxipb. The types of
+// this package stay the ones the server works with; the functions below
+// convert between the two.
+
+// ToProto converts the location to a taxi.v1.Location
+func (l *Location) ToProto() *taxipb.Location {
+	return &taxipb.Location{Lat: l.Lat, Lon: l.Lon}
+}
+
+// locationFromProto converts a taxi.v1.Location; a missing one is (0, 0)
+func locationFromProto(p *taxipb.Location) Location {
+	return Location{Lat: p.GetLat(), Lon: p.GetLon()}
+}
+
+// ToProto converts the region to a taxi.v1.Region
+func (r *Region) ToProto() *taxipb.Region {
+	return &taxipb.Region{Lat: r.Lat, Lon: r.Lon, Radius: r.Radius}
+}
+
+// RegionFromProto converts a taxi.v1.Region
+func RegionFromProto(p *taxipb.Region) Region {
+	return Region{Lat: p.GetLat(), Lon: p.GetLon(), Radius: p.GetRadius()}
+}
+
+// viewportFromProto converts a taxi.v1.Viewport
+func viewportFromProto(p *taxipb.Viewport) Viewport {
+	return Viewport{South: p.GetSouth(), West: p.GetWest(), North: p.GetNorth(), East: p.GetEast()}
+}
+
+// ToProto converts the driver to a taxi.v1.Driver
+func (d *DriverResponse) ToProto() *taxipb.Driver {
+	return &taxipb.Driver{
+		Id:        int64(d.ID),
+		Lon:       d.Lon,
+		Lat:       d.Lat,
+		Status:    d.Status,
+		Distance:  d.Distance,
+		Heading:   d.Heading,
+		Speed:     d.Speed,
+		Profile:   d.Profile,
+		Vehicle:   d.Vehicle,
+		Origin:    d.Origin,
+		RemoteId:  int64(d.RemoteID),
+		RawLon:    d.RawLon,
+		RawLat:    d.RawLat,
+		VelLon:    d.VelLon,
+		VelLat:    d.VelLat,
+		UpdatedAt: d.UpdatedAt,
+	}
+}
+
+// driverFromProto converts a taxi.v1.Driver
+func driverFromProto(p *taxipb.Driver) DriverResponse {
+	return DriverResponse{
+		ID:        int(p.GetId()),
+		Lon:       p.GetLon(),
+		Lat:       p.GetLat(),
+		Status:    p.GetStatus(),
+		Distance:  p.GetDistance(),
+		Heading:   p.GetHeading(),
+		Speed:     p.GetSpeed(),
+		Profile:   p.GetProfile(),
+		Vehicle:   p.GetVehicle(),
+		Origin:    p.GetOrigin(),
+		RemoteID:  int(p.GetRemoteId()),
+		RawLon:    p.GetRawLon(),
+		RawLat:    p.GetRawLat(),
+		VelLon:    p.GetVelLon(),
+		VelLat:    p.GetVelLat(),
+		UpdatedAt: p.GetUpdatedAt(),
+	}
+}
+
+func driversToProto(drivers []DriverResponse) []*taxipb.Driver {
+	out := make([]*taxipb.Driver, len(drivers))
+	for i := range drivers {
+		out[i] = drivers[i].ToProto()
+	}
+	return out
+}
+
+// driversFromProto converts repeated taxi.v1.Drivers, never to nil, so the
+// drivers stay a JSON array
+func driversFromProto(drivers []*taxipb.Driver) []DriverResponse {
+	out := make([]DriverResponse, len(drivers))
+	for i, d := range drivers {
+		out[i] = driverFromProto(d)
+	}
+	return out
+}
+
+// ToProto converts the update to a taxi.v1.DriversUpdate
+func (u *DriversUpdate) ToProto() *taxipb.DriversUpdate {
+	return &taxipb.DriversUpdate{
+		Drivers:   driversToProto(u.Drivers),
+		Center:    u.Center.ToProto(),
+		Radius:    u.Radius,
+		Time:      u.Time,
+		DataAgeMs: u.DataAgeMs,
+		Seq:       u.Seq,
+	}
+}
+
+// DriversUpdateFromProto converts a taxi.v1.DriversUpdate
+func DriversUpdateFromProto(p *taxipb.DriversUpdate) DriversUpdate {
+	drivers := driversFromProto(p.GetDrivers())
+	return DriversUpdate{
+		Type:      TypeDriversUpdate,
+		Drivers:   drivers,
+		Count:     len(drivers),
+		Center:    locationFromProto(p.GetCenter()),
+		Radius:    p.GetRadius(),
+		Time:      p.GetTime(),
+		DataAgeMs: p.GetDataAgeMs(),
+		Seq:       p.GetSeq(),
+	}
+}
+
+// MarshalProto encodes the update as a taxi.v1.DriversUpdate
+func (u *DriversUpdate) MarshalProto() ([]byte, error) {
+	return proto.Marshal(u.ToProto())
+}
+
+// UnmarshalProto decodes a taxi.v1.DriversUpdate
+func (u *DriversUpdate) UnmarshalProto(data []byte) error {
+	var p taxipb.DriversUpdate
+	if err := proto.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*u = DriversUpdateFromProto(&p)
+	return nil
+}
+
+// ToProto converts the response to a taxi.v1.DriversResponse
+func (r *DriversResponse) ToProto() *taxipb.DriversResponse {
+	return &taxipb.DriversResponse{
+		Drivers:    driversToProto(r.Drivers),
+		Total:      int32(r.Total),
+		NextOffset: int32(r.NextOffset),
+		Center:     r.Center.ToProto(),
+		Radius:     r.Radius,
+		DataAgeMs:  r.DataAgeMs,
+	}
+}
+
+// RequestRideFromProto converts a taxi.v1.RideRequest. A request without
+// a pickup is an error rather than a ride from (0, 0).
+func RequestRideFromProto(p *taxipb.RideRequest) (RequestRide, error) {
+	if p.GetPickup() == nil {
+		return RequestRide{}, errors.New("pickup is required")
+	}
+	m := RequestRide{Type: TypeRequestRide, Pickup: locationFromProto(p.GetPickup())}
+	if p.GetDropoff() != nil {
+		dropoff := locationFromProto(p.GetDropoff())
+		m.Dropoff = &dropoff
+	}
+	return m, nil
+}
+
+// ToProto converts the fare to a taxi.v1.Fare
+func (f *Fare) ToProto() *taxipb.Fare {
+	return &taxipb.Fare{
+		Base:        f.Base,
+		Distance:    f.Distance,
+		Time:        f.Time,
+		Surge:       f.Surge,
+		Total:       f.Total,
+		Currency:    f.Currency,
+		DistanceKm:  f.DistanceKm,
+		DurationMin: f.DurationMin,
+	}
+}
+
+// fareFromProto converts a taxi.v1.Fare; a missing one is nil
+func fareFromProto(p *taxipb.Fare) *Fare {
+	if p == nil {
+		return nil
+	}
+	return &Fare{
+		Base:        p.GetBase(),
+		Distance:    p.GetDistance(),
+		Time:        p.GetTime(),
+		Surge:       p.GetSurge(),
+		Total:       p.GetTotal(),
+		Currency:    p.GetCurrency(),
+		DistanceKm:  p.GetDistanceKm(),
+		DurationMin: p.GetDurationMin(),
+	}
+}
+
+// fareValue converts a taxi.v1.Fare that is always sent
+func fareValue(p *taxipb.Fare) Fare {
+	if f := fareFromProto(p); f != nil {
+		return *f
+	}
+	return Fare{}
+}
+
+// ToProto converts the assignment to a taxi.v1.RideAssigned
+func (m *RideAssigned) ToProto() *taxipb.RideAssigned {
+	return &taxipb.RideAssigned{
+		TripId:        int64(m.TripID),
+		Driver:        m.Driver.ToProto(),
+		Pickup:        m.Pickup.ToProto(),
+		Dropoff:       m.Dropoff.ToProto(),
+		EstimatedFare: m.EstimatedFare.ToProto(),
+		PickupEtaS:    m.PickupETA,
+		Time:          m.Time,
+	}
+}
+
+// rideAssignedFromProto converts a taxi.v1.RideAssigned
+func rideAssignedFromProto(p *taxipb.RideAssigned) RideAssigned {
+	return RideAssigned{
+		Type:          TypeRideAssigned,
+		TripID:        int(p.GetTripId()),
+		Driver:        driverFromProto(p.GetDriver()),
+		Pickup:        locationFromProto(p.GetPickup()),
+		Dropoff:       locationFromProto(p.GetDropoff()),
+		EstimatedFare: fareValue(p.GetEstimatedFare()),
+		PickupETA:     p.GetPickupEtaS(),
+		Time:          p.GetTime(),
+	}
+}
+
+// ToProto converts the move to a taxi.v1.DriverMove
+func (m *DriverMove) ToProto() *taxipb.DriverMove {
+	return &taxipb.DriverMove{
+		Id:        int64(m.ID),
+		Lon:       m.Lon,
+		Lat:       m.Lat,
+		Heading:   m.Heading,
+		Speed:     m.Speed,
+		Distance:  m.Distance,
+		RawLon:    m.RawLon,
+		RawLat:    m.RawLat,
+		VelLon:    m.VelLon,
+		VelLat:    m.VelLat,
+		UpdatedAt: m.UpdatedAt,
+	}
+}
+
+// driverMoveFromProto converts a taxi.v1.DriverMove
+func driverMoveFromProto(p *taxipb.DriverMove) DriverMove {
+	return DriverMove{
+		ID:        int(p.GetId()),
+		Lon:       p.GetLon(),
+		Lat:       p.GetLat(),
+		Heading:   p.GetHeading(),
+		Speed:     p.GetSpeed(),
+		Distance:  p.GetDistance(),
+		RawLon:    p.GetRawLon(),
+		RawLat:    p.GetRawLat(),
+		VelLon:    p.GetVelLon(),
+		VelLat:    p.GetVelLat(),
+		UpdatedAt: p.GetUpdatedAt(),
+	}
+}
+
+// ToProto converts the delta to a taxi.v1.DriversDelta
+func (d *DriversDelta) ToProto() *taxipb.DriversDelta {
+	p := &taxipb.DriversDelta{
+		Appeared:  driversToProto(d.Appeared),
+		Count:     int32(d.Count),
+		Time:      d.Time,
+		DataAgeMs: d.DataAgeMs,
+		Seq:       d.Seq,
+	}
+	for i := range d.Moved {
+		p.Moved = append(p.Moved, d.Moved[i].ToProto())
+	}
+	for _, c := range d.StatusChanged {
+		p.StatusChanged = append(p.StatusChanged, &taxipb.DriverStatusChange{Id: int64(c.ID), Status: c.Status})
+	}
+	for _, id := range d.Disappeared {
+		p.Disappeared = append(p.Disappeared, int64(id))
+	}
+	return p
+}
+
+// driversDeltaFromProto converts a taxi.v1.DriversDelta
+func driversDeltaFromProto(p *taxipb.DriversDelta) DriversDelta {
+	d := DriversDelta{
+		Type:      TypeDriversDelta,
+		Count:     int(p.GetCount()),
+		Time:      p.GetTime(),
+		DataAgeMs: p.GetDataAgeMs(),
+		Seq:       p.GetSeq(),
+	}
+	if len(p.GetAppeared()) > 0 {
+		d.Appeared = driversFromProto(p.GetAppeared())
+	}
+	for _, m := range p.GetMoved() {
+		d.Moved = append(d.Moved, driverMoveFromProto(m))
+	}
+	for _, c := range p.GetStatusChanged() {
+		d.StatusChanged = append(d.StatusChanged, DriverStatusChange{ID: int(c.GetId()), Status: c.GetStatus()})
+	}
+	for _, id := range p.GetDisappeared() {
+		d.Disappeared = append(d.Disappeared, int(id))
+	}
+	return d
+}
+
+// ToProto converts the event to a taxi.v1.TripEvent
+func (e *TripEvent) ToProto() *taxipb.TripEvent {
+	p := &taxipb.TripEvent{
+		Event:         e.Event,
+		Reason:        e.Reason,
+		TripId:        int64(e.TripID),
+		DriverId:      int64(e.DriverID),
+		Pickup:        e.Pickup.ToProto(),
+		Dropoff:       e.Dropoff.ToProto(),
+		EstimatedFare: e.EstimatedFare.ToProto(),
+		Time:          e.Time,
+	}
+	if e.FinalFare != nil {
+		p.FinalFare = e.FinalFare.ToProto()
+	}
+	return p
+}
+
+// tripEventFromProto converts a taxi.v1.TripEvent
+func tripEventFromProto(p *taxipb.TripEvent) TripEvent {
+	return TripEvent{
+		Type:          TypeTripEvent,
+		Event:         p.GetEvent(),
+		Reason:        p.GetReason(),
+		TripID:        int(p.GetTripId()),
+		DriverID:      int(p.GetDriverId()),
+		Pickup:        locationFromProto(p.GetPickup()),
+		Dropoff:       locationFromProto(p.GetDropoff()),
+		EstimatedFare: fareValue(p.GetEstimatedFare()),
+		FinalFare:     fareFromProto(p.GetFinalFare()),
+		Time:          p.GetTime(),
+	}
+}
+
+// ToProto converts the progress to a taxi.v1.RideProgress
+func (r *RideProgress) ToProto() *taxipb.RideProgress {
+	p := &taxipb.RideProgress{
+		TripId:     int64(r.TripID),
+		State:      r.State,
+		Reason:     r.Reason,
+		DriverId:   int64(r.DriverID),
+		Lon:        r.Lon,
+		Lat:        r.Lat,
+		Heading:    r.Heading,
+		DistanceKm: r.DistanceKm,
+		Time:       r.Time,
+	}
+	if r.FinalFare != nil {
+		p.FinalFare = r.FinalFare.ToProto()
+	}
+	return p
+}
+
+// rideProgressFromProto converts a taxi.v1.RideProgress
+func rideProgressFromProto(p *taxipb.RideProgress) RideProgress {
+	return RideProgress{
+		Type:       TypeRideProgress,
+		TripID:     int(p.GetTripId()),
+		State:      p.GetState(),
+		Reason:     p.GetReason(),
+		DriverID:   int(p.GetDriverId()),
+		Lon:        p.GetLon(),
+		Lat:        p.GetLat(),
+		Heading:    p.GetHeading(),
+		DistanceKm: p.GetDistanceKm(),
+		FinalFare:  fareFromProto(p.GetFinalFare()),
+		Time:       p.GetTime(),
+	}
+}
+
+// ToProto converts the change to a taxi.v1.DriverStatusChanged
+func (c *DriverStatusChanged) ToProto() *taxipb.DriverStatusChanged {
+	return &taxipb.DriverStatusChanged{
+		DriverId:  int64(c.DriverID),
+		OldStatus: c.OldStatus,
+		NewStatus: c.NewStatus,
+		Time:      c.Time,
+	}
+}
+
+// ToProto converts the counters of the statistics to a taxi.v1.Stats,
+// leaving out what it has no fields for
+func (s *Stats) ToProto() *taxipb.Stats {
+	p := &taxipb.Stats{
+		UptimeS:          s.UptimeS,
+		VirtualTime:      s.VirtualTime,
+		Speed:            s.Speed,
+		Clients:          int32(s.Clients),
+		AvailableDrivers: int32(s.AvailableDrivers),
+		BusyDrivers:      int32(s.BusyDrivers),
+		OfflineDrivers:   int32(s.OfflineDrivers),
+		Profiles:         make(map[string]int32, len(s.Profiles)),
+		Queries:          int32(s.Queries),
+		DriversPerQuery:  s.DriversPerQuery,
+		AvgQueryTimeMs:   s.AvgQueryTimeMs,
+		IndexRebuilds:    s.IndexRebuilds,
+		IndexAgeMs:       s.IndexAgeMs,
+		RideRequests:     int32(s.RideRequests),
+		UnservedRequests: int32(s.UnservedRequests),
+		InstantMatches:   int32(s.InstantMatches),
+		DeclinedOffers:   int32(s.DeclinedOffers),
+		ActiveShocks:     int32(s.ActiveShocks),
+		TripsInProgress:  int32(s.TripsInProgress),
+		CompletedTrips:   int32(s.CompletedTrips),
+		CancelledTrips:   int32(s.CancelledTrips),
+		NoShows:          int32(s.NoShows),
+		Revenue:          s.Revenue,
+		Currency:         s.Currency,
+	}
+	for name, n := range s.Profiles {
+		p.Profiles[name] = int32(n)
+	}
+	for _, c := range s.Cities {
+		p.Cities = append(p.Cities, &taxipb.CityStats{
+			City:      c.City,
+			Drivers:   int32(c.Drivers),
+			Available: int32(c.Available),
+			Busy:      int32(c.Busy),
+			Offline:   int32(c.Offline),
+		})
+	}
+	return p
+}
+
+// MarshalFrame encodes a message as a taxi.v1.Frame, the binary WebSocket
+// frame of clients that asked for EncodingProtobuf, with the ID of the
+// command it answers. It reports false for messages a frame can't carry,
+// which are sent as JSON instead.
+func MarshalFrame(v interface{}, id string) ([]byte, bool) {
+	frame := &taxipb.Frame{Id: id}
+	switch msg := v.(type) {
+	case DriversSnapshot:
+		frame.Message = &taxipb.Frame_DriversSnapshot{DriversSnapshot: (*DriversUpdate)(&msg).ToProto()}
+	case DriversUpdate:
+		frame.Message = &taxipb.Frame_DriversUpdate{DriversUpdate: msg.ToProto()}
+	case DriversDelta:
+		frame.Message = &taxipb.Frame_DriversDelta{DriversDelta: msg.ToProto()}
+	case Subscriptions:
+		frame.Message = &taxipb.Frame_Subscriptions{Subscriptions: &taxipb.Subscriptions{Active: msg.Active}}
+	case TripEvent:
+		frame.Message = &taxipb.Frame_TripEvent{TripEvent: msg.ToProto()}
+	case RideAssigned:
+		frame.Message = &taxipb.Frame_RideAssigned{RideAssigned: msg.ToProto()}
+	case RideProgress:
+		frame.Message = &taxipb.Frame_RideProgress{RideProgress: msg.ToProto()}
+	case DriverStatusChanged:
+		frame.Message = &taxipb.Frame_DriverStatusChanged{DriverStatusChanged: msg.ToProto()}
+	default:
+		return nil, false
+	}
+	data, err := proto.Marshal(frame)
+	return data, err == nil
+}
+
+// DecodeFrame decodes a taxi.v1.Frame into a typed pointer such as
+// *DriversUpdate, and the ID of the command it answers
+func DecodeFrame(data []byte) (interface{}, string, error) {
+	var frame taxipb.Frame
+	if err := proto.Unmarshal(data, &frame); err != nil {
+		return nil, "", err
+	}
+
+	var msg interface{}
+	switch m := frame.Message.(type) {
+	case *taxipb.Frame_DriversUpdate:
+		update := DriversUpdateFromProto(m.DriversUpdate)
+		msg = &update
+	case *taxipb.Frame_DriversSnapshot:
+		update := DriversUpdateFromProto(m.DriversSnapshot)
+		update.Type = TypeSnapshot
+		msg = (*DriversSnapshot)(&update)
+	case *taxipb.Frame_DriversDelta:
+		delta := driversDeltaFromProto(m.DriversDelta)
+		msg = &delta
+	case *taxipb.Frame_Subscriptions:
+		msg = &Subscriptions{Type: TypeSubscriptions, Active: append([]string{}, m.Subscriptions.GetActive()...)}
+	case *taxipb.Frame_TripEvent:
+		event := tripEventFromProto(m.TripEvent)
+		msg = &event
+	case *taxipb.Frame_RideAssigned:
+		assigned := rideAssignedFromProto(m.RideAssigned)
+		msg = &assigned
+	case *taxipb.Frame_RideProgress:
+		progress := rideProgressFromProto(m.RideProgress)
+		msg = &progress
+	case *taxipb.Frame_DriverStatusChanged:
+		msg = &DriverStatusChanged{
+			Type:      TypeStatusChanged,
+			DriverID:  int(m.DriverStatusChanged.GetDriverId()),
+			OldStatus: m.DriverStatusChanged.GetOldStatus(),
+			NewStatus: m.DriverStatusChanged.GetNewStatus(),
+			Time:      m.DriverStatusChanged.GetTime(),
+		}
+	default:
+		return nil, frame.Id, errors.New("proto: frame without a message")
+	}
+	return msg, frame.Id, nil
+}
+
+// DecodeClientFrame decodes a taxi.v1.ClientFrame into the JSON text
+// message a client would have sent instead, in an Envelope with the
+// frame's ID, so it is handled like one
+func DecodeClientFrame(data []byte) ([]byte, error) {
+	var frame taxipb.ClientFrame
+	if err := proto.Unmarshal(data, &frame); err != nil {
+		return nil, err
+	}
+
+	var msg interface{}
+	var typ string
+	switch m := frame.Message.(type) {
+	case *taxipb.ClientFrame_Hello:
+		msg = Hello{Type: TypeHello, Version: int(m.Hello.GetVersion()), Capabilities: m.Hello.GetCapabilities()}
+	case *taxipb.ClientFrame_ClientParams:
+		msg, typ = clientParamsFromProto(m.ClientParams), TypeClientParams
+	case *taxipb.ClientFrame_Subscribe:
+		sub := Subscribe{
+			Type:     TypeSubscribe,
+			ID:       m.Subscribe.GetId(),
+			City:     m.Subscribe.GetCity(),
+			Statuses: m.Subscribe.GetStatuses(),
+		}
+		if p := m.Subscribe.GetRegion(); p != nil {
+			region := RegionFromProto(p)
+			sub.Region = &region
+		}
+		if p := m.Subscribe.GetViewport(); p != nil {
+			viewport := viewportFromProto(p)
+			sub.Viewport = &viewport
+		}
+		for _, id := range m.Subscribe.GetDrivers() {
+			sub.Drivers = append(sub.Drivers, int(id))
+		}
+		msg = sub
+	case *taxipb.ClientFrame_Unsubscribe:
+		msg = Unsubscribe{Type: TypeUnsubscribe, ID: m.Unsubscribe.GetId()}
+	case *taxipb.ClientFrame_Follow:
+		msg = Follow{Type: TypeFollow, DriverID: int(m.Follow.GetDriverId())}
+	case *taxipb.ClientFrame_Unfollow:
+		msg = Unfollow{Type: TypeUnfollow, DriverID: int(m.Unfollow.GetDriverId())}
+	case *taxipb.ClientFrame_Resync:
+		msg = Resync{Type: TypeResync}
+	case *taxipb.ClientFrame_RequestRide:
+		ride, err := RequestRideFromProto(m.RequestRide)
+		if err != nil {
+			return nil, err
+		}
+		msg = ride
+	default:
+		return nil, errors.New("proto: client frame without a message")
+	}
+
+	if typ == "" {
+		typ = TypeOf(msg)
+	}
+	data, err := json.Marshal(Envelope{Version: Version, Type: typ, ID: frame.Id, Payload: msg})
+	if err != nil {
+		return nil, fmt.Errorf("proto: %w", err)
+	}
+	return data, nil
+}
+
+// clientParamsFromProto converts a taxi.v1.ClientParams to the fields of a
+// client_params message, leaving out those the frame left out so they keep
+// their current values
+func clientParamsFromProto(p *taxipb.ClientParams) map[string]interface{} {
+	msg := map[string]interface{}{"type": TypeClientParams}
+	if p.Lat != nil {
+		msg["lat"] = p.GetLat()
+	}
+	if p.Lon != nil {
+		msg["lon"] = p.GetLon()
+	}
+	if p.Radius != nil {
+		msg["radius"] = p.GetRadius()
+	}
+	if p.City != nil {
+		msg["city"] = p.GetCity()
+	}
+	if p.Filter != nil {
+		msg["filter"] = p.GetFilter()
+	}
+	if p.Statuses != nil {
+		msg["statuses"] = p.Statuses.GetStatuses()
+	}
+	if p.Deltas != nil {
+		msg["deltas"] = p.GetDeltas()
+	}
+	if p.Encoding != nil {
+		msg["encoding"] = p.GetEncoding()
+	}
+	if p.IntervalMs != 0 {
+		msg["interval_ms"] = p.IntervalMs
+	}
+	return msg
+}
@@ -0,0 +1,1112 @@
+// Package protocol defines the messages exchanged with the taxi simulation
+// over WebSocket and the HTTP API. The server and the Go client package both
+// use these types, so they can't drift apart.
+package protocol
+
+//go:generate go run ../cmd/tsgen -ts ../static/protocol.d.ts -schema schema.json -openapi openapi.json
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Protocol versions. Clients that never negotiate a version are assumed to
+// be legacy frontends and get the original drivers_update format. Version 2
+// sends the types of this package as they are; version 3 wraps every
+// message in an Envelope.
+const (
+	VersionLegacy = 1
+	VersionFlat   = 2
+	Version       = 3
+
+	// Subprotocol selects the current version during the WebSocket handshake
+	Subprotocol = "taxi.v3"
+	// SubprotocolMsgpack selects the current version with driver updates
+	// sent as binary MessagePack frames
+	SubprotocolMsgpack = "taxi.v3.msgpack"
+	// SubprotocolProtobuf selects the current version with driver updates,
+	// subscriptions and events sent as binary protobuf Frames
+	SubprotocolProtobuf = "taxi.v3.proto"
+	// SubprotocolFlat and SubprotocolFlatMsgpack select version 2
+	SubprotocolFlat        = "taxi.v2"
+	SubprotocolFlatMsgpack = "taxi.v2.msgpack"
+)
+
+// Capabilities a server offers in its Welcome. A client that lists the
+// capabilities it wants in its Hello gets only those; leaving out
+// CapEvents stops trip, offer and heatmap messages.
+const (
+	CapDeltas         = "deltas"          // drivers_delta updates
+	CapMsgpack        = "msgpack"         // MessagePack driver updates
+	CapProtobuf       = "protobuf"        // protobuf frames
+	CapSubscriptions  = "subscriptions"   // subscribe and unsubscribe
+	CapUpdateInterval = "update_interval" // client_params interval_ms
+	CapEvents         = "events"          // trip_event, offer_event and demand_heatmap
+	CapStats          = "stats"           // stats every stats interval
+)
+
+// Encodings of driver updates (drivers_update and drivers_delta). Other
+// messages are JSON text frames, except that EncodingProtobuf also sends
+// every message a taxi.v1.Frame carries as a binary Frame (see
+// MarshalFrame).
+const (
+	EncodingJSON     = "json"
+	EncodingMsgpack  = "msgpack"
+	EncodingProtobuf = "protobuf"
+)
+
+// WebSocket message types
+const (
+	TypeHello          = "hello"
+	TypeWelcome        = "welcome"
+	TypeClientParams   = "client_params"
+	TypeSubscribe      = "subscribe"
+	TypeUnsubscribe    = "unsubscribe"
+	TypeSubscriptions  = "subscriptions"
+	TypeDriversUpdate  = "drivers_update"
+	TypeDriversDelta   = "drivers_delta"
+	TypeSnapshot       = "drivers_snapshot"
+	TypeResync         = "resync"
+	TypeSimControl     = "sim_control"
+	TypeSimState       = "sim_state"
+	TypeDemandHeatmap  = "demand_heatmap"
+	TypeTripEvent      = "trip_event"
+	TypeOfferEvent     = "offer_event"
+	TypeStatusChanged  = "driver_status_changed"
+	TypeRequestRide    = "request_ride"
+	TypeRideAssigned   = "ride_assigned"
+	TypeRideProgress   = "ride_progress"
+	TypeFollow         = "follow"
+	TypeUnfollow       = "unfollow"
+	TypeFollowing      = "following"
+	TypeDriverTrack    = "driver_track"
+	TypeDriverPosition = "driver_position"
+	TypeWatchCells     = "watch_cells"
+	TypeDriverCells    = "driver_cells"
+	TypeError          = "error"
+	TypeShutdown       = "server_shutting_down"
+	TypeStats          = "stats"
+)
+
+// Location is a point given as latitude/longitude
+type Location struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// DriverResponse is the JSON response format for driver data
+type DriverResponse struct {
+	ID       int     `json:"id"`
+	Lon      float64 `json:"lon"`
+	Lat      float64 `json:"lat"`
+	Status   string  `json:"status"`
+	Distance float64 `json:"distance,omitempty"`  // distance in km from query point
+	Heading  float64 `json:"heading"`             // direction in degrees (0-360)
+	Speed    float64 `json:"speed"`               // speed in degrees of arc per second
+	Profile  string  `json:"profile,omitempty"`   // behavior archetype (regular, aggressive, cautious, lazy)
+	Vehicle  string  `json:"vehicle,omitempty"`   // car, van or suv
+	Origin   string  `json:"origin,omitempty"`    // federation peer the driver comes from, or "device" for fed positions; empty for simulated drivers
+	RemoteID int     `json:"remote_id,omitempty"` // the driver's ID at its origin
+	// Unfiltered GPS fix, only when the server simulates GPS noise; lon/lat
+	// are then the smoothed position
+	RawLon float64 `json:"raw_lon,omitempty"`
+	RawLat float64 `json:"raw_lat,omitempty"`
+	// Velocity in degrees per second of real time (zero while the
+	// simulation is paused) and the Unix milliseconds the position is as
+	// of, so clients can move the driver on between updates
+	VelLon    float64 `json:"vel_lon,omitempty"`
+	VelLat    float64 `json:"vel_lat,omitempty"`
+	UpdatedAt int64   `json:"updated_at,omitempty"`
+}
+
+// Extrapolate returns where the driver is at the given Unix milliseconds
+// when it kept its velocity since UpdatedAt
+func (d *DriverResponse) Extrapolate(ms int64) (lon, lat float64) {
+	if d.UpdatedAt == 0 {
+		return d.Lon, d.Lat
+	}
+	dt := float64(ms-d.UpdatedAt) / 1000
+	return d.Lon + d.VelLon*dt, d.Lat + d.VelLat*dt
+}
+
+// DriversResponse is the JSON response format for multiple drivers. Count
+// is the drivers on this page, Total those matching the query.
+type DriversResponse struct {
+	Drivers    []DriverResponse `json:"drivers"`
+	Count      int              `json:"count"`
+	Total      int              `json:"total"`
+	NextOffset int              `json:"next_offset,omitempty"` // pass as offset for the next page; left out on the last
+	Center     Location         `json:"center"`
+	Radius     float64          `json:"radius"`
+	DataAgeMs  int64            `json:"data_age_ms"` // age of the index positions in milliseconds
+}
+
+// NearestDriversResponse is the response of /api/drivers/nearest: the
+// drivers closest to a location, closest first
+type NearestDriversResponse struct {
+	Drivers   []DriverResponse `json:"drivers"`
+	Count     int              `json:"count"`
+	Center    Location         `json:"center"`
+	DataAgeMs int64            `json:"data_age_ms"` // age of the index positions in milliseconds
+}
+
+// Hello negotiates the protocol version and capabilities after connecting.
+// The server answers with a Welcome.
+type Hello struct {
+	Type    string `json:"type"`    // "hello"
+	Version int    `json:"version"` // highest version the client speaks
+	// Capabilities the client wants; empty takes all the server offers
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Welcome tells a client which protocol version the server will speak and
+// which capabilities are enabled. Clients that select version 3 through
+// the subprotocol get one right after connecting.
+type Welcome struct {
+	Type         string   `json:"type"` // "welcome"
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Envelope wraps every message exchanged with version 3 clients. The
+// payload is the message itself, such as a DriversUpdate. Version 3
+// clients may send their messages in an envelope or as they are.
+type Envelope struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	// Correlation ID a client may put on a command; the server echoes it on
+	// the command's reply or error
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+// Wrap puts a message in an envelope of the current version, typed by the
+// message's Type field
+func Wrap(v interface{}) Envelope {
+	return Envelope{Version: Version, Type: TypeOf(v), Payload: v}
+}
+
+// TypeOf returns the message's Type field, or "" for values without one
+func TypeOf(v interface{}) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Struct {
+		if f := rv.FieldByName("Type"); f.IsValid() && f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// Unwrap returns the payload of an enveloped message, with the envelope's
+// type filled in when the payload has none, and the envelope's correlation
+// ID. Other messages are returned unchanged.
+func Unwrap(data []byte) ([]byte, string, error) {
+	var env struct {
+		Type    string          `json:"type"`
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, "", err
+	}
+	if len(env.Payload) == 0 || env.Payload[0] != '{' {
+		return data, "", nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return nil, "", err
+	}
+	if _, ok := payload["type"]; !ok {
+		payload["type"], _ = json.Marshal(env.Type)
+		data, err := json.Marshal(payload)
+		return data, env.ID, err
+	}
+	return env.Payload, env.ID, nil
+}
+
+// ClientParams sets the area a WebSocket client receives updates for
+type ClientParams struct {
+	Type   string  `json:"type"` // "client_params"
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius"`         // in degrees
+	City   string  `json:"city,omitempty"` // overrides lat/lon with the city center
+	// Server-side filter expression, e.g. status == "Available" && speed_kmh > 20 && type in ["car", "van"].
+	// An empty string removes the filter; an invalid one is answered with an error message.
+	Filter string `json:"filter"`
+	// Only send drivers with these statuses ("Available", "Busy" or
+	// "Offline"). Null or an empty list sends every status; leaving the
+	// field out keeps the current ones.
+	Statuses []string `json:"statuses"`
+	// Ask for a drivers_update snapshot followed by drivers_delta messages
+	// with only the changes. Any new client_params starts a new snapshot.
+	Deltas bool `json:"deltas,omitempty"`
+	// Encoding of driver updates: "json", "msgpack" or "protobuf" (binary
+	// frames). Empty keeps the current one.
+	Encoding string `json:"encoding,omitempty"`
+	// Milliseconds between driver updates, from 100 to 10000, rounded to
+	// 20ms. Zero keeps the current interval (220ms by default).
+	IntervalMs int `json:"interval_ms,omitempty"`
+}
+
+// Subscribe adds a subscription to a connection, or replaces the one with the
+// same ID. It selects drivers by at most one of Region, Viewport, City or
+// Drivers;
+// Statuses narrows the selection to those statuses, or on its own selects
+// every driver with them. Once a connection has subscribed, its updates
+// carry the drivers of all its subscriptions instead of the client_params
+// area.
+type Subscribe struct {
+	Type     string    `json:"type"` // "subscribe"
+	ID       string    `json:"id"`   // chosen by the client, unique per connection
+	Region   *Region   `json:"region,omitempty"`
+	Viewport *Viewport `json:"viewport,omitempty"`
+	City     string    `json:"city,omitempty"`
+	Drivers  []int     `json:"drivers,omitempty"`  // driver IDs
+	Statuses []string  `json:"statuses,omitempty"` // "Available", "Busy" or "Offline"
+}
+
+// Region is a circular area
+type Region struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius"` // in degrees
+}
+
+// Viewport is the rectangle a map shows, as Leaflet's getBounds reports it.
+// West is greater than East when the viewport crosses the antimeridian.
+type Viewport struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// Contains reports whether a position is inside the viewport
+func (v *Viewport) Contains(lat, lon float64) bool {
+	if lat < v.South || lat > v.North {
+		return false
+	}
+	if v.West <= v.East {
+		return lon >= v.West && lon <= v.East
+	}
+	return lon >= v.West || lon <= v.East
+}
+
+// Validate checks that a viewport is a non-empty area of at most
+// MaxViewportSpan degrees each way
+func (v *Viewport) Validate() error {
+	if v.South < -90 || v.North > 90 || v.West < -180 || v.West > 180 || v.East < -180 || v.East > 180 {
+		return fmt.Errorf("viewport (%g, %g, %g, %g) is not a valid area", v.South, v.West, v.North, v.East)
+	}
+	if !(v.South < v.North) || v.West == v.East {
+		return errors.New("viewport is empty")
+	}
+	if v.North-v.South > MaxViewportSpan || v.width() > MaxViewportSpan {
+		return fmt.Errorf("viewport is larger than %g degrees", MaxViewportSpan)
+	}
+	return nil
+}
+
+// width is the viewport's extent in degrees of longitude
+func (v *Viewport) width() float64 {
+	if v.West <= v.East {
+		return v.East - v.West
+	}
+	return 360 - v.West + v.East
+}
+
+// Subscription limits
+const (
+	MaxSubscriptionID      = 64   // longest subscription ID in bytes
+	MaxSubscriptionDrivers = 1000 // most driver IDs in one subscription
+	MaxRegionRadius        = 2.0  // largest region radius in degrees
+	MaxViewportSpan        = 10.0 // largest viewport height and width in degrees
+)
+
+// Validate checks that a subscribe message is well-formed. Whether a city
+// exists is up to the server.
+func (m *Subscribe) Validate() error {
+	if m.ID == "" {
+		return errors.New("subscription id is required")
+	}
+	if len(m.ID) > MaxSubscriptionID {
+		return fmt.Errorf("subscription id is longer than %d bytes", MaxSubscriptionID)
+	}
+
+	selectors := 0
+	if m.Region != nil {
+		selectors++
+		if err := m.Region.Validate(); err != nil {
+			return err
+		}
+	}
+	if m.Viewport != nil {
+		selectors++
+		if err := m.Viewport.Validate(); err != nil {
+			return err
+		}
+	}
+	if m.City != "" {
+		selectors++
+	}
+	if len(m.Drivers) > 0 {
+		selectors++
+		if len(m.Drivers) > MaxSubscriptionDrivers {
+			return fmt.Errorf("at most %d drivers per subscription", MaxSubscriptionDrivers)
+		}
+	}
+	if selectors > 1 {
+		return errors.New("subscribe to one of region, viewport, city or drivers")
+	}
+	if selectors == 0 && len(m.Statuses) == 0 {
+		return errors.New("subscribe to a region, viewport, city, drivers or statuses")
+	}
+
+	for _, status := range m.Statuses {
+		if !ValidStatus(status) {
+			return fmt.Errorf("unknown status %q", status)
+		}
+	}
+	return nil
+}
+
+// Validate checks that a region is a valid position with a radius up to
+// MaxRegionRadius
+func (r *Region) Validate() error {
+	if !validLocation(Location{Lat: r.Lat, Lon: r.Lon}) {
+		return fmt.Errorf("region center (%g, %g) is not a valid position", r.Lat, r.Lon)
+	}
+	if !(r.Radius > 0 && r.Radius <= MaxRegionRadius) {
+		return fmt.Errorf("region radius %g must be above 0 and at most %g degrees", r.Radius, MaxRegionRadius)
+	}
+	return nil
+}
+
+// ValidStatus reports whether status is a driver status
+func ValidStatus(status string) bool {
+	switch status {
+	case "Available", "Busy", "Offline":
+		return true
+	}
+	return false
+}
+
+// Unsubscribe removes a subscription; an empty ID removes all of them
+type Unsubscribe struct {
+	Type string `json:"type"` // "unsubscribe"
+	ID   string `json:"id,omitempty"`
+}
+
+// Subscriptions answers subscribe and unsubscribe with the IDs of the
+// connection's subscriptions, sorted
+type Subscriptions struct {
+	Type   string   `json:"type"` // "subscriptions"
+	Active []string `json:"active"`
+}
+
+// Follow asks for one driver's position, speed, heading and trip on every
+// simulation update, wherever the driver goes
+type Follow struct {
+	Type     string `json:"type"` // "follow"
+	DriverID int    `json:"driver_id"`
+}
+
+// Unfollow stops following a driver; without a DriverID it stops following
+// all of them
+type Unfollow struct {
+	Type     string `json:"type"` // "unfollow"
+	DriverID int    `json:"driver_id,omitempty"`
+}
+
+// DriverPosition feeds a driver's position from a real device or an
+// external simulator. The driver stops being simulated until its positions
+// stop coming. POST /api/drivers/{id}/position takes the driver from the
+// path instead of DriverID.
+type DriverPosition struct {
+	Type     string   `json:"type,omitempty"` // "driver_position"
+	DriverID int      `json:"driver_id,omitempty"`
+	Lon      float64  `json:"lon"`
+	Lat      float64  `json:"lat"`
+	Heading  *float64 `json:"heading,omitempty"` // direction in degrees (0-360); taken from the last position when missing
+	Status   string   `json:"status,omitempty"`  // Available, Busy or Offline; unchanged when empty
+	Time     int64    `json:"time,omitempty"`    // Unix milliseconds of the fix; when it arrived when 0
+	Token    string   `json:"token,omitempty"`   // the server's admin token, needed over WebSockets
+}
+
+// Validate checks that a position is well-formed. Whether the driver can
+// be fed is up to the server.
+func (m *DriverPosition) Validate() error {
+	if m.DriverID <= 0 {
+		return fmt.Errorf("invalid driver_id %d", m.DriverID)
+	}
+	if !validLocation(Location{Lat: m.Lat, Lon: m.Lon}) {
+		return fmt.Errorf("(%g, %g) is not a valid position", m.Lat, m.Lon)
+	}
+	if m.Heading != nil && (*m.Heading < 0 || *m.Heading > 360) {
+		return fmt.Errorf("heading %g is not between 0 and 360", *m.Heading)
+	}
+	if m.Time < 0 {
+		return fmt.Errorf("invalid time %d", m.Time)
+	}
+	return nil
+}
+
+// Following answers follow and unfollow with the IDs of the drivers the
+// connection follows, sorted
+type Following struct {
+	Type    string `json:"type"` // "following"
+	Drivers []int  `json:"drivers"`
+}
+
+// DriverTrack is sent to the clients following a driver on every
+// simulation update
+type DriverTrack struct {
+	Type      string         `json:"type"` // "driver_track"
+	Driver    DriverResponse `json:"driver"`
+	TripID    int            `json:"trip_id,omitempty"`    // the driver's current trip, if any
+	TripState string         `json:"trip_state,omitempty"` // "assigned" or "picked_up"
+	Time      int64          `json:"time"`                 // virtual time in milliseconds
+}
+
+// DriversUpdate is pushed to WebSocket clients on every broadcast
+type DriversUpdate struct {
+	Type      string           `json:"type"` // "drivers_update"
+	Drivers   []DriverResponse `json:"drivers"`
+	Count     int              `json:"count"`
+	Center    Location         `json:"center"`
+	Radius    float64          `json:"radius"`
+	Time      int64            `json:"time"`        // Timestamp in milliseconds
+	DataAgeMs int64            `json:"data_age_ms"` // age of the index positions in milliseconds
+	// Sequence number of the snapshot, for clients that asked for deltas
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// DriversSnapshot is the full update v3 clients that asked for deltas get
+// first, and again after a resync. The deltas that follow continue its
+// sequence numbers.
+type DriversSnapshot DriversUpdate
+
+// Resync asks the server for a new snapshot, for instance after a client
+// noticed a gap in the sequence numbers
+type Resync struct {
+	Type string `json:"type"` // "resync"
+}
+
+// DriverMove is the new position of a driver in a delta
+type DriverMove struct {
+	ID        int     `json:"id"`
+	Lon       float64 `json:"lon"`
+	Lat       float64 `json:"lat"`
+	Heading   float64 `json:"heading"`
+	Speed     float64 `json:"speed"`
+	Distance  float64 `json:"distance,omitempty"`
+	RawLon    float64 `json:"raw_lon,omitempty"`
+	RawLat    float64 `json:"raw_lat,omitempty"`
+	VelLon    float64 `json:"vel_lon,omitempty"`
+	VelLat    float64 `json:"vel_lat,omitempty"`
+	UpdatedAt int64   `json:"updated_at,omitempty"`
+}
+
+// DriverStatusChange is the new status of a driver in a delta
+type DriverStatusChange struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// DriversDelta is sent instead of a drivers_update to clients that asked
+// for deltas. It lists the changes since the previous message; a driver that
+// moved and changed status appears in both lists. Drivers that moved less
+// than about a meter are left out until they move further.
+type DriversDelta struct {
+	Type          string               `json:"type"`                     // "drivers_delta"
+	Appeared      []DriverResponse     `json:"appeared,omitempty"`       // drivers that entered the area or filter
+	Moved         []DriverMove         `json:"moved,omitempty"`          // drivers that moved
+	StatusChanged []DriverStatusChange `json:"status_changed,omitempty"` // drivers whose status changed
+	Disappeared   []int                `json:"disappeared,omitempty"`    // IDs of drivers that left the area or filter
+	Count         int                  `json:"count"`                    // drivers in the area after applying the delta
+	Time          int64                `json:"time"`                     // Timestamp in milliseconds
+	DataAgeMs     int64                `json:"data_age_ms"`
+	Seq           int64                `json:"seq"` // one more than the snapshot or delta before it
+}
+
+// Apply updates a set of drivers keyed by ID, as built from the last
+// snapshot, with the delta
+func (d *DriversDelta) Apply(drivers map[int]DriverResponse) {
+	for _, id := range d.Disappeared {
+		delete(drivers, id)
+	}
+	for _, driver := range d.Appeared {
+		drivers[driver.ID] = driver
+	}
+	for _, m := range d.Moved {
+		driver := drivers[m.ID]
+		driver.ID, driver.Lon, driver.Lat = m.ID, m.Lon, m.Lat
+		driver.Heading, driver.Speed, driver.Distance = m.Heading, m.Speed, m.Distance
+		driver.RawLon, driver.RawLat = m.RawLon, m.RawLat
+		driver.VelLon, driver.VelLat, driver.UpdatedAt = m.VelLon, m.VelLat, m.UpdatedAt
+		drivers[m.ID] = driver
+	}
+	for _, c := range d.StatusChanged {
+		driver := drivers[c.ID]
+		driver.Status = c.Status
+		drivers[c.ID] = driver
+	}
+}
+
+// SimControlMessage is the WebSocket admin message for controlling the main loop
+type SimControlMessage struct {
+	Type   string `json:"type"`   // "sim_control"
+	Action string `json:"action"` // "pause", "resume", "step" or "state"
+	Ticks  int    `json:"ticks,omitempty"`
+	Token  string `json:"token,omitempty"` // the server's admin token, needed for all but "state"
+}
+
+// SimState describes the run state of the main loop
+type SimState struct {
+	Type        string  `json:"type"` // "sim_state"
+	Paused      bool    `json:"paused"`
+	Tick        int64   `json:"tick"`
+	Speed       float64 `json:"speed"`
+	VirtualTime int64   `json:"virtual_time"` // Unix milliseconds
+}
+
+// HeatmapCell is one grid cell of the demand heatmap
+type HeatmapCell struct {
+	Lat   float64 `json:"lat"` // cell center
+	Lon   float64 `json:"lon"` // cell center
+	Count int     `json:"count"`
+}
+
+// DemandHeatmap aggregates recent ride request origins into a grid. It is
+// served at /api/heatmap/demand and pushed to WebSocket clients periodically.
+type DemandHeatmap struct {
+	Type     string        `json:"type"`      // "demand_heatmap"
+	CellSize float64       `json:"cell_size"` // cell edge in degrees
+	WindowS  float64       `json:"window_s"`  // seconds of virtual time covered
+	Cells    []HeatmapCell `json:"cells"`     // non-empty cells, busiest first
+	Total    int           `json:"total"`
+	Time     int64         `json:"time"` // virtual time in milliseconds
+}
+
+// S2 cell levels drivers can be counted at: level 1 cells are thousands of
+// km across, level 20 ones about 10m
+const (
+	MinCellLevel = 1
+	MaxCellLevel = 20
+)
+
+// WatchCells asks for the drivers counted per S2 cell instead of one by
+// one, which keeps updates small when a map shows a large area. The server
+// answers with a driver_cells message right away and sends a new one every
+// second; level 0 stops them.
+type WatchCells struct {
+	Type     string    `json:"type"`               // "watch_cells"
+	Level    int       `json:"level"`              // S2 cell level, 1 to 20; 0 stops the updates
+	Viewport *Viewport `json:"viewport,omitempty"` // only the cells whose center is in this area; all when missing
+}
+
+// Validate checks that a watch_cells message is well-formed
+func (m *WatchCells) Validate() error {
+	if m.Level != 0 && (m.Level < MinCellLevel || m.Level > MaxCellLevel) {
+		return fmt.Errorf("level must be between %d and %d", MinCellLevel, MaxCellLevel)
+	}
+	if m.Viewport != nil {
+		return m.Viewport.Validate()
+	}
+	return nil
+}
+
+// DriverCell counts the drivers in one S2 cell by status
+type DriverCell struct {
+	Token     string  `json:"token"` // S2 cell token, e.g. "3ffc3"
+	Lat       float64 `json:"lat"`   // the cell center
+	Lon       float64 `json:"lon"`
+	Available int     `json:"available"`
+	Busy      int     `json:"busy"`
+	Offline   int     `json:"offline"`
+	Count     int     `json:"count"`
+}
+
+// DriverCells counts the drivers per S2 cell of a level. It is served at
+// /api/cells and sent to WebSocket clients that watch cells.
+type DriverCells struct {
+	Type  string       `json:"type"` // "driver_cells"
+	Level int          `json:"level"`
+	Cells []DriverCell `json:"cells"` // non-empty cells, fullest first
+	Total int          `json:"total"` // drivers in the cells
+	Time  int64        `json:"time"`  // virtual time in milliseconds
+}
+
+// Trip events, in the order they happen
+const (
+	TripAssigned  = "assigned"  // a driver accepted the ride and heads for the pickup
+	TripPickedUp  = "picked_up" // the rider is on board
+	TripCompleted = "completed" // the rider was dropped off
+	TripCancelled = "cancelled" // the trip ended before the pickup, see Reason
+)
+
+// Reasons a trip is cancelled
+const (
+	CancelByRider = "rider_cancelled" // the rider gave up waiting
+	CancelNoShow  = "driver_no_show"  // the driver abandoned the ride on the way to the pickup
+)
+
+// Offer events; each offer is followed by an accept or a decline
+const (
+	OfferMade     = "offered"
+	OfferAccepted = "accepted"
+	OfferDeclined = "declined"
+)
+
+// OfferEvent is pushed to WebSocket clients as the dispatcher offers a ride
+// request to drivers, one after the other, until one accepts
+type OfferEvent struct {
+	Type       string  `json:"type"`        // "offer_event"
+	Event      string  `json:"event"`       // "offered", "accepted" or "declined"
+	TripID     int     `json:"trip_id"`     // the trip the request becomes once accepted
+	DriverID   int     `json:"driver_id"`   // the driver asked
+	Attempt    int     `json:"attempt"`     // 1 for the first driver asked
+	DistanceKm float64 `json:"distance_km"` // from the driver to the pickup
+	Time       int64   `json:"time"`        // virtual time in milliseconds
+}
+
+// Fare is the price of a trip, broken down by component
+type Fare struct {
+	Base        float64 `json:"base"`
+	Distance    float64 `json:"distance"` // per-km charge
+	Time        float64 `json:"time"`     // per-minute charge
+	Surge       float64 `json:"surge"`    // multiplier applied to the sum
+	Total       float64 `json:"total"`    // rounded, never below the minimum fare
+	Currency    string  `json:"currency"`
+	DistanceKm  float64 `json:"distance_km"`
+	DurationMin float64 `json:"duration_min"`
+}
+
+// FareEstimate is the response of /api/fare
+type FareEstimate struct {
+	From Location `json:"from"`
+	To   Location `json:"to"`
+	Fare Fare     `json:"fare"`
+}
+
+// TripEvent is pushed to WebSocket clients as trips progress
+type TripEvent struct {
+	Type          string   `json:"type"`             // "trip_event"
+	Event         string   `json:"event"`            // "assigned", "picked_up", "completed" or "cancelled"
+	Reason        string   `json:"reason,omitempty"` // why a trip was cancelled
+	TripID        int      `json:"trip_id"`
+	DriverID      int      `json:"driver_id"`
+	Pickup        Location `json:"pickup"`
+	Dropoff       Location `json:"dropoff"`
+	EstimatedFare Fare     `json:"estimated_fare"`
+	FinalFare     *Fare    `json:"final_fare,omitempty"` // completed trips only
+	Time          int64    `json:"time"`                 // virtual time in milliseconds
+}
+
+// RequestRide asks the dispatcher for a ride from Pickup. Without a Dropoff
+// the server picks the rider's destination.
+type RequestRide struct {
+	Type    string    `json:"type"` // "request_ride"
+	Pickup  Location  `json:"pickup"`
+	Dropoff *Location `json:"dropoff,omitempty"`
+}
+
+// Validate checks that a ride request is well-formed. Whether the server
+// serves the area is up to the server.
+func (m *RequestRide) Validate() error {
+	if !validLocation(m.Pickup) {
+		return fmt.Errorf("pickup (%g, %g) is not a valid position", m.Pickup.Lat, m.Pickup.Lon)
+	}
+	if m.Dropoff != nil && !validLocation(*m.Dropoff) {
+		return fmt.Errorf("dropoff (%g, %g) is not a valid position", m.Dropoff.Lat, m.Dropoff.Lon)
+	}
+	return nil
+}
+
+func validLocation(l Location) bool {
+	return l.Lat >= -90 && l.Lat <= 90 && l.Lon >= -180 && l.Lon <= 180
+}
+
+// RideAssigned answers request_ride with the trip and the driver that
+// accepted it. The rider then gets a ride_progress message on every
+// simulation update until the trip ends.
+type RideAssigned struct {
+	Type          string         `json:"type"` // "ride_assigned"
+	TripID        int            `json:"trip_id"`
+	Driver        DriverResponse `json:"driver"`
+	Pickup        Location       `json:"pickup"`
+	Dropoff       Location       `json:"dropoff"`
+	EstimatedFare Fare           `json:"estimated_fare"`
+	PickupETA     float64        `json:"pickup_eta_s"` // expected drive to the pickup in seconds
+	Time          int64          `json:"time"`         // virtual time in milliseconds
+}
+
+// RideProgress tracks a ride for the client that requested it
+type RideProgress struct {
+	Type       string  `json:"type"` // "ride_progress"
+	TripID     int     `json:"trip_id"`
+	State      string  `json:"state"`            // "assigned", "picked_up", "completed" or "cancelled"
+	Reason     string  `json:"reason,omitempty"` // why the trip was cancelled
+	DriverID   int     `json:"driver_id"`
+	Lon        float64 `json:"lon"` // the driver's position
+	Lat        float64 `json:"lat"`
+	Heading    float64 `json:"heading"`     // direction in degrees (0-360)
+	DistanceKm float64 `json:"distance_km"` // straight-line distance left to the pickup, or to the drop-off once picked up
+	FinalFare  *Fare   `json:"final_fare,omitempty"`
+	Time       int64   `json:"time"` // virtual time in milliseconds
+}
+
+// DriverStatusChanged is pushed to WebSocket clients whenever a driver's
+// status changes, whatever the cause
+type DriverStatusChanged struct {
+	Type      string `json:"type"` // "driver_status_changed"
+	DriverID  int    `json:"driver_id"`
+	OldStatus string `json:"old_status"`
+	NewStatus string `json:"new_status"`
+	Time      int64  `json:"time"` // virtual time in milliseconds
+}
+
+// ServerShutdown warns clients right before the server closes their
+// connection because it is shutting down. The close frame that follows has
+// code 1001 (going away).
+type ServerShutdown struct {
+	Type   string `json:"type"` // "server_shutting_down"
+	Reason string `json:"reason"`
+}
+
+// StatsMessage carries the fleet statistics to v3 clients that take the
+// stats capability, once every stats interval
+type StatsMessage struct {
+	Type  string `json:"type"` // "stats"
+	Stats Stats  `json:"stats"`
+}
+
+// ErrorMessage reports a problem with a WebSocket request
+type ErrorMessage struct {
+	Type  string `json:"type"` // "error"
+	Error string `json:"error"`
+}
+
+// SpawnRequest describes drivers to add at a location
+type SpawnRequest struct {
+	Lon    float64 `json:"lon"`
+	Lat    float64 `json:"lat"`
+	City   string  `json:"city,omitempty"` // overrides lon/lat with the city center
+	Count  int     `json:"count"`
+	Radius float64 `json:"radius,omitempty"` // spread in degrees, defaults to 0.01
+	Status string  `json:"status,omitempty"` // Available (default), Busy or Offline
+}
+
+// SpawnResponse lists the IDs of spawned drivers
+type SpawnResponse struct {
+	Spawned []int `json:"spawned"`
+	Count   int   `json:"count"`
+}
+
+// Stats is the response of /api/stats: the counters the server also prints
+// every few seconds
+type Stats struct {
+	UptimeS          float64        `json:"uptime_s"`
+	VirtualTime      int64          `json:"virtual_time"` // simulation clock, Unix milliseconds
+	Speed            float64        `json:"speed"`        // simulation speed multiplier
+	Clients          int            `json:"clients"`      // connected WebSocket clients
+	AvailableDrivers int            `json:"available_drivers"`
+	BusyDrivers      int            `json:"busy_drivers"`
+	OfflineDrivers   int            `json:"offline_drivers"`
+	Profiles         map[string]int `json:"profiles"` // drivers per behavior profile
+	Queries          int            `json:"queries"`  // spatial index queries
+	DriversPerQuery  float64        `json:"drivers_per_query"`
+	AvgQueryTimeMs   float64        `json:"avg_query_time_ms"`
+	IndexRebuilds    int64          `json:"index_rebuilds"`
+	IndexAgeMs       int64          `json:"index_age_ms"`
+	RideRequests     int            `json:"ride_requests"`
+	UnservedRequests int            `json:"unserved_requests"` // no driver nearby
+	InstantMatches   int            `json:"instant_matches"`   // served from a standby pool
+	DeclinedOffers   int            `json:"declined_offers"`
+	ActiveShocks     int            `json:"active_shocks"` // demand shocks under way
+	TripsInProgress  int            `json:"trips_in_progress"`
+	CompletedTrips   int            `json:"completed_trips"`
+	CancelledTrips   int            `json:"cancelled_trips"` // cancelled by the rider
+	NoShows          int            `json:"no_shows"`        // abandoned by the driver
+	Revenue          float64        `json:"revenue"`         // final fares of completed trips
+	Currency         string         `json:"currency"`
+	StandbyPools     []StandbyPool  `json:"standby_pools"`
+	Cities           []CityStats    `json:"cities"` // drivers by closest city
+	Index            IndexStats     `json:"index"`  // of every city together
+	Messages         MessageCounts  `json:"messages"`
+	Broadcast        BroadcastTimes `json:"broadcast"`
+}
+
+// BroadcastTimes are histograms of how long the phases of sending clients
+// their driver updates took since startup. Clients watching the same area
+// share the query, assembly and encoding of a tick, which are counted once.
+type BroadcastTimes struct {
+	Tick     Histogram `json:"tick"`     // a whole broadcast to the clients due an update
+	Query    Histogram `json:"query"`    // collecting a client's drivers from its topics
+	Assemble Histogram `json:"assemble"` // filtering them into an update, or diffing a delta
+	Encode   Histogram `json:"encode"`   // encoding an update frame
+	Write    Histogram `json:"write"`    // writing a frame to a client's connection
+}
+
+// Histogram counts durations in buckets. Percentiles are estimated as the
+// upper bound of the bucket they fall in.
+type Histogram struct {
+	Count   int64             `json:"count"`
+	AvgMs   float64           `json:"avg_ms"`
+	P50Ms   float64           `json:"p50_ms"`
+	P90Ms   float64           `json:"p90_ms"`
+	P99Ms   float64           `json:"p99_ms"`
+	MaxMs   float64           `json:"max_ms"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts the durations above the bound of the bucket
+// before it, up to its own
+type HistogramBucket struct {
+	LeMs  float64 `json:"le_ms,omitempty"` // upper bound; none for the last bucket
+	Count int64   `json:"count"`
+}
+
+// MessageCounts counts the WebSocket and SSE messages since startup by
+// message type
+type MessageCounts struct {
+	In      map[string]int64 `json:"in"`      // received from clients; "invalid" for unparsable ones
+	Out     map[string]int64 `json:"out"`     // written to clients
+	Dropped map[string]int64 `json:"dropped"` // dropped from full send queues
+	Stale   map[string]int64 `json:"stale"`   // driver updates replaced by a newer one before they were written
+}
+
+// StatsHistory is the response of /api/stats/history
+type StatsHistory struct {
+	IntervalMs int64         `json:"interval_ms"` // between samples
+	Samples    []StatsSample `json:"samples"`     // oldest first
+}
+
+// StatsSample is the state of the simulation at one moment, with rates
+// over the time since the sample before it
+type StatsSample struct {
+	Time             int64   `json:"time"`         // Unix milliseconds
+	VirtualTime      int64   `json:"virtual_time"` // simulation clock, Unix milliseconds
+	Clients          int     `json:"clients"`
+	AvailableDrivers int     `json:"available_drivers"`
+	BusyDrivers      int     `json:"busy_drivers"`
+	OfflineDrivers   int     `json:"offline_drivers"`
+	QueriesPerSec    float64 `json:"queries_per_sec"`
+	AvgQueryTimeMs   float64 `json:"avg_query_time_ms"`
+	RequestsPerMin   float64 `json:"requests_per_min"` // ride requests
+	TripsInProgress  int     `json:"trips_in_progress"`
+	CompletedTrips   int     `json:"completed_trips"`
+}
+
+// CityStats counts the drivers of one city, as of the last index update,
+// and describes the city's index
+type CityStats struct {
+	City      string     `json:"city"`
+	Drivers   int        `json:"drivers"`
+	Available int        `json:"available"`
+	Busy      int        `json:"busy"`
+	Offline   int        `json:"offline"`
+	Index     IndexStats `json:"index"`
+}
+
+// IndexStats describes a spatial index: how long keeping it up to date
+// takes and, for a quadtree, its shape
+type IndexStats struct {
+	Kind     string      `json:"kind"` // quadtree or grid
+	Points   int         `json:"points"`
+	Rebuilds int64       `json:"rebuilds"`
+	UpdateMs float64     `json:"update_ms"` // the last update
+	BuildMs  float64     `json:"build_ms"`  // the last full build
+	BuiltAt  int64       `json:"built_at"`  // Unix milliseconds
+	Shape    *IndexShape `json:"shape,omitempty"`
+}
+
+// IndexShape is the shape of a quadtree. Clustered drivers make deep trees
+// with many empty leaves, which queries visit for nothing.
+type IndexShape struct {
+	Capacity      int     `json:"capacity"` // points per node before it splits
+	Depth         int     `json:"depth"`    // of the deepest leaf
+	Nodes         int     `json:"nodes"`
+	Leaves        int     `json:"leaves"`
+	EmptyLeaves   int     `json:"empty_leaves"`
+	AvgLeafPoints float64 `json:"avg_leaf_points"` // points per non-empty leaf
+	LeafPoints    []int   `json:"leaf_points"`     // leaf_points[n] counts the leaves holding n points
+}
+
+// StandbyPool is the occupancy of a landmark's standby pool
+type StandbyPool struct {
+	Landmark string `json:"landmark"`
+	Target   int    `json:"target"`
+	Reserved int    `json:"reserved"` // drivers assigned to the pool
+	Ready    int    `json:"ready"`    // reserved drivers already waiting at the landmark
+}
+
+// DriverDetail is the full state of one driver, as served by
+// /api/drivers/{id}
+type DriverDetail struct {
+	Driver      DriverResponse `json:"driver"`
+	SpeedKmh    float64        `json:"speed_kmh"`
+	City        string         `json:"city"`                  // closest city
+	Destination *Location      `json:"destination,omitempty"` // where the driver is heading, if anywhere
+	Standby     string         `json:"standby,omitempty"`     // landmark whose standby pool the driver waits in
+	OdometerKm  float64        `json:"odometer_km"`           // distance driven since the driver joined
+	Trip        *DriverTrip    `json:"trip,omitempty"`        // the ride the driver is on, if any
+	Time        int64          `json:"time"`
+}
+
+// DriverTrip is the ride a driver is on
+type DriverTrip struct {
+	ID            int      `json:"id"`
+	State         string   `json:"state"` // assigned or picked_up
+	Pickup        Location `json:"pickup"`
+	Dropoff       Location `json:"dropoff"`
+	EstimatedFare Fare     `json:"estimated_fare"`
+	AssignedAt    int64    `json:"assigned_at"` // Unix milliseconds
+}
+
+// DespawnRequest lists drivers to remove
+type DespawnRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// DespawnResponse lists the IDs of drivers that were removed
+type DespawnResponse struct {
+	Removed []int `json:"removed"`
+	Count   int   `json:"count"`
+}
+
+// Decode parses a server-to-client WebSocket message into its typed struct,
+// returned as a pointer (e.g. *DriversUpdate). Enveloped messages are
+// unwrapped first.
+func Decode(data []byte) (interface{}, error) {
+	data, _, err := Unwrap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+
+	var msg interface{}
+	switch head.Type {
+	case TypeWelcome:
+		msg = &Welcome{}
+	case TypeDriversUpdate:
+		msg = &DriversUpdate{}
+	case TypeDriversDelta:
+		msg = &DriversDelta{}
+	case TypeSnapshot:
+		msg = &DriversSnapshot{}
+	case TypeSimState:
+		msg = &SimState{}
+	case TypeDemandHeatmap:
+		msg = &DemandHeatmap{}
+	case TypeTripEvent:
+		msg = &TripEvent{}
+	case TypeOfferEvent:
+		msg = &OfferEvent{}
+	case TypeStatusChanged:
+		msg = &DriverStatusChanged{}
+	case TypeRideAssigned:
+		msg = &RideAssigned{}
+	case TypeRideProgress:
+		msg = &RideProgress{}
+	case TypeFollowing:
+		msg = &Following{}
+	case TypeDriverTrack:
+		msg = &DriverTrack{}
+	case TypeDriverCells:
+		msg = &DriverCells{}
+	case TypeSubscriptions:
+		msg = &Subscriptions{}
+	case TypeError:
+		msg = &ErrorMessage{}
+	case TypeShutdown:
+		msg = &ServerShutdown{}
+	case TypeStats:
+		msg = &StatsMessage{}
+	default:
+		return nil, fmt.Errorf("unknown message type %q", head.Type)
+	}
+
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// MessageInfo describes a wire type for code and schema generation
+type MessageInfo struct {
+	Value     interface{} // zero value of the Go type
+	Type      string      // WebSocket "type" value, empty for HTTP-only types
+	Direction string      // "server" (server to client), "client" (client to server), "http" or "envelope" (wraps the others)
+}
+
+// Registry lists every message type exchanged with the server. cmd/tsgen
+// walks it to produce the TypeScript definitions and JSON schema, so new
+// message types must be added here.
+var Registry = []MessageInfo{
+	{Value: Envelope{}, Direction: "envelope"},
+	{Value: Hello{}, Type: TypeHello, Direction: "client"},
+	{Value: ClientParams{}, Type: TypeClientParams, Direction: "client"},
+	{Value: Subscribe{}, Type: TypeSubscribe, Direction: "client"},
+	{Value: Unsubscribe{}, Type: TypeUnsubscribe, Direction: "client"},
+	{Value: Resync{}, Type: TypeResync, Direction: "client"},
+	{Value: SimControlMessage{}, Type: TypeSimControl, Direction: "client"},
+	{Value: RequestRide{}, Type: TypeRequestRide, Direction: "client"},
+	{Value: Follow{}, Type: TypeFollow, Direction: "client"},
+	{Value: Unfollow{}, Type: TypeUnfollow, Direction: "client"},
+	{Value: DriverPosition{}, Type: TypeDriverPosition, Direction: "client"},
+	{Value: WatchCells{}, Type: TypeWatchCells, Direction: "client"},
+	{Value: Welcome{}, Type: TypeWelcome, Direction: "server"},
+	{Value: DriversUpdate{}, Type: TypeDriversUpdate, Direction: "server"},
+	{Value: DriversSnapshot{}, Type: TypeSnapshot, Direction: "server"},
+	{Value: DriversDelta{}, Type: TypeDriversDelta, Direction: "server"},
+	{Value: SimState{}, Type: TypeSimState, Direction: "server"},
+	{Value: DemandHeatmap{}, Type: TypeDemandHeatmap, Direction: "server"},
+	{Value: OfferEvent{}, Type: TypeOfferEvent, Direction: "server"},
+	{Value: TripEvent{}, Type: TypeTripEvent, Direction: "server"},
+	{Value: DriverStatusChanged{}, Type: TypeStatusChanged, Direction: "server"},
+	{Value: RideAssigned{}, Type: TypeRideAssigned, Direction: "server"},
+	{Value: RideProgress{}, Type: TypeRideProgress, Direction: "server"},
+	{Value: Subscriptions{}, Type: TypeSubscriptions, Direction: "server"},
+	{Value: Following{}, Type: TypeFollowing, Direction: "server"},
+	{Value: DriverTrack{}, Type: TypeDriverTrack, Direction: "server"},
+	{Value: DriverCells{}, Type: TypeDriverCells, Direction: "server"},
+	{Value: ErrorMessage{}, Type: TypeError, Direction: "server"},
+	{Value: ServerShutdown{}, Type: TypeShutdown, Direction: "server"},
+	{Value: StatsMessage{}, Type: TypeStats, Direction: "server"},
+	{Value: DriversResponse{}, Direction: "http"},
+	{Value: NearestDriversResponse{}, Direction: "http"},
+	{Value: FareEstimate{}, Direction: "http"},
+	{Value: SpawnRequest{}, Direction: "http"},
+	{Value: SpawnResponse{}, Direction: "http"},
+	{Value: Stats{}, Direction: "http"},
+	{Value: StatsHistory{}, Direction: "http"},
+	{Value: DriverDetail{}, Direction: "http"},
+	{Value: SpeedState{}, Direction: "http"},
+	{Value: RuntimeConfig{}, Direction: "http"},
+	{Value: ConfigPatch{}, Direction: "http"},
+	{Value: ClientsResponse{}, Direction: "http"},
+	{Value: AuditEvents{}, Direction: "http"},
+	{Value: TripRecords{}, Direction: "http"},
+	{Value: WebhookRegistration{}, Direction: "http"},
+	{Value: Webhook{}, Direction: "http"},
+	{Value: Webhooks{}, Direction: "http"},
+	{Value: WebhookEvent{}, Direction: "http"},
+	{Value: CloudEvent{}, Direction: "http"},
+	{Value: ZoneEvent{}, Direction: "http"},
+	{Value: TraceAssignment{}, Direction: "http"},
+	{Value: ClientEvent{}, Direction: "http"},
+	{Value: AdminEvent{}, Direction: "http"},
+	{Value: DespawnRequest{}, Direction: "http"},
+	{Value: DespawnResponse{}, Direction: "http"},
+}
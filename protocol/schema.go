@@ -0,0 +1,14 @@
+package protocol
+
+import _ "embed"
+
+// Schema is the JSON schema of all wire messages, generated by cmd/tsgen
+//
+//go:embed schema.json
+var Schema []byte
+
+// OpenAPI is the OpenAPI document of the HTTP API, generated by cmd/tsgen
+// from Endpoints
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -0,0 +1,3096 @@
+// The gRPC service of the simulation, for backend consumers, and the
+// binary frames of WebSocket clients that ask for the protobuf encoding.
+// Its messages mirror the JSON ones of the WebSocket and HTTP APIs field for
+// field. The Go types and service stubs in taxipb are generated from this
+// file (go generate ./protocol); generate a client with protoc for your
+// language, or call the service with grpcurl and this file.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: taxi.proto
+
+package taxipb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Location struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Location) Reset() {
+	*x = Location{}
+	mi := &file_taxi_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Location) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Location) ProtoMessage() {}
+
+func (x *Location) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Location.ProtoReflect.Descriptor instead.
+func (*Location) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Location) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Location) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+// A circular area
+type Region struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	Radius        float64                `protobuf:"fixed64,3,opt,name=radius,proto3" json:"radius,omitempty"` // in degrees
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Region) Reset() {
+	*x = Region{}
+	mi := &file_taxi_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Region) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Region) ProtoMessage() {}
+
+func (x *Region) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Region.ProtoReflect.Descriptor instead.
+func (*Region) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Region) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Region) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *Region) GetRadius() float64 {
+	if x != nil {
+		return x.Radius
+	}
+	return 0
+}
+
+type Driver struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Id       int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Lon      float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	Lat      float64                `protobuf:"fixed64,3,opt,name=lat,proto3" json:"lat,omitempty"`
+	Status   string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`                       // Available, Busy or Offline
+	Distance float64                `protobuf:"fixed64,5,opt,name=distance,proto3" json:"distance,omitempty"`                 // in km from the center of the query
+	Heading  float64                `protobuf:"fixed64,6,opt,name=heading,proto3" json:"heading,omitempty"`                   // direction in degrees (0-360)
+	Speed    float64                `protobuf:"fixed64,7,opt,name=speed,proto3" json:"speed,omitempty"`                       // in degrees of arc per second
+	Profile  string                 `protobuf:"bytes,8,opt,name=profile,proto3" json:"profile,omitempty"`                     // behavior archetype
+	Vehicle  string                 `protobuf:"bytes,9,opt,name=vehicle,proto3" json:"vehicle,omitempty"`                     // car, van or suv
+	Origin   string                 `protobuf:"bytes,10,opt,name=origin,proto3" json:"origin,omitempty"`                      // federation peer the driver comes from; empty for local drivers
+	RemoteId int64                  `protobuf:"varint,11,opt,name=remote_id,json=remoteId,proto3" json:"remote_id,omitempty"` // the driver's ID at its origin
+	// Unfiltered GPS fix, only when the server simulates GPS noise
+	RawLon float64 `protobuf:"fixed64,12,opt,name=raw_lon,json=rawLon,proto3" json:"raw_lon,omitempty"`
+	RawLat float64 `protobuf:"fixed64,13,opt,name=raw_lat,json=rawLat,proto3" json:"raw_lat,omitempty"`
+	// Velocity in degrees per second of real time, and the Unix
+	// milliseconds the position is as of
+	VelLon        float64 `protobuf:"fixed64,14,opt,name=vel_lon,json=velLon,proto3" json:"vel_lon,omitempty"`
+	VelLat        float64 `protobuf:"fixed64,15,opt,name=vel_lat,json=velLat,proto3" json:"vel_lat,omitempty"`
+	UpdatedAt     int64   `protobuf:"varint,16,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Driver) Reset() {
+	*x = Driver{}
+	mi := &file_taxi_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Driver) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Driver) ProtoMessage() {}
+
+func (x *Driver) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Driver.ProtoReflect.Descriptor instead.
+func (*Driver) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Driver) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Driver) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *Driver) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Driver) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Driver) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *Driver) GetHeading() float64 {
+	if x != nil {
+		return x.Heading
+	}
+	return 0
+}
+
+func (x *Driver) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *Driver) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Driver) GetVehicle() string {
+	if x != nil {
+		return x.Vehicle
+	}
+	return ""
+}
+
+func (x *Driver) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *Driver) GetRemoteId() int64 {
+	if x != nil {
+		return x.RemoteId
+	}
+	return 0
+}
+
+func (x *Driver) GetRawLon() float64 {
+	if x != nil {
+		return x.RawLon
+	}
+	return 0
+}
+
+func (x *Driver) GetRawLat() float64 {
+	if x != nil {
+		return x.RawLat
+	}
+	return 0
+}
+
+func (x *Driver) GetVelLon() float64 {
+	if x != nil {
+		return x.VelLon
+	}
+	return 0
+}
+
+func (x *Driver) GetVelLat() float64 {
+	if x != nil {
+		return x.VelLat
+	}
+	return 0
+}
+
+func (x *Driver) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+type DriversUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Drivers       []*Driver              `protobuf:"bytes,1,rep,name=drivers,proto3" json:"drivers,omitempty"`
+	Center        *Location              `protobuf:"bytes,2,opt,name=center,proto3" json:"center,omitempty"`
+	Radius        float64                `protobuf:"fixed64,3,opt,name=radius,proto3" json:"radius,omitempty"`                         // in degrees
+	Time          int64                  `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"`                              // Unix milliseconds
+	DataAgeMs     int64                  `protobuf:"varint,5,opt,name=data_age_ms,json=dataAgeMs,proto3" json:"data_age_ms,omitempty"` // age of the index positions
+	Seq           int64                  `protobuf:"varint,6,opt,name=seq,proto3" json:"seq,omitempty"`                                // sequence number of a snapshot, for clients that asked for deltas
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriversUpdate) Reset() {
+	*x = DriversUpdate{}
+	mi := &file_taxi_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriversUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriversUpdate) ProtoMessage() {}
+
+func (x *DriversUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriversUpdate.ProtoReflect.Descriptor instead.
+func (*DriversUpdate) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DriversUpdate) GetDrivers() []*Driver {
+	if x != nil {
+		return x.Drivers
+	}
+	return nil
+}
+
+func (x *DriversUpdate) GetCenter() *Location {
+	if x != nil {
+		return x.Center
+	}
+	return nil
+}
+
+func (x *DriversUpdate) GetRadius() float64 {
+	if x != nil {
+		return x.Radius
+	}
+	return 0
+}
+
+func (x *DriversUpdate) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *DriversUpdate) GetDataAgeMs() int64 {
+	if x != nil {
+		return x.DataAgeMs
+	}
+	return 0
+}
+
+func (x *DriversUpdate) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+// A driver that moved, in a delta
+type DriverMove struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	Lat           float64                `protobuf:"fixed64,3,opt,name=lat,proto3" json:"lat,omitempty"`
+	Heading       float64                `protobuf:"fixed64,4,opt,name=heading,proto3" json:"heading,omitempty"`
+	Speed         float64                `protobuf:"fixed64,5,opt,name=speed,proto3" json:"speed,omitempty"`
+	Distance      float64                `protobuf:"fixed64,6,opt,name=distance,proto3" json:"distance,omitempty"`
+	RawLon        float64                `protobuf:"fixed64,7,opt,name=raw_lon,json=rawLon,proto3" json:"raw_lon,omitempty"`
+	RawLat        float64                `protobuf:"fixed64,8,opt,name=raw_lat,json=rawLat,proto3" json:"raw_lat,omitempty"`
+	VelLon        float64                `protobuf:"fixed64,9,opt,name=vel_lon,json=velLon,proto3" json:"vel_lon,omitempty"`
+	VelLat        float64                `protobuf:"fixed64,10,opt,name=vel_lat,json=velLat,proto3" json:"vel_lat,omitempty"`
+	UpdatedAt     int64                  `protobuf:"varint,11,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriverMove) Reset() {
+	*x = DriverMove{}
+	mi := &file_taxi_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriverMove) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriverMove) ProtoMessage() {}
+
+func (x *DriverMove) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriverMove.ProtoReflect.Descriptor instead.
+func (*DriverMove) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DriverMove) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DriverMove) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *DriverMove) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *DriverMove) GetHeading() float64 {
+	if x != nil {
+		return x.Heading
+	}
+	return 0
+}
+
+func (x *DriverMove) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *DriverMove) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *DriverMove) GetRawLon() float64 {
+	if x != nil {
+		return x.RawLon
+	}
+	return 0
+}
+
+func (x *DriverMove) GetRawLat() float64 {
+	if x != nil {
+		return x.RawLat
+	}
+	return 0
+}
+
+func (x *DriverMove) GetVelLon() float64 {
+	if x != nil {
+		return x.VelLon
+	}
+	return 0
+}
+
+func (x *DriverMove) GetVelLat() float64 {
+	if x != nil {
+		return x.VelLat
+	}
+	return 0
+}
+
+func (x *DriverMove) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+// The new status of a driver, in a delta
+type DriverStatusChange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriverStatusChange) Reset() {
+	*x = DriverStatusChange{}
+	mi := &file_taxi_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriverStatusChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriverStatusChange) ProtoMessage() {}
+
+func (x *DriverStatusChange) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriverStatusChange.ProtoReflect.Descriptor instead.
+func (*DriverStatusChange) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DriverStatusChange) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *DriverStatusChange) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+// The changes since the previous snapshot or delta
+type DriversDelta struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Appeared      []*Driver              `protobuf:"bytes,1,rep,name=appeared,proto3" json:"appeared,omitempty"`
+	Moved         []*DriverMove          `protobuf:"bytes,2,rep,name=moved,proto3" json:"moved,omitempty"`
+	StatusChanged []*DriverStatusChange  `protobuf:"bytes,3,rep,name=status_changed,json=statusChanged,proto3" json:"status_changed,omitempty"`
+	Disappeared   []int64                `protobuf:"varint,4,rep,packed,name=disappeared,proto3" json:"disappeared,omitempty"`
+	Count         int32                  `protobuf:"varint,5,opt,name=count,proto3" json:"count,omitempty"` // drivers in the area after applying the delta
+	Time          int64                  `protobuf:"varint,6,opt,name=time,proto3" json:"time,omitempty"`
+	DataAgeMs     int64                  `protobuf:"varint,7,opt,name=data_age_ms,json=dataAgeMs,proto3" json:"data_age_ms,omitempty"`
+	Seq           int64                  `protobuf:"varint,8,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriversDelta) Reset() {
+	*x = DriversDelta{}
+	mi := &file_taxi_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriversDelta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriversDelta) ProtoMessage() {}
+
+func (x *DriversDelta) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriversDelta.ProtoReflect.Descriptor instead.
+func (*DriversDelta) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DriversDelta) GetAppeared() []*Driver {
+	if x != nil {
+		return x.Appeared
+	}
+	return nil
+}
+
+func (x *DriversDelta) GetMoved() []*DriverMove {
+	if x != nil {
+		return x.Moved
+	}
+	return nil
+}
+
+func (x *DriversDelta) GetStatusChanged() []*DriverStatusChange {
+	if x != nil {
+		return x.StatusChanged
+	}
+	return nil
+}
+
+func (x *DriversDelta) GetDisappeared() []int64 {
+	if x != nil {
+		return x.Disappeared
+	}
+	return nil
+}
+
+func (x *DriversDelta) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *DriversDelta) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *DriversDelta) GetDataAgeMs() int64 {
+	if x != nil {
+		return x.DataAgeMs
+	}
+	return 0
+}
+
+func (x *DriversDelta) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+// The IDs of a connection's subscriptions
+type Subscriptions struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Active        []string               `protobuf:"bytes,1,rep,name=active,proto3" json:"active,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Subscriptions) Reset() {
+	*x = Subscriptions{}
+	mi := &file_taxi_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Subscriptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Subscriptions) ProtoMessage() {}
+
+func (x *Subscriptions) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Subscriptions.ProtoReflect.Descriptor instead.
+func (*Subscriptions) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Subscriptions) GetActive() []string {
+	if x != nil {
+		return x.Active
+	}
+	return nil
+}
+
+type NearbyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	Radius        float64                `protobuf:"fixed64,3,opt,name=radius,proto3" json:"radius,omitempty"` // in degrees; 0 is the server's default
+	City          string                 `protobuf:"bytes,4,opt,name=city,proto3" json:"city,omitempty"`       // searches the city's drivers around its center instead
+	Filter        string                 `protobuf:"bytes,5,opt,name=filter,proto3" json:"filter,omitempty"`   // filter expression, e.g. vehicle == "van"
+	Sort          string                 `protobuf:"bytes,6,opt,name=sort,proto3" json:"sort,omitempty"`       // distance (the default), id or status
+	Limit         int32                  `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`    // drivers per page; 0 returns them all
+	Offset        int32                  `protobuf:"varint,8,opt,name=offset,proto3" json:"offset,omitempty"`  // drivers to skip, e.g. next_offset of the previous page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NearbyRequest) Reset() {
+	*x = NearbyRequest{}
+	mi := &file_taxi_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NearbyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NearbyRequest) ProtoMessage() {}
+
+func (x *NearbyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NearbyRequest.ProtoReflect.Descriptor instead.
+func (*NearbyRequest) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *NearbyRequest) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *NearbyRequest) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *NearbyRequest) GetRadius() float64 {
+	if x != nil {
+		return x.Radius
+	}
+	return 0
+}
+
+func (x *NearbyRequest) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *NearbyRequest) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *NearbyRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *NearbyRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *NearbyRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type DriversResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Drivers       []*Driver              `protobuf:"bytes,1,rep,name=drivers,proto3" json:"drivers,omitempty"`
+	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`                             // drivers matching the query, across pages
+	NextOffset    int32                  `protobuf:"varint,3,opt,name=next_offset,json=nextOffset,proto3" json:"next_offset,omitempty"` // 0 on the last page
+	Center        *Location              `protobuf:"bytes,4,opt,name=center,proto3" json:"center,omitempty"`
+	Radius        float64                `protobuf:"fixed64,5,opt,name=radius,proto3" json:"radius,omitempty"`
+	DataAgeMs     int64                  `protobuf:"varint,6,opt,name=data_age_ms,json=dataAgeMs,proto3" json:"data_age_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriversResponse) Reset() {
+	*x = DriversResponse{}
+	mi := &file_taxi_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriversResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriversResponse) ProtoMessage() {}
+
+func (x *DriversResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriversResponse.ProtoReflect.Descriptor instead.
+func (*DriversResponse) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DriversResponse) GetDrivers() []*Driver {
+	if x != nil {
+		return x.Drivers
+	}
+	return nil
+}
+
+func (x *DriversResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *DriversResponse) GetNextOffset() int32 {
+	if x != nil {
+		return x.NextOffset
+	}
+	return 0
+}
+
+func (x *DriversResponse) GetCenter() *Location {
+	if x != nil {
+		return x.Center
+	}
+	return nil
+}
+
+func (x *DriversResponse) GetRadius() float64 {
+	if x != nil {
+		return x.Radius
+	}
+	return 0
+}
+
+func (x *DriversResponse) GetDataAgeMs() int64 {
+	if x != nil {
+		return x.DataAgeMs
+	}
+	return 0
+}
+
+type RideRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pickup        *Location              `protobuf:"bytes,1,opt,name=pickup,proto3" json:"pickup,omitempty"`
+	Dropoff       *Location              `protobuf:"bytes,2,opt,name=dropoff,proto3" json:"dropoff,omitempty"` // the server picks one when left out
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RideRequest) Reset() {
+	*x = RideRequest{}
+	mi := &file_taxi_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RideRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RideRequest) ProtoMessage() {}
+
+func (x *RideRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RideRequest.ProtoReflect.Descriptor instead.
+func (*RideRequest) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RideRequest) GetPickup() *Location {
+	if x != nil {
+		return x.Pickup
+	}
+	return nil
+}
+
+func (x *RideRequest) GetDropoff() *Location {
+	if x != nil {
+		return x.Dropoff
+	}
+	return nil
+}
+
+type Fare struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Base          float64                `protobuf:"fixed64,1,opt,name=base,proto3" json:"base,omitempty"`
+	Distance      float64                `protobuf:"fixed64,2,opt,name=distance,proto3" json:"distance,omitempty"` // per-km charge
+	Time          float64                `protobuf:"fixed64,3,opt,name=time,proto3" json:"time,omitempty"`         // per-minute charge
+	Surge         float64                `protobuf:"fixed64,4,opt,name=surge,proto3" json:"surge,omitempty"`       // multiplier applied to the sum
+	Total         float64                `protobuf:"fixed64,5,opt,name=total,proto3" json:"total,omitempty"`
+	Currency      string                 `protobuf:"bytes,6,opt,name=currency,proto3" json:"currency,omitempty"`
+	DistanceKm    float64                `protobuf:"fixed64,7,opt,name=distance_km,json=distanceKm,proto3" json:"distance_km,omitempty"`
+	DurationMin   float64                `protobuf:"fixed64,8,opt,name=duration_min,json=durationMin,proto3" json:"duration_min,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Fare) Reset() {
+	*x = Fare{}
+	mi := &file_taxi_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Fare) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fare) ProtoMessage() {}
+
+func (x *Fare) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fare.ProtoReflect.Descriptor instead.
+func (*Fare) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Fare) GetBase() float64 {
+	if x != nil {
+		return x.Base
+	}
+	return 0
+}
+
+func (x *Fare) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *Fare) GetTime() float64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Fare) GetSurge() float64 {
+	if x != nil {
+		return x.Surge
+	}
+	return 0
+}
+
+func (x *Fare) GetTotal() float64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Fare) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Fare) GetDistanceKm() float64 {
+	if x != nil {
+		return x.DistanceKm
+	}
+	return 0
+}
+
+func (x *Fare) GetDurationMin() float64 {
+	if x != nil {
+		return x.DurationMin
+	}
+	return 0
+}
+
+type RideAssigned struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TripId        int64                  `protobuf:"varint,1,opt,name=trip_id,json=tripId,proto3" json:"trip_id,omitempty"`
+	Driver        *Driver                `protobuf:"bytes,2,opt,name=driver,proto3" json:"driver,omitempty"`
+	Pickup        *Location              `protobuf:"bytes,3,opt,name=pickup,proto3" json:"pickup,omitempty"`
+	Dropoff       *Location              `protobuf:"bytes,4,opt,name=dropoff,proto3" json:"dropoff,omitempty"`
+	EstimatedFare *Fare                  `protobuf:"bytes,5,opt,name=estimated_fare,json=estimatedFare,proto3" json:"estimated_fare,omitempty"`
+	PickupEtaS    float64                `protobuf:"fixed64,6,opt,name=pickup_eta_s,json=pickupEtaS,proto3" json:"pickup_eta_s,omitempty"` // expected drive to the pickup in seconds
+	Time          int64                  `protobuf:"varint,7,opt,name=time,proto3" json:"time,omitempty"`                                  // virtual Unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RideAssigned) Reset() {
+	*x = RideAssigned{}
+	mi := &file_taxi_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RideAssigned) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RideAssigned) ProtoMessage() {}
+
+func (x *RideAssigned) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RideAssigned.ProtoReflect.Descriptor instead.
+func (*RideAssigned) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *RideAssigned) GetTripId() int64 {
+	if x != nil {
+		return x.TripId
+	}
+	return 0
+}
+
+func (x *RideAssigned) GetDriver() *Driver {
+	if x != nil {
+		return x.Driver
+	}
+	return nil
+}
+
+func (x *RideAssigned) GetPickup() *Location {
+	if x != nil {
+		return x.Pickup
+	}
+	return nil
+}
+
+func (x *RideAssigned) GetDropoff() *Location {
+	if x != nil {
+		return x.Dropoff
+	}
+	return nil
+}
+
+func (x *RideAssigned) GetEstimatedFare() *Fare {
+	if x != nil {
+		return x.EstimatedFare
+	}
+	return nil
+}
+
+func (x *RideAssigned) GetPickupEtaS() float64 {
+	if x != nil {
+		return x.PickupEtaS
+	}
+	return 0
+}
+
+func (x *RideAssigned) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type TripEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Event         string                 `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`   // assigned, picked_up, completed or cancelled
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"` // why a trip was cancelled
+	TripId        int64                  `protobuf:"varint,3,opt,name=trip_id,json=tripId,proto3" json:"trip_id,omitempty"`
+	DriverId      int64                  `protobuf:"varint,4,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	Pickup        *Location              `protobuf:"bytes,5,opt,name=pickup,proto3" json:"pickup,omitempty"`
+	Dropoff       *Location              `protobuf:"bytes,6,opt,name=dropoff,proto3" json:"dropoff,omitempty"`
+	EstimatedFare *Fare                  `protobuf:"bytes,7,opt,name=estimated_fare,json=estimatedFare,proto3" json:"estimated_fare,omitempty"`
+	FinalFare     *Fare                  `protobuf:"bytes,8,opt,name=final_fare,json=finalFare,proto3" json:"final_fare,omitempty"` // completed trips only
+	Time          int64                  `protobuf:"varint,9,opt,name=time,proto3" json:"time,omitempty"`                           // virtual Unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TripEvent) Reset() {
+	*x = TripEvent{}
+	mi := &file_taxi_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TripEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TripEvent) ProtoMessage() {}
+
+func (x *TripEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TripEvent.ProtoReflect.Descriptor instead.
+func (*TripEvent) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *TripEvent) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *TripEvent) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *TripEvent) GetTripId() int64 {
+	if x != nil {
+		return x.TripId
+	}
+	return 0
+}
+
+func (x *TripEvent) GetDriverId() int64 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+func (x *TripEvent) GetPickup() *Location {
+	if x != nil {
+		return x.Pickup
+	}
+	return nil
+}
+
+func (x *TripEvent) GetDropoff() *Location {
+	if x != nil {
+		return x.Dropoff
+	}
+	return nil
+}
+
+func (x *TripEvent) GetEstimatedFare() *Fare {
+	if x != nil {
+		return x.EstimatedFare
+	}
+	return nil
+}
+
+func (x *TripEvent) GetFinalFare() *Fare {
+	if x != nil {
+		return x.FinalFare
+	}
+	return nil
+}
+
+func (x *TripEvent) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+// Where the driver of a ride is, for the rider
+type RideProgress struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TripId        int64                  `protobuf:"varint,1,opt,name=trip_id,json=tripId,proto3" json:"trip_id,omitempty"`
+	State         string                 `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`   // assigned, picked_up, completed or cancelled
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"` // why the trip was cancelled
+	DriverId      int64                  `protobuf:"varint,4,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	Lon           float64                `protobuf:"fixed64,5,opt,name=lon,proto3" json:"lon,omitempty"`
+	Lat           float64                `protobuf:"fixed64,6,opt,name=lat,proto3" json:"lat,omitempty"`
+	Heading       float64                `protobuf:"fixed64,7,opt,name=heading,proto3" json:"heading,omitempty"`
+	DistanceKm    float64                `protobuf:"fixed64,8,opt,name=distance_km,json=distanceKm,proto3" json:"distance_km,omitempty"` // straight-line distance left to the pickup, or to the drop-off once picked up
+	FinalFare     *Fare                  `protobuf:"bytes,9,opt,name=final_fare,json=finalFare,proto3" json:"final_fare,omitempty"`
+	Time          int64                  `protobuf:"varint,10,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RideProgress) Reset() {
+	*x = RideProgress{}
+	mi := &file_taxi_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RideProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RideProgress) ProtoMessage() {}
+
+func (x *RideProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RideProgress.ProtoReflect.Descriptor instead.
+func (*RideProgress) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RideProgress) GetTripId() int64 {
+	if x != nil {
+		return x.TripId
+	}
+	return 0
+}
+
+func (x *RideProgress) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *RideProgress) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *RideProgress) GetDriverId() int64 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+func (x *RideProgress) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
+func (x *RideProgress) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *RideProgress) GetHeading() float64 {
+	if x != nil {
+		return x.Heading
+	}
+	return 0
+}
+
+func (x *RideProgress) GetDistanceKm() float64 {
+	if x != nil {
+		return x.DistanceKm
+	}
+	return 0
+}
+
+func (x *RideProgress) GetFinalFare() *Fare {
+	if x != nil {
+		return x.FinalFare
+	}
+	return nil
+}
+
+func (x *RideProgress) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type DriverStatusChanged struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DriverId      int64                  `protobuf:"varint,1,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	OldStatus     string                 `protobuf:"bytes,2,opt,name=old_status,json=oldStatus,proto3" json:"old_status,omitempty"`
+	NewStatus     string                 `protobuf:"bytes,3,opt,name=new_status,json=newStatus,proto3" json:"new_status,omitempty"`
+	Time          int64                  `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"` // virtual Unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DriverStatusChanged) Reset() {
+	*x = DriverStatusChanged{}
+	mi := &file_taxi_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DriverStatusChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DriverStatusChanged) ProtoMessage() {}
+
+func (x *DriverStatusChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DriverStatusChanged.ProtoReflect.Descriptor instead.
+func (*DriverStatusChanged) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *DriverStatusChanged) GetDriverId() int64 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+func (x *DriverStatusChanged) GetOldStatus() string {
+	if x != nil {
+		return x.OldStatus
+	}
+	return ""
+}
+
+func (x *DriverStatusChanged) GetNewStatus() string {
+	if x != nil {
+		return x.NewStatus
+	}
+	return ""
+}
+
+func (x *DriverStatusChanged) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_taxi_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{16}
+}
+
+type CityStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	City          string                 `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	Drivers       int32                  `protobuf:"varint,2,opt,name=drivers,proto3" json:"drivers,omitempty"`
+	Available     int32                  `protobuf:"varint,3,opt,name=available,proto3" json:"available,omitempty"`
+	Busy          int32                  `protobuf:"varint,4,opt,name=busy,proto3" json:"busy,omitempty"`
+	Offline       int32                  `protobuf:"varint,5,opt,name=offline,proto3" json:"offline,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CityStats) Reset() {
+	*x = CityStats{}
+	mi := &file_taxi_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CityStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CityStats) ProtoMessage() {}
+
+func (x *CityStats) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CityStats.ProtoReflect.Descriptor instead.
+func (*CityStats) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CityStats) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *CityStats) GetDrivers() int32 {
+	if x != nil {
+		return x.Drivers
+	}
+	return 0
+}
+
+func (x *CityStats) GetAvailable() int32 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *CityStats) GetBusy() int32 {
+	if x != nil {
+		return x.Busy
+	}
+	return 0
+}
+
+func (x *CityStats) GetOffline() int32 {
+	if x != nil {
+		return x.Offline
+	}
+	return 0
+}
+
+// The counters of /api/stats; its standby pools, index, message and
+// broadcast details are only served as JSON
+type Stats struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	UptimeS          float64                `protobuf:"fixed64,1,opt,name=uptime_s,json=uptimeS,proto3" json:"uptime_s,omitempty"`
+	VirtualTime      int64                  `protobuf:"varint,2,opt,name=virtual_time,json=virtualTime,proto3" json:"virtual_time,omitempty"`
+	Speed            float64                `protobuf:"fixed64,3,opt,name=speed,proto3" json:"speed,omitempty"`
+	Clients          int32                  `protobuf:"varint,4,opt,name=clients,proto3" json:"clients,omitempty"`
+	AvailableDrivers int32                  `protobuf:"varint,5,opt,name=available_drivers,json=availableDrivers,proto3" json:"available_drivers,omitempty"`
+	BusyDrivers      int32                  `protobuf:"varint,6,opt,name=busy_drivers,json=busyDrivers,proto3" json:"busy_drivers,omitempty"`
+	OfflineDrivers   int32                  `protobuf:"varint,7,opt,name=offline_drivers,json=offlineDrivers,proto3" json:"offline_drivers,omitempty"`
+	Profiles         map[string]int32       `protobuf:"bytes,8,rep,name=profiles,proto3" json:"profiles,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	Queries          int32                  `protobuf:"varint,9,opt,name=queries,proto3" json:"queries,omitempty"`
+	DriversPerQuery  float64                `protobuf:"fixed64,10,opt,name=drivers_per_query,json=driversPerQuery,proto3" json:"drivers_per_query,omitempty"`
+	AvgQueryTimeMs   float64                `protobuf:"fixed64,11,opt,name=avg_query_time_ms,json=avgQueryTimeMs,proto3" json:"avg_query_time_ms,omitempty"`
+	IndexRebuilds    int64                  `protobuf:"varint,12,opt,name=index_rebuilds,json=indexRebuilds,proto3" json:"index_rebuilds,omitempty"`
+	IndexAgeMs       int64                  `protobuf:"varint,13,opt,name=index_age_ms,json=indexAgeMs,proto3" json:"index_age_ms,omitempty"`
+	RideRequests     int32                  `protobuf:"varint,14,opt,name=ride_requests,json=rideRequests,proto3" json:"ride_requests,omitempty"`
+	UnservedRequests int32                  `protobuf:"varint,15,opt,name=unserved_requests,json=unservedRequests,proto3" json:"unserved_requests,omitempty"`
+	InstantMatches   int32                  `protobuf:"varint,16,opt,name=instant_matches,json=instantMatches,proto3" json:"instant_matches,omitempty"`
+	DeclinedOffers   int32                  `protobuf:"varint,17,opt,name=declined_offers,json=declinedOffers,proto3" json:"declined_offers,omitempty"`
+	ActiveShocks     int32                  `protobuf:"varint,18,opt,name=active_shocks,json=activeShocks,proto3" json:"active_shocks,omitempty"`
+	TripsInProgress  int32                  `protobuf:"varint,19,opt,name=trips_in_progress,json=tripsInProgress,proto3" json:"trips_in_progress,omitempty"`
+	CompletedTrips   int32                  `protobuf:"varint,20,opt,name=completed_trips,json=completedTrips,proto3" json:"completed_trips,omitempty"`
+	CancelledTrips   int32                  `protobuf:"varint,21,opt,name=cancelled_trips,json=cancelledTrips,proto3" json:"cancelled_trips,omitempty"`
+	NoShows          int32                  `protobuf:"varint,22,opt,name=no_shows,json=noShows,proto3" json:"no_shows,omitempty"`
+	Revenue          float64                `protobuf:"fixed64,23,opt,name=revenue,proto3" json:"revenue,omitempty"`
+	Currency         string                 `protobuf:"bytes,24,opt,name=currency,proto3" json:"currency,omitempty"`
+	Cities           []*CityStats           `protobuf:"bytes,25,rep,name=cities,proto3" json:"cities,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_taxi_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *Stats) GetUptimeS() float64 {
+	if x != nil {
+		return x.UptimeS
+	}
+	return 0
+}
+
+func (x *Stats) GetVirtualTime() int64 {
+	if x != nil {
+		return x.VirtualTime
+	}
+	return 0
+}
+
+func (x *Stats) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *Stats) GetClients() int32 {
+	if x != nil {
+		return x.Clients
+	}
+	return 0
+}
+
+func (x *Stats) GetAvailableDrivers() int32 {
+	if x != nil {
+		return x.AvailableDrivers
+	}
+	return 0
+}
+
+func (x *Stats) GetBusyDrivers() int32 {
+	if x != nil {
+		return x.BusyDrivers
+	}
+	return 0
+}
+
+func (x *Stats) GetOfflineDrivers() int32 {
+	if x != nil {
+		return x.OfflineDrivers
+	}
+	return 0
+}
+
+func (x *Stats) GetProfiles() map[string]int32 {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+func (x *Stats) GetQueries() int32 {
+	if x != nil {
+		return x.Queries
+	}
+	return 0
+}
+
+func (x *Stats) GetDriversPerQuery() float64 {
+	if x != nil {
+		return x.DriversPerQuery
+	}
+	return 0
+}
+
+func (x *Stats) GetAvgQueryTimeMs() float64 {
+	if x != nil {
+		return x.AvgQueryTimeMs
+	}
+	return 0
+}
+
+func (x *Stats) GetIndexRebuilds() int64 {
+	if x != nil {
+		return x.IndexRebuilds
+	}
+	return 0
+}
+
+func (x *Stats) GetIndexAgeMs() int64 {
+	if x != nil {
+		return x.IndexAgeMs
+	}
+	return 0
+}
+
+func (x *Stats) GetRideRequests() int32 {
+	if x != nil {
+		return x.RideRequests
+	}
+	return 0
+}
+
+func (x *Stats) GetUnservedRequests() int32 {
+	if x != nil {
+		return x.UnservedRequests
+	}
+	return 0
+}
+
+func (x *Stats) GetInstantMatches() int32 {
+	if x != nil {
+		return x.InstantMatches
+	}
+	return 0
+}
+
+func (x *Stats) GetDeclinedOffers() int32 {
+	if x != nil {
+		return x.DeclinedOffers
+	}
+	return 0
+}
+
+func (x *Stats) GetActiveShocks() int32 {
+	if x != nil {
+		return x.ActiveShocks
+	}
+	return 0
+}
+
+func (x *Stats) GetTripsInProgress() int32 {
+	if x != nil {
+		return x.TripsInProgress
+	}
+	return 0
+}
+
+func (x *Stats) GetCompletedTrips() int32 {
+	if x != nil {
+		return x.CompletedTrips
+	}
+	return 0
+}
+
+func (x *Stats) GetCancelledTrips() int32 {
+	if x != nil {
+		return x.CancelledTrips
+	}
+	return 0
+}
+
+func (x *Stats) GetNoShows() int32 {
+	if x != nil {
+		return x.NoShows
+	}
+	return 0
+}
+
+func (x *Stats) GetRevenue() float64 {
+	if x != nil {
+		return x.Revenue
+	}
+	return 0
+}
+
+func (x *Stats) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Stats) GetCities() []*CityStats {
+	if x != nil {
+		return x.Cities
+	}
+	return nil
+}
+
+// A binary WebSocket frame of a client that asked for the protobuf
+// encoding. Messages without a field here stay JSON text frames.
+type Frame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // the id of the command this answers, if any
+	// Types that are valid to be assigned to Message:
+	//
+	//	*Frame_DriversUpdate
+	//	*Frame_DriversSnapshot
+	//	*Frame_DriversDelta
+	//	*Frame_Subscriptions
+	//	*Frame_TripEvent
+	//	*Frame_RideAssigned
+	//	*Frame_RideProgress
+	//	*Frame_DriverStatusChanged
+	Message       isFrame_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Frame) Reset() {
+	*x = Frame{}
+	mi := &file_taxi_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frame) ProtoMessage() {}
+
+func (x *Frame) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frame.ProtoReflect.Descriptor instead.
+func (*Frame) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *Frame) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Frame) GetMessage() isFrame_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *Frame) GetDriversUpdate() *DriversUpdate {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_DriversUpdate); ok {
+			return x.DriversUpdate
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetDriversSnapshot() *DriversUpdate {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_DriversSnapshot); ok {
+			return x.DriversSnapshot
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetDriversDelta() *DriversDelta {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_DriversDelta); ok {
+			return x.DriversDelta
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetSubscriptions() *Subscriptions {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_Subscriptions); ok {
+			return x.Subscriptions
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetTripEvent() *TripEvent {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_TripEvent); ok {
+			return x.TripEvent
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetRideAssigned() *RideAssigned {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_RideAssigned); ok {
+			return x.RideAssigned
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetRideProgress() *RideProgress {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_RideProgress); ok {
+			return x.RideProgress
+		}
+	}
+	return nil
+}
+
+func (x *Frame) GetDriverStatusChanged() *DriverStatusChanged {
+	if x != nil {
+		if x, ok := x.Message.(*Frame_DriverStatusChanged); ok {
+			return x.DriverStatusChanged
+		}
+	}
+	return nil
+}
+
+type isFrame_Message interface {
+	isFrame_Message()
+}
+
+type Frame_DriversUpdate struct {
+	DriversUpdate *DriversUpdate `protobuf:"bytes,2,opt,name=drivers_update,json=driversUpdate,proto3,oneof"`
+}
+
+type Frame_DriversSnapshot struct {
+	DriversSnapshot *DriversUpdate `protobuf:"bytes,3,opt,name=drivers_snapshot,json=driversSnapshot,proto3,oneof"`
+}
+
+type Frame_DriversDelta struct {
+	DriversDelta *DriversDelta `protobuf:"bytes,4,opt,name=drivers_delta,json=driversDelta,proto3,oneof"`
+}
+
+type Frame_Subscriptions struct {
+	Subscriptions *Subscriptions `protobuf:"bytes,5,opt,name=subscriptions,proto3,oneof"`
+}
+
+type Frame_TripEvent struct {
+	TripEvent *TripEvent `protobuf:"bytes,6,opt,name=trip_event,json=tripEvent,proto3,oneof"`
+}
+
+type Frame_RideAssigned struct {
+	RideAssigned *RideAssigned `protobuf:"bytes,7,opt,name=ride_assigned,json=rideAssigned,proto3,oneof"`
+}
+
+type Frame_RideProgress struct {
+	RideProgress *RideProgress `protobuf:"bytes,8,opt,name=ride_progress,json=rideProgress,proto3,oneof"`
+}
+
+type Frame_DriverStatusChanged struct {
+	DriverStatusChanged *DriverStatusChanged `protobuf:"bytes,9,opt,name=driver_status_changed,json=driverStatusChanged,proto3,oneof"`
+}
+
+func (*Frame_DriversUpdate) isFrame_Message() {}
+
+func (*Frame_DriversSnapshot) isFrame_Message() {}
+
+func (*Frame_DriversDelta) isFrame_Message() {}
+
+func (*Frame_Subscriptions) isFrame_Message() {}
+
+func (*Frame_TripEvent) isFrame_Message() {}
+
+func (*Frame_RideAssigned) isFrame_Message() {}
+
+func (*Frame_RideProgress) isFrame_Message() {}
+
+func (*Frame_DriverStatusChanged) isFrame_Message() {}
+
+// Negotiates the protocol version and capabilities, like hello
+type Hello struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       int32                  `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`          // highest version the client speaks
+	Capabilities  []string               `protobuf:"bytes,2,rep,name=capabilities,proto3" json:"capabilities,omitempty"` // empty takes all the server offers
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Hello) Reset() {
+	*x = Hello{}
+	mi := &file_taxi_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hello) ProtoMessage() {}
+
+func (x *Hello) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hello.ProtoReflect.Descriptor instead.
+func (*Hello) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Hello) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Hello) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+// The driver statuses of a ClientParams
+type StatusList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Statuses      []string               `protobuf:"bytes,1,rep,name=statuses,proto3" json:"statuses,omitempty"` // Available, Busy or Offline; empty sends every status
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusList) Reset() {
+	*x = StatusList{}
+	mi := &file_taxi_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusList) ProtoMessage() {}
+
+func (x *StatusList) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusList.ProtoReflect.Descriptor instead.
+func (*StatusList) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *StatusList) GetStatuses() []string {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+// Sets the area a client receives updates for, like client_params. Fields
+// left out keep their current values.
+type ClientParams struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           *float64               `protobuf:"fixed64,1,opt,name=lat,proto3,oneof" json:"lat,omitempty"`
+	Lon           *float64               `protobuf:"fixed64,2,opt,name=lon,proto3,oneof" json:"lon,omitempty"`
+	Radius        *float64               `protobuf:"fixed64,3,opt,name=radius,proto3,oneof" json:"radius,omitempty"` // in degrees
+	City          *string                `protobuf:"bytes,4,opt,name=city,proto3,oneof" json:"city,omitempty"`       // overrides lat/lon with the city center
+	Filter        *string                `protobuf:"bytes,5,opt,name=filter,proto3,oneof" json:"filter,omitempty"`   // filter expression; empty removes the filter
+	Statuses      *StatusList            `protobuf:"bytes,6,opt,name=statuses,proto3" json:"statuses,omitempty"`
+	Deltas        *bool                  `protobuf:"varint,7,opt,name=deltas,proto3,oneof" json:"deltas,omitempty"`
+	Encoding      *string                `protobuf:"bytes,8,opt,name=encoding,proto3,oneof" json:"encoding,omitempty"`                  // json, msgpack or protobuf
+	IntervalMs    int32                  `protobuf:"varint,9,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"` // 0 keeps the current interval
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientParams) Reset() {
+	*x = ClientParams{}
+	mi := &file_taxi_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientParams) ProtoMessage() {}
+
+func (x *ClientParams) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientParams.ProtoReflect.Descriptor instead.
+func (*ClientParams) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ClientParams) GetLat() float64 {
+	if x != nil && x.Lat != nil {
+		return *x.Lat
+	}
+	return 0
+}
+
+func (x *ClientParams) GetLon() float64 {
+	if x != nil && x.Lon != nil {
+		return *x.Lon
+	}
+	return 0
+}
+
+func (x *ClientParams) GetRadius() float64 {
+	if x != nil && x.Radius != nil {
+		return *x.Radius
+	}
+	return 0
+}
+
+func (x *ClientParams) GetCity() string {
+	if x != nil && x.City != nil {
+		return *x.City
+	}
+	return ""
+}
+
+func (x *ClientParams) GetFilter() string {
+	if x != nil && x.Filter != nil {
+		return *x.Filter
+	}
+	return ""
+}
+
+func (x *ClientParams) GetStatuses() *StatusList {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+func (x *ClientParams) GetDeltas() bool {
+	if x != nil && x.Deltas != nil {
+		return *x.Deltas
+	}
+	return false
+}
+
+func (x *ClientParams) GetEncoding() string {
+	if x != nil && x.Encoding != nil {
+		return *x.Encoding
+	}
+	return ""
+}
+
+func (x *ClientParams) GetIntervalMs() int32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+// The rectangle a map shows; west is greater than east across the
+// antimeridian
+type Viewport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	South         float64                `protobuf:"fixed64,1,opt,name=south,proto3" json:"south,omitempty"`
+	West          float64                `protobuf:"fixed64,2,opt,name=west,proto3" json:"west,omitempty"`
+	North         float64                `protobuf:"fixed64,3,opt,name=north,proto3" json:"north,omitempty"`
+	East          float64                `protobuf:"fixed64,4,opt,name=east,proto3" json:"east,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Viewport) Reset() {
+	*x = Viewport{}
+	mi := &file_taxi_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Viewport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Viewport) ProtoMessage() {}
+
+func (x *Viewport) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Viewport.ProtoReflect.Descriptor instead.
+func (*Viewport) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *Viewport) GetSouth() float64 {
+	if x != nil {
+		return x.South
+	}
+	return 0
+}
+
+func (x *Viewport) GetWest() float64 {
+	if x != nil {
+		return x.West
+	}
+	return 0
+}
+
+func (x *Viewport) GetNorth() float64 {
+	if x != nil {
+		return x.North
+	}
+	return 0
+}
+
+func (x *Viewport) GetEast() float64 {
+	if x != nil {
+		return x.East
+	}
+	return 0
+}
+
+// Adds a subscription, or replaces the one with the same id, like
+// subscribe. It selects drivers by at most one of region, viewport, city
+// or drivers.
+type Subscribe struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Region        *Region                `protobuf:"bytes,2,opt,name=region,proto3" json:"region,omitempty"`
+	Viewport      *Viewport              `protobuf:"bytes,3,opt,name=viewport,proto3" json:"viewport,omitempty"`
+	City          string                 `protobuf:"bytes,4,opt,name=city,proto3" json:"city,omitempty"`
+	Drivers       []int64                `protobuf:"varint,5,rep,packed,name=drivers,proto3" json:"drivers,omitempty"`
+	Statuses      []string               `protobuf:"bytes,6,rep,name=statuses,proto3" json:"statuses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Subscribe) Reset() {
+	*x = Subscribe{}
+	mi := &file_taxi_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Subscribe) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Subscribe) ProtoMessage() {}
+
+func (x *Subscribe) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Subscribe.ProtoReflect.Descriptor instead.
+func (*Subscribe) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *Subscribe) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Subscribe) GetRegion() *Region {
+	if x != nil {
+		return x.Region
+	}
+	return nil
+}
+
+func (x *Subscribe) GetViewport() *Viewport {
+	if x != nil {
+		return x.Viewport
+	}
+	return nil
+}
+
+func (x *Subscribe) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *Subscribe) GetDrivers() []int64 {
+	if x != nil {
+		return x.Drivers
+	}
+	return nil
+}
+
+func (x *Subscribe) GetStatuses() []string {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+// Removes a subscription; an empty id removes all of them
+type Unsubscribe struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Unsubscribe) Reset() {
+	*x = Unsubscribe{}
+	mi := &file_taxi_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unsubscribe) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unsubscribe) ProtoMessage() {}
+
+func (x *Unsubscribe) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unsubscribe.ProtoReflect.Descriptor instead.
+func (*Unsubscribe) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *Unsubscribe) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// Asks for one driver's position on every simulation update
+type Follow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DriverId      int64                  `protobuf:"varint,1,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Follow) Reset() {
+	*x = Follow{}
+	mi := &file_taxi_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Follow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Follow) ProtoMessage() {}
+
+func (x *Follow) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Follow.ProtoReflect.Descriptor instead.
+func (*Follow) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *Follow) GetDriverId() int64 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+// Stops following a driver; 0 stops following all of them
+type Unfollow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DriverId      int64                  `protobuf:"varint,1,opt,name=driver_id,json=driverId,proto3" json:"driver_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Unfollow) Reset() {
+	*x = Unfollow{}
+	mi := &file_taxi_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unfollow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unfollow) ProtoMessage() {}
+
+func (x *Unfollow) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unfollow.ProtoReflect.Descriptor instead.
+func (*Unfollow) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *Unfollow) GetDriverId() int64 {
+	if x != nil {
+		return x.DriverId
+	}
+	return 0
+}
+
+// Asks for a new snapshot
+type Resync struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Resync) Reset() {
+	*x = Resync{}
+	mi := &file_taxi_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Resync) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Resync) ProtoMessage() {}
+
+func (x *Resync) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Resync.ProtoReflect.Descriptor instead.
+func (*Resync) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{28}
+}
+
+// A binary WebSocket frame a client that asked for the protobuf encoding
+// may send instead of a JSON text message
+type ClientFrame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // correlation id, echoed on the reply
+	// Types that are valid to be assigned to Message:
+	//
+	//	*ClientFrame_Hello
+	//	*ClientFrame_ClientParams
+	//	*ClientFrame_Subscribe
+	//	*ClientFrame_Unsubscribe
+	//	*ClientFrame_Follow
+	//	*ClientFrame_Unfollow
+	//	*ClientFrame_Resync
+	//	*ClientFrame_RequestRide
+	Message       isClientFrame_Message `protobuf_oneof:"message"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientFrame) Reset() {
+	*x = ClientFrame{}
+	mi := &file_taxi_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientFrame) ProtoMessage() {}
+
+func (x *ClientFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_taxi_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientFrame.ProtoReflect.Descriptor instead.
+func (*ClientFrame) Descriptor() ([]byte, []int) {
+	return file_taxi_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *ClientFrame) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ClientFrame) GetMessage() isClientFrame_Message {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetHello() *Hello {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Hello); ok {
+			return x.Hello
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetClientParams() *ClientParams {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_ClientParams); ok {
+			return x.ClientParams
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetSubscribe() *Subscribe {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Subscribe); ok {
+			return x.Subscribe
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetUnsubscribe() *Unsubscribe {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Unsubscribe); ok {
+			return x.Unsubscribe
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetFollow() *Follow {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Follow); ok {
+			return x.Follow
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetUnfollow() *Unfollow {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Unfollow); ok {
+			return x.Unfollow
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetResync() *Resync {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_Resync); ok {
+			return x.Resync
+		}
+	}
+	return nil
+}
+
+func (x *ClientFrame) GetRequestRide() *RideRequest {
+	if x != nil {
+		if x, ok := x.Message.(*ClientFrame_RequestRide); ok {
+			return x.RequestRide
+		}
+	}
+	return nil
+}
+
+type isClientFrame_Message interface {
+	isClientFrame_Message()
+}
+
+type ClientFrame_Hello struct {
+	Hello *Hello `protobuf:"bytes,2,opt,name=hello,proto3,oneof"`
+}
+
+type ClientFrame_ClientParams struct {
+	ClientParams *ClientParams `protobuf:"bytes,3,opt,name=client_params,json=clientParams,proto3,oneof"`
+}
+
+type ClientFrame_Subscribe struct {
+	Subscribe *Subscribe `protobuf:"bytes,4,opt,name=subscribe,proto3,oneof"`
+}
+
+type ClientFrame_Unsubscribe struct {
+	Unsubscribe *Unsubscribe `protobuf:"bytes,5,opt,name=unsubscribe,proto3,oneof"`
+}
+
+type ClientFrame_Follow struct {
+	Follow *Follow `protobuf:"bytes,6,opt,name=follow,proto3,oneof"`
+}
+
+type ClientFrame_Unfollow struct {
+	Unfollow *Unfollow `protobuf:"bytes,7,opt,name=unfollow,proto3,oneof"`
+}
+
+type ClientFrame_Resync struct {
+	Resync *Resync `protobuf:"bytes,8,opt,name=resync,proto3,oneof"`
+}
+
+type ClientFrame_RequestRide struct {
+	RequestRide *RideRequest `protobuf:"bytes,9,opt,name=request_ride,json=requestRide,proto3,oneof"`
+}
+
+func (*ClientFrame_Hello) isClientFrame_Message() {}
+
+func (*ClientFrame_ClientParams) isClientFrame_Message() {}
+
+func (*ClientFrame_Subscribe) isClientFrame_Message() {}
+
+func (*ClientFrame_Unsubscribe) isClientFrame_Message() {}
+
+func (*ClientFrame_Follow) isClientFrame_Message() {}
+
+func (*ClientFrame_Unfollow) isClientFrame_Message() {}
+
+func (*ClientFrame_Resync) isClientFrame_Message() {}
+
+func (*ClientFrame_RequestRide) isClientFrame_Message() {}
+
+var File_taxi_proto protoreflect.FileDescriptor
+
+const file_taxi_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"taxi.proto\x12\ataxi.v1\".\n" +
+	"\bLocation\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\"D\n" +
+	"\x06Region\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\x12\x16\n" +
+	"\x06radius\x18\x03 \x01(\x01R\x06radius\"\x8c\x03\n" +
+	"\x06Driver\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\x12\x10\n" +
+	"\x03lat\x18\x03 \x01(\x01R\x03lat\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1a\n" +
+	"\bdistance\x18\x05 \x01(\x01R\bdistance\x12\x18\n" +
+	"\aheading\x18\x06 \x01(\x01R\aheading\x12\x14\n" +
+	"\x05speed\x18\a \x01(\x01R\x05speed\x12\x18\n" +
+	"\aprofile\x18\b \x01(\tR\aprofile\x12\x18\n" +
+	"\avehicle\x18\t \x01(\tR\avehicle\x12\x16\n" +
+	"\x06origin\x18\n" +
+	" \x01(\tR\x06origin\x12\x1b\n" +
+	"\tremote_id\x18\v \x01(\x03R\bremoteId\x12\x17\n" +
+	"\araw_lon\x18\f \x01(\x01R\x06rawLon\x12\x17\n" +
+	"\araw_lat\x18\r \x01(\x01R\x06rawLat\x12\x17\n" +
+	"\avel_lon\x18\x0e \x01(\x01R\x06velLon\x12\x17\n" +
+	"\avel_lat\x18\x0f \x01(\x01R\x06velLat\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\x10 \x01(\x03R\tupdatedAt\"\xc3\x01\n" +
+	"\rDriversUpdate\x12)\n" +
+	"\adrivers\x18\x01 \x03(\v2\x0f.taxi.v1.DriverR\adrivers\x12)\n" +
+	"\x06center\x18\x02 \x01(\v2\x11.taxi.v1.LocationR\x06center\x12\x16\n" +
+	"\x06radius\x18\x03 \x01(\x01R\x06radius\x12\x12\n" +
+	"\x04time\x18\x04 \x01(\x03R\x04time\x12\x1e\n" +
+	"\vdata_age_ms\x18\x05 \x01(\x03R\tdataAgeMs\x12\x10\n" +
+	"\x03seq\x18\x06 \x01(\x03R\x03seq\"\x8f\x02\n" +
+	"\n" +
+	"DriverMove\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\x12\x10\n" +
+	"\x03lat\x18\x03 \x01(\x01R\x03lat\x12\x18\n" +
+	"\aheading\x18\x04 \x01(\x01R\aheading\x12\x14\n" +
+	"\x05speed\x18\x05 \x01(\x01R\x05speed\x12\x1a\n" +
+	"\bdistance\x18\x06 \x01(\x01R\bdistance\x12\x17\n" +
+	"\araw_lon\x18\a \x01(\x01R\x06rawLon\x12\x17\n" +
+	"\araw_lat\x18\b \x01(\x01R\x06rawLat\x12\x17\n" +
+	"\avel_lon\x18\t \x01(\x01R\x06velLon\x12\x17\n" +
+	"\avel_lat\x18\n" +
+	" \x01(\x01R\x06velLat\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\v \x01(\x03R\tupdatedAt\"<\n" +
+	"\x12DriverStatusChange\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\"\xa8\x02\n" +
+	"\fDriversDelta\x12+\n" +
+	"\bappeared\x18\x01 \x03(\v2\x0f.taxi.v1.DriverR\bappeared\x12)\n" +
+	"\x05moved\x18\x02 \x03(\v2\x13.taxi.v1.DriverMoveR\x05moved\x12B\n" +
+	"\x0estatus_changed\x18\x03 \x03(\v2\x1b.taxi.v1.DriverStatusChangeR\rstatusChanged\x12 \n" +
+	"\vdisappeared\x18\x04 \x03(\x03R\vdisappeared\x12\x14\n" +
+	"\x05count\x18\x05 \x01(\x05R\x05count\x12\x12\n" +
+	"\x04time\x18\x06 \x01(\x03R\x04time\x12\x1e\n" +
+	"\vdata_age_ms\x18\a \x01(\x03R\tdataAgeMs\x12\x10\n" +
+	"\x03seq\x18\b \x01(\x03R\x03seq\"'\n" +
+	"\rSubscriptions\x12\x16\n" +
+	"\x06active\x18\x01 \x03(\tR\x06active\"\xb9\x01\n" +
+	"\rNearbyRequest\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon\x12\x16\n" +
+	"\x06radius\x18\x03 \x01(\x01R\x06radius\x12\x12\n" +
+	"\x04city\x18\x04 \x01(\tR\x04city\x12\x16\n" +
+	"\x06filter\x18\x05 \x01(\tR\x06filter\x12\x12\n" +
+	"\x04sort\x18\x06 \x01(\tR\x04sort\x12\x14\n" +
+	"\x05limit\x18\a \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\b \x01(\x05R\x06offset\"\xd6\x01\n" +
+	"\x0fDriversResponse\x12)\n" +
+	"\adrivers\x18\x01 \x03(\v2\x0f.taxi.v1.DriverR\adrivers\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\x12\x1f\n" +
+	"\vnext_offset\x18\x03 \x01(\x05R\n" +
+	"nextOffset\x12)\n" +
+	"\x06center\x18\x04 \x01(\v2\x11.taxi.v1.LocationR\x06center\x12\x16\n" +
+	"\x06radius\x18\x05 \x01(\x01R\x06radius\x12\x1e\n" +
+	"\vdata_age_ms\x18\x06 \x01(\x03R\tdataAgeMs\"e\n" +
+	"\vRideRequest\x12)\n" +
+	"\x06pickup\x18\x01 \x01(\v2\x11.taxi.v1.LocationR\x06pickup\x12+\n" +
+	"\adropoff\x18\x02 \x01(\v2\x11.taxi.v1.LocationR\adropoff\"\xd6\x01\n" +
+	"\x04Fare\x12\x12\n" +
+	"\x04base\x18\x01 \x01(\x01R\x04base\x12\x1a\n" +
+	"\bdistance\x18\x02 \x01(\x01R\bdistance\x12\x12\n" +
+	"\x04time\x18\x03 \x01(\x01R\x04time\x12\x14\n" +
+	"\x05surge\x18\x04 \x01(\x01R\x05surge\x12\x14\n" +
+	"\x05total\x18\x05 \x01(\x01R\x05total\x12\x1a\n" +
+	"\bcurrency\x18\x06 \x01(\tR\bcurrency\x12\x1f\n" +
+	"\vdistance_km\x18\a \x01(\x01R\n" +
+	"distanceKm\x12!\n" +
+	"\fduration_min\x18\b \x01(\x01R\vdurationMin\"\x94\x02\n" +
+	"\fRideAssigned\x12\x17\n" +
+	"\atrip_id\x18\x01 \x01(\x03R\x06tripId\x12'\n" +
+	"\x06driver\x18\x02 \x01(\v2\x0f.taxi.v1.DriverR\x06driver\x12)\n" +
+	"\x06pickup\x18\x03 \x01(\v2\x11.taxi.v1.LocationR\x06pickup\x12+\n" +
+	"\adropoff\x18\x04 \x01(\v2\x11.taxi.v1.LocationR\adropoff\x124\n" +
+	"\x0eestimated_fare\x18\x05 \x01(\v2\r.taxi.v1.FareR\restimatedFare\x12 \n" +
+	"\fpickup_eta_s\x18\x06 \x01(\x01R\n" +
+	"pickupEtaS\x12\x12\n" +
+	"\x04time\x18\a \x01(\x03R\x04time\"\xbf\x02\n" +
+	"\tTripEvent\x12\x14\n" +
+	"\x05event\x18\x01 \x01(\tR\x05event\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x17\n" +
+	"\atrip_id\x18\x03 \x01(\x03R\x06tripId\x12\x1b\n" +
+	"\tdriver_id\x18\x04 \x01(\x03R\bdriverId\x12)\n" +
+	"\x06pickup\x18\x05 \x01(\v2\x11.taxi.v1.LocationR\x06pickup\x12+\n" +
+	"\adropoff\x18\x06 \x01(\v2\x11.taxi.v1.LocationR\adropoff\x124\n" +
+	"\x0eestimated_fare\x18\a \x01(\v2\r.taxi.v1.FareR\restimatedFare\x12,\n" +
+	"\n" +
+	"final_fare\x18\b \x01(\v2\r.taxi.v1.FareR\tfinalFare\x12\x12\n" +
+	"\x04time\x18\t \x01(\x03R\x04time\"\x93\x02\n" +
+	"\fRideProgress\x12\x17\n" +
+	"\atrip_id\x18\x01 \x01(\x03R\x06tripId\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x12\x1b\n" +
+	"\tdriver_id\x18\x04 \x01(\x03R\bdriverId\x12\x10\n" +
+	"\x03lon\x18\x05 \x01(\x01R\x03lon\x12\x10\n" +
+	"\x03lat\x18\x06 \x01(\x01R\x03lat\x12\x18\n" +
+	"\aheading\x18\a \x01(\x01R\aheading\x12\x1f\n" +
+	"\vdistance_km\x18\b \x01(\x01R\n" +
+	"distanceKm\x12,\n" +
+	"\n" +
+	"final_fare\x18\t \x01(\v2\r.taxi.v1.FareR\tfinalFare\x12\x12\n" +
+	"\x04time\x18\n" +
+	" \x01(\x03R\x04time\"\x84\x01\n" +
+	"\x13DriverStatusChanged\x12\x1b\n" +
+	"\tdriver_id\x18\x01 \x01(\x03R\bdriverId\x12\x1d\n" +
+	"\n" +
+	"old_status\x18\x02 \x01(\tR\toldStatus\x12\x1d\n" +
+	"\n" +
+	"new_status\x18\x03 \x01(\tR\tnewStatus\x12\x12\n" +
+	"\x04time\x18\x04 \x01(\x03R\x04time\"\x0e\n" +
+	"\fStatsRequest\"\x85\x01\n" +
+	"\tCityStats\x12\x12\n" +
+	"\x04city\x18\x01 \x01(\tR\x04city\x12\x18\n" +
+	"\adrivers\x18\x02 \x01(\x05R\adrivers\x12\x1c\n" +
+	"\tavailable\x18\x03 \x01(\x05R\tavailable\x12\x12\n" +
+	"\x04busy\x18\x04 \x01(\x05R\x04busy\x12\x18\n" +
+	"\aoffline\x18\x05 \x01(\x05R\aoffline\"\xe3\a\n" +
+	"\x05Stats\x12\x19\n" +
+	"\buptime_s\x18\x01 \x01(\x01R\auptimeS\x12!\n" +
+	"\fvirtual_time\x18\x02 \x01(\x03R\vvirtualTime\x12\x14\n" +
+	"\x05speed\x18\x03 \x01(\x01R\x05speed\x12\x18\n" +
+	"\aclients\x18\x04 \x01(\x05R\aclients\x12+\n" +
+	"\x11available_drivers\x18\x05 \x01(\x05R\x10availableDrivers\x12!\n" +
+	"\fbusy_drivers\x18\x06 \x01(\x05R\vbusyDrivers\x12'\n" +
+	"\x0foffline_drivers\x18\a \x01(\x05R\x0eofflineDrivers\x128\n" +
+	"\bprofiles\x18\b \x03(\v2\x1c.taxi.v1.Stats.ProfilesEntryR\bprofiles\x12\x18\n" +
+	"\aqueries\x18\t \x01(\x05R\aqueries\x12*\n" +
+	"\x11drivers_per_query\x18\n" +
+	" \x01(\x01R\x0fdriversPerQuery\x12)\n" +
+	"\x11avg_query_time_ms\x18\v \x01(\x01R\x0eavgQueryTimeMs\x12%\n" +
+	"\x0eindex_rebuilds\x18\f \x01(\x03R\rindexRebuilds\x12 \n" +
+	"\findex_age_ms\x18\r \x01(\x03R\n" +
+	"indexAgeMs\x12#\n" +
+	"\rride_requests\x18\x0e \x01(\x05R\frideRequests\x12+\n" +
+	"\x11unserved_requests\x18\x0f \x01(\x05R\x10unservedRequests\x12'\n" +
+	"\x0finstant_matches\x18\x10 \x01(\x05R\x0einstantMatches\x12'\n" +
+	"\x0fdeclined_offers\x18\x11 \x01(\x05R\x0edeclinedOffers\x12#\n" +
+	"\ractive_shocks\x18\x12 \x01(\x05R\factiveShocks\x12*\n" +
+	"\x11trips_in_progress\x18\x13 \x01(\x05R\x0ftripsInProgress\x12'\n" +
+	"\x0fcompleted_trips\x18\x14 \x01(\x05R\x0ecompletedTrips\x12'\n" +
+	"\x0fcancelled_trips\x18\x15 \x01(\x05R\x0ecancelledTrips\x12\x19\n" +
+	"\bno_shows\x18\x16 \x01(\x05R\anoShows\x12\x18\n" +
+	"\arevenue\x18\x17 \x01(\x01R\arevenue\x12\x1a\n" +
+	"\bcurrency\x18\x18 \x01(\tR\bcurrency\x12*\n" +
+	"\x06cities\x18\x19 \x03(\v2\x12.taxi.v1.CityStatsR\x06cities\x1a;\n" +
+	"\rProfilesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\"\xab\x04\n" +
+	"\x05Frame\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12?\n" +
+	"\x0edrivers_update\x18\x02 \x01(\v2\x16.taxi.v1.DriversUpdateH\x00R\rdriversUpdate\x12C\n" +
+	"\x10drivers_snapshot\x18\x03 \x01(\v2\x16.taxi.v1.DriversUpdateH\x00R\x0fdriversSnapshot\x12<\n" +
+	"\rdrivers_delta\x18\x04 \x01(\v2\x15.taxi.v1.DriversDeltaH\x00R\fdriversDelta\x12>\n" +
+	"\rsubscriptions\x18\x05 \x01(\v2\x16.taxi.v1.SubscriptionsH\x00R\rsubscriptions\x123\n" +
+	"\n" +
+	"trip_event\x18\x06 \x01(\v2\x12.taxi.v1.TripEventH\x00R\ttripEvent\x12<\n" +
+	"\rride_assigned\x18\a \x01(\v2\x15.taxi.v1.RideAssignedH\x00R\frideAssigned\x12<\n" +
+	"\rride_progress\x18\b \x01(\v2\x15.taxi.v1.RideProgressH\x00R\frideProgress\x12R\n" +
+	"\x15driver_status_changed\x18\t \x01(\v2\x1c.taxi.v1.DriverStatusChangedH\x00R\x13driverStatusChangedB\t\n" +
+	"\amessage\"E\n" +
+	"\x05Hello\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x05R\aversion\x12\"\n" +
+	"\fcapabilities\x18\x02 \x03(\tR\fcapabilities\"(\n" +
+	"\n" +
+	"StatusList\x12\x1a\n" +
+	"\bstatuses\x18\x01 \x03(\tR\bstatuses\"\xe6\x02\n" +
+	"\fClientParams\x12\x15\n" +
+	"\x03lat\x18\x01 \x01(\x01H\x00R\x03lat\x88\x01\x01\x12\x15\n" +
+	"\x03lon\x18\x02 \x01(\x01H\x01R\x03lon\x88\x01\x01\x12\x1b\n" +
+	"\x06radius\x18\x03 \x01(\x01H\x02R\x06radius\x88\x01\x01\x12\x17\n" +
+	"\x04city\x18\x04 \x01(\tH\x03R\x04city\x88\x01\x01\x12\x1b\n" +
+	"\x06filter\x18\x05 \x01(\tH\x04R\x06filter\x88\x01\x01\x12/\n" +
+	"\bstatuses\x18\x06 \x01(\v2\x13.taxi.v1.StatusListR\bstatuses\x12\x1b\n" +
+	"\x06deltas\x18\a \x01(\bH\x05R\x06deltas\x88\x01\x01\x12\x1f\n" +
+	"\bencoding\x18\b \x01(\tH\x06R\bencoding\x88\x01\x01\x12\x1f\n" +
+	"\vinterval_ms\x18\t \x01(\x05R\n" +
+	"intervalMsB\x06\n" +
+	"\x04_latB\x06\n" +
+	"\x04_lonB\t\n" +
+	"\a_radiusB\a\n" +
+	"\x05_cityB\t\n" +
+	"\a_filterB\t\n" +
+	"\a_deltasB\v\n" +
+	"\t_encoding\"^\n" +
+	"\bViewport\x12\x14\n" +
+	"\x05south\x18\x01 \x01(\x01R\x05south\x12\x12\n" +
+	"\x04west\x18\x02 \x01(\x01R\x04west\x12\x14\n" +
+	"\x05north\x18\x03 \x01(\x01R\x05north\x12\x12\n" +
+	"\x04east\x18\x04 \x01(\x01R\x04east\"\xbd\x01\n" +
+	"\tSubscribe\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12'\n" +
+	"\x06region\x18\x02 \x01(\v2\x0f.taxi.v1.RegionR\x06region\x12-\n" +
+	"\bviewport\x18\x03 \x01(\v2\x11.taxi.v1.ViewportR\bviewport\x12\x12\n" +
+	"\x04city\x18\x04 \x01(\tR\x04city\x12\x18\n" +
+	"\adrivers\x18\x05 \x03(\x03R\adrivers\x12\x1a\n" +
+	"\bstatuses\x18\x06 \x03(\tR\bstatuses\"\x1d\n" +
+	"\vUnsubscribe\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"%\n" +
+	"\x06Follow\x12\x1b\n" +
+	"\tdriver_id\x18\x01 \x01(\x03R\bdriverId\"'\n" +
+	"\bUnfollow\x12\x1b\n" +
+	"\tdriver_id\x18\x01 \x01(\x03R\bdriverId\"\b\n" +
+	"\x06Resync\"\xbe\x03\n" +
+	"\vClientFrame\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12&\n" +
+	"\x05hello\x18\x02 \x01(\v2\x0e.taxi.v1.HelloH\x00R\x05hello\x12<\n" +
+	"\rclient_params\x18\x03 \x01(\v2\x15.taxi.v1.ClientParamsH\x00R\fclientParams\x122\n" +
+	"\tsubscribe\x18\x04 \x01(\v2\x12.taxi.v1.SubscribeH\x00R\tsubscribe\x128\n" +
+	"\vunsubscribe\x18\x05 \x01(\v2\x14.taxi.v1.UnsubscribeH\x00R\vunsubscribe\x12)\n" +
+	"\x06follow\x18\x06 \x01(\v2\x0f.taxi.v1.FollowH\x00R\x06follow\x12/\n" +
+	"\bunfollow\x18\a \x01(\v2\x11.taxi.v1.UnfollowH\x00R\bunfollow\x12)\n" +
+	"\x06resync\x18\b \x01(\v2\x0f.taxi.v1.ResyncH\x00R\x06resync\x129\n" +
+	"\frequest_ride\x18\t \x01(\v2\x14.taxi.v1.RideRequestH\x00R\vrequestRideB\t\n" +
+	"\amessage2\xfa\x01\n" +
+	"\x04Taxi\x12=\n" +
+	"\x10SubscribeDrivers\x12\x0f.taxi.v1.Region\x1a\x16.taxi.v1.DriversUpdate0\x01\x12D\n" +
+	"\x10GetNearbyDrivers\x12\x16.taxi.v1.NearbyRequest\x1a\x18.taxi.v1.DriversResponse\x12:\n" +
+	"\vRequestRide\x12\x14.taxi.v1.RideRequest\x1a\x15.taxi.v1.RideAssigned\x121\n" +
+	"\bGetStats\x12\x15.taxi.v1.StatsRequest\x1a\x0e.taxi.v1.StatsB\x1aZ\x18quadtree/protocol/taxipbb\x06proto3"
+
+var (
+	file_taxi_proto_rawDescOnce sync.Once
+	file_taxi_proto_rawDescData []byte
+)
+
+func file_taxi_proto_rawDescGZIP() []byte {
+	file_taxi_proto_rawDescOnce.Do(func() {
+		file_taxi_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_taxi_proto_rawDesc), len(file_taxi_proto_rawDesc)))
+	})
+	return file_taxi_proto_rawDescData
+}
+
+var file_taxi_proto_msgTypes = make([]protoimpl.MessageInfo, 31)
+var file_taxi_proto_goTypes = []any{
+	(*Location)(nil),            // 0: taxi.v1.Location
+	(*Region)(nil),              // 1: taxi.v1.Region
+	(*Driver)(nil),              // 2: taxi.v1.Driver
+	(*DriversUpdate)(nil),       // 3: taxi.v1.DriversUpdate
+	(*DriverMove)(nil),          // 4: taxi.v1.DriverMove
+	(*DriverStatusChange)(nil),  // 5: taxi.v1.DriverStatusChange
+	(*DriversDelta)(nil),        // 6: taxi.v1.DriversDelta
+	(*Subscriptions)(nil),       // 7: taxi.v1.Subscriptions
+	(*NearbyRequest)(nil),       // 8: taxi.v1.NearbyRequest
+	(*DriversResponse)(nil),     // 9: taxi.v1.DriversResponse
+	(*RideRequest)(nil),         // 10: taxi.v1.RideRequest
+	(*Fare)(nil),                // 11: taxi.v1.Fare
+	(*RideAssigned)(nil),        // 12: taxi.v1.RideAssigned
+	(*TripEvent)(nil),           // 13: taxi.v1.TripEvent
+	(*RideProgress)(nil),        // 14: taxi.v1.RideProgress
+	(*DriverStatusChanged)(nil), // 15: taxi.v1.DriverStatusChanged
+	(*StatsRequest)(nil),        // 16: taxi.v1.StatsRequest
+	(*CityStats)(nil),           // 17: taxi.v1.CityStats
+	(*Stats)(nil),               // 18: taxi.v1.Stats
+	(*Frame)(nil),               // 19: taxi.v1.Frame
+	(*Hello)(nil),               // 20: taxi.v1.Hello
+	(*StatusList)(nil),          // 21: taxi.v1.StatusList
+	(*ClientParams)(nil),        // 22: taxi.v1.ClientParams
+	(*Viewport)(nil),            // 23: taxi.v1.Viewport
+	(*Subscribe)(nil),           // 24: taxi.v1.Subscribe
+	(*Unsubscribe)(nil),         // 25: taxi.v1.Unsubscribe
+	(*Follow)(nil),              // 26: taxi.v1.Follow
+	(*Unfollow)(nil),            // 27: taxi.v1.Unfollow
+	(*Resync)(nil),              // 28: taxi.v1.Resync
+	(*ClientFrame)(nil),         // 29: taxi.v1.ClientFrame
+	nil,                         // 30: taxi.v1.Stats.ProfilesEntry
+}
+var file_taxi_proto_depIdxs = []int32{
+	2,  // 0: taxi.v1.DriversUpdate.drivers:type_name -> taxi.v1.Driver
+	0,  // 1: taxi.v1.DriversUpdate.center:type_name -> taxi.v1.Location
+	2,  // 2: taxi.v1.DriversDelta.appeared:type_name -> taxi.v1.Driver
+	4,  // 3: taxi.v1.DriversDelta.moved:type_name -> taxi.v1.DriverMove
+	5,  // 4: taxi.v1.DriversDelta.status_changed:type_name -> taxi.v1.DriverStatusChange
+	2,  // 5: taxi.v1.DriversResponse.drivers:type_name -> taxi.v1.Driver
+	0,  // 6: taxi.v1.DriversResponse.center:type_name -> taxi.v1.Location
+	0,  // 7: taxi.v1.RideRequest.pickup:type_name -> taxi.v1.Location
+	0,  // 8: taxi.v1.RideRequest.dropoff:type_name -> taxi.v1.Location
+	2,  // 9: taxi.v1.RideAssigned.driver:type_name -> taxi.v1.Driver
+	0,  // 10: taxi.v1.RideAssigned.pickup:type_name -> taxi.v1.Location
+	0,  // 11: taxi.v1.RideAssigned.dropoff:type_name -> taxi.v1.Location
+	11, // 12: taxi.v1.RideAssigned.estimated_fare:type_name -> taxi.v1.Fare
+	0,  // 13: taxi.v1.TripEvent.pickup:type_name -> taxi.v1.Location
+	0,  // 14: taxi.v1.TripEvent.dropoff:type_name -> taxi.v1.Location
+	11, // 15: taxi.v1.TripEvent.estimated_fare:type_name -> taxi.v1.Fare
+	11, // 16: taxi.v1.TripEvent.final_fare:type_name -> taxi.v1.Fare
+	11, // 17: taxi.v1.RideProgress.final_fare:type_name -> taxi.v1.Fare
+	30, // 18: taxi.v1.Stats.profiles:type_name -> taxi.v1.Stats.ProfilesEntry
+	17, // 19: taxi.v1.Stats.cities:type_name -> taxi.v1.CityStats
+	3,  // 20: taxi.v1.Frame.drivers_update:type_name -> taxi.v1.DriversUpdate
+	3,  // 21: taxi.v1.Frame.drivers_snapshot:type_name -> taxi.v1.DriversUpdate
+	6,  // 22: taxi.v1.Frame.drivers_delta:type_name -> taxi.v1.DriversDelta
+	7,  // 23: taxi.v1.Frame.subscriptions:type_name -> taxi.v1.Subscriptions
+	13, // 24: taxi.v1.Frame.trip_event:type_name -> taxi.v1.TripEvent
+	12, // 25: taxi.v1.Frame.ride_assigned:type_name -> taxi.v1.RideAssigned
+	14, // 26: taxi.v1.Frame.ride_progress:type_name -> taxi.v1.RideProgress
+	15, // 27: taxi.v1.Frame.driver_status_changed:type_name -> taxi.v1.DriverStatusChanged
+	21, // 28: taxi.v1.ClientParams.statuses:type_name -> taxi.v1.StatusList
+	1,  // 29: taxi.v1.Subscribe.region:type_name -> taxi.v1.Region
+	23, // 30: taxi.v1.Subscribe.viewport:type_name -> taxi.v1.Viewport
+	20, // 31: taxi.v1.ClientFrame.hello:type_name -> taxi.v1.Hello
+	22, // 32: taxi.v1.ClientFrame.client_params:type_name -> taxi.v1.ClientParams
+	24, // 33: taxi.v1.ClientFrame.subscribe:type_name -> taxi.v1.Subscribe
+	25, // 34: taxi.v1.ClientFrame.unsubscribe:type_name -> taxi.v1.Unsubscribe
+	26, // 35: taxi.v1.ClientFrame.follow:type_name -> taxi.v1.Follow
+	27, // 36: taxi.v1.ClientFrame.unfollow:type_name -> taxi.v1.Unfollow
+	28, // 37: taxi.v1.ClientFrame.resync:type_name -> taxi.v1.Resync
+	10, // 38: taxi.v1.ClientFrame.request_ride:type_name -> taxi.v1.RideRequest
+	1,  // 39: taxi.v1.Taxi.SubscribeDrivers:input_type -> taxi.v1.Region
+	8,  // 40: taxi.v1.Taxi.GetNearbyDrivers:input_type -> taxi.v1.NearbyRequest
+	10, // 41: taxi.v1.Taxi.RequestRide:input_type -> taxi.v1.RideRequest
+	16, // 42: taxi.v1.Taxi.GetStats:input_type -> taxi.v1.StatsRequest
+	3,  // 43: taxi.v1.Taxi.SubscribeDrivers:output_type -> taxi.v1.DriversUpdate
+	9,  // 44: taxi.v1.Taxi.GetNearbyDrivers:output_type -> taxi.v1.DriversResponse
+	12, // 45: taxi.v1.Taxi.RequestRide:output_type -> taxi.v1.RideAssigned
+	18, // 46: taxi.v1.Taxi.GetStats:output_type -> taxi.v1.Stats
+	43, // [43:47] is the sub-list for method output_type
+	39, // [39:43] is the sub-list for method input_type
+	39, // [39:39] is the sub-list for extension type_name
+	39, // [39:39] is the sub-list for extension extendee
+	0,  // [0:39] is the sub-list for field type_name
+}
+
+func init() { file_taxi_proto_init() }
+func file_taxi_proto_init() {
+	if File_taxi_proto != nil {
+		return
+	}
+	file_taxi_proto_msgTypes[19].OneofWrappers = []any{
+		(*Frame_DriversUpdate)(nil),
+		(*Frame_DriversSnapshot)(nil),
+		(*Frame_DriversDelta)(nil),
+		(*Frame_Subscriptions)(nil),
+		(*Frame_TripEvent)(nil),
+		(*Frame_RideAssigned)(nil),
+		(*Frame_RideProgress)(nil),
+		(*Frame_DriverStatusChanged)(nil),
+	}
+	file_taxi_proto_msgTypes[22].OneofWrappers = []any{}
+	file_taxi_proto_msgTypes[29].OneofWrappers = []any{
+		(*ClientFrame_Hello)(nil),
+		(*ClientFrame_ClientParams)(nil),
+		(*ClientFrame_Subscribe)(nil),
+		(*ClientFrame_Unsubscribe)(nil),
+		(*ClientFrame_Follow)(nil),
+		(*ClientFrame_Unfollow)(nil),
+		(*ClientFrame_Resync)(nil),
+		(*ClientFrame_RequestRide)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_taxi_proto_rawDesc), len(file_taxi_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   31,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_taxi_proto_goTypes,
+		DependencyIndexes: file_taxi_proto_depIdxs,
+		MessageInfos:      file_taxi_proto_msgTypes,
+	}.Build()
+	File_taxi_proto = out.File
+	file_taxi_proto_goTypes = nil
+	file_taxi_proto_depIdxs = nil
+}
@@ -0,0 +1,256 @@
+// The gRPC service of the simulation, for backend consumers, and the
+// binary frames of WebSocket clients that ask for the protobuf encoding.
+// Its messages mirror the JSON ones of the WebSocket and HTTP APIs field for
+// field. The Go types and service stubs in taxipb are generated from this
+// file (go generate ./protocol); generate a client with protoc for your
+// language, or call the service with grpcurl and this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: taxi.proto
+
+package taxipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Taxi_SubscribeDrivers_FullMethodName = "/taxi.v1.Taxi/SubscribeDrivers"
+	Taxi_GetNearbyDrivers_FullMethodName = "/taxi.v1.Taxi/GetNearbyDrivers"
+	Taxi_RequestRide_FullMethodName      = "/taxi.v1.Taxi/RequestRide"
+	Taxi_GetStats_FullMethodName         = "/taxi.v1.Taxi/GetStats"
+)
+
+// TaxiClient is the client API for Taxi service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TaxiClient interface {
+	// Streams the drivers within a region on every broadcast interval, like
+	// a WebSocket client's drivers_update messages
+	SubscribeDrivers(ctx context.Context, in *Region, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DriversUpdate], error)
+	// Finds the drivers around a location or in a city, like /api/drivers
+	GetNearbyDrivers(ctx context.Context, in *NearbyRequest, opts ...grpc.CallOption) (*DriversResponse, error)
+	// Dispatches a ride, like POST /api/rides
+	RequestRide(ctx context.Context, in *RideRequest, opts ...grpc.CallOption) (*RideAssigned, error)
+	// The simulation statistics, like /api/stats
+	GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error)
+}
+
+type taxiClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTaxiClient(cc grpc.ClientConnInterface) TaxiClient {
+	return &taxiClient{cc}
+}
+
+func (c *taxiClient) SubscribeDrivers(ctx context.Context, in *Region, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DriversUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Taxi_ServiceDesc.Streams[0], Taxi_SubscribeDrivers_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Region, DriversUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Taxi_SubscribeDriversClient = grpc.ServerStreamingClient[DriversUpdate]
+
+func (c *taxiClient) GetNearbyDrivers(ctx context.Context, in *NearbyRequest, opts ...grpc.CallOption) (*DriversResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DriversResponse)
+	err := c.cc.Invoke(ctx, Taxi_GetNearbyDrivers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taxiClient) RequestRide(ctx context.Context, in *RideRequest, opts ...grpc.CallOption) (*RideAssigned, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RideAssigned)
+	err := c.cc.Invoke(ctx, Taxi_RequestRide_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taxiClient) GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Taxi_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TaxiServer is the server API for Taxi service.
+// All implementations must embed UnimplementedTaxiServer
+// for forward compatibility.
+type TaxiServer interface {
+	// Streams the drivers within a region on every broadcast interval, like
+	// a WebSocket client's drivers_update messages
+	SubscribeDrivers(*Region, grpc.ServerStreamingServer[DriversUpdate]) error
+	// Finds the drivers around a location or in a city, like /api/drivers
+	GetNearbyDrivers(context.Context, *NearbyRequest) (*DriversResponse, error)
+	// Dispatches a ride, like POST /api/rides
+	RequestRide(context.Context, *RideRequest) (*RideAssigned, error)
+	// The simulation statistics, like /api/stats
+	GetStats(context.Context, *StatsRequest) (*Stats, error)
+	mustEmbedUnimplementedTaxiServer()
+}
+
+// UnimplementedTaxiServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTaxiServer struct{}
+
+func (UnimplementedTaxiServer) SubscribeDrivers(*Region, grpc.ServerStreamingServer[DriversUpdate]) error {
+	return status.Error(codes.Unimplemented, "method SubscribeDrivers not implemented")
+}
+func (UnimplementedTaxiServer) GetNearbyDrivers(context.Context, *NearbyRequest) (*DriversResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetNearbyDrivers not implemented")
+}
+func (UnimplementedTaxiServer) RequestRide(context.Context, *RideRequest) (*RideAssigned, error) {
+	return nil, status.Error(codes.Unimplemented, "method RequestRide not implemented")
+}
+func (UnimplementedTaxiServer) GetStats(context.Context, *StatsRequest) (*Stats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedTaxiServer) mustEmbedUnimplementedTaxiServer() {}
+func (UnimplementedTaxiServer) testEmbeddedByValue()              {}
+
+// UnsafeTaxiServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TaxiServer will
+// result in compilation errors.
+type UnsafeTaxiServer interface {
+	mustEmbedUnimplementedTaxiServer()
+}
+
+func RegisterTaxiServer(s grpc.ServiceRegistrar, srv TaxiServer) {
+	// If the following call panics, it indicates UnimplementedTaxiServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Taxi_ServiceDesc, srv)
+}
+
+func _Taxi_SubscribeDrivers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Region)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaxiServer).SubscribeDrivers(m, &grpc.GenericServerStream[Region, DriversUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Taxi_SubscribeDriversServer = grpc.ServerStreamingServer[DriversUpdate]
+
+func _Taxi_GetNearbyDrivers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NearbyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaxiServer).GetNearbyDrivers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Taxi_GetNearbyDrivers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaxiServer).GetNearbyDrivers(ctx, req.(*NearbyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Taxi_RequestRide_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RideRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaxiServer).RequestRide(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Taxi_RequestRide_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaxiServer).RequestRide(ctx, req.(*RideRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Taxi_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaxiServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Taxi_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaxiServer).GetStats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Taxi_ServiceDesc is the grpc.ServiceDesc for Taxi service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Taxi_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "taxi.v1.Taxi",
+	HandlerType: (*TaxiServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetNearbyDrivers",
+			Handler:    _Taxi_GetNearbyDrivers_Handler,
+		},
+		{
+			MethodName: "RequestRide",
+			Handler:    _Taxi_RequestRide_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Taxi_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeDrivers",
+			Handler:       _Taxi_SubscribeDrivers_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "taxi.proto",
+}
@@ -0,0 +1,18 @@
+package quadtree
+
+// Index is a spatial index of points. The quadtree implements it, and so
+// can other structures that serve the same queries, such as a grid.
+type Index interface {
+	// Insert adds a point, reporting false when it is outside the index
+	Insert(p Point) bool
+	// Remove deletes the point with p's ID at p's position
+	Remove(p Point) bool
+	// Move changes the position of the point with p's ID at p's position
+	Move(p Point, x, y float64) bool
+	// QueryResults returns all points within the given bounds
+	QueryResults(bounds Bounds) []Point
+	// Nearest returns up to n points closest to (x, y), closest first
+	Nearest(x, y float64, n int, dist DistanceFunc, keep func(Point) bool) []Point
+}
+
+var _ Index = (*Quadtree)(nil)
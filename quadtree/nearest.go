@@ -0,0 +1,97 @@
+package quadtree
+
+import (
+	"container/heap"
+	"math"
+)
+
+// DistanceFunc measures the distance between two positions. It must not
+// shrink as either coordinate moves further away, so the closest position
+// of a node's bounds is never further than any point inside.
+type DistanceFunc func(x1, y1, x2, y2 float64) float64
+
+// Euclidean is the straight-line distance in the plane
+func Euclidean(x1, y1, x2, y2 float64) float64 {
+	return math.Hypot(x2-x1, y2-y1)
+}
+
+// Nearest returns up to n points closest to (x, y), closest first, skipping
+// those keep rejects (a nil keep accepts every point). Nodes are visited in
+// order of their distance, so only the part of the tree that can hold a
+// closer point is searched.
+func (qt *Quadtree) Nearest(x, y float64, n int, dist DistanceFunc, keep func(Point) bool) []Point {
+	if n <= 0 {
+		return nil
+	}
+	if dist == nil {
+		dist = Euclidean
+	}
+
+	results := make([]Point, 0, n)
+	queue := &nearestQueue{{dist: qt.distanceTo(x, y, dist), node: qt}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(nearestItem)
+		if item.node == nil {
+			results = append(results, item.point)
+			if len(results) == n {
+				break
+			}
+			continue
+		}
+
+		node := item.node
+		for _, p := range node.nodes {
+			if keep == nil || keep(p) {
+				heap.Push(queue, nearestItem{dist: dist(x, y, p.X, p.Y), point: p})
+			}
+		}
+		if node.divided {
+			for _, child := range []*Quadtree{node.northWest, node.northEast, node.southWest, node.southEast} {
+				heap.Push(queue, nearestItem{dist: child.distanceTo(x, y, dist), node: child})
+			}
+		}
+	}
+	return results
+}
+
+// distanceTo returns the distance from (x, y) to the closest position within
+// the node's bounds; zero when it is inside
+func (qt *Quadtree) distanceTo(x, y float64, dist DistanceFunc) float64 {
+	cx := math.Max(qt.bounds.MinX, math.Min(x, qt.bounds.MaxX))
+	cy := math.Max(qt.bounds.MinY, math.Min(y, qt.bounds.MaxY))
+	if cx == x && cy == y {
+		return 0
+	}
+	return dist(x, y, cx, cy)
+}
+
+// nearestItem is a node to search or a point found, queued by distance
+type nearestItem struct {
+	dist  float64
+	node  *Quadtree // nil for points
+	point Point
+}
+
+// nearestQueue is a min-heap of nearestItems
+type nearestQueue []nearestItem
+
+func (q nearestQueue) Len() int { return len(q) }
+
+// Less orders by distance; at equal distance points come before nodes, so
+// a search can stop as soon as it has enough of them
+func (q nearestQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	return q[i].node == nil && q[j].node != nil
+}
+
+func (q nearestQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nearestQueue) Push(x interface{}) { *q = append(*q, x.(nearestItem)) }
+
+func (q *nearestQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
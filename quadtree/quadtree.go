@@ -1,6 +1,9 @@
 package quadtree
 
-import "sync"
+import (
+	"slices"
+	"sync"
+)
 
 // Bounds represents a rectangular area in 2D space.
 type Bounds struct {
@@ -8,13 +11,16 @@ type Bounds struct {
 	MaxX, MaxY float64
 }
 
-func (b Bounds) contains(x, y float64) bool {
+// Contains reports whether a point is inside the bounds or on their edge.
+func (b Bounds) Contains(x, y float64) bool {
 	return x >= b.MinX && x <= b.MaxX && y >= b.MinY && y <= b.MaxY
 }
 
-// Point represents a location in 2D space.
+// Point represents a location in 2D space, optionally tagged with the ID of
+// what is there.
 type Point struct {
 	X, Y float64
+	ID   int
 }
 
 // Quadtree is a spatial data structure for efficient point storage and retrieval.
@@ -57,20 +63,140 @@ func (qt *Quadtree) Insert(node Point) bool {
 }
 
 func (qt *Quadtree) insertIntoChild(node Point) bool {
+	return qt.childFor(node.X, node.Y).Insert(node)
+}
+
+// childFor returns the child of a divided node that (x, y) belongs in
+func (qt *Quadtree) childFor(x, y float64) *Quadtree {
 	midX := (qt.bounds.MinX + qt.bounds.MaxX) / 2
 	midY := (qt.bounds.MinY + qt.bounds.MaxY) / 2
 
-	if node.X <= midX { // West side
-		if node.Y <= midY { // South
-			return qt.southWest.Insert(node)
+	if x <= midX { // West side
+		if y <= midY { // South
+			return qt.southWest
 		}
-		return qt.northWest.Insert(node) // North
+		return qt.northWest // North
 	} else { // East side
-		if node.Y <= midY { // South
-			return qt.southEast.Insert(node)
+		if y <= midY { // South
+			return qt.southEast
+		}
+		return qt.northEast // North
+	}
+}
+
+// leaf returns the leaf node that (x, y) belongs in, or nil outside the tree
+func (qt *Quadtree) leaf(x, y float64) *Quadtree {
+	if !qt.InsideBounds(x, y) {
+		return nil
+	}
+	node := qt
+	for node.divided {
+		node = node.childFor(x, y)
+	}
+	return node
+}
+
+// indexOf returns where a leaf holds the point with p's ID and position, or
+// -1 when it doesn't
+func (qt *Quadtree) indexOf(p Point) int {
+	for i, n := range qt.nodes {
+		if n.ID == p.ID && n.X == p.X && n.Y == p.Y {
+			return i
+		}
+	}
+	return -1
+}
+
+// Remove deletes the point with p's ID at p's position, reporting false when
+// there is none. Nodes that become empty stay divided, so a tree that saw
+// many removals is best rebuilt (see Leaves).
+func (qt *Quadtree) Remove(p Point) bool {
+	leaf := qt.leaf(p.X, p.Y)
+	if leaf == nil {
+		return false
+	}
+	i := leaf.indexOf(p)
+	if i < 0 {
+		return false
+	}
+	leaf.nodes = slices.Delete(leaf.nodes, i, i+1)
+	return true
+}
+
+// Move changes the position of the point with p's ID at p's position to
+// (x, y). A point that stays in its leaf is updated in place; otherwise it
+// is removed and inserted again. It reports false when there is no such
+// point, or when (x, y) is outside the tree, which then no longer holds it.
+func (qt *Quadtree) Move(p Point, x, y float64) bool {
+	leaf := qt.leaf(p.X, p.Y)
+	if leaf == nil {
+		return false
+	}
+	i := leaf.indexOf(p)
+	if i < 0 {
+		return false
+	}
+	if qt.leaf(x, y) == leaf {
+		leaf.nodes[i].X, leaf.nodes[i].Y = x, y
+		return true
+	}
+
+	leaf.nodes = slices.Delete(leaf.nodes, i, i+1)
+	p.X, p.Y = x, y
+	return qt.Insert(p)
+}
+
+// Leaves counts the leaf nodes of the tree and how many of them are empty.
+// Removals and moves leave empty leaves behind, which queries still visit.
+func (qt *Quadtree) Leaves() (total, empty int) {
+	if !qt.divided {
+		if len(qt.nodes) == 0 {
+			return 1, 1
 		}
-		return qt.northEast.Insert(node) // North
+		return 1, 0
+	}
+	for _, child := range []*Quadtree{qt.northWest, qt.northEast, qt.southWest, qt.southEast} {
+		t, e := child.Leaves()
+		total += t
+		empty += e
+	}
+	return total, empty
+}
+
+// Stats describes the shape of a tree
+type Stats struct {
+	Capacity int // points a node holds before it splits
+	Depth    int // of the deepest leaf; the root is at depth 0
+	Nodes    int // inner nodes and leaves
+	Leaves   int
+	Points   int
+	// LeafPoints[n] counts the leaves holding n points. Clustered points
+	// make deep trees whose leaves are mostly empty or full.
+	LeafPoints []int
+}
+
+// Stats walks the tree and describes its shape
+func (qt *Quadtree) Stats() Stats {
+	stats := Stats{Capacity: qt.capacity, LeafPoints: make([]int, qt.capacity+1)}
+	qt.collectStats(&stats, 0)
+	return stats
+}
+
+func (qt *Quadtree) collectStats(stats *Stats, depth int) {
+	stats.Nodes++
+	stats.Depth = max(stats.Depth, depth)
+	if qt.divided {
+		for _, child := range []*Quadtree{qt.northWest, qt.northEast, qt.southWest, qt.southEast} {
+			child.collectStats(stats, depth+1)
+		}
+		return
+	}
+	stats.Leaves++
+	stats.Points += len(qt.nodes)
+	for len(stats.LeafPoints) <= len(qt.nodes) {
+		stats.LeafPoints = append(stats.LeafPoints, 0)
 	}
+	stats.LeafPoints[len(qt.nodes)]++
 }
 
 func (qt *Quadtree) subDivide() {
@@ -129,7 +255,7 @@ func (qt *Quadtree) Query(bounds Bounds, results *[]Point) {
 		return
 	}
 	for _, node := range qt.nodes {
-		if bounds.contains(node.X, node.Y) {
+		if bounds.Contains(node.X, node.Y) {
 			*results = append(*results, node)
 		}
 	}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"sync"
+	"time"
+)
+
+// recordingVersion is bumped whenever the recording line format changes
+const recordingVersion = 2
+
+// RecordedDriver is the state of a single driver captured in a recording frame
+type RecordedDriver struct {
+	ID      int          `json:"id"`
+	Lon     float64      `json:"lon"`
+	Lat     float64      `json:"lat"`
+	Status  DriverStatus `json:"status"`
+	Speed   float64      `json:"speed"`
+	Heading float64      `json:"heading"` // in radians
+}
+
+// recordLine is a single line of a recording file. A recording starts with a
+// "session" line followed by one "frame" line per simulation update, with
+// "command" lines for runtime changes made between updates.
+type recordLine struct {
+	Type string `json:"type"` // "session", "frame" or "command"
+
+	// Session fields
+	Version          int        `json:"version,omitempty"`
+	StartedAt        int64      `json:"started_at,omitempty"` // Unix milliseconds
+	UpdateIntervalMs int64      `json:"update_interval_ms,omitempty"`
+	PhysicsStepMs    int64      `json:"physics_step_ms,omitempty"` // zero before drivers moved in fixed steps
+	Config           *RunConfig `json:"config,omitempty"`
+
+	// Frame fields
+	Tick      int64            `json:"tick,omitempty"`
+	ElapsedMs int64            `json:"elapsed_ms,omitempty"` // milliseconds since the session started
+	Dt        float64          `json:"dt,omitempty"`         // simulated seconds covered by the update
+	Drivers   []RecordedDriver `json:"drivers,omitempty"`
+
+	// Command fields; Tick is the number of frames written before the command
+	Command string                 `json:"command,omitempty"` // "spawn", "despawn", "shock", "cancel_shock" or "config"
+	Spawn   *protocol.SpawnRequest `json:"spawn,omitempty"`
+	IDs     []int                  `json:"ids,omitempty"`
+	Shock   *DemandShock           `json:"shock,omitempty"`
+	Patch   *protocol.ConfigPatch  `json:"patch,omitempty"`
+}
+
+// Recorder appends driver position/status frames to a JSONL file
+type Recorder struct {
+	file    *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	started time.Time
+	tick    int64
+	mu      sync.Mutex
+}
+
+// NewRecorder opens (or creates) the recording file in append-only mode and
+// writes a session header holding the run configuration
+func NewRecorder(path string, cfg RunConfig) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	rec := &Recorder{
+		file:    f,
+		w:       w,
+		enc:     json.NewEncoder(w),
+		started: time.Now(),
+	}
+
+	header := recordLine{
+		Type:             "session",
+		Version:          recordingVersion,
+		StartedAt:        rec.started.UnixNano() / int64(time.Millisecond),
+		UpdateIntervalMs: cfg.settings().UpdateInterval.Milliseconds(),
+		PhysicsStepMs:    physicsStep.Milliseconds(),
+		Config:           &cfg,
+	}
+	if err := rec.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write recording header: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write recording header: %w", err)
+	}
+
+	return rec, nil
+}
+
+// RecordFrame writes the current state of all drivers as one frame covering
+// dt simulated seconds
+func (rec *Recorder) RecordFrame(drivers []*Driver, dt float64) error {
+	frame := recordLine{
+		Type:    "frame",
+		Dt:      dt,
+		Drivers: recordDrivers(drivers),
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.tick++
+	frame.Tick = rec.tick
+	frame.ElapsedMs = time.Since(rec.started).Milliseconds()
+
+	if err := rec.enc.Encode(frame); err != nil {
+		return err
+	}
+	return rec.w.Flush()
+}
+
+// RecordCommand writes a runtime change so verification can re-apply it
+// between the same two frames
+func (rec *Recorder) RecordCommand(cmd recordLine) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	cmd.Type = "command"
+	cmd.Tick = rec.tick
+	if err := rec.enc.Encode(cmd); err != nil {
+		return err
+	}
+	return rec.w.Flush()
+}
+
+// recordDrivers captures the state of the given drivers. Federated drivers
+// are left out since they aren't part of the local simulation.
+func recordDrivers(drivers []*Driver) []RecordedDriver {
+	recorded := make([]RecordedDriver, 0, len(drivers))
+	for _, driver := range drivers {
+		if driver.Origin != "" {
+			continue
+		}
+		driver.mu.Lock()
+		recorded = append(recorded, RecordedDriver{
+			ID:      driver.ID,
+			Lon:     driver.lon(),
+			Lat:     driver.lat(),
+			Status:  driver.Status,
+			Speed:   driver.speed(),
+			Heading: driver.heading(),
+		})
+		driver.mu.Unlock()
+	}
+	return recorded
+}
+
+// record writes a command line if the simulation is being recorded, and
+// audits the change
+func (s *Simulation) record(cmd recordLine) {
+	s.audit(protocol.AuditAdmin, protocol.AdminEvent{Action: cmd.Command, Params: cmd.params()})
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.RecordCommand(cmd); err != nil {
+		slog.Error("Recording command failed", "command", cmd.Command, "err", err)
+	}
+}
+
+// params returns what a command line changes with
+func (cmd recordLine) params() interface{} {
+	switch {
+	case cmd.Spawn != nil:
+		return cmd.Spawn
+	case cmd.Shock != nil:
+		return cmd.Shock
+	case cmd.Patch != nil:
+		return cmd.Patch
+	case cmd.IDs != nil:
+		return cmd.IDs
+	}
+	return nil
+}
+
+// Close flushes and closes the recording file
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if err := rec.w.Flush(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	return rec.file.Close()
+}
+
+// Replayer streams frames from a recording file and hands them out as the
+// (scaled) playback clock advances. Frames are read lazily so arbitrarily
+// long recordings can be replayed without loading them into memory.
+type Replayer struct {
+	path  string
+	speed float64
+	loop  bool
+
+	file    *os.File
+	dec     *json.Decoder
+	clock   *SimClock
+	started time.Time // virtual time when playback (re)started
+
+	// offsetMs shifts frames of later sessions appended to the same file so
+	// playback continues where the previous session ended
+	offsetMs  int64
+	lastMs    int64
+	next      *recordLine
+	exhausted bool
+}
+
+// NewReplayer opens a recording for playback at the given speed multiplier
+func NewReplayer(path string, speed float64, loop bool) (*Replayer, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed %v: must be positive", speed)
+	}
+
+	rp := &Replayer{path: path, speed: speed, loop: loop}
+	if err := rp.rewind(); err != nil {
+		return nil, err
+	}
+
+	// Make sure the recording has at least one frame to play
+	if _, err := rp.peek(); err != nil {
+		rp.file.Close()
+		return nil, err
+	}
+
+	return rp, nil
+}
+
+// rewind reopens the recording from the beginning
+func (rp *Replayer) rewind() error {
+	if rp.file != nil {
+		rp.file.Close()
+	}
+
+	f, err := os.Open(rp.path)
+	if err != nil {
+		return fmt.Errorf("open recording: %w", err)
+	}
+
+	rp.file = f
+	rp.dec = json.NewDecoder(bufio.NewReader(f))
+	rp.offsetMs = 0
+	rp.lastMs = 0
+	rp.next = nil
+	rp.exhausted = false
+	return nil
+}
+
+// peek returns the next frame without consuming it
+func (rp *Replayer) peek() (*recordLine, error) {
+	if rp.next != nil {
+		return rp.next, nil
+	}
+
+	for {
+		var line recordLine
+		if err := rp.dec.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("read recording: %w", err)
+		}
+
+		switch line.Type {
+		case "session":
+			if line.Version > recordingVersion {
+				return nil, fmt.Errorf("unsupported recording version %d", line.Version)
+			}
+			// A later session appended to the file continues after the last frame
+			rp.offsetMs = rp.lastMs
+		case "frame":
+			line.ElapsedMs += rp.offsetMs
+			rp.next = &line
+			return rp.next, nil
+		}
+	}
+}
+
+// InitialDrivers returns drivers built from the first frame of the recording
+func (rp *Replayer) InitialDrivers() ([]*Driver, error) {
+	frame, err := rp.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]*Driver, 0, len(frame.Drivers))
+	for _, rd := range frame.Drivers {
+		drivers = append(drivers, &Driver{
+			ID:     rd.ID,
+			Status: rd.Status,
+			motion: newMotion(rd.Lon, rd.Lat, rd.Speed, rd.Heading),
+		})
+	}
+	return drivers, nil
+}
+
+// Start begins playback on the simulation clock, so pausing or speeding up
+// the simulation applies to the replay as well
+func (rp *Replayer) Start(clock *SimClock) {
+	rp.clock = clock
+	rp.started = clock.Now()
+}
+
+// Advance returns the most recent frame whose timestamp has been reached by
+// the playback clock, or nil if no new frame is due yet
+func (rp *Replayer) Advance() (*recordLine, error) {
+	if rp.exhausted {
+		return nil, nil
+	}
+
+	target := int64(float64(rp.clock.Now().Sub(rp.started).Milliseconds()) * rp.speed)
+
+	var latest *recordLine
+	for {
+		frame, err := rp.peek()
+		if errors.Is(err, io.EOF) {
+			if !rp.loop {
+				rp.exhausted = true
+				return latest, nil
+			}
+			if err := rp.rewind(); err != nil {
+				return latest, err
+			}
+			rp.started = rp.clock.Now()
+			return latest, nil
+		}
+		if err != nil {
+			return latest, err
+		}
+		if frame.ElapsedMs > target {
+			return latest, nil
+		}
+
+		latest = frame
+		rp.lastMs = frame.ElapsedMs
+		rp.next = nil
+	}
+}
+
+// Close closes the underlying recording file
+func (rp *Replayer) Close() error {
+	return rp.file.Close()
+}
+
+// ApplyFrame overwrites driver state with the contents of a recorded frame.
+// Frames don't hold velocities, so drivers that aren't offline are taken to
+// go on at their recorded speed and heading, as fast as playback shows it.
+func (s *Simulation) ApplyFrame(frame *recordLine) {
+	s.movedAt = time.Now()
+	pace := 1.0 // recorded simulated seconds per second of playback, before the clock's scale
+	if s.replayer != nil {
+		pace = s.replayer.speed
+	}
+	if frame.Dt != 0 {
+		pace *= frame.Dt / s.settings.UpdateInterval.Seconds()
+	}
+	for _, rd := range frame.Drivers {
+		driver, ok := s.driverByID(rd.ID)
+		if !ok {
+			continue
+		}
+		driver.mu.Lock()
+		driver.moveTo(rd.Lon, rd.Lat)
+		driver.Status = rd.Status
+		driver.setSpeed(rd.Speed)
+		driver.setHeading(rd.Heading)
+		driver.setVelocity(0, 0)
+		if rd.Status != Offline {
+			v := rd.Speed * pace
+			driver.setVelocity(math.Sin(rd.Heading)*v*geo.LonScale(rd.Lat), math.Cos(rd.Heading)*v)
+		}
+		driver.mu.Unlock()
+	}
+}
+
+// LoadReplay switches the simulation into replay mode, replacing the
+// simulated drivers with the ones from the recording
+func (s *Simulation) LoadReplay(rp *Replayer) error {
+	drivers, err := rp.InitialDrivers()
+	if err != nil {
+		return err
+	}
+
+	s.setDrivers(drivers)
+	s.replayer = rp
+	for _, driver := range drivers {
+		if driver.ID > s.nextDriverID {
+			s.nextDriverID = driver.ID
+		}
+	}
+	s.UpdateIndex()
+	return nil
+}
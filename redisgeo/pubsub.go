@@ -0,0 +1,85 @@
+package redisgeo
+
+import (
+	"fmt"
+	"time"
+)
+
+// Subscription receives the messages published to some channels. It has a
+// connection of its own, since a subscribed connection runs no other
+// commands.
+type Subscription struct {
+	c *Client
+}
+
+// Subscribe connects to the server of a URL like Dial does and subscribes
+// to channels
+func Subscribe(rawURL string, channels ...string) (*Subscription, error) {
+	c, err := Dial(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	fmt.Fprintf(c.w, "*%d\r\n$9\r\nSUBSCRIBE\r\n", len(channels)+1)
+	for _, channel := range channels {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(channel), channel)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.close()
+		return nil, err
+	}
+	// One confirmation per channel
+	for range channels {
+		reply, err := c.read()
+		if err == nil {
+			if e, ok := reply.(Error); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	c.conn.SetDeadline(time.Time{})
+	return &Subscription{c: c}, nil
+}
+
+// Receive waits up to timeout for the next message and returns its channel
+// and payload. After an error, including a timeout, the subscription is
+// closed; subscribe again to go on.
+func (sub *Subscription) Receive(timeout time.Duration) (channel, payload string, err error) {
+	c := sub.c
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return "", "", fmt.Errorf("redis: subscription closed")
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		reply, err := c.read()
+		if err != nil {
+			sub.Close()
+			return "", "", err
+		}
+		// ["message", channel, payload]; anything else confirms a command
+		items, ok := reply.([]any)
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		channel, _ := items[1].(string)
+		payload, _ := items[2].(string)
+		return channel, payload, nil
+	}
+}
+
+// Close unsubscribes by closing the connection, which makes a waiting
+// Receive return
+func (sub *Subscription) Close() error {
+	return sub.c.Close()
+}
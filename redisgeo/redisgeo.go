@@ -0,0 +1,254 @@
+// Package redisgeo keeps a spatial index of points in Redis, as a sorted
+// set of geohashes written with GEOADD and searched with GEOSEARCH (Redis
+// 6.2 or newer). It serves the same queries as the quadtree
+// (quadtree.Index), but its points live outside the process: other servers
+// and tools can search the same key.
+//
+// Points are positions in degrees of longitude (X) and latitude (Y), and
+// come back from Redis rounded to its geohash precision, under a meter.
+// Changes are buffered and written in one pipeline by Flush; queries see
+// them only after that.
+//
+// Subscribe receives the messages published to channels, for servers that
+// share more than the index through the same Redis.
+package redisgeo
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"quadtree/quadtree"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+const (
+	// earthRadiusKm is the radius Redis measures distances with
+	earthRadiusKm = 6372.797560856
+	kmPerDegree   = earthRadiusKm * math.Pi / 180
+
+	// Members per GEOADD or ZREM, so one command never gets too large
+	batchSize = 1000
+)
+
+// Index is a spatial index of points in a Redis key
+type Index struct {
+	client *Client
+	key    string
+	bounds quadtree.Bounds
+
+	mu     sync.Mutex
+	points map[int]quadtree.Point // what the key should hold
+	dirty  map[int]bool           // points changed since the last Flush
+	resync bool                   // rewrite the whole key on the next Flush
+	err    error                  // of the last failed query, for Flush to report
+}
+
+var _ quadtree.Index = (*Index)(nil)
+
+// NewIndex creates an index over bounds in key. Whatever the key held is
+// replaced with the index's points on the first Flush.
+func NewIndex(client *Client, key string, bounds quadtree.Bounds) *Index {
+	return &Index{
+		client: client,
+		key:    key,
+		bounds: bounds,
+		points: make(map[int]quadtree.Point),
+		dirty:  make(map[int]bool),
+		resync: true,
+	}
+}
+
+// inside reports whether (x, y) is within the index's bounds
+func (ix *Index) inside(x, y float64) bool {
+	return x >= ix.bounds.MinX && x <= ix.bounds.MaxX &&
+		y >= ix.bounds.MinY && y <= ix.bounds.MaxY
+}
+
+// Insert adds a point, reporting false when it is outside the index
+func (ix *Index) Insert(p quadtree.Point) bool {
+	if !ix.inside(p.X, p.Y) {
+		return false
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.points[p.ID], ix.dirty[p.ID] = p, true
+	return true
+}
+
+// Remove deletes the point with p's ID
+func (ix *Index) Remove(p quadtree.Point) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.points[p.ID]; !ok {
+		return false
+	}
+	delete(ix.points, p.ID)
+	ix.dirty[p.ID] = true
+	return true
+}
+
+// Move changes the position of the point with p's ID. A point moved outside
+// the index is removed, and Move reports false.
+func (ix *Index) Move(p quadtree.Point, x, y float64) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.points[p.ID]; !ok {
+		return false
+	}
+	ix.dirty[p.ID] = true
+	if !ix.inside(x, y) {
+		delete(ix.points, p.ID)
+		return false
+	}
+	ix.points[p.ID] = quadtree.Point{ID: p.ID, X: x, Y: y}
+	return true
+}
+
+// Flush writes the changes since the last Flush to Redis. After a failure
+// the next Flush rewrites the whole key, so Redis never keeps a half
+// applied update. It returns the error of a query that failed since the
+// last Flush as well.
+func (ix *Index) Flush() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var cmds [][]string
+	var removed, added []string
+	if ix.resync {
+		cmds = append(cmds, []string{"DEL", ix.key})
+		for _, p := range ix.points {
+			added = append(added, ftoa(p.X), ftoa(p.Y), strconv.Itoa(p.ID))
+		}
+	} else {
+		for id := range ix.dirty {
+			if p, ok := ix.points[id]; ok {
+				added = append(added, ftoa(p.X), ftoa(p.Y), strconv.Itoa(id))
+			} else {
+				removed = append(removed, strconv.Itoa(id))
+			}
+		}
+	}
+	for len(removed) > 0 {
+		n := min(len(removed), batchSize)
+		cmds = append(cmds, append([]string{"ZREM", ix.key}, removed[:n]...))
+		removed = removed[n:]
+	}
+	for len(added) > 0 {
+		n := min(len(added), 3*batchSize)
+		cmds = append(cmds, append([]string{"GEOADD", ix.key}, added[:n]...))
+		added = added[n:]
+	}
+
+	queryErr := ix.err
+	ix.err = nil
+	if _, err := ix.client.Pipeline(cmds); err != nil {
+		ix.resync = true
+		return err
+	}
+	ix.resync = false
+	clear(ix.dirty)
+	return queryErr
+}
+
+// QueryResults returns all points within the given bounds
+func (ix *Index) QueryResults(bounds quadtree.Bounds) []quadtree.Point {
+	// GEOSEARCH boxes are measured in km along the parallels of the points,
+	// so the box is as wide as the bounds where they are widest: at the
+	// latitude closest to the equator. The points are then cut to bounds.
+	closest := 0.0
+	if bounds.MinY > 0 || bounds.MaxY < 0 {
+		closest = math.Min(math.Abs(bounds.MinY), math.Abs(bounds.MaxY))
+	}
+	width := (bounds.MaxX - bounds.MinX) * kmPerDegree * math.Cos(closest*math.Pi/180)
+	height := (bounds.MaxY - bounds.MinY) * kmPerDegree
+	points, err := ix.search("FROMLONLAT", ftoa((bounds.MinX+bounds.MaxX)/2), ftoa((bounds.MinY+bounds.MaxY)/2),
+		"BYBOX", ftoa(width*1.01), ftoa(height*1.01), "km")
+	if err != nil {
+		return nil
+	}
+	return slices.DeleteFunc(points, func(p quadtree.Point) bool {
+		return p.X < bounds.MinX || p.X > bounds.MaxX || p.Y < bounds.MinY || p.Y > bounds.MaxY
+	})
+}
+
+// Nearest returns up to n points closest to (x, y), closest first, skipping
+// those keep rejects (a nil keep accepts every point). Redis orders them by
+// great-circle distance; they are then sorted by dist (Euclidean when nil),
+// which should agree.
+func (ix *Index) Nearest(x, y float64, n int, dist quadtree.DistanceFunc, keep func(quadtree.Point) bool) []quadtree.Point {
+	if n <= 0 {
+		return nil
+	}
+	if dist == nil {
+		dist = quadtree.Euclidean
+	}
+
+	// Ask for more of the closest points until enough of them are kept
+	for count := n; ; count *= 2 {
+		points, err := ix.search("FROMLONLAT", ftoa(x), ftoa(y), "BYRADIUS", ftoa(math.Pi*earthRadiusKm), "km", "ASC", "COUNT", strconv.Itoa(count))
+		if err != nil {
+			return nil
+		}
+		found := len(points)
+		if keep != nil {
+			points = slices.DeleteFunc(points, func(p quadtree.Point) bool { return !keep(p) })
+		}
+		if len(points) >= n || found < count {
+			slices.SortStableFunc(points, func(a, b quadtree.Point) int {
+				return cmp.Compare(dist(x, y, a.X, a.Y), dist(x, y, b.X, b.Y))
+			})
+			return points[:min(n, len(points))]
+		}
+	}
+}
+
+// search runs GEOSEARCH with the given arguments and returns the points
+// found with their coordinates
+func (ix *Index) search(args ...string) ([]quadtree.Point, error) {
+	reply, err := ix.client.Do(append(append([]string{"GEOSEARCH", ix.key}, args...), "WITHCOORD")...)
+	if err == nil {
+		var points []quadtree.Point
+		if points, err = parsePoints(reply); err == nil {
+			return points, nil
+		}
+	}
+	ix.mu.Lock()
+	ix.err = err
+	ix.mu.Unlock()
+	return nil, err
+}
+
+// parsePoints reads a GEOSEARCH WITHCOORD reply: an array of
+// [member, [longitude, latitude]]
+func parsePoints(reply any) ([]quadtree.Point, error) {
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected GEOSEARCH reply %v", reply)
+	}
+	points := make([]quadtree.Point, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.([]any)
+		if !ok || len(fields) != 2 {
+			return nil, fmt.Errorf("redis: unexpected GEOSEARCH item %v", item)
+		}
+		member, _ := fields[0].(string)
+		coords, _ := fields[1].([]any)
+		if len(coords) != 2 {
+			return nil, fmt.Errorf("redis: unexpected GEOSEARCH item %v", item)
+		}
+		lon, _ := coords[0].(string)
+		lat, _ := coords[1].(string)
+		id, err1 := strconv.Atoi(member)
+		x, err2 := strconv.ParseFloat(lon, 64)
+		y, err3 := strconv.ParseFloat(lat, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("redis: unexpected GEOSEARCH item %v", item)
+		}
+		points = append(points, quadtree.Point{ID: id, X: x, Y: y})
+	}
+	return points, nil
+}
+
+func ftoa(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
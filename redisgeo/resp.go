@@ -0,0 +1,221 @@
+package redisgeo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// commandTimeout bounds a round trip to the server, pipelined commands
+	// and all
+	commandTimeout = 2 * time.Second
+	// redialDelay is how long commands fail right away after dialing
+	// failed, rather than each waiting for a server that is down
+	redialDelay = 1 * time.Second
+)
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client is a connection to a Redis server speaking RESP2. It is safe for
+// concurrent use: commands take turns on the one connection, which is
+// dialed again after it fails.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+
+	mu       sync.Mutex
+	conn     net.Conn // nil until dialed and after a failure
+	r        *bufio.Reader
+	w        *bufio.Writer
+	dialErr  error // of the last failed dial
+	redialAt time.Time
+}
+
+// Dial connects to the server of a URL like redis://:password@host:6379/0
+func Dial(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q: want redis://[:password@]host[:port][/db]", rawURL)
+	}
+	c := &Client{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect dials the server and authenticates. c.mu must be held.
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, commandTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r, c.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	var setup [][]string
+	switch {
+	case c.username != "" && c.password != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if _, err := c.roundTrip(setup); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// Do runs one command and returns its reply
+func (c *Client) Do(args ...string) (any, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends the commands at once and returns their replies in order.
+// An error reply to any of them is returned as its Error.
+func (c *Client) Pipeline(cmds [][]string) ([]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if time.Now().Before(c.redialAt) {
+			return nil, c.dialErr
+		}
+		if err := c.connect(); err != nil {
+			c.dialErr, c.redialAt = err, time.Now().Add(redialDelay)
+			return nil, err
+		}
+	}
+	replies, err := c.roundTrip(cmds)
+	var reply Error
+	if err != nil && !errors.As(err, &reply) {
+		// The connection may be out of step with the replies
+		c.close()
+	}
+	return replies, err
+}
+
+// roundTrip writes the commands and reads their replies. c.mu must be held.
+func (c *Client) roundTrip(cmds [][]string) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	var replyErr error
+	for i := range replies {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(Error); ok && replyErr == nil {
+			replyErr = e
+		}
+		replies[i] = reply
+	}
+	return replies, replyErr
+}
+
+// read reads a reply: a string for simple and bulk strings (nil for a null
+// bulk string), an int64, an Error or a []any of replies
+func (c *Client) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return Error(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err // a null bulk string is nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// close drops the connection. c.mu must be held.
+func (c *Client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
@@ -0,0 +1,118 @@
+package redisgeo
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    any
+		wantErr bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{"-ERR unknown command\r\n", Error("ERR unknown command"), false},
+		{":42\r\n", int64(42), false},
+		{":-3\r\n", int64(-3), false},
+		{"$5\r\nhello\r\n", "hello", false},
+		{"$0\r\n\r\n", "", false},
+		{"$7\r\nline\r\n2\r\n", "line\r\n2", false},
+		{"$-1\r\n", nil, false},
+		{"*-1\r\n", nil, false},
+		{"*0\r\n", []any{}, false},
+		{"*3\r\n$4\r\ntaxi\r\n:7\r\n*2\r\n+a\r\n$-1\r\n", []any{"taxi", int64(7), []any{"a", nil}}, false},
+		{"+OK\n", nil, true},
+		{"!3\r\n", nil, true},
+		{":x\r\n", nil, true},
+		{"$5\r\nhi\r\n", nil, true},
+		{"*2\r\n+a\r\n", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		c := &Client{r: bufio.NewReader(strings.NewReader(tt.reply))}
+		got, err := c.read()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("read(%q) error = %v, want error %v", tt.reply, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("read(%q) = %#v, want %#v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmds    [][]string
+		request string // what the server receives
+		replies string // what it answers
+		want    []any
+		wantErr error
+	}{
+		{
+			name:    "one command",
+			cmds:    [][]string{{"GET", "k"}},
+			request: "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n",
+			replies: "$1\r\nv\r\n",
+			want:    []any{"v"},
+		},
+		{
+			name:    "pipelined",
+			cmds:    [][]string{{"GEOADD", "drivers", "44.0092", "36.1911", "7"}, {"ZCARD", "drivers"}},
+			request: "*5\r\n$6\r\nGEOADD\r\n$7\r\ndrivers\r\n$7\r\n44.0092\r\n$7\r\n36.1911\r\n$1\r\n7\r\n*2\r\n$5\r\nZCARD\r\n$7\r\ndrivers\r\n",
+			replies: ":1\r\n:1\r\n",
+			want:    []any{int64(1), int64(1)},
+		},
+		{
+			name:    "binary-safe arguments",
+			cmds:    [][]string{{"SET", "k", "a b\r\nc"}},
+			request: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$6\r\na b\r\nc\r\n",
+			replies: "+OK\r\n",
+			want:    []any{"OK"},
+		},
+		{
+			name:    "error reply",
+			cmds:    [][]string{{"PING"}, {"NOPE"}},
+			request: "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nNOPE\r\n",
+			replies: "+PONG\r\n-ERR unknown command 'NOPE'\r\n",
+			want:    []any{"PONG", Error("ERR unknown command 'NOPE'")},
+			wantErr: Error("ERR unknown command 'NOPE'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			c := &Client{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+			defer c.Close()
+
+			received := make(chan string, 1)
+			go func() {
+				buf := make([]byte, len(tt.request))
+				if _, err := io.ReadFull(server, buf); err != nil {
+					received <- err.Error()
+					return
+				}
+				received <- string(buf)
+				io.WriteString(server, tt.replies)
+			}()
+
+			got, err := c.Pipeline(tt.cmds)
+			if request := <-received; request != tt.request {
+				t.Errorf("sent %q, want %q", request, tt.request)
+			}
+			if err != tt.wantErr {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replies = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"strconv"
+	"time"
+)
+
+// maxRidesPerClient is how many rides one connection may have under way
+const maxRidesPerClient = 3
+
+// maxEndedRides is how many ended rides /api/rides/{id} still knows about
+const maxEndedRides = 1000
+
+// Errors of ride requests that aren't the request's fault
+var (
+	errRidesReplaying = errors.New("rides cannot be requested while replaying a recording")
+	errNoDriver       = errors.New("no driver accepted the ride")
+	errUnknownRide    = errors.New("unknown ride")
+)
+
+// handleRequestRide dispatches a ride requested over a WebSocket connection
+// and answers with the assigned driver or an error
+func (s *Simulation) handleRequestRide(ctx context.Context, client *WebSocketClient, requestID string, message []byte) {
+	var msg protocol.RequestRide
+	err := json.Unmarshal(message, &msg)
+	if err != nil {
+		err = fmt.Errorf("invalid request_ride message: %v", err)
+	} else {
+		err = msg.Validate()
+	}
+	if err == nil {
+		// The reply goes out from the main loop, so it comes before the
+		// trip's first progress message
+		err = s.exec(ctx, func() error {
+			assigned, err := s.requestRide(client, msg)
+			if err == nil {
+				s.sendReply(client, requestID, assigned)
+			}
+			return err
+		})
+	}
+	if err != nil {
+		s.sendReply(client, requestID, protocol.ErrorMessage{Type: protocol.TypeError, Error: err.Error()})
+	}
+}
+
+// RidesHandler handles POST /api/rides, dispatching a ride like a
+// request_ride message does. The rider tracks it through /api/rides/{id}.
+func (s *Simulation) RidesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg protocol.RequestRide
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid ride request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := msg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var assigned protocol.RideAssigned
+	err := s.exec(r.Context(), func() error {
+		var err error
+		assigned, err = s.requestRide(nil, msg)
+		return err
+	})
+	switch {
+	case errors.Is(err, errRidesReplaying), errors.Is(err, errClusterFollower), errors.Is(err, errShardGateway):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errNoDriver):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/rides/"+strconv.Itoa(assigned.TripID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(assigned)
+}
+
+// RideHandler handles GET /api/rides/{id}: where the driver of a ride is,
+// or how the ride ended
+func (s *Simulation) RideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ride id %q", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	var progress protocol.RideProgress
+	err = s.exec(r.Context(), func() error {
+		var err error
+		progress, err = s.rideStatus(id)
+		return err
+	})
+	if errors.Is(err, errUnknownRide) {
+		http.Error(w, fmt.Sprintf("unknown ride %d", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// rideStatus describes a ride under way, or one of the last maxEndedRides
+// that ended. It must run on the main loop.
+func (s *Simulation) rideStatus(id int) (protocol.RideProgress, error) {
+	for _, trip := range s.trips {
+		if trip.ID == id {
+			return s.rideProgress(trip, trip.State, "", nil), nil
+		}
+	}
+	if progress, ok := s.endedRides[id]; ok {
+		return progress, nil
+	}
+	return protocol.RideProgress{}, errUnknownRide
+}
+
+// rememberEndedRide keeps the last progress of a ride that ended, dropping
+// the oldest beyond maxEndedRides. It must run on the main loop.
+func (s *Simulation) rememberEndedRide(progress protocol.RideProgress) {
+	if s.endedRides == nil {
+		s.endedRides = make(map[int]protocol.RideProgress)
+	}
+	s.endedRides[progress.TripID] = progress
+	s.endedOrder = append(s.endedOrder, progress.TripID)
+	if len(s.endedOrder) > maxEndedRides {
+		delete(s.endedRides, s.endedOrder[0])
+		s.endedOrder = s.endedOrder[1:]
+	}
+}
+
+// requestRide dispatches a ride. A WebSocket client that requested it is
+// then sent the trip's progress; client is nil for HTTP requests. It must
+// run on the main loop.
+func (s *Simulation) requestRide(client *WebSocketClient, msg protocol.RequestRide) (protocol.RideAssigned, error) {
+	if s.replayer != nil {
+		return protocol.RideAssigned{}, errRidesReplaying
+	}
+	if err := s.mirroring(); err != nil {
+		return protocol.RideAssigned{}, err
+	}
+
+	pickup := waypoint{msg.Pickup.Lon, msg.Pickup.Lat}
+	if err := s.checkServed("pickup", pickup); err != nil {
+		return protocol.RideAssigned{}, err
+	}
+	var dropoff *waypoint
+	if msg.Dropoff != nil {
+		dropoff = &waypoint{msg.Dropoff.Lon, msg.Dropoff.Lat}
+		if err := s.checkServed("dropoff", *dropoff); err != nil {
+			return protocol.RideAssigned{}, err
+		}
+	}
+
+	if client != nil {
+		rides := 0
+		for _, trip := range s.trips {
+			if trip.rider == client {
+				rides++
+			}
+		}
+		if rides >= maxRidesPerClient {
+			return protocol.RideAssigned{}, fmt.Errorf("at most %d rides per connection", maxRidesPerClient)
+		}
+	}
+
+	trip := s.dispatchRide(pickup.lon, pickup.lat, dropoff)
+	if trip == nil {
+		return protocol.RideAssigned{}, errNoDriver
+	}
+	trip.rider = client
+
+	d := trip.Driver
+	d.mu.Lock()
+	driver := protocol.DriverResponse{
+		ID:       d.ID,
+		Lon:      d.lon(),
+		Lat:      d.lat(),
+		Status:   d.Status.String(),
+		Distance: geo.HaversineKm(pickup.lon, pickup.lat, d.lon(), d.lat()),
+		Heading:  math.Mod(d.heading()*180/math.Pi+360, 360),
+		Speed:    d.speed(),
+		Profile:  d.behavior().Name,
+		Vehicle:  d.Vehicle,
+	}
+	d.mu.Unlock()
+	d.applyGPS(&driver)
+
+	return protocol.RideAssigned{
+		Type:          protocol.TypeRideAssigned,
+		TripID:        trip.ID,
+		Driver:        driver,
+		Pickup:        msg.Pickup,
+		Dropoff:       protocol.Location{Lat: trip.Dropoff.lat, Lon: trip.Dropoff.lon},
+		EstimatedFare: trip.Estimate,
+		PickupETA:     trip.pickupETA.Seconds(),
+		Time:          s.clock.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+// checkServed reports an error for a ride position drivers can't reach
+func (s *Simulation) checkServed(what string, p waypoint) error {
+	if !s.settings.bounds().Contains(p.lon, p.lat) || !s.geofences.Allowed(p.lon, p.lat) {
+		return fmt.Errorf("%s (%g, %g) is outside the service area", what, p.lat, p.lon)
+	}
+	return nil
+}
+
+// publishRideProgress sends every rider the position of its driver. It must
+// run on the main loop, after the trips were updated.
+func (s *Simulation) publishRideProgress() {
+	for _, trip := range s.trips {
+		s.sendRideProgress(trip, trip.State, "", nil)
+	}
+}
+
+// sendRideProgress tells the rider of a trip, if it's still connected, where
+// its driver is and how far the next stop is
+func (s *Simulation) sendRideProgress(trip *Trip, state, reason string, final *protocol.Fare) {
+	if trip.rider == nil {
+		return
+	}
+	s.clientsMu.RLock()
+	_, connected := s.clients[trip.rider.clientID]
+	s.clientsMu.RUnlock()
+	if !connected {
+		trip.rider = nil
+		return
+	}
+	s.sendJSON(trip.rider, s.rideProgress(trip, state, reason, final))
+}
+
+// rideProgress describes where a trip's driver is and how far the next stop
+// is, once the trip reached state
+func (s *Simulation) rideProgress(trip *Trip, state, reason string, final *protocol.Fare) protocol.RideProgress {
+	target := trip.Pickup
+	if state == protocol.TripPickedUp || state == protocol.TripCompleted {
+		target = trip.Dropoff
+	}
+
+	d := trip.Driver
+	d.mu.Lock()
+	progress := protocol.RideProgress{
+		Type:       protocol.TypeRideProgress,
+		TripID:     trip.ID,
+		State:      state,
+		Reason:     reason,
+		DriverID:   d.ID,
+		Lon:        d.lon(),
+		Lat:        d.lat(),
+		Heading:    math.Mod(d.heading()*180/math.Pi+360, 360),
+		DistanceKm: geo.HaversineKm(d.lon(), d.lat(), target.lon, target.lat),
+		FinalFare:  final,
+		Time:       s.clock.Now().UnixNano() / int64(time.Millisecond),
+	}
+	d.mu.Unlock()
+	return progress
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"quadtree/protocol"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	sendQueueSize = 32              // frames waiting for a client's writer
+	maxSaturation = 5 * time.Second // clients whose queue stays full this long are disconnected
+	closeWait     = 2 * time.Second // how long a closed client has to answer the close frame
+)
+
+// outbound is a frame waiting in a client's send queue
+type outbound struct {
+	messageType int
+	data        []byte
+	// The same frame prepared once for every WebSocket it goes to, so it
+	// is framed and compressed once; nil for frames sent to a single client
+	prepared *websocket.PreparedMessage
+	// A placeholder for the client's pending driver update, which the
+	// writer sends in its place
+	update bool
+	kind   string // message type, for the message counts
+}
+
+// enqueue queues a frame for the client's writer without blocking, so a
+// slow client can't hold up a broadcast. When the queue is full the oldest
+// frame is dropped; a client that asked for deltas then gets a new snapshot,
+// since it missed changes. Clients whose queue stays full for maxSaturation
+// are disconnected.
+func (s *Simulation) enqueue(client *WebSocketClient, messageType int, kind string, data []byte) {
+	s.enqueueFrame(client, outbound{messageType: messageType, kind: kind, data: data})
+}
+
+// enqueueUpdate queues a driver update. When the client's writer hasn't
+// sent the previous one yet, the new one takes its place in the queue, so a
+// client that fell behind gets the latest positions once it catches up
+// rather than a burst of outdated ones.
+func (s *Simulation) enqueueUpdate(client *WebSocketClient, msg outbound) {
+	client.queueMu.Lock()
+	defer client.queueMu.Unlock()
+	if client.pendingUpdate != nil {
+		s.messages.stale.add(client.pendingUpdate.kind)
+		client.pendingUpdate = &msg
+		s.staleUpdates.Add(1)
+		client.metrics.stale.Add(1)
+		return
+	}
+	if s.queue(client, outbound{update: true, kind: msg.kind}) {
+		client.pendingUpdate = &msg
+	}
+}
+
+// lagging reports whether a driver update is still waiting for the
+// client's writer
+func (client *WebSocketClient) lagging() bool {
+	client.queueMu.Lock()
+	defer client.queueMu.Unlock()
+	return client.pendingUpdate != nil
+}
+
+// takeUpdate hands the writer the client's pending driver update
+func (client *WebSocketClient) takeUpdate() (outbound, bool) {
+	client.queueMu.Lock()
+	defer client.queueMu.Unlock()
+	msg := client.pendingUpdate
+	client.pendingUpdate = nil
+	if msg == nil {
+		return outbound{}, false
+	}
+	return *msg, true
+}
+
+// enqueueFrame is enqueue for a frame that may have been prepared
+func (s *Simulation) enqueueFrame(client *WebSocketClient, msg outbound) {
+	client.queueMu.Lock()
+	defer client.queueMu.Unlock()
+	s.queue(client, msg)
+}
+
+// queue does the work of enqueueFrame and reports whether the frame was
+// queued. client.queueMu must be held.
+func (s *Simulation) queue(client *WebSocketClient, msg outbound) bool {
+	if client.disconnecting {
+		return false // nothing goes out after the close frame
+	}
+
+	select {
+	case client.send <- msg:
+		client.saturatedSince = time.Time{}
+		return true
+	default:
+	}
+
+	now := time.Now()
+	if client.saturatedSince.IsZero() {
+		client.saturatedSince = now
+	} else if now.Sub(client.saturatedSince) > maxSaturation {
+		client.disconnecting = true
+		s.slowDisconnects.Add(1)
+		client.logger().Warn("Disconnecting slow client", "saturated_for", maxSaturation)
+
+		// The queue is full, so the close frame can't wait its turn
+		go func() {
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow")
+			client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+			time.AfterFunc(closeWait, func() { client.conn.Close() }) // ends its read loop, which removes it
+		}()
+		return false
+	}
+
+	// Make room by dropping the oldest frame. The writer may have taken one
+	// meanwhile, in which case nothing needs dropping.
+	select {
+	case old := <-client.send:
+		s.messages.dropped.add(client.dropped(old))
+		s.droppedMessages.Add(1)
+		client.metrics.dropped.Add(1)
+		client.resync.Store(true)
+	default:
+	}
+	select {
+	case client.send <- msg:
+		return true
+	default:
+		s.messages.dropped.add(msg.kind)
+		s.droppedMessages.Add(1)
+		client.metrics.dropped.Add(1)
+		return false
+	}
+}
+
+// dropped forgets the pending driver update when its placeholder was
+// dropped from the queue, and returns the type of the message dropped.
+// client.queueMu must be held.
+func (client *WebSocketClient) dropped(msg outbound) string {
+	if !msg.update {
+		return msg.kind
+	}
+	kind := msg.kind
+	if client.pendingUpdate != nil {
+		kind = client.pendingUpdate.kind
+	}
+	client.pendingUpdate = nil
+	return kind
+}
+
+// closeClient starts the close handshake with a client: the close frame is
+// queued behind the frames already waiting, and the connection is dropped
+// if the client hasn't answered it within closeWait
+func (s *Simulation) closeClient(client *WebSocketClient, code int, reason string) {
+	client.queueMu.Lock()
+	defer client.queueMu.Unlock()
+	if client.disconnecting {
+		return
+	}
+	client.disconnecting = true
+
+	msg := outbound{messageType: websocket.CloseMessage, kind: messageClose, data: websocket.FormatCloseMessage(code, reason)}
+	select {
+	case client.send <- msg:
+	default:
+		// Full; the close frame matters more than the oldest update
+		select {
+		case old := <-client.send:
+			s.messages.dropped.add(client.dropped(old))
+		default:
+		}
+		client.send <- msg
+	}
+}
+
+// writeLoop is the client's writer, the only goroutine that writes to its
+// connection: it sends the queued frames and a ping every pingPeriod until
+// ctx is canceled, a write fails or it sent a close frame
+func (s *Simulation) writeLoop(ctx context.Context, client *WebSocketClient) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-client.send:
+			if msg.update {
+				var ok bool
+				if msg, ok = client.takeUpdate(); !ok {
+					continue
+				}
+			}
+			err = s.writeFrame(client, msg)
+			if err == nil && msg.messageType == websocket.CloseMessage {
+				// The client answers with its own close frame, which ends
+				// the read loop; don't wait for it forever
+				time.AfterFunc(closeWait, func() { client.conn.Close() })
+				return
+			}
+		case <-ticker.C:
+			err = s.writeFrame(client, outbound{messageType: websocket.PingMessage})
+		}
+
+		if err != nil {
+			client.queueMu.Lock()
+			disconnecting := client.disconnecting
+			client.queueMu.Unlock()
+			if !disconnecting {
+				client.logger().Warn("Sending to client failed", "err", err)
+			}
+			client.conn.Close() // ends its read loop, which removes it
+			return
+		}
+	}
+}
+
+// writeToClient queues a text frame with a message of the given type for a
+// client
+func (s *Simulation) writeToClient(client *WebSocketClient, kind string, jsonMessage []byte) {
+	s.enqueue(client, websocket.TextMessage, kind, jsonMessage)
+}
+
+// disconnectClients tells every client that the server is going away and
+// closes its connection, then waits up to closeWait for them to answer
+func (s *Simulation) disconnectClients(reason string) {
+	s.shuttingDown.Store(true)
+
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		// Legacy frontends don't know the message; the close frame still
+		// tells them
+		if client.settings().version >= protocol.VersionFlat {
+			s.sendJSON(client, protocol.ServerShutdown{Type: protocol.TypeShutdown, Reason: reason})
+		}
+		s.closeClient(client, websocket.CloseGoingAway, reason)
+	}
+	s.clientsMu.RUnlock()
+
+	deadline := time.Now().Add(closeWait)
+	for time.Now().Before(deadline) {
+		s.clientsMu.RLock()
+		remaining := len(s.clients)
+		s.clientsMu.RUnlock()
+		if remaining == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
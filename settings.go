@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"quadtree/quadtree"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are the parameters of a run that used to be compiled in: the
+// world bounds, the initial fleet, the driver speeds, the search radius,
+// the intervals of the main loop and the port. main reads them from flags
+// and they are recorded with the run, so verify reproduces them. Each
+// simulation keeps its own; they don't change while it runs.
+type Settings struct {
+	MinLon float64 `json:"min_lon"`
+	MinLat float64 `json:"min_lat"`
+	MaxLon float64 `json:"max_lon"`
+	MaxLat float64 `json:"max_lat"`
+
+	Drivers      int     `json:"drivers"`
+	MinSpeedKmh  float64 `json:"min_speed_kmh"`
+	MaxSpeedKmh  float64 `json:"max_speed_kmh"`
+	SearchRadius float64 `json:"search_radius"` // degrees of arc
+
+	UpdateInterval time.Duration `json:"update_interval_ns"`
+	StatsInterval  time.Duration `json:"stats_interval_ns"`
+	QueryInterval  time.Duration `json:"query_interval_ns"`
+	DiagInterval   time.Duration `json:"diag_interval_ns"`
+	Port           int           `json:"port"`
+}
+
+// defaultSettings returns the settings of a run without flags
+func defaultSettings() Settings {
+	return Settings{
+		MinLon:         defaultMinLon,
+		MinLat:         defaultMinLat,
+		MaxLon:         defaultMaxLon,
+		MaxLat:         defaultMaxLat,
+		Drivers:        defaultDrivers,
+		MinSpeedKmh:    speedToKmh(minSpeed),
+		MaxSpeedKmh:    speedToKmh(maxSpeed),
+		SearchRadius:   defaultSearchRadius,
+		UpdateInterval: defaultUpdateInterval,
+		StatsInterval:  defaultStatsInterval,
+		QueryInterval:  defaultQueryInterval,
+		DiagInterval:   defaultDiagInterval,
+		Port:           serverPort,
+	}
+}
+
+// registerFlags defines a flag for every setting, defaulting to its
+// current value
+func (st *Settings) registerFlags(fs *flag.FlagSet) {
+	bounds := fmt.Sprintf("%g,%g,%g,%g", st.MinLon, st.MinLat, st.MaxLon, st.MaxLat)
+	fs.Func("bounds", "world bounds as minLon,minLat,maxLon,maxLat, which must contain the cities (default "+bounds+")", func(v string) error {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return fmt.Errorf("want minLon,minLat,maxLon,maxLat")
+		}
+		var b [4]float64
+		for i, part := range parts {
+			var err error
+			if b[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return err
+			}
+		}
+		st.MinLon, st.MinLat, st.MaxLon, st.MaxLat = b[0], b[1], b[2], b[3]
+		return nil
+	})
+	fs.IntVar(&st.Drivers, "drivers", st.Drivers, "drivers created at startup")
+	fs.Float64Var(&st.MinSpeedKmh, "min-speed", st.MinSpeedKmh, "lowest cruising speed of new drivers in km/h")
+	fs.Float64Var(&st.MaxSpeedKmh, "max-speed", st.MaxSpeedKmh, "highest cruising speed of new drivers in km/h")
+	fs.Float64Var(&st.SearchRadius, "radius", st.SearchRadius, "radius of the periodic nearby-driver query in degrees of arc")
+	fs.DurationVar(&st.UpdateInterval, "update-interval", st.UpdateInterval, "how often drivers move and their positions are broadcast")
+	fs.DurationVar(&st.StatsInterval, "stats-interval", st.StatsInterval, "how often fleet statistics are computed and broadcast")
+	fs.DurationVar(&st.QueryInterval, "query-interval", st.QueryInterval, "how often the nearby-driver query runs")
+	fs.DurationVar(&st.DiagInterval, "diag-interval", st.DiagInterval, "how often runtime diagnostics are logged")
+	fs.IntVar(&st.Port, "port", st.Port, "HTTP and WebSocket port")
+}
+
+// validate reports the first setting that is out of range
+func (st Settings) validate() error {
+	if !(st.MinLon >= -180 && st.MinLon < st.MaxLon && st.MaxLon <= 180) ||
+		!(st.MinLat >= -90 && st.MinLat < st.MaxLat && st.MaxLat <= 90) {
+		return fmt.Errorf("bounds must satisfy -180 <= minLon < maxLon <= 180 and -90 <= minLat < maxLat <= 90")
+	}
+	for _, city := range generateCities(numCities, nil) {
+		if city.Lon < st.MinLon || city.Lon > st.MaxLon || city.Lat < st.MinLat || city.Lat > st.MaxLat {
+			return fmt.Errorf("bounds must contain %s (%g,%g)", city.Name, city.Lon, city.Lat)
+		}
+	}
+	if st.Drivers < 0 || st.Drivers > maxFleetSize {
+		return fmt.Errorf("drivers must be between 0 and %d", maxFleetSize)
+	}
+	if !(st.MinSpeedKmh > 0) || st.MinSpeedKmh > st.MaxSpeedKmh || st.MaxSpeedKmh > maxSpeedKmhCap {
+		return fmt.Errorf("speeds must satisfy 0 < min <= max <= %d km/h", maxSpeedKmhCap)
+	}
+	if span := max(st.MaxLon-st.MinLon, st.MaxLat-st.MinLat); !(st.SearchRadius > 0 && st.SearchRadius <= span) {
+		return fmt.Errorf("radius must be above 0 and at most %g degrees, the span of the bounds", span)
+	}
+	if st.UpdateInterval < minClientInterval || st.UpdateInterval > maxClientInterval || st.UpdateInterval%broadcastTick != 0 {
+		return fmt.Errorf("update interval must be a multiple of %dms between %dms and %dms",
+			broadcastTick.Milliseconds(), minClientInterval.Milliseconds(), maxClientInterval.Milliseconds())
+	}
+	if min(st.StatsInterval, st.QueryInterval, st.DiagInterval) < broadcastTick {
+		return fmt.Errorf("stats, query and diag intervals must be at least %dms", broadcastTick.Milliseconds())
+	}
+	if st.Port < 1 || st.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// bounds returns the world bounds, which every index covers and drivers,
+// riders and destinations stay inside
+func (st Settings) bounds() quadtree.Bounds {
+	return quadtree.Bounds{MinX: st.MinLon, MinY: st.MinLat, MaxX: st.MaxLon, MaxY: st.MaxLat}
+}
+
+// tunables returns the parameters a simulation with these settings starts
+// with
+func (st Settings) tunables() Tunables {
+	t := defaultTunables
+	t.MinSpeed = kmhToSpeed(st.MinSpeedKmh)
+	t.MaxSpeed = kmhToSpeed(st.MaxSpeedKmh)
+	t.ClientInterval = st.UpdateInterval // clients are sent every move by default
+	return t
+}
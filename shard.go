@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"quadtree/protocol"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Points of every shard on the hash ring; more spread the drivers more
+	// evenly
+	ringReplicas = 128
+
+	// Broadcast events waiting for the gateway connection (see offer); the
+	// ones dropped count towards shard_dropped in /api/diag
+	shardQueueSize = 1000
+)
+
+// errShardGateway is returned for changes made on a shard gateway, whose
+// drivers the shard workers simulate
+var errShardGateway = errors.New("this server shows the drivers of its shards; make changes on the shards")
+
+// hashRing assigns driver IDs to shards by consistent hashing: every shard
+// has ringReplicas points on a ring of hashes, and an ID belongs to the
+// first point at or after its own hash. Adding or removing a shard only
+// moves the IDs next to its points.
+type hashRing struct {
+	hashes []uint64
+	shards []string // owner of the hash at the same index
+}
+
+func newHashRing(shards []string) *hashRing {
+	type point struct {
+		hash  uint64
+		shard string
+	}
+	points := make([]point, 0, len(shards)*ringReplicas)
+	for _, shard := range shards {
+		for i := 0; i < ringReplicas; i++ {
+			h := fnv.New64a()
+			h.Write([]byte(shard + "#" + strconv.Itoa(i)))
+			points = append(points, point{mix64(h.Sum64()), shard})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &hashRing{hashes: make([]uint64, len(points)), shards: make([]string, len(points))}
+	for i, p := range points {
+		ring.hashes[i], ring.shards[i] = p.hash, p.shard
+	}
+	return ring
+}
+
+// owner returns the shard of a driver ID
+func (r *hashRing) owner(id int) string {
+	h := mix64(uint64(id))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.shards[i]
+}
+
+// mix64 scatters the bits of x (the SplitMix64 finalizer), so consecutive
+// IDs land all over the ring
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ParseShards parses a -shards list like "w1,w2,w3"
+func ParseShards(spec string) ([]string, error) {
+	var shards []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if slices.Contains(shards, name) {
+			return nil, fmt.Errorf("duplicate shard %q", name)
+		}
+		shards = append(shards, name)
+	}
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	return shards, nil
+}
+
+// ShardStatus reports the state of a shard's feed to the gateway
+type ShardStatus struct {
+	Name       string    `json:"name"`
+	Connected  bool      `json:"connected"`
+	Drivers    int       `json:"drivers"`
+	LastUpdate time.Time `json:"last_update,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+	// Drivers the shard sent that the ring gives another shard, which
+	// means the servers disagree about the shards
+	Misplaced int64 `json:"misplaced,omitempty"`
+}
+
+// ShardConfig configures a shard worker
+type ShardConfig struct {
+	Shards   []string // every shard, the same list on the gateway and every worker
+	Name     string   // this worker's shard
+	Gateway  string   // http(s)://host:port of the gateway
+	Token    string   // API key presented to the gateway, if it requires one
+	Interval time.Duration
+}
+
+// shardWorker simulates one shard's drivers and feeds them to the gateway
+type shardWorker struct {
+	name    string
+	feedURL string
+	token   string
+	ring    *hashRing
+	every   time.Duration
+
+	frames chan []protocol.DriverResponse // the latest drivers
+	events chan []byte                    // encoded clusterEvents
+
+	mu     sync.Mutex
+	status ShardStatus
+}
+
+// owns reports whether a driver ID is the worker's
+func (w *shardWorker) owns(id int) bool {
+	return w.ring.owner(id) == w.name
+}
+
+// RunShard makes the simulation one shard of a sharded fleet: it keeps
+// only the drivers the ring gives its shard, and feeds them and its events
+// to the gateway until ctx is canceled. It must run before the simulation
+// starts.
+func (s *Simulation) RunShard(ctx context.Context, cfg ShardConfig) error {
+	if !slices.Contains(cfg.Shards, cfg.Name) {
+		return fmt.Errorf("shard %q is not one of %s", cfg.Name, strings.Join(cfg.Shards, ","))
+	}
+	u, err := url.Parse(cfg.Gateway)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid gateway URL %q: want http(s)://host:port", cfg.Gateway)
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/shards/feed"
+	u.RawQuery = url.Values{"shard": {cfg.Name}}.Encode()
+	if cfg.Interval <= 0 {
+		cfg.Interval = s.settings.UpdateInterval
+	}
+
+	w := &shardWorker{
+		name:    cfg.Name,
+		feedURL: u.String(),
+		token:   cfg.Token,
+		ring:    newHashRing(cfg.Shards),
+		every:   cfg.Interval,
+		frames:  make(chan []protocol.DriverResponse, 1),
+		events:  make(chan []byte, shardQueueSize),
+		status:  ShardStatus{Name: cfg.Name},
+	}
+	var others []int
+	for _, driver := range s.drivers {
+		if driver.Origin == "" && !w.owns(driver.ID) {
+			others = append(others, driver.ID)
+		}
+	}
+	s.removeDrivers(others)
+	s.UpdateIndex()
+	s.shard = w
+	slog.Info("Simulating shard", "shard", cfg.Name, "shards", len(cfg.Shards), "drivers", len(s.drivers), "gateway", cfg.Gateway)
+
+	s.goTracked(func() { s.feedGateway(ctx, w) })
+	return nil
+}
+
+// queueShardFrame queues the shard's drivers as last published for the
+// gateway, replacing ones not sent yet. It runs on the main loop, paused
+// or not, so the gateway sees drivers stop.
+func (s *Simulation) queueShardFrame() {
+	world := s.world()
+	drivers := make([]protocol.DriverResponse, 0, len(world.drivers))
+	for _, state := range world.drivers {
+		if state.resp.Origin == "" || state.resp.Origin == deviceOrigin {
+			drivers = append(drivers, state.resp)
+		}
+	}
+	select {
+	case <-s.shard.frames:
+		s.shardDropped.Add(1)
+	default:
+	}
+	s.shard.frames <- drivers
+}
+
+// queueShardEvent queues a broadcast message for the gateway's clients.
+// The demand heatmap only covers the shard's share of the ride requests,
+// so it stays with the shard.
+func (s *Simulation) queueShardEvent(frames *eventFrames) {
+	if frames.kind == protocol.TypeDemandHeatmap {
+		return
+	}
+	payload, err := json.Marshal(clusterEvent{
+		Node:      s.shard.name,
+		Kind:      frames.kind,
+		Flat:      frames.flat,
+		Enveloped: frames.enveloped,
+		Proto:     frames.proto,
+	})
+	if err != nil {
+		slog.Error("Encoding shard event failed", "err", err)
+		return
+	}
+	offer(s.shard.events, payload, &s.shardDropped)
+}
+
+// feedGateway keeps a connection to the gateway, reconnecting with backoff
+// when it drops
+func (s *Simulation) feedGateway(ctx context.Context, w *shardWorker) {
+	retry := peerRetryMin
+	for {
+		err := s.streamToGateway(ctx, w, func() { retry = peerRetryMin })
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("Shard gateway disconnected", "shard", w.name, "err", err, "retry_in", retry)
+		w.mu.Lock()
+		w.status.Connected = false
+		w.status.LastError = err.Error()
+		w.mu.Unlock()
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		retry = min(retry*2, peerRetryMax)
+	}
+}
+
+// streamToGateway runs one connection to the gateway, sending the queued
+// drivers as binary taxi.v1.DriversUpdate frames and the events as JSON
+// text frames; connected is called once the gateway accepted the shard
+func (s *Simulation) streamToGateway(ctx context.Context, w *shardWorker, connected func()) error {
+	header := http.Header{}
+	if w.token != "" {
+		header.Set("Authorization", "Bearer "+w.token)
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, w.feedURL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	slog.Info("Feeding shard to gateway", "shard", w.name, "url", w.feedURL)
+	w.mu.Lock()
+	w.status.Connected = true
+	w.status.LastError = ""
+	w.mu.Unlock()
+	connected()
+
+	// The gateway sends nothing but control frames; reading handles them
+	// and notices when it goes away
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shard shutting down"), time.Now().Add(writeWait))
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case drivers := <-w.frames:
+			update := protocol.DriversUpdate{Drivers: drivers, Time: time.Now().UnixMilli()}
+			var data []byte
+			if data, err = update.MarshalProto(); err != nil {
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err = conn.WriteMessage(websocket.BinaryMessage, data); err == nil {
+				s.shardSent.Add(1)
+				w.mu.Lock()
+				w.status.Drivers = len(drivers)
+				w.status.LastUpdate = time.Now()
+				w.mu.Unlock()
+			}
+		case event := <-w.events:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err = conn.WriteMessage(websocket.TextMessage, event); err == nil {
+				s.shardSent.Add(1)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// shardGateway owns the client connections of a sharded fleet and shows
+// the drivers its shards feed it
+type shardGateway struct {
+	ring   *hashRing
+	shards []string
+
+	// The drivers of every shard by ID, and whether the index is behind
+	// them; only used on the main loop
+	drivers map[string]map[int]*Driver
+	dirty   bool
+
+	mu     sync.Mutex
+	status map[string]*ShardStatus
+}
+
+// ServeShards makes the simulation the gateway of the given shards: it
+// simulates no drivers of its own, and shows the ones the shard workers
+// feed it on /api/shards/feed. It must run before the simulation starts.
+func (s *Simulation) ServeShards(shards []string) {
+	gw := &shardGateway{
+		ring:    newHashRing(shards),
+		shards:  shards,
+		drivers: make(map[string]map[int]*Driver, len(shards)),
+		status:  make(map[string]*ShardStatus, len(shards)),
+	}
+	for _, name := range shards {
+		gw.drivers[name] = make(map[int]*Driver)
+		gw.status[name] = &ShardStatus{Name: name}
+	}
+	s.setDrivers(nil)
+	s.UpdateIndex()
+	s.gateway = gw
+	slog.Info("Serving as shard gateway", "shards", strings.Join(shards, ","))
+}
+
+// setStatus updates a shard's status under the lock
+func (gw *shardGateway) setStatus(name string, update func(*ShardStatus)) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	update(gw.status[name])
+}
+
+// ShardFeedHandler accepts the feed of a shard worker on
+// /api/shards/feed?shard={name}
+func (s *Simulation) ShardFeedHandler(w http.ResponseWriter, r *http.Request) {
+	gw := s.gateway
+	if gw == nil {
+		http.Error(w, "this server is not a shard gateway", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("shard")
+	if !slices.Contains(gw.shards, name) {
+		http.Error(w, fmt.Sprintf("unknown shard %q", name), http.StatusBadRequest)
+		return
+	}
+	gw.mu.Lock()
+	taken := gw.status[name].Connected
+	if !taken {
+		gw.status[name].Connected = true
+		gw.status[name].LastError = ""
+	}
+	gw.mu.Unlock()
+	if taken {
+		http.Error(w, fmt.Sprintf("shard %s is already connected", name), http.StatusConflict)
+		return
+	}
+
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		gw.setStatus(name, func(st *ShardStatus) { st.Connected = false })
+		return
+	}
+	s.tracked.Add(1)
+	defer s.tracked.Done()
+	defer conn.Close()
+	ctx := r.Context()
+	stop := context.AfterFunc(ctx, func() {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+		conn.Close()
+	})
+	defer stop()
+
+	slog.Info("Shard connected", "shard", name, "remote_addr", remoteIP(r))
+	err = s.readShard(ctx, conn, name)
+	if ctx.Err() != nil {
+		return
+	}
+
+	slog.Warn("Shard disconnected", "shard", name, "err", err)
+	// Drop the shard's drivers rather than showing them frozen in place,
+	// before a new connection of the shard can add them again
+	s.exec(ctx, func() error {
+		s.applyShardUpdate(name, nil)
+		return nil
+	})
+	gw.setStatus(name, func(st *ShardStatus) {
+		st.Connected = false
+		st.LastError = err.Error()
+	})
+}
+
+// readShard merges the frames of a shard's feed until it ends
+func (s *Simulation) readShard(ctx context.Context, conn *websocket.Conn, name string) error {
+	for {
+		// Workers send the drivers at least every update, paused or not
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		s.shardReceived.Add(1)
+
+		switch typ {
+		case websocket.BinaryMessage:
+			var update protocol.DriversUpdate
+			if err := update.UnmarshalProto(data); err != nil {
+				return fmt.Errorf("invalid drivers frame: %w", err)
+			}
+			err = s.exec(ctx, func() error {
+				s.applyShardUpdate(name, &update)
+				return nil
+			})
+		case websocket.TextMessage:
+			var event clusterEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return fmt.Errorf("invalid event frame: %w", err)
+			}
+			err = s.exec(ctx, func() error {
+				s.hub.Publish(topicEvents, &eventFrames{kind: event.Kind, flat: event.Flat, enveloped: event.Enveloped, proto: event.Proto})
+				return nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyShardUpdate replaces the drivers of a shard with the ones in update
+// (nil removes them all). They keep their IDs and carry the shard's name
+// as their origin. Drivers the ring gives another shard are skipped, so a
+// misconfigured worker can't show a driver twice. The index is updated
+// with the next simulation update. It must run on the main loop.
+func (s *Simulation) applyShardUpdate(name string, update *protocol.DriversUpdate) {
+	gw := s.gateway
+	known := gw.drivers[name]
+	seen := make(map[int]bool, len(known))
+	var added []*Driver
+	var misplaced int64
+
+	if update != nil {
+		for _, rd := range update.Drivers {
+			if gw.ring.owner(rd.ID) != name {
+				misplaced++
+				continue
+			}
+			status, err := parseDriverStatus(rd.Status)
+			if err != nil {
+				continue
+			}
+			seen[rd.ID] = true
+
+			driver, ok := known[rd.ID]
+			if !ok {
+				driver = &Driver{ID: rd.ID, Origin: name, motion: newMotion(0, 0, 0, 0)}
+				known[rd.ID] = driver
+				added = append(added, driver)
+			}
+
+			driver.mu.Lock()
+			driver.moveTo(rd.Lon, rd.Lat)
+			driver.Status = status
+			driver.setSpeed(rd.Speed)
+			driver.setHeading(rd.Heading * math.Pi / 180)
+			driver.setVelocity(rd.VelLon, rd.VelLat)
+			driver.reportedAt = time.Now()
+			if rd.UpdatedAt != 0 {
+				driver.reportedAt = time.UnixMilli(rd.UpdatedAt)
+			}
+			driver.Vehicle = rd.Vehicle
+			if profile, ok := findProfile(rd.Profile); ok {
+				driver.profile = profile
+			}
+			driver.mu.Unlock()
+		}
+	}
+
+	removed := make(map[*Driver]bool)
+	for id, driver := range known {
+		if !seen[id] {
+			removed[driver] = true
+			delete(known, id)
+		}
+	}
+	s.swapDrivers(removed, added)
+	gw.dirty = true
+
+	gw.setStatus(name, func(st *ShardStatus) {
+		st.Drivers = len(known)
+		st.Misplaced += misplaced
+		if update != nil {
+			st.LastUpdate = time.Now()
+		}
+	})
+}
+
+// ShardsHandler lists the shards and the state of their feeds: all of them
+// on a gateway, and its own on a worker
+func (s *Simulation) ShardsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	role := ""
+	shards := []ShardStatus{}
+	switch {
+	case s.gateway != nil:
+		role = "gateway"
+		s.gateway.mu.Lock()
+		for _, name := range s.gateway.shards {
+			shards = append(shards, *s.gateway.status[name])
+		}
+		s.gateway.mu.Unlock()
+	case s.shard != nil:
+		role = "shard"
+		s.shard.mu.Lock()
+		shards = append(shards, s.shard.status)
+		s.shard.mu.Unlock()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":   role,
+		"shards": shards,
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"quadtree/geo"
+	"quadtree/quadtree"
+	"time"
+)
+
+// WorldSnapshot is the world as of one publish: every driver as clients
+// are sent it, the drivers of every hub topic, and copies of the cities'
+// spatial indexes. The main loop builds a new one after every update and
+// publishes it atomically. A published snapshot never changes, so HTTP
+// handlers and WebSocket clients read it without taking any lock and
+// always see drivers and index from the same moment.
+type WorldSnapshot struct {
+	epoch       int64 // counts publishes; 0 before the first
+	publishedAt time.Time
+
+	drivers map[int]driverState
+	topics  map[string][]driverState // the drivers of every zone, city and driver topic
+
+	// Copied from the live index, and shared with the previous snapshot
+	// when the live index hasn't changed since
+	trees        cityTrees
+	indexVersion int64
+}
+
+// emptyWorld is what readers see before the first publish
+var emptyWorld = &WorldSnapshot{}
+
+// world returns the last published snapshot
+func (s *Simulation) world() *WorldSnapshot {
+	if w := s.snapshot.Load(); w != nil {
+		return w
+	}
+	return emptyWorld
+}
+
+// publishWorld publishes a snapshot of the given drivers and topics along
+// with the current index. It must run on the main loop.
+func (s *Simulation) publishWorld(drivers map[int]driverState, topics map[string][]driverState) {
+	prev := s.world()
+	trees, version := prev.trees, prev.indexVersion
+	if _, v, _, _ := s.index.state(); trees == nil || v != version {
+		trees, version = s.index.copyTrees()
+	}
+
+	s.snapshot.Store(&WorldSnapshot{
+		epoch:        prev.epoch + 1,
+		publishedAt:  time.Now(),
+		drivers:      drivers,
+		topics:       topics,
+		trees:        trees,
+		indexVersion: version,
+	})
+}
+
+// driver returns a driver as published
+func (w *WorldSnapshot) driver(id int) (driverState, bool) {
+	state, ok := w.drivers[id]
+	return state, ok
+}
+
+// nearby returns the indexed drivers within radius degrees of arc of a
+// location, only among the named city's drivers unless city is empty
+func (w *WorldSnapshot) nearby(city string, lon, lat, radius float64) []quadtree.Point {
+	return inCircle(w.trees.query(city, circleBounds(lon, lat, radius)), lon, lat, radius)
+}
+
+// nearest returns up to n indexed drivers closest to a location by
+// great-circle distance, closest first, skipping those keep rejects
+func (w *WorldSnapshot) nearest(lon, lat float64, n int, keep func(quadtree.Point) bool) []quadtree.Point {
+	return w.trees.nearest(lon, lat, n, geo.HaversineKm, keep)
+}
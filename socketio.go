@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Engine.IO 4 packet types, the transport layer of Socket.IO 3 and later
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+	eioUpgrade = '5'
+	eioNoop    = '6'
+)
+
+// Socket.IO 5 packet types, carried in Engine.IO messages
+const (
+	sioConnect      = '0'
+	sioDisconnect   = '1'
+	sioEvent        = '2'
+	sioAck          = '3'
+	sioConnectError = '4'
+)
+
+const (
+	socketIOPingInterval = 25 * time.Second
+	socketIOPingTimeout  = 20 * time.Second
+	// Largest packet, or POST of packets, a client may send
+	socketIOMaxPayload = 1 << 20
+	// Packets waiting for a polling client's next request. A client that
+	// falls further behind is disconnected, like a WebSocket client whose
+	// writes time out.
+	socketIOMaxPending = 256
+	// Separates the packets of a polling request or response
+	socketIOSeparator = "\x1e"
+)
+
+var errSessionClosed = errors.New("Socket.IO session closed")
+
+// socketIOHandshake is the payload of an Engine.IO open packet
+type socketIOHandshake struct {
+	SID          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int64    `json:"pingInterval"`
+	PingTimeout  int64    `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload"`
+}
+
+// socketIOServer serves /socket.io/, the driver feed of a WebSocket client
+// for socket.io-client: Engine.IO sessions over HTTP long polling or
+// WebSockets, each with a Socket.IO client in the default namespace.
+// Server messages arrive as events named after their type, and clients
+// emit their messages the same way.
+type socketIOServer struct {
+	sim *Simulation
+	ctx context.Context // ends every session when done
+
+	mu       sync.Mutex
+	sessions map[string]*socketIOSession // by Engine.IO session ID
+}
+
+func newSocketIOServer(ctx context.Context, sim *Simulation) *socketIOServer {
+	return &socketIOServer{sim: sim, ctx: ctx, sessions: make(map[string]*socketIOSession)}
+}
+
+// socketIOSession is the Engine.IO session of one client, and the
+// connection its frames go out on. Until the client upgrades, packets wait
+// for its next poll; after, they go out over the WebSocket.
+type socketIOSession struct {
+	sid    string
+	client *WebSocketClient
+	ctx    context.Context // the session's; ends its writer and commands
+	cancel context.CancelFunc
+
+	mu        sync.Mutex // held while writing, so packets stay in order
+	ws        atomic.Pointer[websocket.Conn]
+	pending   []string      // packets for the next poll
+	ready     chan struct{} // signaled when packets were added
+	polling   bool          // a poll is waiting
+	joined    bool          // the client connected to the namespace
+	lastPong  time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ServeHTTP handles the Engine.IO requests of /socket.io/: opening a
+// session, polling it, posting packets to it and upgrading it
+func (srv *socketIOServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	query := r.URL.Query()
+	if query.Get("EIO") != "4" {
+		socketIOError(w, 5, "Unsupported protocol version")
+		return
+	}
+	var sess *socketIOSession
+	if sid := query.Get("sid"); sid != "" {
+		srv.mu.Lock()
+		sess = srv.sessions[sid]
+		srv.mu.Unlock()
+		if sess == nil {
+			socketIOError(w, 1, "Session ID unknown")
+			return
+		}
+	}
+
+	switch transport := query.Get("transport"); {
+	case transport == "polling" && r.Method == http.MethodGet && sess == nil:
+		srv.open(w, r)
+	case transport == "polling" && r.Method == http.MethodGet:
+		srv.poll(w, r, sess)
+	case transport == "polling" && r.Method == http.MethodPost && sess != nil:
+		srv.post(w, r, sess)
+	case transport == "polling":
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case transport == "websocket":
+		srv.serveWebSocket(w, r, sess)
+	default:
+		socketIOError(w, 0, "Transport unknown")
+	}
+}
+
+// socketIOError answers a request Engine.IO can't serve the way its
+// servers do
+func socketIOError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+}
+
+// newSession admits a client and starts its session, which lasts until the
+// client leaves or stops answering pings. ws is nil for polling clients.
+func (srv *socketIOServer) newSession(w http.ResponseWriter, r *http.Request) (*socketIOSession, bool) {
+	key, release, ok := srv.sim.admitClient(w, r)
+	if !ok {
+		return nil, false
+	}
+	sess := &socketIOSession{
+		sid:      rand.Text(),
+		ready:    make(chan struct{}, 1),
+		lastPong: time.Now(),
+		closed:   make(chan struct{}),
+	}
+	sess.ctx, sess.cancel = context.WithCancel(srv.ctx)
+	sess.client = &WebSocketClient{
+		conn:     sess,
+		clientID: fmt.Sprintf("client-%d", time.Now().UnixNano()),
+		identity: identityName(key),
+		// Events carry the messages of the flat protocol
+		cfg:  clientSettings{version: protocol.VersionFlat, encoding: protocol.EncodingJSON},
+		send: make(chan outbound, sendQueueSize),
+
+		transport:   TransportSocketIO,
+		remoteAddr:  remoteIP(r),
+		connectedAt: time.Now(),
+	}
+
+	srv.mu.Lock()
+	srv.sessions[sess.sid] = sess
+	srv.mu.Unlock()
+	srv.sim.goTracked(func() { srv.run(sess, release) })
+	return sess, true
+}
+
+// handshake returns the open packet of a session
+func (sess *socketIOSession) handshake(upgrades []string) string {
+	data, _ := json.Marshal(socketIOHandshake{
+		SID:          sess.sid,
+		Upgrades:     upgrades,
+		PingInterval: socketIOPingInterval.Milliseconds(),
+		PingTimeout:  socketIOPingTimeout.Milliseconds(),
+		MaxPayload:   socketIOMaxPayload,
+	})
+	return string(eioOpen) + string(data)
+}
+
+// open starts a polling session, which the client may upgrade
+func (srv *socketIOServer) open(w http.ResponseWriter, r *http.Request) {
+	sess, ok := srv.newSession(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	io.WriteString(w, sess.handshake([]string{"websocket"}))
+}
+
+// run pings the client until the session closes, then takes the client out
+// of the broadcasts
+func (srv *socketIOServer) run(sess *socketIOSession, release func()) {
+	defer func() {
+		sess.Close()
+		sess.cancel()
+		srv.mu.Lock()
+		delete(srv.sessions, sess.sid)
+		srv.mu.Unlock()
+
+		sess.mu.Lock()
+		joined := sess.joined
+		sess.mu.Unlock()
+		if joined {
+			client := sess.client
+			srv.sim.removeClient(client)
+			client.logger().Info("Socket.IO client disconnected", "connected_for", time.Since(client.connectedAt),
+				"messages_sent", client.metrics.messages.Load(), "bytes_sent", client.metrics.bytes.Load())
+		}
+		release()
+	}()
+
+	ticker := time.NewTicker(socketIOPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.closed:
+			return
+		case <-sess.ctx.Done():
+			return
+		case <-ticker.C:
+			sess.mu.Lock()
+			silent := time.Since(sess.lastPong)
+			sess.mu.Unlock()
+			if silent > socketIOPingInterval+socketIOPingTimeout {
+				sess.client.logger().Warn("Socket.IO client lost", "silent", silent.Round(time.Second))
+				return
+			}
+			if err := sess.send(string(eioPing)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// poll answers a polling client's GET with the packets waiting for it,
+// holding the request until there are some
+func (srv *socketIOServer) poll(w http.ResponseWriter, r *http.Request, sess *socketIOSession) {
+	sess.mu.Lock()
+	if sess.polling {
+		// Clients never poll twice at once
+		sess.mu.Unlock()
+		sess.Close()
+		socketIOError(w, 3, "Bad request")
+		return
+	}
+	sess.polling = true
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		sess.polling = false
+		sess.mu.Unlock()
+	}()
+
+	// A ping goes out every interval, so no poll waits longer than that
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(socketIOPingInterval + writeWait))
+	var packets []string
+	for len(packets) == 0 {
+		select {
+		case <-sess.ready:
+			packets = sess.take()
+		case <-sess.closed:
+			packets = append(sess.take(), string(eioClose))
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	io.WriteString(w, strings.Join(packets, socketIOSeparator))
+	if packets[len(packets)-1] == string(eioClose) {
+		sess.Close()
+	}
+}
+
+// post handles the packets a polling client sends
+func (srv *socketIOServer) post(w http.ResponseWriter, r *http.Request, sess *socketIOSession) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, socketIOMaxPayload))
+	if err != nil {
+		sess.Close()
+		socketIOError(w, 3, "Bad request")
+		return
+	}
+	for _, packet := range strings.Split(string(body), socketIOSeparator) {
+		srv.handlePacket(sess, packet)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	io.WriteString(w, "ok")
+}
+
+// serveWebSocket handles a WebSocket request: a new session for clients
+// that skip polling, or the upgrade of a polling session
+func (srv *socketIOServer) serveWebSocket(w http.ResponseWriter, r *http.Request, sess *socketIOSession) {
+	upgrading := sess != nil
+	if !upgrading {
+		var ok bool
+		if sess, ok = srv.newSession(w, r); !ok {
+			return
+		}
+	}
+	conn, err := srv.sim.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("Socket.IO WebSocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		if !upgrading {
+			sess.Close()
+		}
+		return
+	}
+	// The server doesn't wait for hijacked connections when it shuts down
+	srv.sim.tracked.Add(1)
+	defer srv.sim.tracked.Done()
+	defer conn.Close()
+	conn.SetReadLimit(socketIOMaxPayload)
+
+	if upgrading {
+		if err := sess.upgrade(conn); err != nil {
+			sess.client.logger().Warn("Socket.IO upgrade failed", "err", err)
+			return
+		}
+	} else {
+		sess.mu.Lock()
+		sess.ws.Store(conn)
+		sess.mu.Unlock()
+		if err := sess.send(sess.handshake([]string{})); err != nil {
+			sess.Close()
+			return
+		}
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketIOPingInterval + socketIOPingTimeout))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if messageType == websocket.TextMessage {
+			srv.handlePacket(sess, string(data))
+		}
+	}
+	// The polling transport is gone by now, so the session ends with its
+	// WebSocket
+	sess.Close()
+}
+
+// upgrade moves a polling session to a WebSocket: the client probes it,
+// then asks for the upgrade, and the waiting poll ends with a noop
+func (sess *socketIOSession) upgrade(conn *websocket.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(socketIOPingTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if string(data) != string(eioPing)+"probe" {
+		return fmt.Errorf("unexpected probe %q", data)
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(string(eioPong)+"probe")); err != nil {
+		return err
+	}
+	_, data, err = conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if string(data) != string(eioUpgrade) {
+		return fmt.Errorf("unexpected upgrade %q", data)
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for _, packet := range sess.pending {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(packet)); err != nil {
+			return err
+		}
+	}
+	sess.pending = []string{string(eioNoop)}
+	sess.ws.Store(conn)
+	select {
+	case sess.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// handlePacket handles an Engine.IO packet from the client
+func (srv *socketIOServer) handlePacket(sess *socketIOSession, packet string) {
+	if packet == "" {
+		return
+	}
+	switch packet[0] {
+	case eioClose:
+		sess.Close()
+	case eioPing:
+		sess.send(string(eioPong) + packet[1:])
+	case eioPong:
+		sess.mu.Lock()
+		sess.lastPong = time.Now()
+		sess.mu.Unlock()
+	case eioMessage:
+		srv.handleSocketIO(sess, packet[1:])
+	}
+}
+
+// handleSocketIO handles a Socket.IO packet: connecting to the namespace,
+// disconnecting, or an event whose name is the type of a client message
+func (srv *socketIOServer) handleSocketIO(sess *socketIOSession, packet string) {
+	if packet == "" {
+		return
+	}
+	kind, rest := packet[0], packet[1:]
+	if strings.HasPrefix(rest, "/") {
+		// Only the default namespace carries the feed
+		nsp, _, _ := strings.Cut(rest, ",")
+		if nsp != "/" {
+			sess.send(fmt.Sprintf(`%c%c%s,{"message":"Invalid namespace"}`, eioMessage, sioConnectError, nsp))
+			return
+		}
+		_, rest, _ = strings.Cut(rest, ",")
+	}
+
+	switch kind {
+	case sioConnect:
+		srv.join(sess)
+	case sioDisconnect:
+		sess.Close()
+	case sioEvent:
+		sess.mu.Lock()
+		joined := sess.joined
+		sess.mu.Unlock()
+		if !joined {
+			return
+		}
+		// An ID before the arguments asks for an acknowledgement
+		i := strings.IndexByte(rest, '[')
+		if i < 0 {
+			return
+		}
+		ackID, args := rest[:i], rest[i:]
+		if message, ok := socketIOMessage(args); ok {
+			srv.sim.handleClientMessage(sess.ctx, sess.client, message)
+		} else {
+			srv.sim.messages.received("", errors.New("invalid Socket.IO event"))
+		}
+		if _, err := strconv.Atoi(ackID); err == nil {
+			sess.send(fmt.Sprintf("%c%c%s[]", eioMessage, sioAck, ackID))
+		}
+	}
+}
+
+// join connects the client to the default namespace and starts its feed
+func (srv *socketIOServer) join(sess *socketIOSession) {
+	sess.mu.Lock()
+	joined := sess.joined
+	sess.joined = true
+	sess.mu.Unlock()
+
+	data, _ := json.Marshal(struct {
+		SID string `json:"sid"`
+	}{sess.sid})
+	if err := sess.send(string(eioMessage) + string(sioConnect) + string(data)); err != nil || joined {
+		return
+	}
+
+	client := sess.client
+	srv.sim.addClient(client)
+	client.logger().Info("Socket.IO client connected")
+	srv.sim.goTracked(func() { srv.sim.writeLoop(sess.ctx, client) })
+	// Start with the drivers around the client rather than waiting for
+	// its first broadcast tick
+	srv.sim.SendDriversToClient(client)
+}
+
+// socketIOMessage turns the arguments of an event, its name and an
+// optional object, into the client message of that type
+func socketIOMessage(args string) ([]byte, bool) {
+	var list []json.RawMessage
+	if err := json.Unmarshal([]byte(args), &list); err != nil || len(list) == 0 {
+		return nil, false
+	}
+	var name string
+	if err := json.Unmarshal(list[0], &name); err != nil {
+		return nil, false
+	}
+	typ, _ := json.Marshal(name)
+
+	if len(list) > 1 {
+		// The event name wins over a type in the object
+		if obj := bytes.TrimSpace(list[1]); len(obj) > 2 && obj[0] == '{' {
+			message := append([]byte(nil), obj[:len(obj)-1]...)
+			message = append(message, `,"type":`...)
+			message = append(message, typ...)
+			return append(message, '}'), true
+		}
+	}
+	return append(append([]byte(`{"type":`), typ...), '}'), true
+}
+
+// socketIOEvent returns the Engine.IO packet of a Socket.IO event carrying
+// a server message, named after the message's type
+func socketIOEvent(data []byte) string {
+	name, _ := json.Marshal(messageType(data))
+	var b strings.Builder
+	b.Grow(len(data) + len(name) + 5)
+	b.WriteByte(eioMessage)
+	b.WriteByte(sioEvent)
+	b.WriteByte('[')
+	b.Write(name)
+	b.WriteByte(',')
+	b.Write(data)
+	b.WriteByte(']')
+	return b.String()
+}
+
+// messageType reads the type of a JSON message without decoding the rest.
+// The type comes first in flat messages and second, after the version, in
+// envelopes.
+func messageType(data []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return ""
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if key == "type" {
+			var typ string
+			dec.Decode(&typ)
+			return typ
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// send writes a packet to the client's WebSocket, or queues it for the
+// next poll
+func (sess *socketIOSession) send(packet string) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	select {
+	case <-sess.closed:
+		return errSessionClosed
+	default:
+	}
+
+	if conn := sess.ws.Load(); conn != nil {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteMessage(websocket.TextMessage, []byte(packet))
+	}
+	if len(sess.pending) >= socketIOMaxPending {
+		return errors.New("Socket.IO client stopped polling")
+	}
+	sess.pending = append(sess.pending, packet)
+	select {
+	case sess.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns the packets waiting for a poll
+func (sess *socketIOSession) take() []string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	packets := sess.pending
+	sess.pending = nil
+	return packets
+}
+
+// WriteMessage sends a text frame as an event. The session pings the
+// client on its own schedule, and a close frame closes the transport
+// rather than the namespace, so the client reconnects.
+func (sess *socketIOSession) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.TextMessage:
+		return sess.send(socketIOEvent(data))
+	case websocket.PingMessage:
+		return nil
+	case websocket.CloseMessage:
+		return sess.send(string(eioClose))
+	default:
+		return fmt.Errorf("Socket.IO clients can't be sent frame type %d", messageType)
+	}
+}
+
+func (sess *socketIOSession) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return sess.WriteMessage(messageType, data)
+}
+
+// SetWriteDeadline does nothing; every packet written to a WebSocket gets
+// writeWait
+func (sess *socketIOSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Close ends the session, its WebSocket and the poll waiting on it
+func (sess *socketIOSession) Close() error {
+	sess.closeOnce.Do(func() {
+		close(sess.closed)
+		if conn := sess.ws.Load(); conn != nil {
+			conn.Close()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+)
+
+// maxSpawnCount limits how many drivers a single spawn request can add
+const maxSpawnCount = 10000
+
+// errReplayMode is returned when the fleet is changed during a replay
+var errReplayMode = errors.New("drivers cannot be spawned or removed while replaying a recording")
+
+// parseDriverStatus converts a status name into a DriverStatus
+func parseDriverStatus(name string) (DriverStatus, error) {
+	for _, status := range []DriverStatus{Available, Busy, Offline} {
+		if strings.EqualFold(status.String(), name) {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown status %q", name)
+}
+
+// exec runs fn on the main loop, between updates
+func (s *Simulation) exec(ctx context.Context, fn func() error) error {
+	cmd := simCommand{action: "exec", fn: fn, reply: make(chan simResult, 1)}
+	select {
+	case s.control <- cmd:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case res := <-cmd.reply:
+		return res.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SpawnDrivers adds drivers around a location and returns their IDs.
+// It must run on the main loop.
+func (s *Simulation) SpawnDrivers(req protocol.SpawnRequest) ([]int, error) {
+	if s.replayer != nil {
+		return nil, errReplayMode
+	}
+	if err := s.mirroring(); err != nil {
+		return nil, err
+	}
+
+	if n := s.localDriverCount(); n+req.Count > maxFleetSize {
+		return nil, fmt.Errorf("%d more drivers would exceed the fleet limit of %d (%d now)", req.Count, maxFleetSize, n)
+	}
+
+	status := Available
+	if req.Status != "" {
+		parsed, err := parseDriverStatus(req.Status)
+		if err != nil {
+			return nil, err
+		}
+		status = parsed
+	}
+
+	ids := make([]int, 0, req.Count)
+	newDrivers := make([]*Driver, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		var lon, lat float64
+		for attempt := 0; attempt < 100; attempt++ {
+			angle := s.rand.Float64() * 2 * math.Pi
+			dist := s.rand.Float64() * req.Radius
+			lon, lat = geo.Offset(req.Lon, req.Lat, angle, geo.DegreesToKm(dist))
+			lon = math.Max(s.settings.MinLon, math.Min(s.settings.MaxLon, lon))
+			lat = math.Max(s.settings.MinLat, math.Min(s.settings.MaxLat, lat))
+			if s.geofences.Allowed(lon, lat) {
+				break
+			}
+		}
+		if !s.geofences.Allowed(lon, lat) {
+			return nil, fmt.Errorf("no allowed position found near (%.6f, %.6f)", req.Lon, req.Lat)
+		}
+
+		id := s.newDriverID()
+		newDrivers = append(newDrivers, &Driver{
+			ID:      id,
+			Status:  status,
+			motion:  newMotion(lon, lat, s.tunables.Load().randomSpeed(s.rand.Float64()), s.rand.Float64()*2*math.Pi),
+			Vehicle: randomVehicle(s.rand.Float64()),
+			profile: s.profileMix.Pick(s.rand.Float64()),
+		})
+		ids = append(ids, id)
+	}
+
+	s.addDrivers(newDrivers)
+	s.record(recordLine{Command: "spawn", Spawn: &req})
+
+	// Make the new drivers visible to queries and broadcasts right away
+	s.UpdateIndex()
+	return ids, nil
+}
+
+// DespawnDrivers removes drivers by ID and returns the IDs that were found.
+// It must run on the main loop.
+func (s *Simulation) DespawnDrivers(ids []int) ([]int, error) {
+	if s.replayer != nil {
+		return nil, errReplayMode
+	}
+	if err := s.mirroring(); err != nil {
+		return nil, err
+	}
+
+	removed := s.removeDrivers(ids)
+	s.record(recordLine{Command: "despawn", IDs: ids})
+
+	s.UpdateIndex()
+	return removed, nil
+}
+
+// driverByID returns the driver with the given ID, local or federated
+func (s *Simulation) driverByID(id int) (*Driver, bool) {
+	s.driversMu.RLock()
+	defer s.driversMu.RUnlock()
+	driver, ok := s.driversByID[id]
+	return driver, ok
+}
+
+// setDrivers replaces all drivers. It must run on the main loop.
+func (s *Simulation) setDrivers(drivers []*Driver) {
+	s.driversMu.Lock()
+	defer s.driversMu.Unlock()
+	for _, driver := range s.drivers {
+		driver.detach()
+	}
+	s.motion.arrange(drivers)
+	s.drivers = drivers
+	s.driversByID = make(map[int]*Driver, len(drivers))
+	for _, driver := range drivers {
+		s.driversByID[driver.ID] = driver
+	}
+}
+
+// addDrivers adds new drivers. It must run on the main loop.
+func (s *Simulation) addDrivers(drivers []*Driver) {
+	s.driversMu.Lock()
+	defer s.driversMu.Unlock()
+	s.drivers = append(s.drivers, drivers...)
+	s.motion.arrange(s.drivers)
+	for _, driver := range drivers {
+		s.driversByID[driver.ID] = driver
+	}
+}
+
+// swapDrivers takes the removed drivers out and puts the added ones in,
+// whatever their origin. It must run on the main loop.
+func (s *Simulation) swapDrivers(removed map[*Driver]bool, added []*Driver) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	s.driversMu.Lock()
+	defer s.driversMu.Unlock()
+	kept := make([]*Driver, 0, len(s.drivers)+len(added))
+	for _, driver := range s.drivers {
+		if removed[driver] {
+			delete(s.driversByID, driver.ID)
+			driver.detach()
+			continue
+		}
+		kept = append(kept, driver)
+	}
+	s.drivers = append(kept, added...)
+	s.motion.arrange(s.drivers)
+	for _, driver := range added {
+		s.driversByID[driver.ID] = driver
+	}
+}
+
+// newDriverID returns the ID of a new local driver. A shard worker skips
+// the IDs the ring gives other shards. It must run on the main loop.
+func (s *Simulation) newDriverID() int {
+	for {
+		s.nextDriverID++
+		if s.shard == nil || s.shard.owns(s.nextDriverID) {
+			return s.nextDriverID
+		}
+	}
+}
+
+// removeDrivers takes the local drivers with the given IDs, fed from a
+// device or not, out of the simulation, along with their trips, and returns the IDs that were found.
+// The caller rebuilds the quadtree. It must run on the main loop.
+func (s *Simulation) removeDrivers(ids []int) []int {
+	remove := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	removed := make([]int, 0, len(ids))
+	s.driversMu.Lock()
+	kept := make([]*Driver, 0, len(s.drivers))
+	for _, driver := range s.drivers {
+		if remove[driver.ID] && (driver.Origin == "" || driver.Origin == deviceOrigin) {
+			removed = append(removed, driver.ID)
+			delete(s.driversByID, driver.ID)
+			driver.detach()
+			continue
+		}
+		kept = append(kept, driver)
+	}
+	s.motion.arrange(kept)
+	s.drivers = kept
+	s.driversMu.Unlock()
+	s.dropTrips(removed)
+	return removed
+}
+
+// SpawnHandler handles POST /api/drivers/spawn
+func (s *Simulation) SpawnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req protocol.SpawnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid spawn request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.City != "" {
+		city, ok := s.findCity(req.City)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown city %q", req.City), http.StatusBadRequest)
+			return
+		}
+		req.Lon, req.Lat = city.Lon, city.Lat
+	}
+	if !s.settings.bounds().Contains(req.Lon, req.Lat) {
+		http.Error(w, "location is outside the world bounds", http.StatusBadRequest)
+		return
+	}
+	if req.Count < 1 || req.Count > maxSpawnCount {
+		http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxSpawnCount), http.StatusBadRequest)
+		return
+	}
+	if req.Radius <= 0 {
+		req.Radius = 0.01 // about 1.1km
+	}
+
+	var ids []int
+	err := s.exec(r.Context(), func() error {
+		var err error
+		ids, err = s.SpawnDrivers(req)
+		return err
+	})
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	slog.Info("Spawned drivers", "count", len(ids), "lon", req.Lon, "lat", req.Lat)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(protocol.SpawnResponse{Spawned: ids, Count: len(ids)})
+}
+
+// DespawnHandler handles POST /api/drivers/despawn with {"ids": [...]} and
+// DELETE /api/drivers/despawn?ids=1,2,3
+func (s *Simulation) DespawnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	var req protocol.DespawnRequest
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid despawn request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		for _, idStr := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if idStr == "" {
+				continue
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid driver id %q", idStr), http.StatusBadRequest)
+				return
+			}
+			req.IDs = append(req.IDs, id)
+		}
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		http.Error(w, "no driver ids given", http.StatusBadRequest)
+		return
+	}
+
+	var removed []int
+	err := s.exec(r.Context(), func() error {
+		var err error
+		removed, err = s.DespawnDrivers(req.IDs)
+		return err
+	})
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	slog.Info("Removed drivers", "count", len(removed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.DespawnResponse{Removed: removed, Count: len(removed)})
+}
+
+// writeFleetError maps spawn/despawn errors to HTTP responses
+func writeFleetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errReplayMode), errors.Is(err, errClusterFollower), errors.Is(err, errShardGateway):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,1166 @@
+// Code generated by cmd/tsgen from the protocol package. DO NOT EDIT.
+
+/**
+ * Envelope wraps every message exchanged with version 3 clients. The
+ * payload is the message itself, such as a DriversUpdate. Version 3
+ * clients may send their messages in an envelope or as they are.
+ */
+export interface Envelope {
+  version: number;
+  type: string;
+  /**
+   * Correlation ID a client may put on a command; the server echoes it on
+   * the command's reply or error
+   */
+  id?: string;
+  payload: unknown;
+}
+
+/**
+ * Hello negotiates the protocol version and capabilities after connecting.
+ * The server answers with a Welcome.
+ */
+export interface Hello {
+  /** "hello" */
+  type: "hello";
+  /** highest version the client speaks */
+  version: number;
+  /** Capabilities the client wants; empty takes all the server offers */
+  capabilities?: string[];
+}
+
+/** ClientParams sets the area a WebSocket client receives updates for */
+export interface ClientParams {
+  /** "client_params" */
+  type: "client_params";
+  lat: number;
+  lon: number;
+  /** in degrees */
+  radius: number;
+  /** overrides lat/lon with the city center */
+  city?: string;
+  /**
+   * Server-side filter expression, e.g. status == "Available" && speed_kmh > 20 && type in ["car", "van"].
+   * An empty string removes the filter; an invalid one is answered with an error message.
+   */
+  filter: string;
+  /**
+   * Only send drivers with these statuses ("Available", "Busy" or
+   * "Offline"). Null or an empty list sends every status; leaving the
+   * field out keeps the current ones.
+   */
+  statuses: string[];
+  /**
+   * Ask for a drivers_update snapshot followed by drivers_delta messages
+   * with only the changes. Any new client_params starts a new snapshot.
+   */
+  deltas?: boolean;
+  /**
+   * Encoding of driver updates: "json", "msgpack" or "protobuf" (binary
+   * frames). Empty keeps the current one.
+   */
+  encoding?: string;
+  /**
+   * Milliseconds between driver updates, from 100 to 10000, rounded to
+   * 20ms. Zero keeps the current interval (220ms by default).
+   */
+  interval_ms?: number;
+}
+
+/** Region is a circular area */
+export interface Region {
+  lat: number;
+  lon: number;
+  /** in degrees */
+  radius: number;
+}
+
+/**
+ * Viewport is the rectangle a map shows, as Leaflet's getBounds reports it.
+ * West is greater than East when the viewport crosses the antimeridian.
+ */
+export interface Viewport {
+  south: number;
+  west: number;
+  north: number;
+  east: number;
+}
+
+/**
+ * Subscribe adds a subscription to a connection, or replaces the one with the
+ * same ID. It selects drivers by at most one of Region, Viewport, City or
+ * Drivers;
+ * Statuses narrows the selection to those statuses, or on its own selects
+ * every driver with them. Once a connection has subscribed, its updates
+ * carry the drivers of all its subscriptions instead of the client_params
+ * area.
+ */
+export interface Subscribe {
+  /** "subscribe" */
+  type: "subscribe";
+  /** chosen by the client, unique per connection */
+  id: string;
+  region?: Region | null;
+  viewport?: Viewport | null;
+  city?: string;
+  /** driver IDs */
+  drivers?: number[];
+  /** "Available", "Busy" or "Offline" */
+  statuses?: string[];
+}
+
+/** Unsubscribe removes a subscription; an empty ID removes all of them */
+export interface Unsubscribe {
+  /** "unsubscribe" */
+  type: "unsubscribe";
+  id?: string;
+}
+
+/**
+ * Resync asks the server for a new snapshot, for instance after a client
+ * noticed a gap in the sequence numbers
+ */
+export interface Resync {
+  /** "resync" */
+  type: "resync";
+}
+
+/** SimControlMessage is the WebSocket admin message for controlling the main loop */
+export interface SimControlMessage {
+  /** "sim_control" */
+  type: "sim_control";
+  /** "pause", "resume", "step" or "state" */
+  action: string;
+  ticks?: number;
+  /** the server's admin token, needed for all but "state" */
+  token?: string;
+}
+
+/** Location is a point given as latitude/longitude */
+export interface Location {
+  lat: number;
+  lon: number;
+}
+
+/**
+ * RequestRide asks the dispatcher for a ride from Pickup. Without a Dropoff
+ * the server picks the rider's destination.
+ */
+export interface RequestRide {
+  /** "request_ride" */
+  type: "request_ride";
+  pickup: Location;
+  dropoff?: Location | null;
+}
+
+/**
+ * Follow asks for one driver's position, speed, heading and trip on every
+ * simulation update, wherever the driver goes
+ */
+export interface Follow {
+  /** "follow" */
+  type: "follow";
+  driver_id: number;
+}
+
+/**
+ * Unfollow stops following a driver; without a DriverID it stops following
+ * all of them
+ */
+export interface Unfollow {
+  /** "unfollow" */
+  type: "unfollow";
+  driver_id?: number;
+}
+
+/**
+ * DriverPosition feeds a driver's position from a real device or an
+ * external simulator. The driver stops being simulated until its positions
+ * stop coming. POST /api/drivers/{id}/position takes the driver from the
+ * path instead of DriverID.
+ */
+export interface DriverPosition {
+  /** "driver_position" */
+  type?: "driver_position";
+  driver_id?: number;
+  lon: number;
+  lat: number;
+  /** direction in degrees (0-360); taken from the last position when missing */
+  heading?: number | null;
+  /** Available, Busy or Offline; unchanged when empty */
+  status?: string;
+  /** Unix milliseconds of the fix; when it arrived when 0 */
+  time?: number;
+  /** the server's admin token, needed over WebSockets */
+  token?: string;
+}
+
+/**
+ * WatchCells asks for the drivers counted per S2 cell instead of one by
+ * one, which keeps updates small when a map shows a large area. The server
+ * answers with a driver_cells message right away and sends a new one every
+ * second; level 0 stops them.
+ */
+export interface WatchCells {
+  /** "watch_cells" */
+  type: "watch_cells";
+  /** S2 cell level, 1 to 20; 0 stops the updates */
+  level: number;
+  /** only the cells whose center is in this area; all when missing */
+  viewport?: Viewport | null;
+}
+
+/**
+ * Welcome tells a client which protocol version the server will speak and
+ * which capabilities are enabled. Clients that select version 3 through
+ * the subprotocol get one right after connecting.
+ */
+export interface Welcome {
+  /** "welcome" */
+  type: "welcome";
+  version: number;
+  capabilities: string[];
+}
+
+/** DriverResponse is the JSON response format for driver data */
+export interface DriverResponse {
+  id: number;
+  lon: number;
+  lat: number;
+  status: string;
+  /** distance in km from query point */
+  distance?: number;
+  /** direction in degrees (0-360) */
+  heading: number;
+  /** speed in degrees of arc per second */
+  speed: number;
+  /** behavior archetype (regular, aggressive, cautious, lazy) */
+  profile?: string;
+  /** car, van or suv */
+  vehicle?: string;
+  /** federation peer the driver comes from, or "device" for fed positions; empty for simulated drivers */
+  origin?: string;
+  /** the driver's ID at its origin */
+  remote_id?: number;
+  /**
+   * Unfiltered GPS fix, only when the server simulates GPS noise; lon/lat
+   * are then the smoothed position
+   */
+  raw_lon?: number;
+  raw_lat?: number;
+  /**
+   * Velocity in degrees per second of real time (zero while the
+   * simulation is paused) and the Unix milliseconds the position is as
+   * of, so clients can move the driver on between updates
+   */
+  vel_lon?: number;
+  vel_lat?: number;
+  updated_at?: number;
+}
+
+/** DriversUpdate is pushed to WebSocket clients on every broadcast */
+export interface DriversUpdate {
+  /** "drivers_update" */
+  type: "drivers_update";
+  drivers: DriverResponse[];
+  count: number;
+  center: Location;
+  radius: number;
+  /** Timestamp in milliseconds */
+  time: number;
+  /** age of the index positions in milliseconds */
+  data_age_ms: number;
+  /** Sequence number of the snapshot, for clients that asked for deltas */
+  seq?: number;
+}
+
+/**
+ * DriversSnapshot is the full update v3 clients that asked for deltas get
+ * first, and again after a resync. The deltas that follow continue its
+ * sequence numbers.
+ */
+export interface DriversSnapshot {
+  type: "drivers_snapshot";
+  drivers: DriverResponse[];
+  count: number;
+  center: Location;
+  radius: number;
+  time: number;
+  data_age_ms: number;
+  seq?: number;
+}
+
+/** DriverMove is the new position of a driver in a delta */
+export interface DriverMove {
+  id: number;
+  lon: number;
+  lat: number;
+  heading: number;
+  speed: number;
+  distance?: number;
+  raw_lon?: number;
+  raw_lat?: number;
+  vel_lon?: number;
+  vel_lat?: number;
+  updated_at?: number;
+}
+
+/** DriverStatusChange is the new status of a driver in a delta */
+export interface DriverStatusChange {
+  id: number;
+  status: string;
+}
+
+/**
+ * DriversDelta is sent instead of a drivers_update to clients that asked
+ * for deltas. It lists the changes since the previous message; a driver that
+ * moved and changed status appears in both lists. Drivers that moved less
+ * than about a meter are left out until they move further.
+ */
+export interface DriversDelta {
+  /** "drivers_delta" */
+  type: "drivers_delta";
+  /** drivers that entered the area or filter */
+  appeared?: DriverResponse[];
+  /** drivers that moved */
+  moved?: DriverMove[];
+  /** drivers whose status changed */
+  status_changed?: DriverStatusChange[];
+  /** IDs of drivers that left the area or filter */
+  disappeared?: number[];
+  /** drivers in the area after applying the delta */
+  count: number;
+  /** Timestamp in milliseconds */
+  time: number;
+  data_age_ms: number;
+  /** one more than the snapshot or delta before it */
+  seq: number;
+}
+
+/** SimState describes the run state of the main loop */
+export interface SimState {
+  /** "sim_state" */
+  type: "sim_state";
+  paused: boolean;
+  tick: number;
+  speed: number;
+  /** Unix milliseconds */
+  virtual_time: number;
+}
+
+/** HeatmapCell is one grid cell of the demand heatmap */
+export interface HeatmapCell {
+  /** cell center */
+  lat: number;
+  /** cell center */
+  lon: number;
+  count: number;
+}
+
+/**
+ * DemandHeatmap aggregates recent ride request origins into a grid. It is
+ * served at /api/heatmap/demand and pushed to WebSocket clients periodically.
+ */
+export interface DemandHeatmap {
+  /** "demand_heatmap" */
+  type: "demand_heatmap";
+  /** cell edge in degrees */
+  cell_size: number;
+  /** seconds of virtual time covered */
+  window_s: number;
+  /** non-empty cells, busiest first */
+  cells: HeatmapCell[];
+  total: number;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/**
+ * OfferEvent is pushed to WebSocket clients as the dispatcher offers a ride
+ * request to drivers, one after the other, until one accepts
+ */
+export interface OfferEvent {
+  /** "offer_event" */
+  type: "offer_event";
+  /** "offered", "accepted" or "declined" */
+  event: string;
+  /** the trip the request becomes once accepted */
+  trip_id: number;
+  /** the driver asked */
+  driver_id: number;
+  /** 1 for the first driver asked */
+  attempt: number;
+  /** from the driver to the pickup */
+  distance_km: number;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/** Fare is the price of a trip, broken down by component */
+export interface Fare {
+  base: number;
+  /** per-km charge */
+  distance: number;
+  /** per-minute charge */
+  time: number;
+  /** multiplier applied to the sum */
+  surge: number;
+  /** rounded, never below the minimum fare */
+  total: number;
+  currency: string;
+  distance_km: number;
+  duration_min: number;
+}
+
+/** TripEvent is pushed to WebSocket clients as trips progress */
+export interface TripEvent {
+  /** "trip_event" */
+  type: "trip_event";
+  /** "assigned", "picked_up", "completed" or "cancelled" */
+  event: string;
+  /** why a trip was cancelled */
+  reason?: string;
+  trip_id: number;
+  driver_id: number;
+  pickup: Location;
+  dropoff: Location;
+  estimated_fare: Fare;
+  /** completed trips only */
+  final_fare?: Fare | null;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/**
+ * DriverStatusChanged is pushed to WebSocket clients whenever a driver's
+ * status changes, whatever the cause
+ */
+export interface DriverStatusChanged {
+  /** "driver_status_changed" */
+  type: "driver_status_changed";
+  driver_id: number;
+  old_status: string;
+  new_status: string;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/**
+ * RideAssigned answers request_ride with the trip and the driver that
+ * accepted it. The rider then gets a ride_progress message on every
+ * simulation update until the trip ends.
+ */
+export interface RideAssigned {
+  /** "ride_assigned" */
+  type: "ride_assigned";
+  trip_id: number;
+  driver: DriverResponse;
+  pickup: Location;
+  dropoff: Location;
+  estimated_fare: Fare;
+  /** expected drive to the pickup in seconds */
+  pickup_eta_s: number;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/** RideProgress tracks a ride for the client that requested it */
+export interface RideProgress {
+  /** "ride_progress" */
+  type: "ride_progress";
+  trip_id: number;
+  /** "assigned", "picked_up", "completed" or "cancelled" */
+  state: string;
+  /** why the trip was cancelled */
+  reason?: string;
+  driver_id: number;
+  /** the driver's position */
+  lon: number;
+  lat: number;
+  /** direction in degrees (0-360) */
+  heading: number;
+  /** straight-line distance left to the pickup, or to the drop-off once picked up */
+  distance_km: number;
+  final_fare?: Fare | null;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/**
+ * Subscriptions answers subscribe and unsubscribe with the IDs of the
+ * connection's subscriptions, sorted
+ */
+export interface Subscriptions {
+  /** "subscriptions" */
+  type: "subscriptions";
+  active: string[];
+}
+
+/**
+ * Following answers follow and unfollow with the IDs of the drivers the
+ * connection follows, sorted
+ */
+export interface Following {
+  /** "following" */
+  type: "following";
+  drivers: number[];
+}
+
+/**
+ * DriverTrack is sent to the clients following a driver on every
+ * simulation update
+ */
+export interface DriverTrack {
+  /** "driver_track" */
+  type: "driver_track";
+  driver: DriverResponse;
+  /** the driver's current trip, if any */
+  trip_id?: number;
+  /** "assigned" or "picked_up" */
+  trip_state?: string;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/** DriverCell counts the drivers in one S2 cell by status */
+export interface DriverCell {
+  /** S2 cell token, e.g. "3ffc3" */
+  token: string;
+  /** the cell center */
+  lat: number;
+  lon: number;
+  available: number;
+  busy: number;
+  offline: number;
+  count: number;
+}
+
+/**
+ * DriverCells counts the drivers per S2 cell of a level. It is served at
+ * /api/cells and sent to WebSocket clients that watch cells.
+ */
+export interface DriverCells {
+  /** "driver_cells" */
+  type: "driver_cells";
+  level: number;
+  /** non-empty cells, fullest first */
+  cells: DriverCell[];
+  /** drivers in the cells */
+  total: number;
+  /** virtual time in milliseconds */
+  time: number;
+}
+
+/** ErrorMessage reports a problem with a WebSocket request */
+export interface ErrorMessage {
+  /** "error" */
+  type: "error";
+  error: string;
+}
+
+/**
+ * ServerShutdown warns clients right before the server closes their
+ * connection because it is shutting down. The close frame that follows has
+ * code 1001 (going away).
+ */
+export interface ServerShutdown {
+  /** "server_shutting_down" */
+  type: "server_shutting_down";
+  reason: string;
+}
+
+/** StandbyPool is the occupancy of a landmark's standby pool */
+export interface StandbyPool {
+  landmark: string;
+  target: number;
+  /** drivers assigned to the pool */
+  reserved: number;
+  /** reserved drivers already waiting at the landmark */
+  ready: number;
+}
+
+/**
+ * IndexShape is the shape of a quadtree. Clustered drivers make deep trees
+ * with many empty leaves, which queries visit for nothing.
+ */
+export interface IndexShape {
+  /** points per node before it splits */
+  capacity: number;
+  /** of the deepest leaf */
+  depth: number;
+  nodes: number;
+  leaves: number;
+  empty_leaves: number;
+  /** points per non-empty leaf */
+  avg_leaf_points: number;
+  /** leaf_points[n] counts the leaves holding n points */
+  leaf_points: number[];
+}
+
+/**
+ * IndexStats describes a spatial index: how long keeping it up to date
+ * takes and, for a quadtree, its shape
+ */
+export interface IndexStats {
+  /** quadtree or grid */
+  kind: string;
+  points: number;
+  rebuilds: number;
+  /** the last update */
+  update_ms: number;
+  /** the last full build */
+  build_ms: number;
+  /** Unix milliseconds */
+  built_at: number;
+  shape?: IndexShape | null;
+}
+
+/**
+ * CityStats counts the drivers of one city, as of the last index update,
+ * and describes the city's index
+ */
+export interface CityStats {
+  city: string;
+  drivers: number;
+  available: number;
+  busy: number;
+  offline: number;
+  index: IndexStats;
+}
+
+/**
+ * MessageCounts counts the WebSocket and SSE messages since startup by
+ * message type
+ */
+export interface MessageCounts {
+  /** received from clients; "invalid" for unparsable ones */
+  in: Record<string, number>;
+  /** written to clients */
+  out: Record<string, number>;
+  /** dropped from full send queues */
+  dropped: Record<string, number>;
+  /** driver updates replaced by a newer one before they were written */
+  stale: Record<string, number>;
+}
+
+/**
+ * HistogramBucket counts the durations above the bound of the bucket
+ * before it, up to its own
+ */
+export interface HistogramBucket {
+  /** upper bound; none for the last bucket */
+  le_ms?: number;
+  count: number;
+}
+
+/**
+ * Histogram counts durations in buckets. Percentiles are estimated as the
+ * upper bound of the bucket they fall in.
+ */
+export interface Histogram {
+  count: number;
+  avg_ms: number;
+  p50_ms: number;
+  p90_ms: number;
+  p99_ms: number;
+  max_ms: number;
+  buckets: HistogramBucket[];
+}
+
+/**
+ * BroadcastTimes are histograms of how long the phases of sending clients
+ * their driver updates took since startup. Clients watching the same area
+ * share the query, assembly and encoding of a tick, which are counted once.
+ */
+export interface BroadcastTimes {
+  /** a whole broadcast to the clients due an update */
+  tick: Histogram;
+  /** collecting a client's drivers from its topics */
+  query: Histogram;
+  /** filtering them into an update, or diffing a delta */
+  assemble: Histogram;
+  /** encoding an update frame */
+  encode: Histogram;
+  /** writing a frame to a client's connection */
+  write: Histogram;
+}
+
+/**
+ * Stats is the response of /api/stats: the counters the server also prints
+ * every few seconds
+ */
+export interface Stats {
+  uptime_s: number;
+  /** simulation clock, Unix milliseconds */
+  virtual_time: number;
+  /** simulation speed multiplier */
+  speed: number;
+  /** connected WebSocket clients */
+  clients: number;
+  available_drivers: number;
+  busy_drivers: number;
+  offline_drivers: number;
+  /** drivers per behavior profile */
+  profiles: Record<string, number>;
+  /** spatial index queries */
+  queries: number;
+  drivers_per_query: number;
+  avg_query_time_ms: number;
+  index_rebuilds: number;
+  index_age_ms: number;
+  ride_requests: number;
+  /** no driver nearby */
+  unserved_requests: number;
+  /** served from a standby pool */
+  instant_matches: number;
+  declined_offers: number;
+  /** demand shocks under way */
+  active_shocks: number;
+  trips_in_progress: number;
+  completed_trips: number;
+  /** cancelled by the rider */
+  cancelled_trips: number;
+  /** abandoned by the driver */
+  no_shows: number;
+  /** final fares of completed trips */
+  revenue: number;
+  currency: string;
+  standby_pools: StandbyPool[];
+  /** drivers by closest city */
+  cities: CityStats[];
+  /** of every city together */
+  index: IndexStats;
+  messages: MessageCounts;
+  broadcast: BroadcastTimes;
+}
+
+/**
+ * StatsMessage carries the fleet statistics to v3 clients that take the
+ * stats capability, once every stats interval
+ */
+export interface StatsMessage {
+  /** "stats" */
+  type: "stats";
+  stats: Stats;
+}
+
+/**
+ * DriversResponse is the JSON response format for multiple drivers. Count
+ * is the drivers on this page, Total those matching the query.
+ */
+export interface DriversResponse {
+  drivers: DriverResponse[];
+  count: number;
+  total: number;
+  /** pass as offset for the next page; left out on the last */
+  next_offset?: number;
+  center: Location;
+  radius: number;
+  /** age of the index positions in milliseconds */
+  data_age_ms: number;
+}
+
+/**
+ * NearestDriversResponse is the response of /api/drivers/nearest: the
+ * drivers closest to a location, closest first
+ */
+export interface NearestDriversResponse {
+  drivers: DriverResponse[];
+  count: number;
+  center: Location;
+  /** age of the index positions in milliseconds */
+  data_age_ms: number;
+}
+
+/** FareEstimate is the response of /api/fare */
+export interface FareEstimate {
+  from: Location;
+  to: Location;
+  fare: Fare;
+}
+
+/** SpawnRequest describes drivers to add at a location */
+export interface SpawnRequest {
+  lon: number;
+  lat: number;
+  /** overrides lon/lat with the city center */
+  city?: string;
+  count: number;
+  /** spread in degrees, defaults to 0.01 */
+  radius?: number;
+  /** Available (default), Busy or Offline */
+  status?: string;
+}
+
+/** SpawnResponse lists the IDs of spawned drivers */
+export interface SpawnResponse {
+  spawned: number[];
+  count: number;
+}
+
+/**
+ * StatsSample is the state of the simulation at one moment, with rates
+ * over the time since the sample before it
+ */
+export interface StatsSample {
+  /** Unix milliseconds */
+  time: number;
+  /** simulation clock, Unix milliseconds */
+  virtual_time: number;
+  clients: number;
+  available_drivers: number;
+  busy_drivers: number;
+  offline_drivers: number;
+  queries_per_sec: number;
+  avg_query_time_ms: number;
+  /** ride requests */
+  requests_per_min: number;
+  trips_in_progress: number;
+  completed_trips: number;
+}
+
+/** StatsHistory is the response of /api/stats/history */
+export interface StatsHistory {
+  /** between samples */
+  interval_ms: number;
+  /** oldest first */
+  samples: StatsSample[];
+}
+
+/** DriverTrip is the ride a driver is on */
+export interface DriverTrip {
+  id: number;
+  /** assigned or picked_up */
+  state: string;
+  pickup: Location;
+  dropoff: Location;
+  estimated_fare: Fare;
+  /** Unix milliseconds */
+  assigned_at: number;
+}
+
+/**
+ * DriverDetail is the full state of one driver, as served by
+ * /api/drivers/{id}
+ */
+export interface DriverDetail {
+  driver: DriverResponse;
+  speed_kmh: number;
+  /** closest city */
+  city: string;
+  /** where the driver is heading, if anywhere */
+  destination?: Location | null;
+  /** landmark whose standby pool the driver waits in */
+  standby?: string;
+  /** distance driven since the driver joined */
+  odometer_km: number;
+  /** the ride the driver is on, if any */
+  trip?: DriverTrip | null;
+  time: number;
+}
+
+/** SpeedState is the response of /api/sim/speed */
+export interface SpeedState {
+  /** simulation speed multiplier */
+  speed: number;
+  /** simulation clock, Unix milliseconds */
+  virtual_time: number;
+  /** simulated seconds since the start */
+  elapsed_s: number;
+}
+
+/**
+ * RuntimeConfig is the response of /api/admin/config: the simulation
+ * parameters that can be changed while it runs
+ */
+export interface RuntimeConfig {
+  /** driver updates of clients that didn't pick an interval */
+  broadcast_interval_ms: number;
+  /** locally simulated drivers */
+  drivers: number;
+  min_speed_kmh: number;
+  max_speed_kmh: number;
+  /** per update, for drivers not on a trip */
+  status_change_chance: number;
+  /** chance a new or changed status is Available */
+  available_share: number;
+  /** chance it is Busy; otherwise it is Offline */
+  busy_share: number;
+  /** surge added per recent request per available driver above one */
+  surge_slope: number;
+  max_surge: number;
+}
+
+/**
+ * ConfigPatch is the body of PATCH /api/admin/config. Fields left out keep
+ * their value.
+ */
+export interface ConfigPatch {
+  broadcast_interval_ms?: number | null;
+  /** drivers are added or removed to reach it */
+  drivers?: number | null;
+  min_speed_kmh?: number | null;
+  max_speed_kmh?: number | null;
+  status_change_chance?: number | null;
+  available_share?: number | null;
+  busy_share?: number | null;
+  surge_slope?: number | null;
+  max_surge?: number | null;
+}
+
+/**
+ * ClientInfo describes a connected client: its connection, what it asked
+ * for and what it was sent
+ */
+export interface ClientInfo {
+  id: string;
+  /** name of the API key it connected with */
+  identity?: string;
+  /** "websocket", "sse" or "socketio" */
+  transport: string;
+  /** IP address it connected from */
+  remote: string;
+  /** Unix milliseconds */
+  connected_at: number;
+  /** negotiated protocol version */
+  version: number;
+  /** of driver updates: json, msgpack or protobuf */
+  encoding: string;
+  /** between driver updates */
+  interval_ms: number;
+  deltas: boolean;
+  /** client_params area */
+  lat?: number;
+  lon?: number;
+  radius?: number;
+  city?: string;
+  /** a server-side filter is set */
+  filter?: boolean;
+  /** Subscriptions replace the client_params area once there is one */
+  subscriptions?: string[];
+  /** IDs of the drivers it follows */
+  follows?: number[];
+  /** hub topics it receives */
+  topics: number;
+  /**
+   * Traffic: data frames written and their bytes before compression,
+   * frames waiting in the send queue (of QueueSize), frames dropped from
+   * it and driver updates replaced before they were written
+   */
+  messages_sent: number;
+  bytes_sent: number;
+  queue_depth: number;
+  queue_size: number;
+  dropped: number;
+  stale_updates: number;
+  /**
+   * How long its last and its slowest write took, and how long its
+   * queue has been full (zero when it has room)
+   */
+  last_write_ms: number;
+  max_write_ms: number;
+  saturated_ms?: number;
+}
+
+/** ClientsResponse is the response of /api/admin/clients */
+export interface ClientsResponse {
+  clients: ClientInfo[];
+  count: number;
+}
+
+/**
+ * AuditEvent is a line of the audit log. Driver status changes and the
+ * events of rides are audited with the types and data of their
+ * driver_status_changed, trip_event and offer_event messages.
+ */
+export interface AuditEvent {
+  /** counts the events of a server run */
+  seq: number;
+  /** Unix milliseconds */
+  time: number;
+  /** simulation clock, Unix milliseconds */
+  virtual_time: number;
+  type: string;
+  /** ClientEvent, AdminEvent or the message audited */
+  data: unknown;
+}
+
+/** AuditEvents is the response of /api/events */
+export interface AuditEvents {
+  events: AuditEvent[];
+  /** the limit left later events out */
+  more: boolean;
+}
+
+/**
+ * TripRecord is a trip as the trip store (see -store) keeps it, brought up
+ * to date by each of its trip events
+ */
+export interface TripRecord {
+  /** the server run that dispatched it */
+  run_id: number;
+  trip_id: number;
+  driver_id: number;
+  /** its last event: "assigned", "picked_up", "completed" or "cancelled" */
+  state: string;
+  /** why it was cancelled */
+  reason?: string;
+  pickup: Location;
+  dropoff: Location;
+  estimated_fare: Fare;
+  /** completed trips only */
+  final_fare?: Fare | null;
+  /** Virtual times in milliseconds; the later ones once they happened */
+  assigned_at: number;
+  picked_up_at?: number;
+  ended_at?: number;
+}
+
+/** TripRecords is the response of /api/trips */
+export interface TripRecords {
+  trips: TripRecord[];
+  /** the limit left later trips out */
+  more: boolean;
+}
+
+/** WebhookRegistration registers a URL to be POSTed events */
+export interface WebhookRegistration {
+  /** http(s) */
+  url: string;
+  /** Event types to deliver; every type when empty */
+  events?: string[];
+  /**
+   * Signs every delivery: X-Webhook-Signature is "sha256=" and the hex
+   * HMAC-SHA256 of the body with it as the key
+   */
+  secret?: string;
+}
+
+/**
+ * Webhook is a registered webhook and how its deliveries went. Its secret
+ * is never returned.
+ */
+export interface Webhook {
+  id: number;
+  url: string;
+  /** every type when empty */
+  events: string[];
+  /** it has a secret */
+  signed: boolean;
+  delivered: number;
+  /**
+   * Events given up on after the last retry or a 4xx response, and ones
+   * dropped because too many were waiting
+   */
+  failed: number;
+  dropped: number;
+  last_error?: string;
+}
+
+/** Webhooks is the response of GET /api/webhooks */
+export interface Webhooks {
+  webhooks: Webhook[];
+}
+
+/** WebhookEvent is the body POSTed to a webhook */
+export interface WebhookEvent {
+  /** counts the events of a server run; retries repeat it */
+  id: number;
+  type: string;
+  /** virtual time in milliseconds */
+  time: number;
+  /** ZoneEvent, or the TripEvent or DriverStatusChanged message */
+  data: unknown;
+}
+
+/**
+ * CloudEvent is a webhook, Kafka or NATS event in the CloudEvents 1.0 JSON
+ * envelope
+ */
+export interface CloudEvent {
+  specversion: string;
+  /**
+   * Unique per source: the trip and its event for trip events, the
+   * webhook event ID otherwise, after the server run
+   */
+  id: string;
+  /** URI reference of the server, see -cloudevents-source */
+  source: string;
+  type: string;
+  /** "trips/{trip_id}" or "drivers/{driver_id}" */
+  subject?: string;
+  /** virtual time, RFC 3339 */
+  time: string;
+  /** Always application/json */
+  datacontenttype: string;
+  /** as in WebhookEvent */
+  data: unknown;
+}
+
+/** ZoneEvent is the data of zone_entered and zone_exited webhook events */
+export interface ZoneEvent {
+  driver_id: number;
+  /** name of the geofence zone */
+  zone: string;
+  lon: number;
+  lat: number;
+}
+
+/** TraceAssignment is the response of PUT /api/drivers/{id}/trace */
+export interface TraceAssignment {
+  driver_id: number;
+  name: string;
+  points: number;
+  /** at the trace's own pace */
+  duration_s: number;
+  distance_km: number;
+  /** trace seconds per simulated second */
+  scale: number;
+  /** starts over at the end; otherwise the driver is simulated again */
+  loop: boolean;
+}
+
+/**
+ * ClientEvent is the data of client_connected and client_disconnected
+ * audit events
+ */
+export interface ClientEvent {
+  id: string;
+  /** name of the API key it connected with */
+  identity?: string;
+  /** "websocket", "sse" or "socketio" */
+  transport: string;
+  /** IP address it connected from */
+  remote: string;
+  /** Once it disconnected: how long it was connected and what it was sent */
+  connected_ms?: number;
+  messages_sent?: number;
+  bytes_sent?: number;
+}
+
+/**
+ * AdminEvent is the data of an admin audit event: a change made through
+ * the admin or simulation control API
+ */
+export interface AdminEvent {
+  /** config, spawn, despawn, shock, cancel_shock, speed, pause, resume or step */
+  action: string;
+  params?: unknown;
+}
+
+/** DespawnRequest lists drivers to remove */
+export interface DespawnRequest {
+  ids: number[];
+}
+
+/** DespawnResponse lists the IDs of drivers that were removed */
+export interface DespawnResponse {
+  removed: number[];
+  count: number;
+}
+
+/** Any message a client can send over the WebSocket */
+export type ClientMessage = Hello | ClientParams | Subscribe | Unsubscribe | Resync | SimControlMessage | RequestRide | Follow | Unfollow | DriverPosition | WatchCells;
+
+/** Any message the server can send over the WebSocket */
+export type ServerMessage = Welcome | DriversUpdate | DriversSnapshot | DriversDelta | SimState | DemandHeatmap | OfferEvent | TripEvent | DriverStatusChanged | RideAssigned | RideProgress | Subscriptions | Following | DriverTrack | DriverCells | ErrorMessage | ServerShutdown | StatsMessage;
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"quadtree/protocol"
+	"time"
+)
+
+// StatsHandler handles GET /api/stats
+func (s *Simulation) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Trips and driver counts change on the main loop, so read them there
+	var stats protocol.Stats
+	err := s.exec(r.Context(), func() error {
+		stats = s.CollectStats()
+		return nil
+	})
+	if err != nil {
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// CollectStats gathers the current simulation statistics. It must run on
+// the main loop.
+func (s *Simulation) CollectStats() protocol.Stats {
+	s.UpdateStats()
+	s.statsMu.Lock()
+	stats := s.stats
+	s.statsMu.Unlock()
+
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+	active, _ := s.demand.Shocks()
+	index, cities := s.index.stats()
+	_, _, rebuilds, updatedAt := s.index.state()
+
+	resp := protocol.Stats{
+		UptimeS:          time.Since(startTime).Seconds(),
+		VirtualTime:      s.clock.Now().UnixNano() / int64(time.Millisecond),
+		Speed:            s.clock.Scale(),
+		Clients:          clients,
+		AvailableDrivers: stats.AvailableDrivers,
+		BusyDrivers:      stats.BusyDrivers,
+		OfflineDrivers:   stats.OfflineDrivers,
+		Profiles:         stats.Profiles,
+		Queries:          stats.TotalQueries,
+		DriversPerQuery:  stats.AvgDriversPerQuery,
+		AvgQueryTimeMs:   float64(stats.AvgQueryTime) / float64(time.Millisecond),
+		IndexRebuilds:    rebuilds,
+		IndexAgeMs:       time.Since(updatedAt).Milliseconds(),
+		RideRequests:     stats.RideRequests,
+		UnservedRequests: stats.UnservedRequests,
+		InstantMatches:   stats.InstantMatches,
+		DeclinedOffers:   stats.DeclinedOffers,
+		ActiveShocks:     len(active),
+		TripsInProgress:  len(s.trips),
+		CompletedTrips:   stats.CompletedTrips,
+		CancelledTrips:   stats.CancelledTrips,
+		NoShows:          stats.NoShows,
+		Revenue:          stats.Revenue,
+		Currency:         s.fares.Currency,
+		StandbyPools:     make([]protocol.StandbyPool, len(stats.StandbyPools)),
+		Cities:           cities,
+		Index:            index,
+		Messages:         s.messages.snapshot(),
+		Broadcast:        s.broadcast.snapshot(),
+	}
+	for i, pool := range stats.StandbyPools {
+		resp.StandbyPools[i] = protocol.StandbyPool{
+			Landmark: pool.Landmark,
+			Target:   pool.Target,
+			Reserved: pool.Reserved,
+			Ready:    pool.Ready,
+		}
+	}
+	return resp
+}
+
+// BroadcastStats publishes the statistics to the stats topic. It must run
+// on the main loop.
+func (s *Simulation) BroadcastStats() {
+	if s.hub.Subscribers(topicStats) == 0 {
+		return
+	}
+	msg := protocol.StatsMessage{Type: protocol.TypeStats, Stats: s.CollectStats()}
+	flat, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Encoding stats failed", "err", err)
+		return
+	}
+	enveloped, err := json.Marshal(protocol.Wrap(msg))
+	if err != nil {
+		slog.Error("Encoding stats failed", "err", err)
+		return
+	}
+	s.hub.Publish(topicStats, &eventFrames{kind: protocol.TypeStats, flat: flat, enveloped: enveloped})
+}
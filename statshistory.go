@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultStatsHistory is how far back /api/stats/history goes unless
+// -stats-history says otherwise
+const defaultStatsHistory = time.Hour
+
+// statsHistory keeps the last statistics samples in a ring buffer, so
+// dashboards can chart them without a metrics stack of their own
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []protocol.StatsSample // ring buffer; next is the oldest once full
+	next    int
+	full    bool
+
+	// Counters as of the last sample, for the rates of the next one
+	queries, requests int
+	sampledAt         time.Time
+}
+
+// newStatsHistory returns a history keeping the samples of the given
+// duration, one every interval; nil keeps none
+func newStatsHistory(keep, interval time.Duration) *statsHistory {
+	size := int(keep / interval)
+	if size <= 0 {
+		return nil
+	}
+	return &statsHistory{samples: make([]protocol.StatsSample, size)}
+}
+
+// add stores a sample, replacing the oldest once the buffer is full.
+// h.mu must be held.
+func (h *statsHistory) add(sample protocol.StatsSample) {
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns the samples taken after the given Unix milliseconds,
+// oldest first
+func (h *statsHistory) since(ms int64) []protocol.StatsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	oldest, n := 0, h.next
+	if h.full {
+		oldest, n = h.next, len(h.samples)
+	}
+	samples := []protocol.StatsSample{}
+	for i := range n {
+		if sample := h.samples[(oldest+i)%len(h.samples)]; sample.Time > ms {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// sampleStats adds the current statistics to the history. It runs on the
+// main loop right after UpdateStats.
+func (s *Simulation) sampleStats() {
+	h := s.statsHistory
+	if h == nil {
+		return
+	}
+
+	s.statsMu.Lock()
+	stats := s.stats
+	s.statsMu.Unlock()
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	now := time.Now()
+	sample := protocol.StatsSample{
+		Time:             now.UnixNano() / int64(time.Millisecond),
+		VirtualTime:      s.clock.Now().UnixNano() / int64(time.Millisecond),
+		Clients:          clients,
+		AvailableDrivers: stats.AvailableDrivers,
+		BusyDrivers:      stats.BusyDrivers,
+		OfflineDrivers:   stats.OfflineDrivers,
+		AvgQueryTimeMs:   float64(stats.AvgQueryTime) / float64(time.Millisecond),
+		TripsInProgress:  len(s.trips),
+		CompletedTrips:   stats.CompletedTrips,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.sampledAt.IsZero() {
+		elapsed := now.Sub(h.sampledAt).Seconds()
+		sample.QueriesPerSec = float64(stats.TotalQueries-h.queries) / elapsed
+		sample.RequestsPerMin = float64(stats.RideRequests-h.requests) / elapsed * 60
+	}
+	h.queries, h.requests, h.sampledAt = stats.TotalQueries, stats.RideRequests, now
+	h.add(sample)
+}
+
+// StatsHistoryHandler handles GET /api/stats/history: the statistics
+// samples of the last -stats-history, or of the last ?minutes= or those
+// after ?since= (Unix milliseconds), oldest first
+func (s *Simulation) StatsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since int64
+	query := r.URL.Query()
+	if str := query.Get("since"); str != "" {
+		ms, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = ms
+	}
+	if str := query.Get("minutes"); str != "" {
+		minutes, err := strconv.ParseFloat(str, 64)
+		if err != nil || minutes <= 0 {
+			http.Error(w, "invalid minutes", http.StatusBadRequest)
+			return
+		}
+		from := time.Now().Add(-time.Duration(minutes * float64(time.Minute)))
+		since = max(since, from.UnixNano()/int64(time.Millisecond))
+	}
+
+	resp := protocol.StatsHistory{IntervalMs: s.settings.StatsInterval.Milliseconds(), Samples: []protocol.StatsSample{}}
+	if s.statsHistory != nil {
+		resp.Samples = s.statsHistory.since(since)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+const (
+	defaultTripsLimit = 1000
+	maxTripsLimit     = 10000
+
+	// Trip events and status changes waiting for the writer (see offer);
+	// the ones dropped count towards store_dropped in /api/diag
+	storeQueueSize = 10000
+)
+
+// storeSchema creates the tables of the trip store. Times are virtual Unix
+// milliseconds, and every row belongs to the run that wrote it, so several
+// runs can share a file.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY,
+	started_at INTEGER NOT NULL,
+	config     TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS trips (
+	run_id         INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	trip_id        INTEGER NOT NULL,
+	driver_id      INTEGER NOT NULL,
+	state          TEXT NOT NULL,
+	reason         TEXT,
+	pickup_lat     REAL NOT NULL,
+	pickup_lon     REAL NOT NULL,
+	dropoff_lat    REAL NOT NULL,
+	dropoff_lon    REAL NOT NULL,
+	estimated_fare TEXT NOT NULL,
+	final_fare     TEXT,
+	assigned_at    INTEGER NOT NULL,
+	picked_up_at   INTEGER,
+	ended_at       INTEGER,
+	PRIMARY KEY (run_id, trip_id)
+);
+CREATE INDEX IF NOT EXISTS trips_assigned_at ON trips (assigned_at);
+
+CREATE TABLE IF NOT EXISTS trip_events (
+	run_id    INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	trip_id   INTEGER NOT NULL,
+	driver_id INTEGER NOT NULL,
+	event     TEXT NOT NULL,
+	reason    TEXT,
+	time      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trip_events_trip ON trip_events (run_id, trip_id);
+
+CREATE TABLE IF NOT EXISTS status_changes (
+	run_id     INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	driver_id  INTEGER NOT NULL,
+	old_status TEXT NOT NULL,
+	new_status TEXT NOT NULL,
+	time       INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS status_changes_driver ON status_changes (driver_id, time);
+`
+
+// upsertTrip adds a trip on its assignment and brings it up to date with
+// each later event
+const upsertTrip = `
+INSERT INTO trips (run_id, trip_id, driver_id, state, reason, pickup_lat, pickup_lon, dropoff_lat, dropoff_lon,
+	estimated_fare, final_fare, assigned_at, picked_up_at, ended_at)
+VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (run_id, trip_id) DO UPDATE SET
+	state = excluded.state,
+	reason = excluded.reason,
+	final_fare = excluded.final_fare,
+	picked_up_at = coalesce(excluded.picked_up_at, picked_up_at),
+	ended_at = excluded.ended_at`
+
+const selectTrips = `
+SELECT run_id, trip_id, driver_id, state, coalesce(reason, ''), pickup_lat, pickup_lon, dropoff_lat, dropoff_lon,
+	estimated_fare, final_fare, assigned_at, coalesce(picked_up_at, 0), coalesce(ended_at, 0)
+FROM trips
+WHERE assigned_at >= ? AND assigned_at < ? AND (? = 0 OR driver_id = ?)
+ORDER BY assigned_at, run_id, trip_id
+LIMIT ?`
+
+// tripStore keeps the trips, trip events and driver status changes of the
+// dispatcher in a SQLite file. Its goroutine does the writing; queries read
+// through the same pool.
+type tripStore struct {
+	db      *sql.DB
+	runID   int64
+	records chan any // protocol.TripEvent or protocol.DriverStatusChanged, from the main loop
+}
+
+// OpenTripStore opens (or creates) the SQLite database at path and a row
+// for this run, then writes the trip events and driver status changes
+// broadcast from then on until ctx is canceled. Failed writes are logged
+// and dropped.
+func (s *Simulation) OpenTripStore(ctx context.Context, path string) error {
+	// WAL lets /api/trips read while the writer writes
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, storeSchema); err != nil {
+		db.Close()
+		return fmt.Errorf("create schema: %w", err)
+	}
+	config, err := json.Marshal(s.runConfig)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	st := &tripStore{db: db, records: make(chan any, storeQueueSize)}
+	res, err := db.ExecContext(ctx, `INSERT INTO runs (started_at, config) VALUES (?, ?)`,
+		s.clock.Now().UnixMilli(), string(config))
+	if err == nil {
+		st.runID, err = res.LastInsertId()
+	}
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("create run: %w", err)
+	}
+
+	s.store = st
+	slog.Info("Storing trips", "path", path, "run_id", st.runID)
+	s.goTracked(func() { s.runTripStore(ctx, st) })
+	return nil
+}
+
+// runTripStore writes records as they are queued, in one transaction for
+// all that are waiting, then writes what is left and closes the database
+// once ctx is canceled
+func (s *Simulation) runTripStore(ctx context.Context, st *tripStore) {
+	failing := false
+	write := func(records []any) {
+		err := st.write(records)
+		switch {
+		case err == nil:
+			s.storeWritten.Add(int64(len(records)))
+			if failing {
+				slog.Info("Trip store writes recovered")
+				failing = false
+			}
+		case !failing:
+			slog.Warn("Writing to the trip store failed", "records", len(records), "err", err)
+			failing = true
+		}
+		if err != nil {
+			s.storeDropped.Add(int64(len(records)))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if records := st.pending(nil); len(records) > 0 {
+				write(records)
+			}
+			if err := st.db.Close(); err != nil {
+				slog.Warn("Closing the trip store failed", "err", err)
+			}
+			return
+		case record := <-st.records:
+			write(st.pending([]any{record}))
+		}
+	}
+}
+
+// pending appends the queued records to records without waiting for more
+func (st *tripStore) pending(records []any) []any {
+	for {
+		select {
+		case record := <-st.records:
+			records = append(records, record)
+		default:
+			return records
+		}
+	}
+}
+
+// write stores records in one transaction
+func (st *tripStore) write(records []any) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, record := range records {
+		switch r := record.(type) {
+		case protocol.TripEvent:
+			err = st.writeTripEvent(tx, r)
+		case protocol.DriverStatusChanged:
+			_, err = tx.Exec(`INSERT INTO status_changes (run_id, driver_id, old_status, new_status, time) VALUES (?, ?, ?, ?, ?)`,
+				st.runID, r.DriverID, r.OldStatus, r.NewStatus, r.Time)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// writeTripEvent adds a trip event and updates its trip
+func (st *tripStore) writeTripEvent(tx *sql.Tx, e protocol.TripEvent) error {
+	_, err := tx.Exec(`INSERT INTO trip_events (run_id, trip_id, driver_id, event, reason, time) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		st.runID, e.TripID, e.DriverID, e.Event, e.Reason, e.Time)
+	if err != nil {
+		return err
+	}
+	estimate, err := json.Marshal(e.EstimatedFare)
+	if err != nil {
+		return err
+	}
+	var final, pickedUpAt, endedAt any
+	if e.FinalFare != nil {
+		encoded, err := json.Marshal(e.FinalFare)
+		if err != nil {
+			return err
+		}
+		final = string(encoded)
+	}
+	switch e.Event {
+	case protocol.TripPickedUp:
+		pickedUpAt = e.Time
+	case protocol.TripCompleted, protocol.TripCancelled:
+		endedAt = e.Time
+	}
+	_, err = tx.Exec(upsertTrip, st.runID, e.TripID, e.DriverID, e.Event, e.Reason,
+		e.Pickup.Lat, e.Pickup.Lon, e.Dropoff.Lat, e.Dropoff.Lon, string(estimate), final, e.Time, pickedUpAt, endedAt)
+	return err
+}
+
+// Trips returns up to limit trips assigned in [from, to) (virtual Unix
+// milliseconds), to driver unless it is 0, in the order they were assigned
+func (st *tripStore) Trips(ctx context.Context, from, to int64, driver, limit int) (protocol.TripRecords, error) {
+	resp := protocol.TripRecords{Trips: []protocol.TripRecord{}}
+	rows, err := st.db.QueryContext(ctx, selectTrips, from, to, driver, driver, limit+1)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t protocol.TripRecord
+		var estimate string
+		var final sql.NullString
+		err := rows.Scan(&t.RunID, &t.TripID, &t.DriverID, &t.State, &t.Reason,
+			&t.Pickup.Lat, &t.Pickup.Lon, &t.Dropoff.Lat, &t.Dropoff.Lon,
+			&estimate, &final, &t.AssignedAt, &t.PickedUpAt, &t.EndedAt)
+		if err != nil {
+			return resp, err
+		}
+		if err := json.Unmarshal([]byte(estimate), &t.EstimatedFare); err != nil {
+			return resp, err
+		}
+		if final.Valid {
+			t.FinalFare = new(protocol.Fare)
+			if err := json.Unmarshal([]byte(final.String), t.FinalFare); err != nil {
+				return resp, err
+			}
+		}
+		if len(resp.Trips) == limit {
+			resp.More = true
+			break
+		}
+		resp.Trips = append(resp.Trips, t)
+	}
+	return resp, rows.Err()
+}
+
+// storeRecord queues a broadcast trip event or status change for the trip
+// store. It must not block: it runs on the main loop.
+func (s *Simulation) storeRecord(v any) {
+	switch v.(type) {
+	case protocol.TripEvent, protocol.DriverStatusChanged:
+	default:
+		return
+	}
+	offer(s.store.records, v, &s.storeDropped)
+}
+
+// TripsHandler serves the stored trips assigned between from and to
+func (s *Simulation) TripsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.store == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	from, to := int64(0), s.clock.Now().UnixMilli()+1
+	for name, dst := range map[string]*int64{"from": &from, "to": &to} {
+		if str := query.Get(name); str != "" {
+			ms, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = ms
+		}
+	}
+	var driver int
+	if str := query.Get("driver"); str != "" {
+		id, err := strconv.Atoi(str)
+		if err != nil || id < 1 {
+			http.Error(w, "invalid driver", http.StatusBadRequest)
+			return
+		}
+		driver = id
+	}
+	limit := defaultTripsLimit
+	if str := query.Get("limit"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 || n > maxTripsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTripsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	resp, err := s.store.Trips(r.Context(), from, to, driver, limit)
+	if err != nil {
+		slog.Error("Reading trip store failed", "err", err)
+		http.Error(w, "reading trip store failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
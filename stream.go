@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"quadtree/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sseRetry is how long EventSource waits before reconnecting
+const sseRetry = 3 * time.Second
+
+// sseConn writes a client's frames as Server-Sent Events: every text frame
+// becomes one event, pings become comments that keep proxies from timing
+// the stream out, and a close frame ends the response
+type sseConn struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	mu     sync.Mutex // the writer and an eviction may write at once
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newSSEConn(w http.ResponseWriter) *sseConn {
+	return &sseConn{w: w, rc: http.NewResponseController(w), closed: make(chan struct{})}
+}
+
+func (c *sseConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return errors.New("stream closed")
+	default:
+	}
+
+	var err error
+	switch messageType {
+	case websocket.TextMessage:
+		// JSON is encoded without newlines, so it fits one data line
+		_, err = fmt.Fprintf(c.w, "data: %s\n\n", data)
+	case websocket.PingMessage:
+		_, err = fmt.Fprint(c.w, ": ping\n\n")
+	case websocket.CloseMessage:
+		c.close()
+		return nil
+	default:
+		return fmt.Errorf("SSE streams can't carry frame type %d", messageType)
+	}
+	if err != nil {
+		return err
+	}
+	return c.rc.Flush()
+}
+
+func (c *sseConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	c.SetWriteDeadline(deadline)
+	return c.WriteMessage(messageType, data)
+}
+
+func (c *sseConn) SetWriteDeadline(t time.Time) error {
+	return c.rc.SetWriteDeadline(t)
+}
+
+// Close ends the stream; the handler then returns. It waits for a write
+// under way, so nothing is written once the handler is gone.
+func (c *sseConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+// close ends the stream; c.mu must be held
+func (c *sseConn) close() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+// StreamHandler handles GET /api/stream: the driver updates and events a
+// WebSocket client gets, as Server-Sent Events for EventSource and clients
+// behind proxies that don't pass WebSockets. The stream's settings are the
+// client_params of a WebSocket client, given as query parameters: lat,
+// lon, radius or city, interval_ms, deltas, status and filter.
+func (s *Simulation) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := newStreamClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, release, ok := s.admitClient(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	client.identity = identityName(key)
+
+	conn := newSSEConn(w)
+	client.conn = conn
+	// The stream outlives the server's request timeouts; writes set their
+	// own deadlines
+	if err := conn.rc.SetReadDeadline(time.Time{}); err != nil {
+		client.logger().Warn("SSE stream failed", "err", err)
+		return
+	}
+	conn.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would hold events back
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if err := conn.rc.Flush(); err != nil {
+		client.logger().Warn("SSE stream failed", "err", err)
+		return
+	}
+
+	s.addClient(client)
+	client.logger().Info("SSE client connected")
+	defer func() {
+		conn.Close()
+		s.removeClient(client)
+		client.logger().Info("SSE client disconnected", "connected_for", time.Since(client.connectedAt),
+			"messages_sent", client.metrics.messages.Load(), "bytes_sent", client.metrics.bytes.Load())
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s.goTracked(func() { s.writeLoop(ctx, client) })
+
+	// Start with the drivers around the client rather than waiting for
+	// its first broadcast tick
+	s.SendDriversToClient(client)
+
+	select {
+	case <-conn.closed:
+	case <-ctx.Done():
+	}
+}
+
+// newStreamClient creates the client of an SSE stream from its query
+// parameters. Streams speak the flat protocol in JSON, events included.
+func newStreamClient(r *http.Request) (*WebSocketClient, error) {
+	query := r.URL.Query()
+	client := &WebSocketClient{
+		clientID: fmt.Sprintf("client-%d", time.Now().UnixNano()),
+		cfg:      clientSettings{version: protocol.VersionFlat, encoding: protocol.EncodingJSON},
+		send:     make(chan outbound, sendQueueSize),
+
+		transport:   TransportSSE,
+		remoteAddr:  remoteIP(r),
+		connectedAt: time.Now(),
+	}
+	cfg := &client.cfg
+
+	for _, p := range []struct {
+		name string
+		dst  *float64
+	}{{"lat", &cfg.lat}, {"lon", &cfg.lon}, {"radius", &cfg.radius}} {
+		if str := query.Get(p.name); str != "" {
+			val, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", p.name, str)
+			}
+			*p.dst = val
+		}
+	}
+	cfg.city = query.Get("city")
+
+	if str := query.Get("interval_ms"); str != "" {
+		ms, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval_ms %q", str)
+		}
+		if err := client.setUpdateInterval(ms); err != nil {
+			return nil, err
+		}
+	}
+	if str := query.Get("deltas"); str != "" {
+		deltas, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deltas %q", str)
+		}
+		cfg.deltas = deltas
+	}
+	if str := query.Get("status"); str != "" {
+		cfg.statuses = make(map[string]bool)
+		for _, name := range strings.Split(str, ",") {
+			status, err := parseDriverStatus(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			cfg.statuses[status.String()] = true
+		}
+	}
+	f, err := compileDriverFilter(query.Get("filter"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.filter = f
+	return client, nil
+}
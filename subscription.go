@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"sort"
+	"time"
+)
+
+// maxSubscriptions is how many subscriptions one connection may hold
+const maxSubscriptions = 16
+
+// subscription selects drivers for a client: by region, viewport, city or
+// ID, and optionally by status
+type subscription struct {
+	region   *protocol.Region
+	viewport *protocol.Viewport
+	city     string // canonical city name
+	drivers  map[int]bool
+	statuses map[string]bool
+}
+
+// newSubscription validates a subscribe message against the simulation
+func (s *Simulation) newSubscription(msg *protocol.Subscribe) (*subscription, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{region: msg.Region, viewport: msg.Viewport}
+	if msg.City != "" {
+		city, ok := s.findCity(msg.City)
+		if !ok {
+			return nil, fmt.Errorf("unknown city %q", msg.City)
+		}
+		sub.city = city.Name
+	}
+	if len(msg.Drivers) > 0 {
+		sub.drivers = make(map[int]bool, len(msg.Drivers))
+		for _, id := range msg.Drivers {
+			sub.drivers[id] = true
+		}
+	}
+	if len(msg.Statuses) > 0 {
+		sub.statuses = make(map[string]bool, len(msg.Statuses))
+		for _, status := range msg.Statuses {
+			sub.statuses[status] = true
+		}
+	}
+	return sub, nil
+}
+
+// matches reports whether a driver is selected by the subscription, and its
+// distance from the region center for region subscriptions
+func (sub *subscription) matches(id int, status string, city string, lon, lat float64) (float64, bool) {
+	if sub.statuses != nil && !sub.statuses[status] {
+		return 0, false
+	}
+	switch {
+	case sub.region != nil:
+		distKm := geo.HaversineKm(sub.region.Lon, sub.region.Lat, lon, lat)
+		return distKm, distKm <= geo.DegreesToKm(sub.region.Radius)
+	case sub.viewport != nil:
+		return 0, sub.viewport.Contains(lat, lon)
+	case sub.city != "":
+		return 0, city == sub.city
+	case sub.drivers != nil:
+		return 0, sub.drivers[id]
+	}
+	return 0, true
+}
+
+// handleSubscription processes a subscribe or unsubscribe message, answering
+// with the active subscriptions or an error, and sends an update right away
+func (s *Simulation) handleSubscription(client *WebSocketClient, requestID, msgType string, message []byte) {
+	panned, err := s.updateSubscriptions(client, msgType, message)
+	if err != nil {
+		s.sendReply(client, requestID, protocol.ErrorMessage{Type: protocol.TypeError, Error: err.Error()})
+		return
+	}
+	s.resubscribe(client)
+
+	client.subsMu.Lock()
+	active := make([]string, 0, len(client.subscriptions))
+	for id := range client.subscriptions {
+		active = append(active, id)
+	}
+	client.subsMu.Unlock()
+	sort.Strings(active)
+	s.sendReply(client, requestID, protocol.Subscriptions{Type: protocol.TypeSubscriptions, Active: active})
+
+	// The selection changed, so start over with a snapshot. A map that was
+	// panned or zoomed keeps getting deltas instead: the drivers that left
+	// and entered its viewport are exactly the changes it needs.
+	if !panned {
+		client.deltaMu.Lock()
+		client.lastSent = nil
+		client.deltaMu.Unlock()
+	}
+	s.SendDriversToClient(client)
+}
+
+// updateSubscriptions applies a subscribe or unsubscribe message. It reports
+// whether a viewport subscription was replaced by another viewport.
+func (s *Simulation) updateSubscriptions(client *WebSocketClient, msgType string, message []byte) (bool, error) {
+	if msgType == protocol.TypeUnsubscribe {
+		var msg protocol.Unsubscribe
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return false, fmt.Errorf("invalid unsubscribe message: %v", err)
+		}
+
+		client.subsMu.Lock()
+		defer client.subsMu.Unlock()
+		if msg.ID == "" {
+			clear(client.subscriptions)
+			return false, nil
+		}
+		if _, ok := client.subscriptions[msg.ID]; !ok {
+			return false, fmt.Errorf("no subscription %q", msg.ID)
+		}
+		delete(client.subscriptions, msg.ID)
+		return false, nil
+	}
+
+	var msg protocol.Subscribe
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return false, fmt.Errorf("invalid subscribe message: %v", err)
+	}
+	sub, err := s.newSubscription(&msg)
+	if err != nil {
+		return false, err
+	}
+
+	client.subsMu.Lock()
+	defer client.subsMu.Unlock()
+	if client.subscriptions == nil {
+		client.subscriptions = make(map[string]*subscription)
+	}
+	old, ok := client.subscriptions[msg.ID]
+	if !ok && len(client.subscriptions) >= maxSubscriptions {
+		return false, fmt.Errorf("at most %d subscriptions per connection", maxSubscriptions)
+	}
+	client.subscriptions[msg.ID] = sub
+	return ok && old.viewport != nil && sub.viewport != nil, nil
+}
+
+// subscribedDrivers builds the update for a client with subscriptions: every
+// driver selected by any of them, from the client's topics. It reports false
+// for clients that never subscribed.
+func (s *Simulation) subscribedDrivers(client *WebSocketClient) (protocol.DriversUpdate, bool) {
+	client.subsMu.Lock()
+	if client.subscriptions == nil {
+		client.subsMu.Unlock()
+		return protocol.DriversUpdate{}, false
+	}
+	subs := make([]*subscription, 0, len(client.subscriptions))
+	for _, sub := range client.subscriptions {
+		subs = append(subs, sub)
+	}
+	client.subsMu.Unlock()
+
+	cfg := client.settings()
+	candidates, publishedAt := s.topicDrivers(client)
+	defer s.broadcast.assemble.since(time.Now())
+	drivers := make([]protocol.DriverResponse, 0)
+	for _, state := range candidates {
+		if cfg.statuses != nil && !cfg.statuses[state.resp.Status] {
+			continue
+		}
+
+		// Distances are from the closest region that selected the driver
+		selected := false
+		nearest := math.Inf(1)
+		for _, sub := range subs {
+			if distKm, ok := sub.matches(state.resp.ID, state.resp.Status, state.city, state.lon, state.lat); ok {
+				selected = true
+				if sub.region != nil {
+					nearest = math.Min(nearest, distKm)
+				}
+			}
+		}
+		if !selected {
+			continue
+		}
+
+		resp := state.resp
+		if !math.IsInf(nearest, 1) {
+			resp.Distance = nearest
+		}
+		if matchDriver(cfg.filter, &resp) {
+			drivers = append(drivers, resp)
+		}
+	}
+
+	return protocol.DriversUpdate{
+		Type:      protocol.TypeDriversUpdate,
+		Drivers:   drivers,
+		Count:     len(drivers),
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+		DataAgeMs: time.Since(publishedAt).Milliseconds(),
+	}, true
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Hub topics. Driver state is grouped per zone, city and driver in the
+// world snapshot once per simulation update, and clients subscribe to the
+// topics their area or subscriptions cover; their updates are put together
+// from those instead of a spatial query per client. Events are published to
+// everyone who subscribed to them.
+const (
+	topicEvents = "events" // trip, offer and status events and the demand heatmap
+	topicStats  = "stats"  // fleet statistics every stats interval
+
+	// Zones are the cells of a grid over the world bounds
+	zoneSize = 0.05 // degrees, about 5.5km
+)
+
+// zoneGrid is the grid of zones over the world bounds
+type zoneGrid struct {
+	minLon, minLat float64 // southwest corner of the world, where the grid starts
+	cols, rows     int
+}
+
+// newZoneGrid lays zones over the world bounds
+func newZoneGrid(bounds quadtree.Bounds) zoneGrid {
+	return zoneGrid{
+		minLon: bounds.MinX,
+		minLat: bounds.MinY,
+		cols:   int(math.Ceil((bounds.MaxX - bounds.MinX) / zoneSize)),
+		rows:   int(math.Ceil((bounds.MaxY - bounds.MinY) / zoneSize)),
+	}
+}
+
+func zoneTopic(col, row int) string { return fmt.Sprintf("zone/%d/%d", col, row) }
+func cityTopic(name string) string  { return "city/" + name }
+func driverTopic(id int) string     { return "driver/" + strconv.Itoa(id) }
+
+// isDriverTopic reports whether a topic retains driver state
+func isDriverTopic(name string) bool {
+	return strings.HasPrefix(name, "zone/") || strings.HasPrefix(name, "city/") || strings.HasPrefix(name, "driver/")
+}
+
+// zoneOf returns the zone a position is in. Positions outside the world
+// bounds count towards the nearest zone at the edge.
+func (g zoneGrid) zoneOf(lon, lat float64) (int, int) {
+	col := int(math.Floor((lon - g.minLon) / zoneSize))
+	row := int(math.Floor((lat - g.minLat) / zoneSize))
+	return max(0, min(col, g.cols-1)), max(0, min(row, g.rows-1))
+}
+
+// zoneTopics returns the topics of the zones a rectangle overlaps
+func (g zoneGrid) zoneTopics(west, south, east, north float64) []string {
+	minCol, minRow := g.zoneOf(west, south)
+	maxCol, maxRow := g.zoneOf(east, north)
+	topics := make([]string, 0, (maxCol-minCol+1)*(maxRow-minRow+1))
+	for col := minCol; col <= maxCol; col++ {
+		for row := minRow; row <= maxRow; row++ {
+			topics = append(topics, zoneTopic(col, row))
+		}
+	}
+	return topics
+}
+
+// circleTopics returns the topics of the zones a circle overlaps
+func (g zoneGrid) circleTopics(lon, lat, radius float64) []string {
+	lonRadius := radius * geo.LonScale(lat)
+	return g.zoneTopics(lon-lonRadius, lat-radius, lon+lonRadius, lat+radius)
+}
+
+// driverState is a driver as published in a WorldSnapshot: what clients
+// are sent, plus the status, true position and closest city to select it
+// by. It is never changed once published.
+type driverState struct {
+	resp     protocol.DriverResponse
+	status   DriverStatus
+	lon, lat float64
+	city     string
+}
+
+// publishDrivers publishes a snapshot of the world with the current state
+// of every driver, grouped in its zone, city and driver topics. It must
+// run on the main loop.
+func (s *Simulation) publishDrivers() {
+	sets := make(map[string][]driverState, len(s.world().topics))
+	states := make(map[int]driverState, len(s.drivers))
+	// Velocities of local and replayed drivers are per simulated second
+	scale := s.clock.Scale()
+	if s.clock.Paused() {
+		scale = 0
+	}
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		vlon, vlat := driver.velocity()
+		updatedAt := driver.reportedAt
+		if driver.Origin == "" {
+			vlon, vlat, updatedAt = vlon*scale, vlat*scale, s.movedAt
+		}
+		state := driverState{
+			resp: protocol.DriverResponse{
+				ID:       driver.ID,
+				Lon:      driver.lon(),
+				Lat:      driver.lat(),
+				Status:   driver.Status.String(),
+				Heading:  math.Mod(driver.heading()*180/math.Pi+360, 360),
+				Speed:    driver.speed(),
+				Profile:  driver.behavior().Name,
+				Vehicle:  driver.Vehicle,
+				Origin:   driver.Origin,
+				RemoteID: driver.RemoteID,
+				VelLon:   vlon,
+				VelLat:   vlat,
+			},
+			status: driver.Status,
+			lon:    driver.lon(),
+			lat:    driver.lat(),
+		}
+		driver.mu.Unlock()
+		if !updatedAt.IsZero() {
+			state.resp.UpdatedAt = updatedAt.UnixNano() / int64(time.Millisecond)
+		}
+		driver.applyGPS(&state.resp)
+		state.city = closestCity(s.cities, state.lon, state.lat).Name
+		states[state.resp.ID] = state
+
+		zone := zoneTopic(s.zones.zoneOf(state.lon, state.lat))
+		city := cityTopic(state.city)
+		sets[zone] = append(sets[zone], state)
+		sets[city] = append(sets[city], state)
+		sets[driverTopic(state.resp.ID)] = []driverState{state}
+	}
+
+	s.publishWorld(states, sets)
+}
+
+// topicDrivers returns the drivers of a client's topics in the last
+// published world, each once, and when it was published
+func (s *Simulation) topicDrivers(client *WebSocketClient) ([]driverState, time.Time) {
+	defer s.broadcast.query.since(time.Now())
+	world := s.world()
+	var drivers []driverState
+	seen := make(map[int]bool)
+	for _, name := range s.hub.Topics(client.hubSub) {
+		if !isDriverTopic(name) {
+			continue
+		}
+		for _, state := range world.topics[name] {
+			if !seen[state.resp.ID] {
+				seen[state.resp.ID] = true
+				drivers = append(drivers, state)
+			}
+		}
+	}
+	return drivers, world.publishedAt
+}
+
+// resubscribe points a client's hub subscriptions at what it currently
+// asks for: the zones of its area or the topics of its subscriptions, and
+// events and stats if it takes them. It must be called whenever those change.
+func (s *Simulation) resubscribe(client *WebSocketClient) {
+	cfg := client.settings()
+
+	var topics []string
+	if cfg.version >= protocol.VersionFlat && !cfg.noEvents {
+		topics = append(topics, topicEvents)
+	}
+	if cfg.version >= protocol.Version && !cfg.noStats {
+		topics = append(topics, topicStats)
+	}
+
+	client.subsMu.Lock()
+	subs := make([]*subscription, 0, len(client.subscriptions))
+	for _, sub := range client.subscriptions {
+		subs = append(subs, sub)
+	}
+	subscribed := client.subscriptions != nil
+	client.subsMu.Unlock()
+
+	if subscribed {
+		for _, sub := range subs {
+			topics = append(topics, s.subscriptionTopics(sub)...)
+		}
+	} else {
+		lon, lat, radius := s.clientArea(cfg)
+		if radius != cfg.radius && cfg.radius != 0 {
+			client.logger().Debug("Client radius too small, using default", "radius", cfg.radius, "default", radius)
+		}
+		topics = append(topics, s.zones.circleTopics(lon, lat, radius)...)
+	}
+	s.hub.SetTopics(client.hubSub, topics)
+}
+
+// subscriptionTopics returns the topics holding the drivers a subscription
+// may select
+func (s *Simulation) subscriptionTopics(sub *subscription) []string {
+	switch {
+	case sub.region != nil:
+		return s.zones.circleTopics(sub.region.Lon, sub.region.Lat, sub.region.Radius)
+	case sub.viewport != nil:
+		v := sub.viewport
+		if v.West > v.East {
+			// Across the antimeridian, far outside the world bounds
+			return append(s.zones.zoneTopics(v.West, v.South, 180, v.North), s.zones.zoneTopics(-180, v.South, v.East, v.North)...)
+		}
+		return s.zones.zoneTopics(v.West, v.South, v.East, v.North)
+	case sub.city != "":
+		return []string{cityTopic(sub.city)}
+	case sub.drivers != nil:
+		topics := make([]string, 0, len(sub.drivers))
+		for id := range sub.drivers {
+			topics = append(topics, driverTopic(id))
+		}
+		return topics
+	}
+
+	// Statuses only: every driver is closest to some city
+	topics := make([]string, len(s.cities))
+	for i, city := range s.cities {
+		topics[i] = cityTopic(city.Name)
+	}
+	return topics
+}
+
+// hubSubscriber delivers the messages a client's hub subscriptions receive
+type hubSubscriber struct {
+	s      *Simulation
+	client *WebSocketClient
+}
+
+// eventFrames is an event message encoded once for all subscribers, flat
+// for v2 clients, enveloped for current ones and as a protobuf Frame for
+// the ones that chose that encoding
+type eventFrames struct {
+	kind            string // message type
+	flat, enveloped []byte
+	proto           []byte // nil for messages a Frame can't carry
+}
+
+func (sub *hubSubscriber) Deliver(topic string, msg interface{}) {
+	frames, ok := msg.(*eventFrames)
+	if !ok {
+		return
+	}
+	cfg := sub.client.settings()
+	if cfg.encoding == protocol.EncodingProtobuf && frames.proto != nil {
+		sub.s.enqueue(sub.client, websocket.BinaryMessage, frames.kind, frames.proto)
+	} else if cfg.version >= protocol.Version {
+		sub.s.writeToClient(sub.client, frames.kind, frames.enveloped)
+	} else {
+		sub.s.writeToClient(sub.client, frames.kind, frames.flat)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"quadtree/quadtree"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Speed of the points of a trace that has no times, like a GPX route
+	traceDefaultSpeedKmh = 30
+	// Largest trace accepted over HTTP
+	maxTraceSize = 16 << 20
+)
+
+// errTraceRefused is returned for drivers that can't follow a trace:
+// federated and fed ones, and ones on a trip
+var errTraceRefused = errors.New("trace refused")
+
+// Trace is a recorded track, from a GPX or NMEA file, that drivers can
+// drive again
+type Trace struct {
+	Name   string
+	points []tracePoint
+}
+
+// tracePoint is a fix of a trace, at seconds since its first
+type tracePoint struct {
+	lon, lat float64
+	at       float64
+}
+
+// duration is how long the trace takes at its own pace, in seconds
+func (t *Trace) duration() float64 {
+	return t.points[len(t.points)-1].at
+}
+
+// distanceKm is the length of the trace
+func (t *Trace) distanceKm() float64 {
+	km := 0.0
+	for i := 1; i < len(t.points); i++ {
+		a, b := t.points[i-1], t.points[i]
+		km += geo.HaversineKm(a.lon, a.lat, b.lon, b.lat)
+	}
+	return km
+}
+
+// traceCursor is a driver's place on the trace it follows
+type traceCursor struct {
+	trace   *Trace
+	scale   float64 // trace seconds per simulated second
+	loop    bool    // starts over at the end rather than letting go
+	elapsed float64 // trace seconds since the start
+	next    int     // first point after elapsed
+}
+
+// ParseTrace reads a GPX file or NMEA sentences, telling them apart by
+// their first bytes, whose points must be inside the world bounds. Fixes
+// without a time are paced at 30 km/h.
+func ParseTrace(name string, data []byte, bounds quadtree.Bounds) (*Trace, error) {
+	var (
+		points []tracePoint
+		timed  bool
+		err    error
+	)
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("<")) {
+		points, timed, err = parseGPX(trimmed)
+	} else {
+		points, timed, err = parseNMEA(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trace %s: %w", name, err)
+	}
+
+	for _, p := range points {
+		if !bounds.Contains(p.lon, p.lat) {
+			return nil, fmt.Errorf("trace %s: point %.5f,%.5f is outside the world bounds", name, p.lat, p.lon)
+		}
+	}
+	if !timed {
+		for i := range points {
+			if i > 0 {
+				a, b := points[i-1], points[i]
+				points[i].at = a.at + geo.HaversineKm(a.lon, a.lat, b.lon, b.lat)/traceDefaultSpeedKmh*3600
+			}
+		}
+	}
+	// Keep the fixes that move the clock forward, starting at zero
+	kept := points[:0]
+	for _, p := range points {
+		if len(kept) > 0 && p.at <= kept[len(kept)-1].at {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if len(kept) < 2 {
+		return nil, fmt.Errorf("trace %s: at least 2 points at different times are needed", name)
+	}
+	start := kept[0].at
+	for i := range kept {
+		kept[i].at -= start
+	}
+	return &Trace{Name: name, points: kept}, nil
+}
+
+// gpxFile holds the parts of a GPX file that make up a trace: the points
+// of its tracks, or else of its routes
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+// parseGPX returns the points of a GPX file, and whether every one has a
+// time
+func parseGPX(data []byte) ([]tracePoint, bool, error) {
+	var f gpxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, false, fmt.Errorf("invalid GPX: %w", err)
+	}
+	var gpx []gpxPoint
+	for _, trk := range f.Tracks {
+		for _, seg := range trk.Segments {
+			gpx = append(gpx, seg.Points...)
+		}
+	}
+	if len(gpx) == 0 {
+		for _, rte := range f.Routes {
+			gpx = append(gpx, rte.Points...)
+		}
+	}
+
+	points := make([]tracePoint, 0, len(gpx))
+	timed := true
+	var first time.Time
+	for _, p := range gpx {
+		point := tracePoint{lon: p.Lon, lat: p.Lat}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+		if err != nil {
+			timed = false
+		} else {
+			if first.IsZero() {
+				first = at
+			}
+			point.at = at.Sub(first).Seconds()
+		}
+		points = append(points, point)
+	}
+	return points, timed, nil
+}
+
+// parseNMEA returns the fixes of the RMC and GGA sentences of an NMEA log.
+// Sentences with a bad checksum or without a fix are skipped. Times are of
+// the day, so a time earlier than the one before starts the next day.
+func parseNMEA(data []byte) ([]tracePoint, bool, error) {
+	var points []tracePoint
+	day := 0.0
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if i := strings.IndexByte(line, '$'); i >= 0 {
+			line = line[i:]
+		} else {
+			continue
+		}
+		if body, sum, ok := strings.Cut(line[1:], "*"); ok {
+			want, err := strconv.ParseUint(sum, 16, 8)
+			if err != nil || nmeaChecksum(body) != byte(want) {
+				continue
+			}
+			line = "$" + body
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields[0]) < 6 {
+			continue
+		}
+		var clock, lat, ns, lon, ew string
+		switch fields[0][3:] {
+		case "RMC":
+			// $--RMC,time,status,lat,N/S,lon,E/W,...
+			if len(fields) < 7 || fields[2] != "A" {
+				continue
+			}
+			clock, lat, ns, lon, ew = fields[1], fields[3], fields[4], fields[5], fields[6]
+		case "GGA":
+			// $--GGA,time,lat,N/S,lon,E/W,quality,...
+			if len(fields) < 7 || fields[6] == "" || fields[6] == "0" {
+				continue
+			}
+			clock, lat, ns, lon, ew = fields[1], fields[2], fields[3], fields[4], fields[5]
+		default:
+			continue
+		}
+
+		at, ok1 := nmeaTime(clock)
+		latDeg, ok2 := nmeaDegrees(lat, ns, "N", "S")
+		lonDeg, ok3 := nmeaDegrees(lon, ew, "E", "W")
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		if n := len(points); n > 0 && at+day < points[n-1].at-12*3600 {
+			day += 24 * 3600
+		}
+		points = append(points, tracePoint{lon: lonDeg, lat: latDeg, at: at + day})
+	}
+	if err := lines.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(points) == 0 {
+		return nil, false, errors.New("no GPX track or NMEA fixes found")
+	}
+	return points, true, nil
+}
+
+// nmeaChecksum is the XOR of the bytes between $ and *
+func nmeaChecksum(body string) byte {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return sum
+}
+
+// nmeaTime parses hhmmss.ss into seconds of the day
+func nmeaTime(s string) (float64, bool) {
+	if len(s) < 6 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(s[0:2])
+	m, err2 := strconv.Atoi(s[2:4])
+	sec, err3 := strconv.ParseFloat(s[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return float64(h*3600+m*60) + sec, true
+}
+
+// nmeaDegrees parses a (d)ddmm.mmmm coordinate and its hemisphere
+func nmeaDegrees(s, hemisphere, positive, negative string) (float64, bool) {
+	dot := strings.IndexByte(s, '.')
+	if dot < 0 {
+		dot = len(s)
+	}
+	if dot < 3 {
+		return 0, false
+	}
+	deg, err1 := strconv.Atoi(s[:dot-2])
+	minutes, err2 := strconv.ParseFloat(s[dot-2:], 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	value := float64(deg) + minutes/60
+	switch hemisphere {
+	case positive:
+		return value, true
+	case negative:
+		return -value, true
+	}
+	return 0, false
+}
+
+// LoadTraces reads the traces of a -traces list: GPX or NMEA files, or
+// directories of .gpx and .nmea files, each optionally after the ID of the
+// driver to follow it. Traces without an ID go to the first drivers that
+// have none.
+func LoadTraces(spec string, bounds quadtree.Bounds) (map[int]*Trace, []*Trace, error) {
+	byID := make(map[int]*Trace)
+	var rest []*Trace
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id := 0
+		if before, after, ok := strings.Cut(item, "="); ok {
+			n, err := strconv.Atoi(before)
+			if err != nil || n <= 0 {
+				return nil, nil, fmt.Errorf("invalid driver ID in %q", item)
+			}
+			id, item = n, after
+		}
+
+		paths := []string{item}
+		if info, err := os.Stat(item); err != nil {
+			return nil, nil, err
+		} else if info.IsDir() {
+			if id != 0 {
+				return nil, nil, fmt.Errorf("%s: a directory can't go to one driver", item)
+			}
+			paths = nil
+			for _, pattern := range []string{"*.gpx", "*.nmea"} {
+				matches, _ := filepath.Glob(filepath.Join(item, pattern))
+				paths = append(paths, matches...)
+			}
+			slices.Sort(paths)
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			trace, err := ParseTrace(filepath.Base(path), data, bounds)
+			if err != nil {
+				return nil, nil, err
+			}
+			if id != 0 {
+				byID[id] = trace
+			} else {
+				rest = append(rest, trace)
+			}
+		}
+	}
+	return byID, rest, nil
+}
+
+// assignTraces puts drivers on the traces of a -traces list, scaled by
+// scale and looping. It must run on the main loop.
+func (s *Simulation) assignTraces(spec string, scale float64) error {
+	byID, rest, err := LoadTraces(spec, s.settings.bounds())
+	if err != nil {
+		return err
+	}
+	for id, trace := range byID {
+		if err := s.followTrace(id, trace, scale, true); err != nil {
+			return fmt.Errorf("driver %d: %w", id, err)
+		}
+	}
+	assigned := len(byID)
+	for _, driver := range s.drivers {
+		if len(rest) == 0 {
+			break
+		}
+		if _, ok := byID[driver.ID]; ok || driver.Origin != "" {
+			continue
+		}
+		if err := s.followTrace(driver.ID, rest[0], scale, true); err != nil {
+			return fmt.Errorf("driver %d: %w", driver.ID, err)
+		}
+		rest = rest[1:]
+		assigned++
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%d traces left over: not enough drivers", len(rest))
+	}
+	slog.Info("Drivers following traces", "drivers", assigned, "scale", scale)
+	return nil
+}
+
+// followTrace puts a driver at the start of a trace to drive it, taking
+// it out of any standby pool and off its destination. It must run on the
+// main loop.
+func (s *Simulation) followTrace(id int, trace *Trace, scale float64, loop bool) error {
+	if s.replayer != nil {
+		return errReplayMode
+	}
+	if err := s.mirroring(); err != nil {
+		return err
+	}
+	if scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		return fmt.Errorf("invalid trace scale %g", scale)
+	}
+	driver, ok := s.driverByID(id)
+	if !ok {
+		return errUnknownDriver
+	}
+
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+	switch {
+	case driver.Origin != "":
+		return fmt.Errorf("%w: driver %d comes from %s", errTraceRefused, driver.ID, driver.Origin)
+	case driver.trip != nil:
+		return fmt.Errorf("%w: driver %d is on a trip", errTraceRefused, driver.ID)
+	}
+	driver.standby, driver.dest, driver.idle = nil, nil, 0
+	driver.trace = &traceCursor{trace: trace, scale: scale, loop: loop, next: 1}
+	start := trace.points[0]
+	driver.moveTo(start.lon, start.lat)
+	driver.followTrace(0)
+	return nil
+}
+
+// followTrace moves the driver deltaTime simulated seconds along its
+// trace, between the fixes around that time. At the end it starts over
+// or, without loop, goes back to being simulated from there.
+func (d *Driver) followTrace(deltaTime float64) {
+	c := d.trace
+	pts := c.trace.points
+	c.elapsed += deltaTime * c.scale
+	looped := false
+	if end := c.trace.duration(); c.elapsed >= end {
+		if !c.loop {
+			last := pts[len(pts)-1]
+			d.moveTo(last.lon, last.lat)
+			d.trace = nil
+			return
+		}
+		c.elapsed = math.Mod(c.elapsed, end)
+		c.next = 1
+		looped = true
+	}
+	for pts[c.next].at <= c.elapsed {
+		c.next++
+	}
+
+	a, b := pts[c.next-1], pts[c.next]
+	f := (c.elapsed - a.at) / (b.at - a.at)
+	lon, lat := a.lon+(b.lon-a.lon)*f, a.lat+(b.lat-a.lat)*f
+	if !looped {
+		d.odometer += geo.HaversineKm(d.lon(), d.lat(), lon, lat)
+	}
+	d.moveTo(lon, lat)
+	if a.lon != b.lon || a.lat != b.lat {
+		d.setHeading(geo.BearingTo(a.lon, a.lat, b.lon, b.lat))
+	}
+	// Speed is in degrees of arc per simulated second
+	d.setSpeed(geo.KmToDegrees(geo.HaversineKm(a.lon, a.lat, b.lon, b.lat)) / (b.at - a.at) * c.scale)
+}
+
+// TraceHandler handles PUT /api/drivers/{id}/trace, which puts a driver on
+// the GPX or NMEA trace in the body, and DELETE, which lets it go back to
+// being simulated
+func (s *Simulation) TraceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid driver id %q", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err := s.exec(r.Context(), func() error {
+			driver, ok := s.driverByID(id)
+			if !ok {
+				return errUnknownDriver
+			}
+			driver.mu.Lock()
+			defer driver.mu.Unlock()
+			if driver.trace != nil {
+				driver.trace = nil
+				driver.setSpeed(s.tunables.Load().randomSpeed(s.rand.Float64()))
+			}
+			return nil
+		})
+		if errors.Is(err, errUnknownDriver) {
+			http.Error(w, fmt.Sprintf("unknown driver %d", id), http.StatusNotFound)
+		} else if err != nil {
+			writeFleetError(w, err)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	query := r.URL.Query()
+	scale, loop := 1.0, true
+	if str := query.Get("scale"); str != "" {
+		if scale, err = strconv.ParseFloat(str, 64); err != nil || scale <= 0 {
+			http.Error(w, "scale must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	if str := query.Get("loop"); str != "" {
+		if loop, err = strconv.ParseBool(str); err != nil {
+			http.Error(w, "loop must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTraceSize))
+	if err != nil {
+		http.Error(w, "reading trace: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := query.Get("name")
+	if name == "" {
+		name = fmt.Sprintf("driver-%d", id)
+	}
+	trace, err := ParseTrace(name, data, s.settings.bounds())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.exec(r.Context(), func() error { return s.followTrace(id, trace, scale, loop) })
+	switch {
+	case errors.Is(err, errUnknownDriver):
+		http.Error(w, fmt.Sprintf("unknown driver %d", id), http.StatusNotFound)
+		return
+	case errors.Is(err, errTraceRefused):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		writeFleetError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.TraceAssignment{
+		DriverID:   id,
+		Name:       trace.Name,
+		Points:     len(trace.points),
+		DurationS:  trace.duration(),
+		DistanceKm: trace.distanceKm(),
+		Scale:      scale,
+		Loop:       loop,
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+	"quadtree/geo"
+	"quadtree/protocol"
+	"time"
+)
+
+const (
+	boardingTime    = 30      // how long a pickup takes in seconds
+	riderCancelRate = 0.00017 // chance per second that a waiting rider cancels
+	lateCancelBoost = 5       // riders cancel this much more readily once the driver is late
+)
+
+// Trip is a ride from the moment a driver accepts it until the drop-off
+type Trip struct {
+	ID       int
+	Driver   *Driver
+	Pickup   waypoint
+	Dropoff  waypoint
+	State    string        // protocol.TripAssigned or protocol.TripPickedUp
+	Estimate protocol.Fare // quoted when the ride was accepted
+
+	// WebSocket client that requested the ride, sent its progress; nil for
+	// simulated riders and rides requested over HTTP
+	rider *WebSocketClient
+
+	assignedAt     time.Time
+	pickupETA      time.Duration // expected drive to the pickup when assigned
+	pickedUpAt     time.Time
+	pickupOdometer float64 // driver odometer at the pickup, in km
+}
+
+// startTrip sends a driver that accepted ride request id to the pickup.
+// Without a dropoff the rider's is picked like a driver destination. It
+// must run on the main loop.
+func (s *Simulation) startTrip(id int, driver *Driver, lon, lat float64, dropoff *waypoint) *Trip {
+	switch {
+	case dropoff != nil:
+	case s.destinations != nil:
+		dropoff = s.destinations.Pick(lon, lat, s.geofences, s.rand)
+	default:
+		dLon, dLat := placeInCity(s.nearestCity(lon, lat), 0, 1, s.geofences, s.rand)
+		dropoff = &waypoint{dLon, dLat}
+	}
+
+	trip := &Trip{
+		ID:         id,
+		Driver:     driver,
+		Pickup:     waypoint{lon, lat},
+		Dropoff:    *dropoff,
+		State:      protocol.TripAssigned,
+		Estimate:   s.EstimateFare(lon, lat, dropoff.lon, dropoff.lat),
+		assignedAt: s.clock.Now(),
+	}
+
+	driver.mu.Lock()
+	approachKm := geo.HaversineKm(driver.lon(), driver.lat(), lon, lat) * roadFactor
+	trip.pickupETA = time.Duration(approachKm / s.tunables.Load().averageSpeedKmh() * float64(time.Hour))
+	driver.trip = trip
+	driver.dest = &trip.Pickup
+	driver.mu.Unlock()
+
+	s.trips = append(s.trips, trip)
+	s.publishTripEvent(trip, protocol.TripAssigned, "", nil)
+	return trip
+}
+
+// updateTrips moves trips along when their drivers reach the pickup or the
+// drop-off, and cancels some of those still waiting for a driver. It must
+// run on the main loop, after the drivers moved deltaTime seconds.
+func (s *Simulation) updateTrips(deltaTime float64) {
+	active := s.trips[:0]
+	for _, trip := range s.trips {
+		if !s.updateTrip(trip, deltaTime) {
+			active = append(active, trip)
+		}
+	}
+	clear(s.trips[len(active):])
+	s.trips = active
+}
+
+// updateTrip advances one trip and reports whether it has ended
+func (s *Simulation) updateTrip(trip *Trip, deltaTime float64) bool {
+	d := trip.Driver
+	now := s.clock.Now()
+
+	if trip.State == protocol.TripAssigned {
+		if reason, ok := s.rollCancellation(trip, deltaTime); ok {
+			s.cancelTrip(trip, reason)
+			return true
+		}
+	}
+
+	d.mu.Lock()
+	target := trip.Pickup
+	if trip.State == protocol.TripPickedUp {
+		target = trip.Dropoff
+	}
+	if geo.HaversineKm(d.lon(), d.lat(), target.lon, target.lat) > geo.DegreesToKm(arrivalRadius) {
+		d.mu.Unlock()
+		return false
+	}
+
+	if trip.State == protocol.TripAssigned {
+		// At the pickup: the rider gets in, then off to the drop-off
+		trip.State = protocol.TripPickedUp
+		trip.pickedUpAt = now
+		trip.pickupOdometer = d.odometer
+		d.dest = &trip.Dropoff
+		d.idle = boardingTime
+		d.mu.Unlock()
+
+		s.publishTripEvent(trip, protocol.TripPickedUp, "", nil)
+		return false
+	}
+
+	// At the drop-off: the final fare uses the distance actually driven and
+	// the surge quoted when the ride was accepted
+	final := s.fares.Price(d.odometer-trip.pickupOdometer, now.Sub(trip.pickedUpAt).Minutes(), trip.Estimate.Surge)
+	d.trip = nil
+	d.dest = nil
+	d.Status = Available
+	d.lastTrip = now
+	d.mu.Unlock()
+
+	s.statsMu.Lock()
+	s.stats.CompletedTrips++
+	s.stats.Revenue += final.Total
+	s.statsMu.Unlock()
+
+	s.publishTripEvent(trip, protocol.TripCompleted, "", &final)
+	return true
+}
+
+// rollCancellation decides whether a trip still waiting for its pickup is
+// cancelled this update: the driver may not show up (more likely for lazy
+// drivers), and the rider may give up, much more readily once the driver
+// is later than the ETA.
+func (s *Simulation) rollCancellation(trip *Trip, deltaTime float64) (string, bool) {
+	trip.Driver.mu.Lock()
+	noShowRate := trip.Driver.behavior().NoShowRate
+	trip.Driver.mu.Unlock()
+
+	if s.rand.Float64() < 1-math.Exp(-noShowRate*deltaTime) {
+		return protocol.CancelNoShow, true
+	}
+
+	cancelRate := riderCancelRate
+	if s.clock.Now().Sub(trip.assignedAt) > trip.pickupETA {
+		cancelRate *= lateCancelBoost
+	}
+	if s.rand.Float64() < 1-math.Exp(-cancelRate*deltaTime) {
+		return protocol.CancelByRider, true
+	}
+	return "", false
+}
+
+// cancelTrip ends a trip before the pickup and makes its driver available
+// again
+func (s *Simulation) cancelTrip(trip *Trip, reason string) {
+	d := trip.Driver
+	d.mu.Lock()
+	d.trip = nil
+	d.dest = nil
+	d.Status = Available
+	d.lastTrip = s.clock.Now()
+	d.mu.Unlock()
+
+	s.statsMu.Lock()
+	if reason == protocol.CancelNoShow {
+		s.stats.NoShows++
+	} else {
+		s.stats.CancelledTrips++
+	}
+	s.statsMu.Unlock()
+
+	s.publishTripEvent(trip, protocol.TripCancelled, reason, nil)
+}
+
+// dropTrips forgets the trips of drivers that were removed. It must run on
+// the main loop.
+func (s *Simulation) dropTrips(removed []int) {
+	gone := make(map[int]bool, len(removed))
+	for _, id := range removed {
+		gone[id] = true
+	}
+
+	active := s.trips[:0]
+	for _, trip := range s.trips {
+		if !gone[trip.Driver.ID] {
+			active = append(active, trip)
+		}
+	}
+	clear(s.trips[len(active):])
+	s.trips = active
+}
+
+// publishTripEvent sends a trip event to all clients that speak the current
+// protocol. A rider also learns that its trip ended, and /api/rides/{id}
+// remembers how.
+func (s *Simulation) publishTripEvent(trip *Trip, event, reason string, final *protocol.Fare) {
+	if event == protocol.TripCompleted || event == protocol.TripCancelled {
+		s.sendRideProgress(trip, event, reason, final)
+		s.rememberEndedRide(s.rideProgress(trip, event, reason, final))
+		if s.postgis != nil || s.export != nil {
+			ended := s.newEndedTrip(trip, event, reason, final)
+			if s.postgis != nil {
+				s.persistTrip(ended)
+			}
+			if s.export != nil {
+				s.exportTrip(ended)
+			}
+		}
+	}
+	s.broadcastMessage(protocol.TripEvent{
+		Type:          protocol.TypeTripEvent,
+		Event:         event,
+		Reason:        reason,
+		TripID:        trip.ID,
+		DriverID:      trip.Driver.ID,
+		Pickup:        protocol.Location{Lat: trip.Pickup.lat, Lon: trip.Pickup.lon},
+		Dropoff:       protocol.Location{Lat: trip.Dropoff.lat, Lon: trip.Dropoff.lon},
+		EstimatedFare: trip.Estimate,
+		FinalFare:     final,
+		Time:          s.clock.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RunConfig is everything needed to re-run a simulation from the start. It
+// is written into the session header of every recording.
+type RunConfig struct {
+	Seed          int64     `json:"seed"`
+	Speed         float64   `json:"speed"`
+	Deterministic bool      `json:"deterministic"`  // virtual time only advances through updates
+	Start         time.Time `json:"start,omitzero"` // virtual start time of a deterministic run (default: now)
+	Profiles      string    `json:"profiles,omitempty"`
+	CityWeights   string    `json:"city_weights,omitempty"`
+	Movement      string    `json:"movement,omitempty"` // destination or random (recordings that predate it)
+	GPSNoise      float64   `json:"gps_noise_m,omitempty"`
+	DemandScale   float64   `json:"demand_scale"` // multiplies the everyday demand of the cities
+	Geofences     string    `json:"geofences,omitempty"`
+	Scenario      string    `json:"scenario,omitempty"`
+	Traces        string    `json:"traces,omitempty"`
+	TraceScale    float64   `json:"trace_scale,omitempty"`
+	Settings      *Settings `json:"settings,omitempty"` // the compiled-in defaults when nil
+}
+
+// settings returns the settings of the run, the defaults when it has none
+func (cfg RunConfig) settings() Settings {
+	if cfg.Settings == nil {
+		return defaultSettings()
+	}
+	return *cfg.Settings
+}
+
+// newRunSimulation creates a simulation from a run configuration
+func newRunSimulation(cfg RunConfig) (*Simulation, error) {
+	settings := cfg.settings()
+	if err := settings.validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+
+	if err := validateTimeScale(cfg.Speed); err != nil {
+		return nil, fmt.Errorf("invalid speed: %w", err)
+	}
+
+	if cfg.DemandScale < 0 {
+		return nil, fmt.Errorf("invalid demand scale %g: must not be negative", cfg.DemandScale)
+	}
+
+	// Geofences are loaded first so initial placement can respect them
+	var fences *GeofenceSet
+	if cfg.Geofences != "" {
+		var err error
+		fences, err = LoadGeofences(cfg.Geofences)
+		if err != nil {
+			return nil, fmt.Errorf("load geofences: %w", err)
+		}
+	}
+
+	var cityWeights map[string]float64
+	if cfg.CityWeights != "" {
+		var err error
+		cityWeights, err = parseWeights(cfg.CityWeights)
+		if err != nil {
+			return nil, fmt.Errorf("invalid city weights: %w", err)
+		}
+	}
+
+	sim, err := NewSimulation(settings, rand.New(rand.NewSource(cfg.Seed)), cityWeights, fences)
+	if err != nil {
+		return nil, err
+	}
+	sim.runConfig = cfg
+	if cfg.Deterministic {
+		// Demand follows the time of day, so the start time is part of the run
+		start := cfg.Start
+		if start.IsZero() {
+			start = time.Now()
+		}
+		sim.useClock(NewManualClock(start, cfg.Speed))
+	} else {
+		sim.clock.SetScale(cfg.Speed)
+	}
+
+	// Everyday demand; recordings made before it existed have no scale and
+	// no baseline demand
+	baseline := make([]CityDemand, 0, len(defaultBaseline))
+	for _, cd := range defaultBaseline {
+		cd.PeakPerMin *= cfg.DemandScale
+		if cd.PeakPerMin > 0 {
+			baseline = append(baseline, cd)
+		}
+	}
+	if err := sim.SetBaselineDemand(baseline); err != nil {
+		return nil, err
+	}
+
+	// Recordings made before destinations existed random walk
+	movement := cfg.Movement
+	if movement == "" {
+		movement = MovementRandom
+	}
+	if err := sim.SetMovement(movement); err != nil {
+		return nil, err
+	}
+
+	if err := sim.SetGPSNoise(cfg.GPSNoise); err != nil {
+		return nil, err
+	}
+
+	if cfg.Profiles != "" {
+		mix, err := ParseProfileMix(cfg.Profiles)
+		if err != nil {
+			return nil, err
+		}
+		sim.SetProfileMix(mix)
+	}
+
+	if fences != nil {
+		relocated := sim.SetGeofences(fences)
+		slog.Info("Loaded geofences", "zones", len(fences.Zones), "path", cfg.Geofences, "relocated", relocated)
+	}
+
+	if cfg.Scenario != "" {
+		sc, err := LoadScenario(cfg.Scenario, sim.settings.bounds())
+		if err != nil {
+			return nil, fmt.Errorf("load scenario: %w", err)
+		}
+		sim.demand.Schedule(sc)
+		slog.Info("Scheduled demand shocks", "shocks", len(sc.Shocks), "path", cfg.Scenario)
+		if sc.Baseline != nil {
+			if err := sim.SetBaselineDemand(sc.Baseline); err != nil {
+				return nil, fmt.Errorf("scenario: %w", err)
+			}
+		}
+	}
+
+	if cfg.Traces != "" {
+		if err := sim.assignTraces(cfg.Traces, cfg.TraceScale); err != nil {
+			return nil, fmt.Errorf("load traces: %w", err)
+		}
+	}
+
+	return sim, nil
+}
+
+// applyCommand re-applies a recorded runtime change
+func (s *Simulation) applyCommand(cmd *recordLine) error {
+	switch cmd.Command {
+	case "spawn":
+		if cmd.Spawn == nil {
+			return errors.New("spawn command without a request")
+		}
+		_, err := s.SpawnDrivers(*cmd.Spawn)
+		return err
+	case "despawn":
+		_, err := s.DespawnDrivers(cmd.IDs)
+		return err
+	case "shock":
+		if cmd.Shock == nil {
+			return errors.New("shock command without a shock")
+		}
+		_, err := s.TriggerShock(*cmd.Shock)
+		return err
+	case "cancel_shock":
+		for _, id := range cmd.IDs {
+			s.CancelShock(id)
+		}
+		return nil
+	case "config":
+		if cmd.Patch == nil {
+			return errors.New("config command without a patch")
+		}
+		return s.Reconfigure(*cmd.Patch)
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Command)
+	}
+}
+
+// Divergence is the first difference between a recorded frame and the
+// regenerated one
+type Divergence struct {
+	Session     int
+	Tick        int64
+	DriverID    int
+	Field       string
+	Recorded    string
+	Regenerated string
+}
+
+func (d *Divergence) String() string {
+	if d.DriverID == 0 {
+		return fmt.Sprintf("session %d, frame %d: %s is %s in the recording but %s when re-run",
+			d.Session, d.Tick, d.Field, d.Recorded, d.Regenerated)
+	}
+	return fmt.Sprintf("session %d, frame %d: driver %d %s is %s in the recording but %s when re-run",
+		d.Session, d.Tick, d.DriverID, d.Field, d.Recorded, d.Regenerated)
+}
+
+// VerifyResult summarizes a verification run
+type VerifyResult struct {
+	Sessions   int
+	Frames     int
+	Divergence *Divergence // nil when every frame matched
+}
+
+// VerifyRecording re-runs every session of a recording from its run
+// configuration (or override, when given), re-applying the recorded
+// commands between the same frames, and compares each regenerated frame
+// with the recorded one. It stops at the first divergence.
+func VerifyRecording(path string, override *RunConfig) (VerifyResult, error) {
+	var result VerifyResult
+
+	f, err := os.Open(path)
+	if err != nil {
+		return result, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var sim *Simulation
+	for {
+		var line recordLine
+		if err := dec.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return result, fmt.Errorf("read recording: %w", err)
+		}
+
+		if line.Type != "session" && sim == nil {
+			return result, errors.New("recording does not start with a session header")
+		}
+
+		switch line.Type {
+		case "session":
+			if line.Version > recordingVersion {
+				return result, fmt.Errorf("unsupported recording version %d", line.Version)
+			}
+			result.Sessions++
+
+			cfg := line.Config
+			if override != nil {
+				cfg = override
+			}
+			if cfg == nil {
+				return result, fmt.Errorf("session %d has no run configuration; pass one with -config", result.Sessions)
+			}
+
+			// Virtual time is rebuilt from the recorded update lengths, so
+			// the re-run is always tick-driven
+			run := *cfg
+			if !run.Deterministic {
+				fmt.Fprintf(os.Stderr, "warning: session %d was not recorded with -deterministic; time-dependent demand may diverge\n", result.Sessions)
+			}
+			run.Deterministic = true
+			if line.PhysicsStepMs != physicsStep.Milliseconds() {
+				fmt.Fprintf(os.Stderr, "warning: session %d was not recorded with %v movement steps; positions will diverge\n", result.Sessions, physicsStep)
+			}
+
+			sim, err = newRunSimulation(run)
+			if err != nil {
+				return result, fmt.Errorf("session %d: %w", result.Sessions, err)
+			}
+
+		case "command":
+			if err := sim.applyCommand(&line); err != nil {
+				return result, fmt.Errorf("session %d: re-applying %s command after frame %d: %w",
+					result.Sessions, line.Command, line.Tick, err)
+			}
+
+		case "frame":
+			dt := line.Dt
+			if dt == 0 {
+				// Recordings from before dt was stored ran at the configured speed
+				dt = sim.settings.UpdateInterval.Seconds() * sim.clock.Scale()
+			}
+			sim.advance(dt)
+			result.Frames++
+
+			if d := compareFrame(line.Drivers, recordDrivers(sim.drivers)); d != nil {
+				d.Session = result.Sessions
+				d.Tick = line.Tick
+				result.Divergence = d
+				return result, nil
+			}
+		}
+	}
+}
+
+// compareFrame returns the first difference between two frames, or nil
+func compareFrame(recorded, regenerated []RecordedDriver) *Divergence {
+	if len(recorded) != len(regenerated) {
+		return &Divergence{
+			Field:       "driver count",
+			Recorded:    strconv.Itoa(len(recorded)),
+			Regenerated: strconv.Itoa(len(regenerated)),
+		}
+	}
+
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, want := range recorded {
+		got := regenerated[i]
+		if want.ID != got.ID {
+			return &Divergence{
+				Field:       fmt.Sprintf("driver #%d id", i+1),
+				Recorded:    strconv.Itoa(want.ID),
+				Regenerated: strconv.Itoa(got.ID),
+			}
+		}
+
+		var field, wantVal, gotVal string
+		switch {
+		case want.Lon != got.Lon:
+			field, wantVal, gotVal = "lon", formatFloat(want.Lon), formatFloat(got.Lon)
+		case want.Lat != got.Lat:
+			field, wantVal, gotVal = "lat", formatFloat(want.Lat), formatFloat(got.Lat)
+		case want.Status != got.Status:
+			field, wantVal, gotVal = "status", want.Status.String(), got.Status.String()
+		case want.Speed != got.Speed:
+			field, wantVal, gotVal = "speed", formatFloat(want.Speed), formatFloat(got.Speed)
+		case want.Heading != got.Heading:
+			field, wantVal, gotVal = "heading", formatFloat(want.Heading), formatFloat(got.Heading)
+		default:
+			continue
+		}
+		return &Divergence{DriverID: want.ID, Field: field, Recorded: wantVal, Regenerated: gotVal}
+	}
+	return nil
+}
+
+// runVerify implements the "verify" subcommand and returns the exit code:
+// 0 when the recording matches, 1 on divergence and 2 on errors
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	configPath := fs.String("config", "", "JSON run configuration to use instead of the one recorded in each session")
+	verbose := fs.Bool("v", false, "show the engine's log output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sim verify [-config run.json] [-v] recording.jsonl")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var override *RunConfig
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+			return 2
+		}
+		override = &RunConfig{Speed: 1, DemandScale: 1, Movement: MovementDestination}
+		if err := json.Unmarshal(data, override); err != nil {
+			fmt.Fprintf(os.Stderr, "verify: invalid config: %v\n", err)
+			return 2
+		}
+	}
+
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.DiscardHandler))
+	}
+
+	start := time.Now()
+	result, err := VerifyRecording(fs.Arg(0), override)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+	if result.Divergence != nil {
+		fmt.Printf("DIVERGED after %d matching frames: %s\n", result.Frames-1, result.Divergence)
+		return 1
+	}
+
+	fmt.Printf("OK: %d frames in %d session(s) reproduced exactly (%s)\n",
+		result.Frames, result.Sessions, time.Since(start).Round(time.Millisecond))
+	return 0
+}
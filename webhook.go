@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"quadtree/protocol"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Events waiting for each webhook (see offer), so they don't pile up
+	// behind an endpoint that is down; the ones dropped count towards the
+	// webhook's dropped and webhooks_dropped in /api/diag
+	webhookQueueSize = 1000
+	// Tries of a delivery, waiting webhookRetryMin after the first failure
+	// and twice as long after each one after that
+	webhookAttempts = 5
+	webhookRetryMin = 1 * time.Second
+	webhookRetryMax = 1 * time.Minute
+	webhookTimeout  = 10 * time.Second
+	maxWebhooks     = 100
+)
+
+// webhookEvents are the event types webhooks can ask for
+var webhookEvents = map[string]bool{
+	protocol.WebhookZoneEntered:   true,
+	protocol.WebhookZoneExited:    true,
+	protocol.WebhookTripCompleted: true,
+	protocol.WebhookDriverOffline: true,
+}
+
+// WebhookSet holds the registered webhooks. Each has a goroutine that
+// delivers its events in order, retrying with backoff.
+type WebhookSet struct {
+	ctx          context.Context // stops the deliveries
+	client       *http.Client
+	cloudEvents  *cloudEventSource // nil POSTs WebhookEvents
+	allowPrivate bool              // webhooks may reach privateAddr addresses
+
+	mu      sync.Mutex
+	hooks   map[int]*webhook
+	nextID  int
+	eventID int64
+}
+
+// webhook is a registered webhook with its queue and delivery counts; the
+// counts are guarded by WebhookSet.mu
+type webhook struct {
+	protocol.Webhook
+	secret string
+	events map[string]bool // nil for every type
+	queue  chan protocol.WebhookEvent
+	cancel context.CancelFunc
+}
+
+// errPrivateAddress refuses a webhook that would reach the server's own
+// network, such as a cloud metadata service
+var errPrivateAddress = errors.New("webhooks may not reach loopback, link-local or private addresses")
+
+// privateAddr reports whether an address is loopback, link-local, private
+// or otherwise not a public unicast address
+func privateAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsValid() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsPrivate() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsLinkLocalMulticast()
+}
+
+// StartWebhooks enables registering webhooks, delivering their events until
+// ctx is canceled. The webhooks of the JSON file at path, a list of
+// registrations, are registered right away unless path is empty. Unless
+// allowPrivate is set, webhooks may only reach public addresses; every
+// connection is checked as it is made, so a host name that later resolves
+// to a private address is refused too.
+func (s *Simulation) StartWebhooks(ctx context.Context, path string, allowPrivate bool) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		// A proxy would make the connections, out of the check's sight
+		transport.Proxy = nil
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addr, err := netip.ParseAddrPort(address)
+				if err != nil || privateAddr(addr.Addr()) {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	set := &WebhookSet{
+		ctx:          ctx,
+		client:       &http.Client{Timeout: webhookTimeout, Transport: transport},
+		cloudEvents:  s.cloudEvents,
+		allowPrivate: allowPrivate,
+		hooks:        make(map[int]*webhook),
+	}
+	var regs []protocol.WebhookRegistration
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read webhooks: %w", err)
+		}
+		if err := json.Unmarshal(data, &regs); err != nil {
+			return fmt.Errorf("parse webhooks: %w", err)
+		}
+	}
+	for i, reg := range regs {
+		if _, err := s.registerWebhook(set, reg); err != nil {
+			return fmt.Errorf("webhook %d: %w", i, err)
+		}
+	}
+	s.webhooks = set
+	if len(regs) > 0 {
+		slog.Info("Registered webhooks", "webhooks", len(regs), "path", path)
+	}
+	return nil
+}
+
+// registerWebhook validates a registration and starts delivering to it
+func (s *Simulation) registerWebhook(set *WebhookSet, reg protocol.WebhookRegistration) (protocol.Webhook, error) {
+	u, err := url.Parse(reg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return protocol.Webhook{}, fmt.Errorf("invalid URL %q: want http(s)://host/path", reg.URL)
+	}
+	if !set.allowPrivate {
+		if err := checkWebhookHost(set.ctx, u.Hostname()); err != nil {
+			return protocol.Webhook{}, err
+		}
+	}
+	var events map[string]bool
+	for _, event := range reg.Events {
+		if !webhookEvents[event] {
+			return protocol.Webhook{}, fmt.Errorf("unknown event %q", event)
+		}
+		if events == nil {
+			events = make(map[string]bool)
+		}
+		events[event] = true
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.hooks) >= maxWebhooks {
+		return protocol.Webhook{}, fmt.Errorf("too many webhooks (at most %d)", maxWebhooks)
+	}
+	set.nextID++
+	ctx, cancel := context.WithCancel(set.ctx)
+	hook := &webhook{
+		Webhook: protocol.Webhook{
+			ID:     set.nextID,
+			URL:    reg.URL,
+			Events: append([]string{}, reg.Events...),
+			Signed: reg.Secret != "",
+		},
+		secret: reg.Secret,
+		events: events,
+		queue:  make(chan protocol.WebhookEvent, webhookQueueSize),
+		cancel: cancel,
+	}
+	set.hooks[hook.ID] = hook
+	s.goTracked(func() { s.deliverWebhook(ctx, set, hook) })
+	return hook.Webhook, nil
+}
+
+// checkWebhookHost refuses a webhook host that is, or resolves to, a
+// private address. A host that doesn't resolve yet is left to the check
+// of every connection.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if name := strings.TrimSuffix(strings.ToLower(host), "."); name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return errPrivateAddress
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if privateAddr(ip) {
+			return errPrivateAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ips, _ := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	for _, ip := range ips {
+		if privateAddr(ip) {
+			return fmt.Errorf("%s resolves to %s: %w", host, ip, errPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// List returns the webhooks by ID
+func (set *WebhookSet) List() []protocol.Webhook {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	hooks := make([]protocol.Webhook, 0, len(set.hooks))
+	for _, hook := range set.hooks {
+		hooks = append(hooks, hook.Webhook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks
+}
+
+// Remove stops delivering to a webhook and forgets it, reporting whether
+// it was registered
+func (set *WebhookSet) Remove(id int) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	hook, ok := set.hooks[id]
+	if ok {
+		hook.cancel()
+		delete(set.hooks, id)
+	}
+	return ok
+}
+
+// notifyWebhooks queues an event for every webhook that asked for its
+// type. It must not block: it runs on the main loop.
+func (s *Simulation) notifyWebhooks(kind string, data interface{}) {
+	set := s.webhooks
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.hooks) == 0 {
+		return
+	}
+	set.eventID++
+	event := protocol.WebhookEvent{
+		ID:   set.eventID,
+		Type: kind,
+		Time: s.clock.Now().UnixNano() / int64(time.Millisecond),
+		Data: data,
+	}
+	for _, hook := range set.hooks {
+		if hook.events != nil && !hook.events[kind] {
+			continue
+		}
+		if !offer(hook.queue, event, &s.webhooksDropped) {
+			hook.Dropped++
+		}
+	}
+}
+
+// webhookMessage notifies webhooks of the broadcast messages that are
+// webhook events: completed trips and drivers going offline
+func (s *Simulation) webhookMessage(v interface{}) {
+	switch m := v.(type) {
+	case protocol.TripEvent:
+		if m.Event == protocol.TripCompleted {
+			s.notifyWebhooks(protocol.WebhookTripCompleted, m)
+		}
+	case protocol.DriverStatusChanged:
+		if m.NewStatus == Offline.String() {
+			s.notifyWebhooks(protocol.WebhookDriverOffline, m)
+		}
+	}
+}
+
+// deliverWebhook POSTs a webhook's events in order until ctx is canceled,
+// which drops the ones still waiting
+func (s *Simulation) deliverWebhook(ctx context.Context, set *WebhookSet, hook *webhook) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-hook.queue:
+			var payload interface{} = event
+			if set.cloudEvents != nil {
+				payload = set.cloudEvents.webhookEvent(event)
+			}
+			body, err := json.Marshal(payload)
+			if err != nil {
+				slog.Error("Encoding webhook event failed", "webhook", hook.ID, "type", event.Type, "err", err)
+				continue
+			}
+			err = set.deliver(ctx, hook, event, body)
+			if ctx.Err() != nil {
+				return
+			}
+
+			set.mu.Lock()
+			failing := hook.LastError != ""
+			if err == nil {
+				hook.Delivered++
+				hook.LastError = ""
+			} else {
+				hook.Failed++
+				hook.LastError = err.Error()
+			}
+			set.mu.Unlock()
+			if err == nil {
+				s.webhooksDelivered.Add(1)
+				if failing {
+					slog.Info("Webhook deliveries recovered", "webhook", hook.ID, "url", hook.URL)
+				}
+			} else {
+				s.webhooksFailed.Add(1)
+				if !failing {
+					slog.Warn("Delivering webhook event failed", "webhook", hook.ID, "url", hook.URL, "type", event.Type, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// errPermanent marks a delivery the receiver refused for good
+var errPermanent = errors.New("not retried")
+
+// deliver POSTs one event, retrying network errors, 429s and 5xx responses
+// with backoff
+func (set *WebhookSet) deliver(ctx context.Context, hook *webhook, event protocol.WebhookEvent, body []byte) error {
+	retry := webhookRetryMin
+	for attempt := 1; ; attempt++ {
+		err := set.post(ctx, hook, event, body)
+		if err == nil || errors.Is(err, errPermanent) || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		retry = min(retry*2, webhookRetryMax)
+	}
+}
+
+// post makes one delivery attempt
+func (set *WebhookSet) post(ctx context.Context, hook *webhook, event protocol.WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if set.cloudEvents != nil {
+		// Structured mode of the HTTP binding
+		req.Header.Set("Content-Type", protocol.CloudEventsContentType)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "taxi-sim-webhook")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(event.ID, 10))
+	if hook.secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := set.client.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return fmt.Errorf("%w: %w", err, errPermanent)
+	}
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("status %s", resp.Status)
+	default:
+		return fmt.Errorf("status %s: %w", resp.Status, errPermanent)
+	}
+}
+
+// publishZoneChanges notifies webhooks of the drivers that entered or left
+// a watch zone since the last update. A driver's zones when it is first
+// seen aren't events. It must run on the main loop.
+func (s *Simulation) publishZoneChanges() {
+	watched := s.geofences.watched()
+	if len(watched) == 0 {
+		return
+	}
+	for _, driver := range s.drivers {
+		driver.mu.Lock()
+		lon, lat := driver.lon(), driver.lat()
+		driver.mu.Unlock()
+
+		var zones []string
+		for _, zone := range watched {
+			if zone.Polygon.Contains(lon, lat) {
+				zones = append(zones, zone.Name)
+			}
+		}
+		old, seen := driver.watchZones, driver.zonesSeen
+		driver.watchZones, driver.zonesSeen = zones, true
+		if !seen || s.webhooks == nil {
+			continue
+		}
+		for _, zone := range old {
+			if !slices.Contains(zones, zone) {
+				s.notifyWebhooks(protocol.WebhookZoneExited, protocol.ZoneEvent{DriverID: driver.ID, Zone: zone, Lon: lon, Lat: lat})
+			}
+		}
+		for _, zone := range zones {
+			if !slices.Contains(old, zone) {
+				s.notifyWebhooks(protocol.WebhookZoneEntered, protocol.ZoneEvent{DriverID: driver.ID, Zone: zone, Lon: lon, Lat: lat})
+			}
+		}
+	}
+}
+
+// WebhooksHandler lists the webhooks (GET) and registers new ones (POST)
+func (s *Simulation) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+	if s.webhooks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(protocol.Webhooks{Webhooks: s.webhooks.List()})
+
+	case http.MethodPost:
+		var reg protocol.WebhookRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "invalid webhook: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook, err := s.registerWebhook(s.webhooks, reg)
+		if err != nil {
+			http.Error(w, "invalid webhook: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("Webhook registered", "webhook", hook.ID, "url", hook.URL, "events", hook.Events)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookHandler removes a webhook
+func (s *Simulation) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow CORS
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.webhooks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !s.webhooks.Remove(id) {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Webhook removed", "webhook", id)
+	w.WriteHeader(http.StatusNoContent)
+}
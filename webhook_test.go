@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"quadtree/protocol"
+	"strings"
+	"testing"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string // X-Webhook-Signature; empty when unsigned
+	}{
+		{
+			// The widely published example of HMAC-SHA256
+			name:   "known answer",
+			secret: "key",
+			body:   "The quick brown fox jumps over the lazy dog",
+			want:   "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		},
+		{
+			name:   "event",
+			secret: "s3cret",
+			body:   `{"id":1,"type":"driver_offline","time":1792168919517,"data":{"driver_id":42}}`,
+			want:   "sha256=" + hmacSHA256("s3cret", `{"id":1,"type":"driver_offline","time":1792168919517,"data":{"driver_id":42}}`),
+		},
+		{
+			name:   "empty body",
+			secret: "s3cret",
+			body:   "",
+			want:   "sha256=" + hmacSHA256("s3cret", ""),
+		},
+		{name: "no secret", body: `{"id":2}`},
+	}
+	for _, tt := range tests {
+		var got *http.Request
+		var gotBody []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+			gotBody, _ = io.ReadAll(r.Body)
+		}))
+
+		set := &WebhookSet{client: srv.Client(), allowPrivate: true}
+		hook := &webhook{Webhook: protocol.Webhook{URL: srv.URL}, secret: tt.secret}
+		event := protocol.WebhookEvent{ID: 7, Type: protocol.WebhookDriverOffline}
+		err := set.post(context.Background(), hook, event, []byte(tt.body))
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if string(gotBody) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, gotBody, tt.body)
+		}
+		signature, signed := got.Header["X-Webhook-Signature"]
+		switch {
+		case tt.want == "" && signed:
+			t.Errorf("%s: signed %q without a secret", tt.name, signature)
+		case tt.want != "" && got.Header.Get("X-Webhook-Signature") != tt.want:
+			t.Errorf("%s: signature %q, want %q", tt.name, got.Header.Get("X-Webhook-Signature"), tt.want)
+		}
+		if got.Header.Get("X-Webhook-Event") != protocol.WebhookDriverOffline || got.Header.Get("X-Webhook-Delivery") != "7" {
+			t.Errorf("%s: event headers %q, %q", tt.name, got.Header.Get("X-Webhook-Event"), got.Header.Get("X-Webhook-Delivery"))
+		}
+	}
+}
+
+// hmacSHA256 is the hex HMAC-SHA256 a receiver computes to check a body
+func hmacSHA256(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookPostStatus(t *testing.T) {
+	tests := []struct {
+		status        int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{http.StatusOK, false, false},
+		{http.StatusNoContent, false, false},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusInternalServerError, true, false},
+		{http.StatusServiceUnavailable, true, false},
+		{http.StatusBadRequest, true, true},
+		{http.StatusGone, true, true},
+		{http.StatusMovedPermanently, true, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		set := &WebhookSet{client: srv.Client(), allowPrivate: true}
+		err := set.post(context.Background(), &webhook{Webhook: protocol.Webhook{URL: srv.URL}}, protocol.WebhookEvent{}, []byte("{}"))
+		srv.Close()
+
+		if (err != nil) != tt.wantErr || errors.Is(err, errPermanent) != tt.wantPermanent {
+			t.Errorf("status %d: error = %v, want error %v, permanent %v", tt.status, err, tt.wantErr, tt.wantPermanent)
+		}
+	}
+}
+
+func TestPrivateAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.8.9.10", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"::ffff:93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		if got := privateAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("privateAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !privateAddr(netip.Addr{}) {
+		t.Error("the zero Addr isn't private")
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{"localhost", true},
+		{"api.localhost", true},
+		{"LocalHost", true},
+		{"localhost.", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"[::1]", true},
+		{"93.184.216.34", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]", false},
+	}
+	for _, tt := range tests {
+		err := checkWebhookHost(context.Background(), strings.Trim(tt.host, "[]"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkWebhookHost(%s) error = %v, want error %v", tt.host, err, tt.wantErr)
+		}
+	}
+}
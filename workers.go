@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// fanOut calls fn for every index below n on a pool of one worker per core,
+// each taking the next index until none are left, and returns once all
+// calls have. With a single core or index it calls fn in order on the
+// calling goroutine.
+func fanOut(n int, fn func(i int)) {
+	workers := min(runtime.GOMAXPROCS(0), n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// offer puts v on a sink's bounded queue without blocking, and reports
+// whether it fit. The main loop feeds the sinks (Kafka, NATS, PostGIS,
+// webhooks and the like) through such queues, so a slow or unreachable sink
+// costs it nothing: once the queue is full, v is dropped and counted in
+// dropped instead of holding up the simulation.
+func offer[T any](queue chan<- T, v T, dropped *atomic.Int64) bool {
+	select {
+	case queue <- v:
+		return true
+	default:
+		dropped.Add(1)
+		return false
+	}
+}
+
+// goTracked runs fn on a goroutine of its own that waitTracked waits for
+func (s *Simulation) goTracked(fn func()) {
+	s.tracked.Add(1)
+	go func() {
+		defer s.tracked.Done()
+		fn()
+	}()
+}
+
+// waitTracked waits until every goroutine started by goTracked has
+// returned, or until ctx is done, and reports whether they all returned
+func (s *Simulation) waitTracked(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.tracked.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}